@@ -0,0 +1,51 @@
+/*
+mockoidc is a local OpenID Connect provider for trying the social login without Google.
+It signs in a fixed user as soon as the authorization endpoint is opened.
+
+	go run ./cmd/mockoidc -addr :9999 -email jane@example.com
+
+and point the API at it with
+
+	OIDC_GOOGLE_ISSUER=http://localhost:9999
+	OIDC_GOOGLE_CLIENT_ID=palace
+	OIDC_GOOGLE_CLIENT_SECRET=secret
+*/
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/dancankarani/palace/oidc/oidctest"
+	"github.com/dgrijalva/jwt-go"
+)
+
+func main() {
+	addr := flag.String("addr", ":9999", "address to listen on")
+	issuer := flag.String("issuer", "http://localhost:9999", "issuer URL the provider is reached at")
+	clientID := flag.String("client-id", "palace", "client id the API is configured with")
+	clientSecret := flag.String("client-secret", "secret", "client secret the API is configured with")
+	sub := flag.String("sub", "mock-user-1", "subject of the signed in user")
+	email := flag.String("email", "jane@example.com", "email address of the signed in user")
+	unverified := flag.Bool("unverified", false, "report the email address as unverified")
+	given := flag.String("given-name", "Jane", "first name of the signed in user")
+	family := flag.String("family-name", "Doe", "last name of the signed in user")
+	flag.Parse()
+
+	provider, err := oidctest.New(*clientID, *clientSecret, jwt.MapClaims{
+		"sub":            *sub,
+		"email":          *email,
+		"email_verified": !*unverified,
+		"given_name":     *given,
+		"family_name":    *family,
+		"name":           *given + " " + *family,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	provider.Issuer = *issuer
+
+	log.Printf("mock OIDC provider for %s listening on %s", *email, *addr)
+	log.Fatal(http.ListenAndServe(*addr, provider))
+}
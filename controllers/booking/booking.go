@@ -0,0 +1,177 @@
+package booking
+
+import (
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const defaultReminderInterval = 15 * time.Minute
+
+// SetAvailabilityHandler replaces the seller's weekly availability
+func SetAvailabilityHandler(c *fiber.Ctx) error {
+	response, err := model.SetAvailability(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusBadRequest)
+	}
+	return utilities.ShowSuccess(c, "availability saved successfully", fiber.StatusOK, response)
+}
+
+// GetAvailabilityHandler shows a seller's weekly availability and time off
+func GetAvailabilityHandler(c *fiber.Ctx) error {
+	sellerID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid seller id", fiber.StatusBadRequest)
+	}
+	response, err := model.GetAvailability(sellerID)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "availability retrieved successfully", fiber.StatusOK, response)
+}
+
+// AddTimeOffHandler blocks a period of the seller's calendar
+func AddTimeOffHandler(c *fiber.Ctx) error {
+	response, err := model.AddTimeOff(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusBadRequest)
+	}
+	return utilities.ShowSuccess(c, "time off added successfully", fiber.StatusOK, response)
+}
+
+// DeleteTimeOffHandler removes one of the seller's time off periods
+func DeleteTimeOffHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid time off id", fiber.StatusBadRequest)
+	}
+	if err := model.DeleteTimeOff(c, id); err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusNotFound)
+	}
+	return utilities.ShowMessage(c, "time off deleted successfully", fiber.StatusOK)
+}
+
+// GetServiceSlotsHandler lists the free appointment times of a service
+func GetServiceSlotsHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid service id", fiber.StatusBadRequest)
+	}
+	from := time.Now()
+	if c.Query("from") != "" {
+		from, err = time.ParseInLocation("2006-01-02", c.Query("from"), model.BookingLocation())
+		if err != nil {
+			return utilities.ShowError(c, "from must be a date such as 2024-06-01", fiber.StatusBadRequest)
+		}
+	}
+	response, err := model.GetServiceSlots(id, from, c.QueryInt("days", 7))
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), bookingErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "available times retrieved successfully", fiber.StatusOK, response)
+}
+
+// CreateBookingHandler books a service and opens the order it is paid through
+func CreateBookingHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid service id", fiber.StatusBadRequest)
+	}
+	response, err := model.CreateBooking(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), bookingErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "booking created successfully", fiber.StatusOK, response)
+}
+
+// GetBookingsHandler lists the user's bookings, or with role=seller the bookings of their services
+func GetBookingsHandler(c *fiber.Ctx) error {
+	userID, err := model.GetAuthUserID(c)
+	if err != nil {
+		return utilities.ShowError(c, "unauthorized", fiber.StatusUnauthorized)
+	}
+	filter := model.BookingFilter{}
+	if err := c.QueryParser(&filter); err != nil {
+		return utilities.ShowError(c, "invalid query parameters", fiber.StatusBadRequest)
+	}
+	response, err := model.GetBookings(userID, filter)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "bookings retrieved successfully", fiber.StatusOK, response)
+}
+
+// ConfirmBookingHandler confirms a pending booking of one of the seller's services
+func ConfirmBookingHandler(c *fiber.Ctx) error {
+	return updateBooking(c, model.ConfirmBooking, "booking confirmed successfully")
+}
+
+// CancelBookingHandler cancels a booking for the customer or the seller
+func CancelBookingHandler(c *fiber.Ctx) error {
+	return updateBooking(c, model.CancelBooking, "booking cancelled successfully")
+}
+
+// CompleteBookingHandler marks a booking as done, delivering its order
+func CompleteBookingHandler(c *fiber.Ctx) error {
+	return updateBooking(c, model.CompleteBooking, "booking completed successfully")
+}
+
+func updateBooking(c *fiber.Ctx, update func(*fiber.Ctx, uuid.UUID) (*model.Booking, error), message string) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid booking id", fiber.StatusBadRequest)
+	}
+	response, err := update(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), bookingErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, message, fiber.StatusOK, response)
+}
+
+func bookingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrBookingNotFound), errors.Is(err, model.ErrServiceNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, model.ErrNotBookingParty), errors.Is(err, model.ErrOwnServiceBooking), errors.Is(err, model.ErrUnverifiedAccount):
+		return fiber.StatusForbidden
+	case errors.Is(err, model.ErrSlotUnavailable), errors.Is(err, model.ErrBookingState), errors.Is(err, model.ErrBookingUnpaid):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusBadRequest
+}
+
+/*
+reminds customers and sellers of upcoming bookings on BOOKING_REMINDER_INTERVAL (default 15m)
+and cancels bookings that were not paid in time. The reminders are mailed by the notification
+email run. Meant to be started in its own goroutine.
+*/
+func StartBookingReminderScheduler() {
+	interval, err := time.ParseDuration(os.Getenv("BOOKING_REMINDER_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = defaultReminderInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sent, err := model.SendBookingReminders()
+		if err != nil {
+			log.Println("booking reminder run:", err.Error())
+		}
+		if sent > 0 {
+			log.Println("booking reminder run: reminded", sent, "bookings")
+		}
+		expired, err := model.ExpireUnpaidBookings()
+		if err != nil {
+			log.Println("booking reminder run:", err.Error())
+		}
+		if expired > 0 {
+			log.Println("booking reminder run: cancelled", expired, "unpaid bookings")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package category
+
+import (
+	"errors"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// GetCategoryTreeHandler returns the product or service category tree
+func GetCategoryTreeHandler(c *fiber.Ctx) error {
+	kind := model.CategoryKind(c.Query("kind", string(model.CategoryProduct)))
+	if kind != model.CategoryProduct && kind != model.CategoryService {
+		return utilities.ShowError(c, "kind must be product or service", fiber.StatusBadRequest)
+	}
+	response, err := model.GetCategoryTree(kind)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "categories retrieved successfully", fiber.StatusOK, response)
+}
+
+// GetCategoryHandler returns a category by id or slug with its subcategories and ancestors
+func GetCategoryHandler(c *fiber.Ctx) error {
+	response, err := model.GetCategory(c.Params("ref"))
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), categoryErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "category retrieved successfully", fiber.StatusOK, response)
+}
+
+// CreateCategoryHandler adds a category to the tree
+func CreateCategoryHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	response, err := model.CreateCategory(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusBadRequest)
+	}
+	return utilities.ShowSuccess(c, "category created successfully", fiber.StatusOK, response)
+}
+
+// UpdateCategoryHandler renames, reorders or moves a category
+func UpdateCategoryHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid category id", fiber.StatusBadRequest)
+	}
+	response, err := model.UpdateCategory(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), categoryErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "category updated successfully", fiber.StatusOK, response)
+}
+
+// DeleteCategoryHandler removes a category, moving its contents to its parent
+func DeleteCategoryHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid category id", fiber.StatusBadRequest)
+	}
+	if err := model.DeleteCategory(id); err != nil {
+		return utilities.ShowError(c, err.Error(), categoryErrorStatus(err))
+	}
+	return utilities.ShowMessage(c, "category deleted successfully", fiber.StatusOK)
+}
+
+func categoryErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrCategoryNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, model.ErrCategoryCycle):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusBadRequest
+}
@@ -0,0 +1,70 @@
+package ledger
+
+import (
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// GetTrialBalanceHandler returns debit and credit totals for every ledger account
+func GetTrialBalanceHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	response, err := model.GetTrialBalance()
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "trial balance retrieved successfully", fiber.StatusOK, response)
+}
+
+// RefundPaymentHandler refunds a paid payment, leaving the refund pending until it is sent
+func RefundPaymentHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid payment id", fiber.StatusBadRequest)
+	}
+	response, err := model.RefundPayment(id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "payment refunded, send the money back and settle the refund", fiber.StatusOK, response)
+}
+
+// GetPendingRefundsHandler lists the refunds that still have to be sent to customers
+func GetPendingRefundsHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	response, err := model.GetPendingRefunds()
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "pending refunds retrieved successfully", fiber.StatusOK, response)
+}
+
+// SettleRefundHandler records that a pending refund was sent back to the customer
+func SettleRefundHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid payment id", fiber.StatusBadRequest)
+	}
+	body := struct {
+		Reference string `json:"reference"` // M-Pesa receipt of the money sent back
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return utilities.ShowError(c, "failed to parse JSON data", fiber.StatusBadRequest)
+	}
+	response, err := model.SettleRefund(id, body.Reference)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusBadRequest)
+	}
+	return utilities.ShowSuccess(c, "refund settled successfully", fiber.StatusOK, response)
+}
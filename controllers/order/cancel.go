@@ -0,0 +1,26 @@
+package order
+
+import (
+	"errors"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// CancelOrderHandler cancels an unpaid order and returns its items to stock
+func CancelOrderHandler(c *fiber.Ctx) error {
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid order id", fiber.StatusBadRequest)
+	}
+	response, err := model.CancelOrder(c, orderID)
+	if errors.Is(err, model.ErrOrderNotCancellable) {
+		return utilities.ShowError(c, err.Error(), fiber.StatusConflict)
+	}
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusNotFound)
+	}
+	return utilities.ShowSuccess(c, "order cancelled successfully", fiber.StatusOK, response)
+}
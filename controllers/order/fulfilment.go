@@ -0,0 +1,46 @@
+package order
+
+import (
+	"errors"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// ShipOrderHandler marks the seller's items in a paid order as shipped
+func ShipOrderHandler(c *fiber.Ctx) error {
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid order id", fiber.StatusBadRequest)
+	}
+	response, err := model.ShipOrder(c, orderID)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fulfilmentErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "order shipped successfully", fiber.StatusOK, response)
+}
+
+// ConfirmDeliveryHandler lets the buyer confirm they received a shipped order
+func ConfirmDeliveryHandler(c *fiber.Ctx) error {
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid order id", fiber.StatusBadRequest)
+	}
+	response, err := model.ConfirmDelivery(c, orderID)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fulfilmentErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "delivery confirmed successfully", fiber.StatusOK, response)
+}
+
+func fulfilmentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrOrderNotShippable), errors.Is(err, model.ErrOrderNotConfirmable):
+		return fiber.StatusConflict
+	case errors.Is(err, model.ErrOrderNotFound):
+		return fiber.StatusNotFound
+	}
+	return fiber.StatusInternalServerError
+}
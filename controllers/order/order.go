@@ -1,7 +1,7 @@
 package order
 
 import (
-
+	"errors"
 
 	"github.com/dancankarani/palace/model"
 	"github.com/gofiber/fiber/v2"
@@ -15,8 +15,9 @@ type OrderRequest struct {
 }
 
 type OrderItemRequest struct {
-	ProductID uuid.UUID `json:"product_id"`
-	Quantity  int       `json:"quantity"`
+	ProductID uuid.UUID  `json:"product_id"`
+	VariantID *uuid.UUID `json:"variant_id"`
+	Quantity  int        `json:"quantity"`
 }
 
 func MakeOrderHandler(c *fiber.Ctx) error {
@@ -40,13 +41,19 @@ func MakeOrderHandler(c *fiber.Ctx) error {
 	var items []model.OrderItem
 	for _, item := range req.Items {
 		items = append(items, model.OrderItem{
-			ProductID: item.ProductID,
+			ProductID: &item.ProductID,
+			VariantID: item.VariantID,
 			Quantity:  item.Quantity,
 		})
 	}
 
 	// Call the MakeOrder function
 	order, err := model.MakeOrder(userID, items, req.ShippingAddress, req.PaymentMethod)
+	if errors.Is(err, model.ErrUnverifiedAccount) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": err.Error(),
@@ -0,0 +1,37 @@
+package order
+
+import (
+	"errors"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// GetReceiptHandler downloads the PDF receipt of a paid order
+func GetReceiptHandler(c *fiber.Ctx) error {
+	userID, err := model.GetAuthUserID(c)
+	if err != nil {
+		return utilities.ShowError(c, "unauthorized", fiber.StatusUnauthorized)
+	}
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid order id", fiber.StatusBadRequest)
+	}
+
+	order, payments, err := model.GetReceiptOrder(orderID)
+	if order != nil && order.UserID != userID && model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "order not found", fiber.StatusNotFound)
+	}
+	if errors.Is(err, model.ErrOrderNotPaid) {
+		return utilities.ShowError(c, err.Error(), fiber.StatusConflict)
+	}
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusNotFound)
+	}
+
+	c.Set(fiber.HeaderContentType, "application/pdf")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="receipt-`+order.OrderNumber+`.pdf"`)
+	return c.Send(model.RenderReceiptPDF(order, payments))
+}
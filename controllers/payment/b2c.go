@@ -0,0 +1,244 @@
+package payment
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultPayoutInterval = 24 * time.Hour
+
+/*
+runs the payout job on PAYOUT_INTERVAL (default 24h). Meant to be started in its own goroutine.
+*/
+func StartPayoutScheduler() {
+	interval, err := time.ParseDuration(os.Getenv("PAYOUT_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = defaultPayoutInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		RunPayouts()
+	}
+}
+
+// RunPayouts settles payouts with an unknown outcome, confirms shipped orders the buyers did not confirm
+// in time, credits sellers for delivered items and disburses every balance due
+func RunPayouts() {
+	awaiting, err := model.GetPayoutsAwaitingStatus()
+	if err != nil {
+		log.Println("payout run:", err.Error())
+	}
+	for _, payout := range awaiting {
+		if err := queryPayoutStatus(payout); err != nil {
+			log.Println("payout run: error querying payout", payout.ID, ":", err.Error())
+		}
+	}
+
+	confirmed, err := model.AutoConfirmDeliveries()
+	if err != nil {
+		log.Println("payout run: error confirming deliveries:", err.Error())
+	}
+	if confirmed > 0 {
+		log.Println("payout run: confirmed", confirmed, "deliveries")
+	}
+
+	credited, err := model.CreditDeliveredOrderItems()
+	if err != nil {
+		log.Println("payout run: error crediting sellers:", err.Error())
+	}
+	log.Println("payout run: credited", credited, "order items")
+
+	payouts, err := model.CreatePendingPayouts()
+	if err != nil {
+		log.Println("payout run:", err.Error())
+		return
+	}
+	for _, payout := range payouts {
+		err := sendB2CPayment(payout)
+		if err == nil {
+			continue
+		}
+		log.Println("payout run: error sending payout", payout.ID, ":", err.Error())
+		// the request may have been carried out, so the money stays held until it is looked up
+		if errors.Is(err, errDarajaNoAnswer) {
+			if err := model.MarkPayoutUnknown(payout.ID, err.Error()); err != nil {
+				log.Println("payout run: error updating payout", payout.ID, ":", err.Error())
+			}
+			continue
+		}
+		if err := model.FailPayout(payout.ID, err.Error()); err != nil {
+			log.Println("payout run: error failing payout", payout.ID, ":", err.Error())
+		}
+	}
+}
+
+// sendB2CPayment asks M-Pesa to disburse a payout to the seller's phone number
+func sendB2CPayment(payout model.Payout) error {
+	if payout.Amount.CurrencyCode() != model.MpesaCurrency {
+		return model.ErrCurrencyNotPayable
+	}
+	shortCode := os.Getenv("B2C_SHORT_CODE")
+	if shortCode == "" {
+		shortCode = os.Getenv("SHORT_CODE")
+	}
+	payload := map[string]interface{}{
+		"OriginatorConversationID": payout.ID.String(),
+		"InitiatorName":            os.Getenv("B2C_INITIATOR_NAME"),
+		"SecurityCredential":       os.Getenv("B2C_SECURITY_CREDENTIAL"),
+		"CommandID":                "BusinessPayment",
+		"Amount":                   payout.Amount.WholeUnits(),
+		"PartyA":                   shortCode,
+		"PartyB":                   payout.PhoneNumber,
+		"Remarks":                  "Seller payout",
+		"QueueTimeOutURL":          callbackURL("/api/v1/b2c/timeout"),
+		"ResultURL":                callbackURL("/api/v1/b2c/result"),
+		"Occasion":                 payout.ID.String(),
+	}
+	response, err := postToDaraja("/mpesa/b2c/v3/paymentrequest", payload)
+	if err != nil {
+		return err
+	}
+	if code := fmt.Sprintf("%v", response["ResponseCode"]); code != "0" {
+		return fmt.Errorf("payout rejected: %v", response["ResponseDescription"])
+	}
+
+	conversationID, _ := response["ConversationID"].(string)
+	originatorConversationID, _ := response["OriginatorConversationID"].(string)
+	if conversationID == "" {
+		return fmt.Errorf("%w: payout accepted without a ConversationID", errDarajaNoAnswer)
+	}
+	return model.MarkPayoutSubmitted(payout.ID, conversationID, originatorConversationID)
+}
+
+// queryPayoutStatus asks M-Pesa for the outcome of a payout, answered at the status callback
+func queryPayoutStatus(payout model.Payout) error {
+	shortCode := os.Getenv("B2C_SHORT_CODE")
+	if shortCode == "" {
+		shortCode = os.Getenv("SHORT_CODE")
+	}
+	originatorConversationID := payout.OriginatorConversationID
+	if originatorConversationID == "" {
+		originatorConversationID = payout.ID.String()
+	}
+	payload := map[string]interface{}{
+		"Initiator":              os.Getenv("B2C_INITIATOR_NAME"),
+		"SecurityCredential":     os.Getenv("B2C_SECURITY_CREDENTIAL"),
+		"CommandID":              "TransactionStatusQuery",
+		"TransactionID":          payout.TransactionID,
+		"OriginalConversationID": originatorConversationID,
+		"PartyA":                 shortCode,
+		"IdentifierType":         "4",
+		"ResultURL":              callbackURL("/api/v1/b2c/status"),
+		"QueueTimeOutURL":        callbackURL("/api/v1/b2c/status"),
+		"Remarks":                "Seller payout status",
+		"Occasion":               payout.ID.String(),
+	}
+	response, err := postToDaraja("/mpesa/transactionstatus/v1/query", payload)
+	if err != nil {
+		return err
+	}
+	if code := fmt.Sprintf("%v", response["ResponseCode"]); code != "0" {
+		return fmt.Errorf("status query rejected: %v", response["ResponseDescription"])
+	}
+	conversationID, _ := response["ConversationID"].(string)
+	return model.MarkPayoutStatusQueried(payout.ID, conversationID)
+}
+
+type b2cCallback struct {
+	Result struct {
+		ResultType               int    `json:"ResultType"`
+		ResultCode               int    `json:"ResultCode"`
+		ResultDesc               string `json:"ResultDesc"`
+		OriginatorConversationID string `json:"OriginatorConversationID"`
+		ConversationID           string `json:"ConversationID"`
+		TransactionID            string `json:"TransactionID"`
+		ResultParameters         struct {
+			ResultParameter []struct {
+				Key   string      `json:"Key"`
+				Value interface{} `json:"Value"`
+			} `json:"ResultParameter"`
+		} `json:"ResultParameters"`
+	} `json:"Result"`
+}
+
+// parameter returns a named value from the result parameters
+func (callback b2cCallback) parameter(key string) string {
+	for _, p := range callback.Result.ResultParameters.ResultParameter {
+		if p.Key == key && p.Value != nil {
+			return fmt.Sprintf("%v", p.Value)
+		}
+	}
+	return ""
+}
+
+// B2CResult records the final outcome of a seller payout
+func B2CResult(c *fiber.Ctx) error {
+	var callback b2cCallback
+	if err := c.BodyParser(&callback); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request payload",
+		})
+	}
+
+	result := callback.Result
+	_, err := model.CompletePayout(result.ConversationID, result.ResultCode == 0, result.TransactionID, result.ResultDesc)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"})
+}
+
+// B2CTimeout marks a seller payout that timed out in the M-Pesa queue as unknown until its status is queried
+func B2CTimeout(c *fiber.Ctx) error {
+	var callback b2cCallback
+	if err := c.BodyParser(&callback); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request payload",
+		})
+	}
+
+	result := callback.Result
+	desc := result.ResultDesc
+	if desc == "" {
+		desc = "request timed out"
+	}
+	if err := model.TimeOutPayout(result.ConversationID, desc); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"})
+}
+
+// B2CStatusResult settles a seller payout from the answer to a transaction status query
+func B2CStatusResult(c *fiber.Ctx) error {
+	var callback b2cCallback
+	if err := c.BodyParser(&callback); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request payload",
+		})
+	}
+
+	result := callback.Result
+	// a failed query says nothing about the payout, which is queried again on the next run
+	if result.ResultCode != 0 {
+		log.Println("payout status query", result.ConversationID, "failed:", result.ResultDesc)
+		return c.JSON(fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"})
+	}
+	_, err := model.SettlePayoutStatus(result.ConversationID, callback.parameter("TransactionStatus"), callback.parameter("ReceiptNo"), result.ResultDesc)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{"ResultCode": 0, "ResultDesc": "Accepted"})
+}
@@ -0,0 +1,99 @@
+package payment
+
+import (
+	"errors"
+	"log"
+	"os"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+)
+
+// result codes Safaricom expects back from the C2B validation URL
+const (
+	c2bAccepted             = "0"
+	c2bInvalidAccountNumber = "C2B00012"
+	c2bInvalidAmount        = "C2B00013"
+	c2bOtherError           = "C2B00016"
+)
+
+// RegisterC2BURLs registers the paybill/till validation and confirmation URLs with Safaricom
+func RegisterC2BURLs(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+
+	payload := map[string]interface{}{
+		"ShortCode":       os.Getenv("SHORT_CODE"),
+		"ResponseType":    "Cancelled",
+		"ConfirmationURL": callbackURL("/api/v1/c2b/confirmation"),
+		"ValidationURL":   callbackURL("/api/v1/c2b/validation"),
+	}
+	response, err := postToDaraja("/mpesa/c2b/v1/registerurl", payload)
+	if err != nil {
+		log.Println("error registering c2b urls:", err.Error())
+		return utilities.ShowError(c, "failed to register C2B URLs", fiber.StatusBadGateway)
+	}
+	return utilities.ShowSuccess(c, "C2B URLs registered successfully", fiber.StatusOK, response)
+}
+
+// C2BValidation accepts or rejects a paybill/till payment before M-Pesa completes it
+func C2BValidation(c *fiber.Ctx) error {
+	var txn model.C2BTransaction
+	if err := c.BodyParser(&txn); err != nil {
+		log.Println("error parsing c2b validation request:", err.Error())
+		return c.JSON(c2bResult(c2bOtherError, "Rejected"))
+	}
+
+	amount, err := model.ParseMoney(txn.TransAmount)
+	if err != nil {
+		return c.JSON(c2bResult(c2bInvalidAmount, "Rejected"))
+	}
+
+	err = model.ValidateC2BPayment(txn.BillRefNumber, amount)
+	switch {
+	case err == nil:
+		return c.JSON(c2bResult(c2bAccepted, "Accepted"))
+	case errors.Is(err, model.ErrUnknownOrder), errors.Is(err, model.ErrOrderClosed):
+		return c.JSON(c2bResult(c2bInvalidAccountNumber, "Rejected"))
+	case errors.Is(err, model.ErrInvalidAmount), errors.Is(err, model.ErrOrderSettled):
+		return c.JSON(c2bResult(c2bInvalidAmount, "Rejected"))
+	default:
+		return c.JSON(c2bResult(c2bOtherError, "Rejected"))
+	}
+}
+
+// C2BConfirmation records a completed paybill/till payment against its order
+func C2BConfirmation(c *fiber.Ctx) error {
+	var txn model.C2BTransaction
+	if err := c.BodyParser(&txn); err != nil {
+		log.Println("error parsing c2b confirmation request:", err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(c2bResult(c2bOtherError, "Invalid request payload"))
+	}
+
+	amount, err := model.ParseMoney(txn.TransAmount)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(c2bResult(c2bInvalidAmount, "Invalid amount"))
+	}
+
+	_, err = model.RecordC2BPayment(txn, amount)
+	switch {
+	case err == nil:
+	case errors.Is(err, model.ErrUnknownOrder), errors.Is(err, model.ErrOrderClosed), errors.Is(err, model.ErrInvalidAmount):
+		// safaricom will not take the money back, so the payment is left for manual refund
+		log.Println("refused c2b payment", txn.TransID, "for", txn.BillRefNumber+":", err.Error())
+		return c.JSON(c2bResult(c2bInvalidAccountNumber, "Rejected"))
+	default:
+		log.Println("error recording c2b payment", txn.TransID+":", err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(c2bResult(c2bOtherError, "Failed to record payment"))
+	}
+	return c.JSON(c2bResult(c2bAccepted, "Success"))
+}
+
+func c2bResult(code, desc string) fiber.Map {
+	return fiber.Map{
+		"ResultCode": code,
+		"ResultDesc": desc,
+	}
+}
@@ -0,0 +1,92 @@
+package payment
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const darajaBaseURL = "https://sandbox.safaricom.co.ke"
+
+// errDarajaNoAnswer is returned when a request was sent but Daraja's answer was lost or
+// unusable, so it may or may not have been acted on
+var errDarajaNoAnswer = errors.New("no answer from daraja")
+
+/*
+sends an authenticated POST request to the Daraja API and decodes the JSON response
+@params path
+@params payload
+*/
+func postToDaraja(path string, payload interface{}) (map[string]interface{}, error) {
+	accessToken, err := generateAccessToken()
+	if err != nil {
+		return nil, fmt.Errorf("error generating access token: %v", err)
+	}
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling JSON: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", darajaBaseURL+path, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Authorization", "Bearer "+accessToken)
+
+	client := &http.Client{}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error sending request: %v", errDarajaNoAnswer, err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error reading response body: %v", errDarajaNoAnswer, err)
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("%w: error unmarshaling response: %v", errDarajaNoAnswer, err)
+	}
+	if res.StatusCode >= http.StatusInternalServerError {
+		return result, fmt.Errorf("%w: daraja returned status %d: %v", errDarajaNoAnswer, res.StatusCode, result["errorMessage"])
+	}
+	if res.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("daraja returned status %d: %v", res.StatusCode, result["errorMessage"])
+	}
+	return result, nil
+}
+
+/*
+callbackURL builds the public URL Safaricom should call back for the given path. The
+secret Safaricom_CallbackToken is added as the last path segment so the callback routes
+can tell Safaricom's calls from anyone else's.
+@params path
+*/
+func callbackURL(path string) string {
+	return os.Getenv("Safaricom_CallbackBaseURL") + path + "/" + os.Getenv("Safaricom_CallbackToken")
+}
+
+// CallbackAuth only lets through callbacks whose :token matches Safaricom_CallbackToken
+func CallbackAuth(c *fiber.Ctx) error {
+	token := os.Getenv("Safaricom_CallbackToken")
+	if token == "" {
+		log.Println("rejecting m-pesa callback: Safaricom_CallbackToken is not set")
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if subtle.ConstantTimeCompare([]byte(c.Params("token")), []byte(token)) != 1 {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	return c.Next()
+}
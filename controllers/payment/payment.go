@@ -1,107 +1,96 @@
 package payment
 
 import (
-	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-
-	//"log"
+	"log"
 	"net/http"
 	"os"
+	"strconv"
 
-	"github.com/dancankarani/palace/database"
 	"github.com/dancankarani/palace/model"
 	"github.com/dancankarani/palace/utilities"
 	"github.com/gofiber/fiber/v2"
-	"github.com/google/uuid"
 	//"github.com/joho/godotenv"
 )
 
+type stkPushRequest struct {
+	OrderNumber string `json:"order_number"`
+	PhoneNumber string `json:"phone_number"`
+}
+
+// InitiateSTKPush asks the customer to pay what is left on their order from their phone
 func InitiateSTKPush(c *fiber.Ctx) error {
-	// Step 1: Generate Access Token
-	accessToken, err := generateAccessToken()
+	userID, err := model.GetAuthUserID(c)
 	if err != nil {
-		fmt.Println("Error generating access token:", err)
-		return utilities.ShowError(c, "failed to send an STK push", 1)
+		return utilities.ShowError(c, "unauthorized", fiber.StatusUnauthorized)
 	}
-
-	fmt.Println("Access Token:", accessToken)
-
-	// Step 2: Make STK Push Request
-	stkPushResponse, err := makeSTKPushRequest(c, accessToken)
+	body := stkPushRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		return utilities.ShowError(c, "failed to parse JSON data", fiber.StatusBadRequest)
+	}
+	phone, err := utilities.ValidatePhoneNumber(body.PhoneNumber, "KE")
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusBadRequest)
+	}
+	order, amount, err := model.PrepareSTKPayment(userID, body.OrderNumber)
 	if err != nil {
-		fmt.Println("Error making STK Push request:", err)
-		return utilities.ShowError(c, "failed to send an STK push", 1)
+		return utilities.ShowError(c, err.Error(), stkErrorStatus(err))
 	}
 
-	fmt.Println("STK Push Response:", stkPushResponse)
-	return utilities.ShowSuccess(c, "Check your mobile phone for an MPESA STK push", 0, stkPushResponse)
+	payment, err := makeSTKPushRequest(order, amount, phone)
+	if err != nil {
+		log.Println("error making stk push request for", order.OrderNumber+":", err.Error())
+		return utilities.ShowError(c, "failed to send an STK push", fiber.StatusBadGateway)
+	}
+	return utilities.ShowSuccess(c, "Check your mobile phone for an MPESA STK push", fiber.StatusOK, payment)
 }
 
-func makeSTKPushRequest(c *fiber.Ctx, accessToken string) (*model.Payment, error) {
-	url := "https://sandbox.safaricom.co.ke/mpesa/stkpush/v1/processrequest"
-	method := "POST"
-
-	/*err := godotenv.Load(".env")
-    if err != nil {
-        log.Fatalf("Error loading .env file: %v", err)
-    }*/
-	
-	payment := model.Payment{}
-
-	// Parse the payment data from the request body
-	if err := c.BodyParser(&payment); err != nil {
-		return nil, fmt.Errorf("failed to parse payment JSON data: %v", err)
+/*
+sends the STK push for an order and saves it as a pending payment until its callback
+@params order
+@params amount
+@params phone_number
+*/
+func makeSTKPushRequest(order *model.Order, amount model.Money, phone string) (*model.Payment, error) {
+	if amount.CurrencyCode() != model.MpesaCurrency {
+		return nil, model.ErrCurrencyNotPayable
 	}
-
-	// Prepare the payload for the STK Push request
 	payloadData := map[string]interface{}{
 		"BusinessShortCode": 174379,
-		"Password":         os.Getenv("Safaricom_Password"),
-		"Timestamp":        "20231217153132",
-		"TransactionType":  "CustomerPayBillOnline",
-		"Amount":           payment.Cost,
-		"PartyA":           25497408042,
-		"PartyB":           174379,
-		"PhoneNumber":      payment.CustomerPhone,
-		"CallBackURL":      "https://medicare-t9y1.onrender.com/api/v1/callback",
-		"AccountReference": payment.AccountReference,
-		"TransactionDesc":  "Bike Ride",
-	}
-
-	payloadBytes, err := json.Marshal(payloadData)
-	if err != nil {
-		return nil, fmt.Errorf("error marshaling JSON: %v", err)
-	}
-
-	payload := bytes.NewReader(payloadBytes)
-
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, payload)
+		"Password":          os.Getenv("Safaricom_Password"),
+		"Timestamp":         "20231217153132",
+		"TransactionType":   "CustomerPayBillOnline",
+		"Amount":            amount.WholeUnits(), // M-Pesa only accepts whole shillings
+		"PartyA":            phone,
+		"PartyB":            174379,
+		"PhoneNumber":       phone,
+		"CallBackURL":       callbackURL("/api/v1/callback"),
+		"AccountReference":  order.OrderNumber,
+		"TransactionDesc":   "Order " + order.OrderNumber,
+	}
+	response, err := postToDaraja("/mpesa/stkpush/v1/processrequest", payloadData)
 	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
+		return nil, err
 	}
-
-	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Authorization", "Bearer "+accessToken)
-
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error sending request: %v", err)
+	checkoutRequestID, _ := response["CheckoutRequestID"].(string)
+	if code, _ := response["ResponseCode"].(string); code != "0" || checkoutRequestID == "" {
+		return nil, fmt.Errorf("stk push not accepted: %v", response["ResponseDescription"])
 	}
-	defer res.Body.Close()
+	return model.SaveSTKPayment(order, amount, phone, checkoutRequestID)
+}
 
-	_, err = ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %v", err)
+func stkErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrUnknownOrder):
+		return fiber.StatusNotFound
+	case errors.Is(err, model.ErrOrderClosed), errors.Is(err, model.ErrOrderSettled), errors.Is(err, model.ErrCurrencyNotPayable):
+		return fiber.StatusConflict
 	}
-
-	patientID := c.Get("X-Patient-ID") // From headers
-	fmt.Println(patientID+" ,")
-	// Return the payment details
-	return &payment, nil
+	return fiber.StatusInternalServerError
 }
 
 func generateAccessToken() (string, error) {
@@ -144,18 +133,8 @@ func generateAccessToken() (string, error) {
 	return accessToken, nil
 }
 
+// HandleCallback records the result of an STK push, settling its order when it was paid
 func HandleCallback(c *fiber.Ctx) error {
-	// Extract patientID and billingID from the request headers or body
-	patientID,_ := model.GetAuthUserID(c)
-	billingID := c.Get("X-Billing-ID") // From headers
-
-	
-	if patientID == uuid.Nil || billingID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Patient ID and Billing ID are required",
-		})
-	}
-
 	// Parse the callback payload
 	var callback struct {
 		Body struct {
@@ -175,75 +154,49 @@ func HandleCallback(c *fiber.Ctx) error {
 	}
 
 	if err := c.BodyParser(&callback); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid request payload",
-		})
+		log.Println("error parsing stk callback:", err.Error())
+		return c.Status(fiber.StatusBadRequest).JSON(c2bResult("1", "Invalid request payload"))
 	}
 
 	// Extract relevant fields
-	var (
-		amount          float64
-		transactionID   string
-		phoneNumber     string
-		transactionDate string
-	)
-
-	for _, item := range callback.Body.StkCallback.CallbackMetadata.Item {
+	stk := callback.Body.StkCallback
+	result := model.STKResult{
+		CheckoutRequestID: stk.CheckoutRequestID,
+		ResultCode:        stk.ResultCode,
+		ResultDesc:        stk.ResultDesc,
+	}
+	for _, item := range stk.CallbackMetadata.Item {
 		switch item.Name {
 		case "Amount":
-			amount = item.Value.(float64)
+			if value, ok := item.Value.(float64); ok {
+				result.Amount = model.MoneyFromFloat(value)
+			}
 		case "MpesaReceiptNumber":
-			transactionID = item.Value.(string)
+			result.ReceiptNumber = callbackValue(item.Value)
 		case "PhoneNumber":
-			phoneNumber = fmt.Sprintf("%v", item.Value) // Convert to string
+			result.PhoneNumber = callbackValue(item.Value)
 		case "TransactionDate":
-			transactionDate = fmt.Sprintf("%v", item.Value) // Convert to string
+			result.TransactionDate = callbackValue(item.Value)
 		}
 	}
 
-	// Determine payment status
-	paymentStatus := "Failed"
-	if callback.Body.StkCallback.ResultCode == 0 {
-		paymentStatus = "Completed"
-	}
-
-
-
-	
-
-	// Create a new Payments struct
-	payment := model.Payment{
-		ID:              uuid.New(),
-		CustomerID:       patientID, // Include patientID
-		Cost:            amount,
-		PaymentMethod:   "M-Pesa",
-		TransactionID:   transactionID,
-		PaymentStatus:   paymentStatus,
-		CustomerPhone:   phoneNumber,
-		AccountReference: "Health Bill", // You can dynamically set this based on your logic
-		TransactionDesc: "Payment for Order #123",
-		TransactionDate: transactionDate,
-	
+	_, err := model.RecordSTKResult(result)
+	switch {
+	case err == nil:
+	case errors.Is(err, model.ErrUnknownCheckout), errors.Is(err, model.ErrOrderClosed):
+		// safaricom will not take the money back, so the payment is left for manual refund
+		log.Println("refused stk payment", result.ReceiptNumber, "for", result.CheckoutRequestID+":", err.Error())
+	default:
+		log.Println("error recording stk payment", result.CheckoutRequestID+":", err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(c2bResult("1", "Failed to record payment"))
 	}
+	return c.JSON(c2bResult("0", "Accepted"))
+}
 
-	// Save the payment details to the database
-	db := database.ConnectDB()
-	if err := db.Create(&payment).Error; err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to save payment details",
-		})
+// callbackValue formats a callback item, whose numbers such as phone numbers arrive as JSON numbers
+func callbackValue(value interface{}) string {
+	if number, ok := value.(float64); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
 	}
-
-	// If payment is completed, update the billing record
-	/*if paymentStatus == "Completed" {
-		// Update the billing record where ID matches billingID
-		if err := db.Model(&model.Billing{}).Where("patient_id = ?", parsedPatientID).Update("paid", true).Error; err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Failed to update billing record",
-			})
-		}
-	}*/
-
-	// Return the payment details
-	return c.JSON(payment)
-}
\ No newline at end of file
+	return fmt.Sprintf("%v", value)
+}
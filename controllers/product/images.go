@@ -0,0 +1,88 @@
+package products
+
+import (
+	"errors"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// GetProductImagesHandler lists a product's images in display order
+func GetProductImagesHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid product id", fiber.StatusBadRequest)
+	}
+	response, err := model.GetProductImages(id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "product images retrieved successfully", fiber.StatusOK, response)
+}
+
+// AddProductImagesHandler uploads images for one of the seller's products
+func AddProductImagesHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid product id", fiber.StatusBadRequest)
+	}
+	response, err := model.AddProductImages(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), imageErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "product images added successfully", fiber.StatusOK, response)
+}
+
+// ReorderProductImagesHandler sets the display order of a product's images
+func ReorderProductImagesHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid product id", fiber.StatusBadRequest)
+	}
+	response, err := model.ReorderProductImages(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), imageErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "product images reordered successfully", fiber.StatusOK, response)
+}
+
+// SetPrimaryProductImageHandler makes an image the one shown in listings
+func SetPrimaryProductImageHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid image id", fiber.StatusBadRequest)
+	}
+	response, err := model.SetPrimaryProductImage(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), imageErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "primary image set successfully", fiber.StatusOK, response)
+}
+
+// DeleteProductImageHandler removes a product image
+func DeleteProductImageHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid image id", fiber.StatusBadRequest)
+	}
+	if err := model.DeleteProductImage(c, id); err != nil {
+		return utilities.ShowError(c, err.Error(), imageErrorStatus(err))
+	}
+	return utilities.ShowMessage(c, "product image deleted successfully", fiber.StatusOK)
+}
+
+func imageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrNotProductOwner):
+		return fiber.StatusForbidden
+	case errors.Is(err, model.ErrImageNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, utilities.ErrImageTooLarge):
+		return fiber.StatusRequestEntityTooLarge
+	case errors.Is(err, utilities.ErrUnsupportedType):
+		return fiber.StatusUnsupportedMediaType
+	}
+	return fiber.StatusBadRequest
+}
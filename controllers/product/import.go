@@ -0,0 +1,55 @@
+package products
+
+import (
+	"bufio"
+	"time"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// ImportProductsHandler starts a background import of a CSV of products
+func ImportProductsHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "seller" {
+		return utilities.ShowError(c, "unauthorized - seller access required", fiber.StatusUnauthorized)
+	}
+	response, err := model.StartProductImport(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusBadRequest)
+	}
+	return utilities.ShowSuccess(c, "product import started", fiber.StatusAccepted, response)
+}
+
+// GetImportJobHandler returns the progress and row errors of an import
+func GetImportJobHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid import id", fiber.StatusBadRequest)
+	}
+	response, err := model.GetImportJob(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusNotFound)
+	}
+	return utilities.ShowSuccess(c, "import retrieved successfully", fiber.StatusOK, response)
+}
+
+// ExportProductsHandler streams the seller's catalogue as CSV
+func ExportProductsHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "seller" {
+		return utilities.ShowError(c, "unauthorized - seller access required", fiber.StatusUnauthorized)
+	}
+	sellerID, err := model.GetAuthUserID(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusUnauthorized)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Attachment("products-" + time.Now().Format("2006-01-02") + ".csv")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		model.ExportProductsCSV(sellerID, w)
+		w.Flush()
+	})
+	return nil
+}
@@ -0,0 +1,21 @@
+package products
+
+import (
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// AdjustStockHandler adds or removes stock of one of the seller's products with a reason
+func AdjustStockHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid product id", fiber.StatusBadRequest)
+	}
+	response, err := model.AdjustStock(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), variantErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "stock adjusted successfully", fiber.StatusOK, response)
+}
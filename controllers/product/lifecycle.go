@@ -0,0 +1,72 @@
+package products
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const defaultProductScheduleInterval = time.Minute
+
+// SetProductStatusHandler drafts, publishes, archives or schedules one of the seller's products
+func SetProductStatusHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid product id", fiber.StatusBadRequest)
+	}
+	response, err := model.SetProductStatus(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), variantErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "product status updated successfully", fiber.StatusOK, response)
+}
+
+// GetArchivedProductsHandler lists the seller's archived and deleted products
+func GetArchivedProductsHandler(c *fiber.Ctx) error {
+	id, _ := model.GetAuthUserID(c)
+	response, err := model.GetArchivedProducts(id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "archived products retrieved successfully", fiber.StatusOK, response)
+}
+
+// RestoreProductHandler puts an archived or deleted product back on sale
+func RestoreProductHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid product id", fiber.StatusBadRequest)
+	}
+	response, err := model.RestoreProduct(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), variantErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "product restored successfully", fiber.StatusOK, response)
+}
+
+/*
+publishes and unpublishes scheduled products every PRODUCT_SCHEDULE_INTERVAL
+(default 1m). Meant to be started in its own goroutine.
+*/
+func StartProductScheduler() {
+	interval, err := time.ParseDuration(os.Getenv("PRODUCT_SCHEDULE_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = defaultProductScheduleInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		published, unpublished, err := model.RunProductSchedule()
+		if err != nil {
+			log.Println("product schedule run:", err.Error())
+		}
+		if published > 0 || unpublished > 0 {
+			log.Println("product schedule run: published", published, "and unpublished", unpublished, "products")
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package products
 
 import (
-	"strconv"
+	"errors"
+
 	"github.com/dancankarani/palace/model"
 	"github.com/dancankarani/palace/utilities"
 	"github.com/gofiber/fiber/v2"
@@ -10,6 +11,9 @@ import (
 
 func AddProductHandler(c *fiber.Ctx)error{
 	clothe, err := model.AddProduct(c)
+	if errors.Is(err, model.ErrUnverifiedAccount){
+		return utilities.ShowError(c, err.Error(),fiber.StatusForbidden)
+	}
 	if err != nil{
 		return utilities.ShowError(c, err.Error(),fiber.StatusInternalServerError)
 	}
@@ -19,6 +23,9 @@ func AddProductHandler(c *fiber.Ctx)error{
 func UpdateProductHandler(c *fiber.Ctx)error{
 	id, _:=uuid.Parse(c.Params("id"))
 	clothe, err := model.UpdateProduct(c,id)
+	if errors.Is(err, model.ErrNotProductOwner){
+		return utilities.ShowError(c,err.Error(),fiber.StatusForbidden)
+	}
 	if err != nil{
 		return utilities.ShowError(c,err.Error(),fiber.StatusInternalServerError)
 	}
@@ -36,6 +43,9 @@ func GetAllProductsHandler(c *fiber.Ctx)error{
 func DeleteProductHandler(c *fiber.Ctx)error{
 	id, _:=uuid.Parse(c.Params("id"))
 	err := model.DeleteProduct(c,id)
+	if errors.Is(err, model.ErrNotProductOwner){
+		return utilities.ShowError(c,err.Error(),fiber.StatusForbidden)
+	}
 	if err != nil{
 		return utilities.ShowError(c,err.Error(),fiber.StatusInternalServerError)
 	}
@@ -43,7 +53,10 @@ func DeleteProductHandler(c *fiber.Ctx)error{
 }
 
 func GetProductsByPriceHandler(c *fiber.Ctx)error{
-	maxPrice, _ := strconv.ParseFloat(c.Query("maxPrice", "0"), 64)
+	maxPrice, err := model.ParseMoney(c.Query("maxPrice", "0"))
+	if err != nil{
+		return utilities.ShowError(c,err.Error(),fiber.StatusBadRequest)
+	}
 	response, err := model.GetProductsByPrice(maxPrice)
 	if err != nil{
 		return utilities.ShowError(c,err.Error(),fiber.StatusInternalServerError)
@@ -52,8 +65,14 @@ func GetProductsByPriceHandler(c *fiber.Ctx)error{
 }
 
 func GetProductsByCategory(c *fiber.Ctx)error{
-	category := c.Query("categories","unisex")
+	category := c.Query("category",c.Query("categories"))
+	if category == ""{
+		return utilities.ShowError(c,"category is required",fiber.StatusBadRequest)
+	}
 	response, err := model.GetProductsByCategory(category)
+	if errors.Is(err, model.ErrCategoryNotFound){
+		return utilities.ShowError(c,err.Error(),fiber.StatusNotFound)
+	}
 	if err != nil{
 		return utilities.ShowError(c,err.Error(),fiber.StatusInternalServerError)
 	}
@@ -67,4 +86,16 @@ func GetSellersProductHandler(c *fiber.Ctx)error{
 		return utilities.ShowError(c,err.Error(),fiber.StatusInternalServerError)
 	}
 	return utilities.ShowSuccess(c,"seller's products retrieved successfully",fiber.StatusOK,response)
-}
\ No newline at end of file
+}
+// QueryProductsHandler searches the public catalogue with filters, sorting and cursor pagination
+func QueryProductsHandler(c *fiber.Ctx)error{
+	query := model.ProductQuery{}
+	if err := c.QueryParser(&query); err != nil{
+		return utilities.ShowError(c,"invalid query parameters: "+err.Error(),fiber.StatusBadRequest)
+	}
+	response, err := model.QueryProducts(query)
+	if err != nil{
+		return utilities.ShowError(c,err.Error(),fiber.StatusBadRequest)
+	}
+	return utilities.ShowSuccess(c,"products retrieved successfully",fiber.StatusOK,response)
+}
@@ -0,0 +1,110 @@
+package products
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// GetProductReviewsHandler returns a product's rating summary and a page of its reviews
+func GetProductReviewsHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid product id", fiber.StatusBadRequest)
+	}
+	page, _ := strconv.Atoi(c.Query("page", "1"))
+	limit, _ := strconv.Atoi(c.Query("limit", "10"))
+	response, err := model.GetProductReviews(id, c.Query("sort"), page, limit)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusNotFound)
+	}
+	return utilities.ShowSuccess(c, "product reviews retrieved successfully", fiber.StatusOK, response)
+}
+
+// CreateProductReviewHandler reviews a delivered order item
+func CreateProductReviewHandler(c *fiber.Ctx) error {
+	response, err := model.CreateProductReview(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), reviewErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "review added successfully", fiber.StatusCreated, response)
+}
+
+// UpdateProductReviewHandler edits one of the user's reviews
+func UpdateProductReviewHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid review id", fiber.StatusBadRequest)
+	}
+	response, err := model.UpdateProductReview(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), reviewErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "review updated successfully", fiber.StatusOK, response)
+}
+
+// DeleteProductReviewHandler deletes one of the user's reviews
+func DeleteProductReviewHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid review id", fiber.StatusBadRequest)
+	}
+	if err := model.DeleteProductReview(c, id); err != nil {
+		return utilities.ShowError(c, err.Error(), reviewErrorStatus(err))
+	}
+	return utilities.ShowMessage(c, "review deleted successfully", fiber.StatusOK)
+}
+
+// ReplyToProductReviewHandler sets the seller's reply to a review of their product
+func ReplyToProductReviewHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid review id", fiber.StatusBadRequest)
+	}
+	response, err := model.ReplyToProductReview(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), reviewErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "reply saved successfully", fiber.StatusOK, response)
+}
+
+// VoteReviewHelpfulHandler marks a review as helpful
+func VoteReviewHelpfulHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid review id", fiber.StatusBadRequest)
+	}
+	response, err := model.VoteReviewHelpful(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), reviewErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "review marked as helpful", fiber.StatusOK, response)
+}
+
+// RemoveReviewVoteHandler takes back a helpful vote
+func RemoveReviewVoteHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid review id", fiber.StatusBadRequest)
+	}
+	if err := model.RemoveReviewVote(c, id); err != nil {
+		return utilities.ShowError(c, err.Error(), reviewErrorStatus(err))
+	}
+	return utilities.ShowMessage(c, "vote removed successfully", fiber.StatusOK)
+}
+
+func reviewErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrReviewNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, model.ErrNotReviewAuthor), errors.Is(err, model.ErrNotProductOwner), errors.Is(err, model.ErrReviewNotAllowed):
+		return fiber.StatusForbidden
+	case errors.Is(err, model.ErrAlreadyReviewed), errors.Is(err, model.ErrAlreadyVoted):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusBadRequest
+}
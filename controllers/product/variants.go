@@ -0,0 +1,121 @@
+package products
+
+import (
+	"errors"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// GetProductVariantsHandler lists the sizes and colours of a product
+func GetProductVariantsHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid product id", fiber.StatusBadRequest)
+	}
+	response, err := model.GetProductVariants(id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "product variants retrieved successfully", fiber.StatusOK, response)
+}
+
+// AddProductVariantHandler adds a variant to one of the seller's products
+func AddProductVariantHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid product id", fiber.StatusBadRequest)
+	}
+	response, err := model.AddProductVariant(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), variantErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "product variant added successfully", fiber.StatusOK, response)
+}
+
+// UpdateProductVariantHandler updates a variant of one of the seller's products
+func UpdateProductVariantHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid variant id", fiber.StatusBadRequest)
+	}
+	response, err := model.UpdateProductVariant(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), variantErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "product variant updated successfully", fiber.StatusOK, response)
+}
+
+// DeleteProductVariantHandler removes a variant of one of the seller's products
+func DeleteProductVariantHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid variant id", fiber.StatusBadRequest)
+	}
+	if err := model.DeleteProductVariant(c, id); err != nil {
+		return utilities.ShowError(c, err.Error(), variantErrorStatus(err))
+	}
+	return utilities.ShowMessage(c, "product variant deleted successfully", fiber.StatusOK)
+}
+
+func GetProductsByGenderHandler(c *fiber.Ctx) error {
+	gender := c.Query("gender", "unisex")
+	response, err := model.GetProductsByGender(gender)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "successfull retrieved clothes by gender", fiber.StatusOK, response)
+}
+
+// GetCategoryAttributesHandler lists the variant attributes defined for a category
+func GetCategoryAttributesHandler(c *fiber.Ctx) error {
+	response, err := model.GetCategoryAttributes(c.Query("category"))
+	if errors.Is(err, model.ErrCategoryNotFound) {
+		return utilities.ShowError(c, err.Error(), fiber.StatusNotFound)
+	}
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "category attributes retrieved successfully", fiber.StatusOK, response)
+}
+
+// SaveCategoryAttributeHandler adds or replaces a category attribute definition
+func SaveCategoryAttributeHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	response, err := model.SaveCategoryAttribute(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusBadRequest)
+	}
+	return utilities.ShowSuccess(c, "category attribute saved successfully", fiber.StatusOK, response)
+}
+
+// DeleteCategoryAttributeHandler removes a category attribute definition
+func DeleteCategoryAttributeHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid attribute id", fiber.StatusBadRequest)
+	}
+	if err := model.DeleteCategoryAttribute(id); err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowMessage(c, "category attribute deleted successfully", fiber.StatusOK)
+}
+
+func variantErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrNotProductOwner):
+		return fiber.StatusForbidden
+	case errors.Is(err, model.ErrVariantNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, model.ErrInsufficientStock):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusBadRequest
+}
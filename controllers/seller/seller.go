@@ -0,0 +1,47 @@
+package seller
+
+import (
+	"strconv"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetBalanceHandler returns the authenticated seller's balance, ledger and payout history
+func GetBalanceHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "seller" {
+		return utilities.ShowError(c, "unauthorized - seller access required", fiber.StatusUnauthorized)
+	}
+	id, err := model.GetAuthUserID(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusUnauthorized)
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	if limit <= 0 || limit > 500 {
+		limit = 50
+	}
+	response, err := model.GetSellerBalanceSummary(id, limit)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "seller balance retrieved successfully", fiber.StatusOK, response)
+}
+
+// GetInventoryHandler lists the stock movements of the authenticated seller's products
+func GetInventoryHandler(c *fiber.Ctx) error {
+	id, err := model.GetAuthUserID(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusUnauthorized)
+	}
+	filter := model.InventoryFilter{}
+	if err := c.QueryParser(&filter); err != nil {
+		return utilities.ShowError(c, "invalid query parameters", fiber.StatusBadRequest)
+	}
+	response, err := model.GetInventoryMovements(id, filter)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusBadRequest)
+	}
+	return utilities.ShowSuccess(c, "inventory movements retrieved successfully", fiber.StatusOK, response)
+}
@@ -0,0 +1,60 @@
+package seller
+
+import (
+	"errors"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetStorefrontHandler returns a seller's public shop page by id or shop slug
+func GetStorefrontHandler(c *fiber.Ctx) error {
+	query := model.StorefrontQuery{}
+	if err := c.QueryParser(&query); err != nil {
+		return utilities.ShowError(c, "invalid query parameters", fiber.StatusBadRequest)
+	}
+	response, err := model.GetStorefront(c.Params("id"), query)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), storefrontErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "storefront retrieved successfully", fiber.StatusOK, response)
+}
+
+// UpdateShopProfileHandler edits the authenticated seller's shop profile
+func UpdateShopProfileHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "seller" {
+		return utilities.ShowError(c, "unauthorized - seller access required", fiber.StatusUnauthorized)
+	}
+	response, err := model.UpdateShopProfile(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), storefrontErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "shop profile updated successfully", fiber.StatusOK, response)
+}
+
+// FollowShopHandler follows a seller's shop
+func FollowShopHandler(c *fiber.Ctx) error {
+	if err := model.FollowShop(c, c.Params("id")); err != nil {
+		return utilities.ShowError(c, err.Error(), storefrontErrorStatus(err))
+	}
+	return utilities.ShowMessage(c, "shop followed successfully", fiber.StatusOK)
+}
+
+// UnfollowShopHandler stops following a seller's shop
+func UnfollowShopHandler(c *fiber.Ctx) error {
+	if err := model.UnfollowShop(c, c.Params("id")); err != nil {
+		return utilities.ShowError(c, err.Error(), storefrontErrorStatus(err))
+	}
+	return utilities.ShowMessage(c, "shop unfollowed successfully", fiber.StatusOK)
+}
+
+func storefrontErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrSellerNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, model.ErrShopSlugTaken):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusBadRequest
+}
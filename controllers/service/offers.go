@@ -0,0 +1,169 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// GetServicePackagesHandler lists the priced tiers of a service
+func GetServicePackagesHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid service id", fiber.StatusBadRequest)
+	}
+	response, err := model.GetServicePackages(id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "service packages retrieved successfully", fiber.StatusOK, response)
+}
+
+// SaveServicePackageHandler adds or replaces a basic, standard or premium package of the seller's service
+func SaveServicePackageHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid service id", fiber.StatusBadRequest)
+	}
+	response, err := model.SaveServicePackage(c, id, model.PackageTier(c.Params("tier")))
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), offerErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "service package saved successfully", fiber.StatusOK, response)
+}
+
+// DeleteServicePackageHandler removes a package of the seller's service
+func DeleteServicePackageHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid service id", fiber.StatusBadRequest)
+	}
+	if err := model.DeleteServicePackage(c, id, model.PackageTier(c.Params("tier"))); err != nil {
+		return utilities.ShowError(c, err.Error(), offerErrorStatus(err))
+	}
+	return utilities.ShowMessage(c, "service package deleted successfully", fiber.StatusOK)
+}
+
+// OrderServicePackageHandler orders a service package for the customer
+func OrderServicePackageHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid package id", fiber.StatusBadRequest)
+	}
+	response, err := model.OrderServicePackage(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), offerErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "order placed successfully", fiber.StatusOK, response)
+}
+
+// DeliverServiceOrderHandler marks a paid package or offer order as delivered, for the buyer to confirm
+func DeliverServiceOrderHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid order id", fiber.StatusBadRequest)
+	}
+	response, err := model.DeliverServiceOrder(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusBadRequest)
+	}
+	return utilities.ShowSuccess(c, "order delivered, waiting for the buyer to confirm it", fiber.StatusOK, response)
+}
+
+// CreateQuoteRequestHandler asks the seller of a service for a quote
+func CreateQuoteRequestHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid service id", fiber.StatusBadRequest)
+	}
+	response, err := model.CreateQuoteRequest(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), offerErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "quote request sent successfully", fiber.StatusOK, response)
+}
+
+// GetQuoteRequestsHandler lists the user's quote requests, or with role=seller the ones sent to them
+func GetQuoteRequestsHandler(c *fiber.Ctx) error {
+	userID, err := model.GetAuthUserID(c)
+	if err != nil {
+		return utilities.ShowError(c, "unauthorized", fiber.StatusUnauthorized)
+	}
+	filter := model.QuoteFilter{}
+	if err := c.QueryParser(&filter); err != nil {
+		return utilities.ShowError(c, "invalid query parameters", fiber.StatusBadRequest)
+	}
+	response, err := model.GetQuoteRequests(userID, filter)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "quote requests retrieved successfully", fiber.StatusOK, response)
+}
+
+// CloseQuoteRequestHandler withdraws or declines a quote request
+func CloseQuoteRequestHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid quote request id", fiber.StatusBadRequest)
+	}
+	response, err := model.CloseQuoteRequest(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), offerErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "quote request closed successfully", fiber.StatusOK, response)
+}
+
+// SendCustomOfferHandler answers a quote request with a custom offer
+func SendCustomOfferHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid quote request id", fiber.StatusBadRequest)
+	}
+	response, err := model.SendCustomOffer(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), offerErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "offer sent successfully", fiber.StatusOK, response)
+}
+
+// AcceptCustomOfferHandler accepts a custom offer and opens its order
+func AcceptCustomOfferHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid offer id", fiber.StatusBadRequest)
+	}
+	response, err := model.AcceptCustomOffer(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), offerErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "offer accepted successfully", fiber.StatusOK, response)
+}
+
+// RejectCustomOfferHandler declines an offer for the customer or withdraws it for the seller
+func RejectCustomOfferHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid offer id", fiber.StatusBadRequest)
+	}
+	response, err := model.RejectCustomOffer(c, id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), offerErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "offer closed successfully", fiber.StatusOK, response)
+}
+
+func offerErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrServiceNotFound), errors.Is(err, model.ErrPackageNotFound),
+		errors.Is(err, model.ErrQuoteNotFound), errors.Is(err, model.ErrOfferNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, model.ErrNotServiceOwner), errors.Is(err, model.ErrNotQuoteParty), errors.Is(err, model.ErrUnverifiedAccount):
+		return fiber.StatusForbidden
+	case errors.Is(err, model.ErrQuoteState), errors.Is(err, model.ErrOfferState):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusBadRequest
+}
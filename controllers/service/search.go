@@ -0,0 +1,41 @@
+package service
+
+import (
+	"errors"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+)
+
+// SearchServicesHandler searches active services by text, category, price, seller rating and location
+func SearchServicesHandler(c *fiber.Ctx) error {
+	query := model.ServiceQuery{}
+	if err := c.QueryParser(&query); err != nil {
+		return utilities.ShowError(c, "invalid query parameters", fiber.StatusBadRequest)
+	}
+	response, err := model.SearchServices(query)
+	if errors.Is(err, model.ErrCategoryNotFound) {
+		return utilities.ShowError(c, err.Error(), fiber.StatusNotFound)
+	}
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusBadRequest)
+	}
+	return utilities.ShowSuccess(c, "services retrieved successfully", fiber.StatusOK, response)
+}
+
+// GetAllServicesHandler lists every matching service as a bare array, the shape /all has always had
+func GetAllServicesHandler(c *fiber.Ctx) error {
+	query := model.ServiceQuery{}
+	if err := c.QueryParser(&query); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid query parameters"})
+	}
+	services, err := model.AllServices(query)
+	if errors.Is(err, model.ErrCategoryNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(services)
+}
@@ -1,6 +1,8 @@
 package user
 
 import (
+	"errors"
+	"strconv"
 	"time"
 
 	"github.com/dancankarani/palace/middleware"
@@ -15,46 +17,98 @@ type ResponseUser struct{
 }
 type loginResponse struct {
 	Token string `json:"token"`
+	TwoFactorRequired bool `json:"two_factor_required,omitempty"`
+	TwoFactorSetupRequired bool `json:"two_factor_setup_required,omitempty"`
+	LinkRequired bool `json:"link_required,omitempty"` //the token is for linking a sign-in provider with the password
 }
 
+const (
+	sessionLifetime = time.Hour*24
+	twoFactorLoginLifetime = time.Minute*10
+)
+
 func Login(c *fiber.Ctx)error{
-	user := model.User{}
-	if err := c.BodyParser(&user); err !=nil {
-		return utilities.ShowError(c,"failed to login",fiber.StatusInternalServerError)
+	body := model.LoginRequest{}
+	if err := c.BodyParser(&body); err !=nil {
+		return utilities.ShowError(c,"failed to parse JSON data",fiber.StatusBadRequest)
+	}
+
+	//find the account by email or phone number and check the password, the role comes from the account
+	existingUser,err := model.Authenticate(body,c.IP(),c.Get(fiber.HeaderUserAgent))
+	if err != nil{
+		return utilities.ShowError(c,err.Error(),loginErrorStatus(c,err))
 	}
 
-	//check of user exist
-	userExist,existingUser,_:= model.UserExist(c,user.PhoneNumber,user.UserRole)
-	if ! userExist {
-		return utilities.ShowError(c,"user does not exist",fiber.StatusNotFound)
+	return finishLogin(c,existingUser)
+}
+
+/*
+signs in a user whose password, or sign-in provider, checked out. Accounts with two-factor
+authentication are only given a token for the second step.
+@params user
+*/
+func finishLogin(c *fiber.Ctx,existingUser *model.User)error{
+	//a short-lived token that only works for the second step
+	if existingUser.TwoFactorEnabledAt != nil{
+		tokenString,err := middleware.GenerateToken(middleware.Claims{UserID: &existingUser.ID,Role:existingUser.UserRole,Scope:middleware.ScopeTwoFactor},twoFactorLoginLifetime)
+		if err != nil{
+			return utilities.ShowError(c,err.Error(),fiber.StatusInternalServerError)
+		}
+		return utilities.ShowSuccess(c,"enter the code from your authenticator app",fiber.StatusOK,loginResponse{Token: tokenString,TwoFactorRequired: true})
 	}
-	
-	//compare password
-	err :=utilities.CompareHashAndPassowrd(existingUser.Password,user.Password)
-	if err !=nil{
-		return utilities.ShowError(c,err.Error(),fiber.StatusForbidden)
-			 
+	//roles that require it can only set up two-factor authentication until they have
+	if model.TwoFactorRequired(existingUser.UserRole){
+		tokenString,err := startSession(c,existingUser,middleware.ScopeTwoFactorSetup,twoFactorLoginLifetime)
+		if err != nil{
+			return utilities.ShowError(c,err.Error(),fiber.StatusInternalServerError)
+		}
+		return utilities.ShowSuccess(c,"set up two-factor authentication to continue",fiber.StatusOK,loginResponse{Token: tokenString,TwoFactorSetupRequired: true})
 	}
-	exp :=time.Hour*24
-	//generating token
-	tokenString,err := middleware.GenerateToken(middleware.Claims{UserID: &existingUser.ID,Role:existingUser.UserRole},exp)
+
+	tokenString,err := startSession(c,existingUser,"",sessionLifetime)
 	if err != nil{
 		return utilities.ShowError(c,err.Error(),fiber.StatusInternalServerError)
 	}
+	response_user:=loginResponse{
+		Token: tokenString,
+	}
+
+	return utilities.ShowSuccess(c,"successfully logged in",fiber.StatusOK,response_user)	
+}
+
+/*
+generates a token for the user and sets it as the Authorization cookie
+@params user
+@params scope
+@params expiration time
+*/
+func startSession(c *fiber.Ctx,user *model.User,scope string,exp time.Duration)(string,error){
+	tokenString,err := middleware.GenerateToken(middleware.Claims{UserID: &user.ID,Role:user.UserRole,Scope:scope},exp)
+	if err != nil{
+		return "",err
+	}
 	//set token cookie 
 	c.Cookie(&fiber.Cookie{
 		Name:     "Authorization",
 		Value:    tokenString,
-		Expires:  time.Now().Add(time.Hour * 24), // Same duration as the token
+		Expires:  time.Now().Add(exp), // Same duration as the token
 		HTTPOnly: true, // Important for security, prevents JavaScript access
 		Secure:   true, // Use secure cookies in production
 		Path:     "/",  // Make the cookie available on all routes
 	})
-	response_user:=loginResponse{
-		Token: tokenString,
-	}
+	return tokenString,nil
+}
 
-	return utilities.ShowSuccess(c,"successfully logged in",fiber.StatusOK,response_user)	
+func loginErrorStatus(c *fiber.Ctx,err error)int{
+	var blocked *model.LoginBlockedError
+	switch {
+	case errors.As(err,&blocked):
+		c.Set(fiber.HeaderRetryAfter,strconv.Itoa(int(blocked.RetryAfter.Seconds())+1))
+		return fiber.StatusTooManyRequests
+	case errors.Is(err,model.ErrInvalidCredentials),errors.Is(err,model.ErrInvalidTwoFactorCode):
+		return fiber.StatusUnauthorized
+	}
+	return fiber.StatusInternalServerError
 }
 
 //logut user
@@ -6,34 +6,22 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
-type User struct{
-	FullName string `json:"full_name"`
-	Email string 		`json:"email"`
-	PhoneNumber string `json:"phone_number"`
+type forgotPasswordRequest struct {
+	Email string `json:"email"`
 }
 
+// ForgotPassword emails a reset link. The response is the same whether or not the account exists.
 func ForgotPassword(c *fiber.Ctx) error {
-	
-	user := User{}
-	if err := c.BodyParser(&user);err!=nil {
-		return utilities.ShowError(c,"failed to parse JSON data",fiber.StatusInternalServerError)
+	body := forgotPasswordRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		return utilities.ShowError(c, "failed to parse JSON data", fiber.StatusBadRequest)
 	}
-
-	//checking if the user with the given email and phone number exists
-	found_user,err :=model.FindUser(user.Email,user.PhoneNumber)
-	if err != nil {
-		return utilities.ShowError(c, err.Error(),fiber.StatusNotFound)
+	if body.Email == "" {
+		return utilities.ShowError(c, "email is required", fiber.StatusBadRequest)
 	}
 
-	//generate code and expiration time
-	code,exp_time:=utilities.GenerateCode()
-	err = model.AddResetCode(c,user.PhoneNumber,user.Email,code,exp_time)
-	if err !=  nil {
-		return utilities.ShowError(c,err.Error(),fiber.StatusInternalServerError)
-	}
+	//looked up and sent in the background so the response time doesn't give the account away either
+	go model.RequestPasswordReset(body.Email)
 
-	//send the code password reset code
-	go utilities.SendEmail(user.Email,code,exp_time)
-	
-	return utilities.ShowSuccess(c,"link sent to your email",fiber.StatusOK,User{found_user.FirstName,found_user.Email,found_user.PhoneNumber})
-}
\ No newline at end of file
+	return utilities.ShowMessage(c, "if an account with that email exists, a password reset link has been sent to it", fiber.StatusOK)
+}
@@ -10,16 +10,7 @@ import (
 )
 
 func JWTMiddleware(c *fiber.Ctx) error {
-    // Check for token in cookies first
-    tokenString := c.Cookies("Authorization")
-    log.Println(tokenString)
-    // If not found in cookies, check the Authorization header
-    if tokenString == "" {
-        authHeader := c.Get("Authorization")
-        if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
-            tokenString = strings.TrimPrefix(authHeader, "Bearer ")
-        }
-    }
+    tokenString := requestToken(c)
 
     // If token is still not found, return unauthorized error
     if tokenString == "" {
@@ -36,8 +27,32 @@ func JWTMiddleware(c *fiber.Ctx) error {
     //get ipd address and store in context
     ip := c.IP()
     c.Locals("ip_address", ip)
+    //tokens from the middle of a two-factor login only open the two-factor routes
+    switch claims.Scope {
+    case middleware.ScopeTwoFactor:
+        return utilities.ShowError(c, "unauthorized", fiber.StatusUnauthorized)
+    case middleware.ScopeTwoFactorSetup:
+        if !strings.HasPrefix(c.Path(), "/api/v1/user/2fa") {
+            return utilities.ShowError(c, "set up two-factor authentication to continue", fiber.StatusForbidden)
+        }
+    }
     // Store the userID in context
     c.Locals("user_id", claims.UserID)
     c.Locals("role",claims.Role)
+    c.Locals("scope",claims.Scope)
     return c.Next()
 }
+
+// requestToken reads the JWT from the Authorization cookie, or else the Authorization header
+func requestToken(c *fiber.Ctx) string {
+    // Check for token in cookies first
+    tokenString := c.Cookies("Authorization")
+    // If not found in cookies, check the Authorization header
+    if tokenString == "" {
+        authHeader := c.Get("Authorization")
+        if authHeader != "" && strings.HasPrefix(authHeader, "Bearer ") {
+            tokenString = strings.TrimPrefix(authHeader, "Bearer ")
+        }
+    }
+    return tokenString
+}
@@ -0,0 +1,66 @@
+package user
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const defaultNotificationEmailInterval = time.Minute
+
+// GetNotificationsHandler lists the authenticated user's notifications
+func GetNotificationsHandler(c *fiber.Ctx) error {
+	id, err := model.GetAuthUserID(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusUnauthorized)
+	}
+	response, err := model.GetNotifications(id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "notifications retrieved successfully", fiber.StatusOK, response)
+}
+
+// MarkNotificationReadHandler marks one of the user's notifications as read
+func MarkNotificationReadHandler(c *fiber.Ctx) error {
+	userID, err := model.GetAuthUserID(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusUnauthorized)
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid notification id", fiber.StatusBadRequest)
+	}
+	if err := model.MarkNotificationRead(userID, id); err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowMessage(c, "notification marked as read", fiber.StatusOK)
+}
+
+/*
+emails notifications such as low stock alerts and booking reminders on
+NOTIFICATION_EMAIL_INTERVAL (default 1m), once the change that raised them is
+committed. Meant to be started in its own goroutine.
+*/
+func StartNotificationEmailScheduler() {
+	interval, err := time.ParseDuration(os.Getenv("NOTIFICATION_EMAIL_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = defaultNotificationEmailInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		sent, err := model.SendNotificationEmails()
+		if err != nil {
+			log.Println("notification email run:", err.Error())
+		}
+		if sent > 0 {
+			log.Println("notification email run: emailed", sent, "notifications")
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package user
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/oidc"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+)
+
+// oidcStateCookie ties a sign-in to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+// OIDCLoginHandler sends the user to sign in with a provider such as Google
+func OIDCLoginHandler(c *fiber.Ctx) error {
+	authURL, state, err := model.StartOIDCLogin(c.Params("provider"))
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), oidcErrorStatus(err))
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcStateCookie,
+		Value:    oidc.StateBinding(state),
+		Expires:  time.Now().Add(oidc.StateLifetime),
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: fiber.CookieSameSiteLaxMode, // sent along when the provider redirects back
+		Path:     "/api/v1/user/oidc",
+	})
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// OIDCCallbackHandler signs in the user the provider sent back to the browser that started the sign-in
+func OIDCCallbackHandler(c *fiber.Ctx) error {
+	binding := c.Cookies(oidcStateCookie)
+	// a state is only good for one callback
+	c.Cookie(&fiber.Cookie{Name: oidcStateCookie, Expires: time.Unix(0, 0), HTTPOnly: true, Secure: true, Path: "/api/v1/user/oidc"})
+	if c.Query("error") != "" {
+		return utilities.ShowError(c, "sign-in was cancelled or refused by the provider", fiber.StatusUnauthorized)
+	}
+	if !oidc.StateBound(binding, c.Query("state")) {
+		return utilities.ShowError(c, model.ErrOIDCStateInvalid.Error(), fiber.StatusUnauthorized)
+	}
+	user, err := model.FinishOIDCLogin(c.Params("provider"), c.Query("code"), c.Query("state"), c.IP(), c.Get(fiber.HeaderUserAgent))
+	var link *model.OIDCLinkRequiredError
+	if errors.As(err, &link) {
+		return utilities.ShowSuccess(c, link.Error(), fiber.StatusOK, loginResponse{Token: link.Token, LinkRequired: true})
+	}
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), oidcErrorStatus(err))
+	}
+	return finishLogin(c, user)
+}
+
+type oidcLinkRequest struct {
+	Token    string `json:"token"` // from the callback that asked for the password
+	Password string `json:"password"`
+}
+
+// OIDCLinkHandler links a provider to the existing account with its email address once the password checks out
+func OIDCLinkHandler(c *fiber.Ctx) error {
+	body := oidcLinkRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		return utilities.ShowError(c, "failed to parse JSON data", fiber.StatusBadRequest)
+	}
+	user, err := model.LinkOIDCIdentity(body.Token, body.Password, c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		if errors.Is(err, model.ErrOIDCStateInvalid) {
+			return utilities.ShowError(c, err.Error(), fiber.StatusUnauthorized)
+		}
+		return utilities.ShowError(c, err.Error(), loginErrorStatus(c, err))
+	}
+	return finishLogin(c, user)
+}
+
+func oidcErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, oidc.ErrUnknownProvider), errors.Is(err, oidc.ErrProviderNotEnabled):
+		return fiber.StatusNotFound
+	case errors.Is(err, model.ErrOIDCStateInvalid), errors.Is(err, model.ErrOIDCEmailUnverified), errors.Is(err, model.ErrInvalidCredentials):
+		return fiber.StatusUnauthorized
+	}
+	return fiber.StatusBadGateway
+}
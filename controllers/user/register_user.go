@@ -2,7 +2,6 @@ package user
 
 import (
 	"log"
-	"time"
 
 	"github.com/dancankarani/palace/database"
 	"github.com/dancankarani/palace/model"
@@ -25,6 +24,8 @@ func CreateUserAccount(c *fiber.Ctx) error {
 
 		return utilities.ShowError(c,"failed to create account", fiber.StatusInternalServerError)
 	}
+	//every account starts as a customer, sellers are approved through a seller application
+	user.UserRole = "customer"
 
 	//validate email address
 	_,err:=utilities.ValidateEmail(user.Email)
@@ -63,9 +64,7 @@ func CreateUserAccount(c *fiber.Ctx) error {
 
 	userModel := model.User{ BaseModel: model.BaseModel{
         ID: id, 
-    },FirstName: user.FirstName,LastName:user.LastName,Email: user.Email,PhoneNumber: user.PhoneNumber,Password: hashed_password,ResetCode: "",UserRole: user.UserRole,}
-	//create user
-	userModel.CodeExpirationTime=time.Now()
+    },FirstName: user.FirstName,LastName:user.LastName,Email: user.Email,PhoneNumber: user.PhoneNumber,Password: hashed_password,UserRole: user.UserRole,}
 
 	//create user model
 	err = db.Create(&userModel).Error
@@ -73,6 +72,8 @@ func CreateUserAccount(c *fiber.Ctx) error {
 		log.Fatal(err.Error())
 		return utilities.ShowError(c, "failed to add data to the database",fiber.StatusInternalServerError)
 	}
+	//send the email link and phone code without holding up the response
+	go model.SendAccountVerifications(id)
 	
-	return utilities.ShowMessage(c,"account created successfully",fiber.StatusOK)
+	return utilities.ShowMessage(c,"account created successfully, verify your email address and phone number to start shopping",fiber.StatusOK)
 }
\ No newline at end of file
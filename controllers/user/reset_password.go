@@ -1,21 +1,38 @@
 package user
 
 import (
-	//"time"
+	"errors"
 
 	"github.com/dancankarani/palace/model"
-	"github.com/dancankarani/palace/password"
 	"github.com/dancankarani/palace/utilities"
 	"github.com/gofiber/fiber/v2"
 )
 
-func ResetPassword(c *fiber.Ctx)error{
-	user := model.User{}
-	if err := c.BodyParser(&user); err != nil{
-		return utilities.ShowError(c,"failed to parse Json data",fiber.StatusInternalServerError)
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// ResetPassword sets a new password with the token from a reset link and signs out every session
+func ResetPassword(c *fiber.Ctx) error {
+	body := resetPasswordRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		return utilities.ShowError(c, "failed to parse JSON data", fiber.StatusBadRequest)
+	}
+
+	if err := model.ResetPasswordWithToken(body.Token, body.Password, c.IP()); err != nil {
+		return utilities.ShowError(c, err.Error(), resetPasswordErrorStatus(err))
 	}
+	return utilities.ShowMessage(c, "password changed successfully, sign in with your new password", fiber.StatusOK)
+}
 
-	//call reset password
-	password.ResetPassword(c,user.Email,user.PhoneNumber,user.Password,user.ResetCode)
-	return utilities.ShowMessage(c,"password is changed succefully",fiber.StatusOK)
-}
\ No newline at end of file
+func resetPasswordErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrInvalidResetToken), errors.Is(err, model.ErrWeakPassword):
+		return fiber.StatusBadRequest
+	case errors.Is(err, model.ErrTooManyResetAttempts):
+		return fiber.StatusTooManyRequests
+	default:
+		return fiber.StatusInternalServerError
+	}
+}
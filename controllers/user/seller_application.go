@@ -0,0 +1,127 @@
+package user
+
+import (
+	"errors"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// GetSellerApplicationHandler returns the authenticated user's seller application
+func GetSellerApplicationHandler(c *fiber.Ctx) error {
+	id, err := model.GetAuthUserID(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusUnauthorized)
+	}
+	response, err := model.GetSellerApplication(id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), applicationErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "seller application retrieved successfully", fiber.StatusOK, response)
+}
+
+// SaveSellerApplicationHandler saves the business details and ID document of a seller application
+func SaveSellerApplicationHandler(c *fiber.Ctx) error {
+	response, err := model.SaveSellerApplication(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), applicationErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "seller application saved successfully", fiber.StatusOK, response)
+}
+
+// SendPayoutPhoneCodeHandler texts a verification code to the application's payout number
+func SendPayoutPhoneCodeHandler(c *fiber.Ctx) error {
+	if err := model.SendPayoutPhoneCode(c); err != nil {
+		return utilities.ShowError(c, err.Error(), applicationErrorStatus(err))
+	}
+	return utilities.ShowMessage(c, "verification code sent", fiber.StatusOK)
+}
+
+// VerifyPayoutPhoneHandler confirms the application's payout number
+func VerifyPayoutPhoneHandler(c *fiber.Ctx) error {
+	response, err := model.VerifyPayoutPhone(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), applicationErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "payout number verified successfully", fiber.StatusOK, response)
+}
+
+// SubmitSellerApplicationHandler sends a completed seller application for review
+func SubmitSellerApplicationHandler(c *fiber.Ctx) error {
+	response, err := model.SubmitSellerApplication(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), applicationErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, "seller application submitted successfully", fiber.StatusOK, response)
+}
+
+// GetSellerApplicationsHandler lists seller applications for admins, filtered by ?status=
+func GetSellerApplicationsHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	response, err := model.GetSellerApplications(c.Query("status"))
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "seller applications retrieved successfully", fiber.StatusOK, response)
+}
+
+// ApproveSellerApplicationHandler makes the applicant a verified seller
+func ApproveSellerApplicationHandler(c *fiber.Ctx) error {
+	return reviewSellerApplication(c, true, "seller application approved successfully")
+}
+
+// RejectSellerApplicationHandler turns down a seller application with a reason
+func RejectSellerApplicationHandler(c *fiber.Ctx) error {
+	return reviewSellerApplication(c, false, "seller application rejected successfully")
+}
+
+func reviewSellerApplication(c *fiber.Ctx, approve bool, message string) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid application id", fiber.StatusBadRequest)
+	}
+	response, err := model.ReviewSellerApplication(c, id, approve)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), applicationErrorStatus(err))
+	}
+	return utilities.ShowSuccess(c, message, fiber.StatusOK, response)
+}
+
+// GetSellerApplicationDocumentHandler sends an admin the ID document of a seller application
+func GetSellerApplicationDocumentHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return utilities.ShowError(c, "invalid application id", fiber.StatusBadRequest)
+	}
+	data, contentType, err := model.GetSellerApplicationDocument(id)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), applicationErrorStatus(err))
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderCacheControl, "private, no-store")
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderContentDisposition, "inline")
+	return c.Send(data)
+}
+
+func applicationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrApplicationNotFound), errors.Is(err, model.ErrIDDocumentNotFound):
+		return fiber.StatusNotFound
+	case errors.Is(err, model.ErrApplicationState), errors.Is(err, model.ErrAlreadySeller):
+		return fiber.StatusConflict
+	case errors.Is(err, model.ErrUnverifiedAccount):
+		return fiber.StatusForbidden
+	}
+	return fiber.StatusBadRequest
+}
@@ -0,0 +1,142 @@
+package user
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/dancankarani/palace/middleware"
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+)
+
+type twoFactorLoginRequest struct {
+	Token string `json:"token"` // from the first step of the login, or sent as the bearer token
+	Code  string `json:"code"`  // from the authenticator app, or a recovery code
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	Token         string   `json:"token,omitempty"`
+}
+
+// LoginTwoFactor finishes a two-factor login with a code and signs the user in
+func LoginTwoFactor(c *fiber.Ctx) error {
+	body := twoFactorLoginRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		return utilities.ShowError(c, "failed to parse JSON data", fiber.StatusBadRequest)
+	}
+	if body.Token == "" {
+		body.Token = requestToken(c)
+	}
+	claims, err := middleware.ValidateToken(body.Token)
+	if err != nil || claims.Scope != middleware.ScopeTwoFactor || claims.UserID == nil {
+		return utilities.ShowError(c, "the login has expired, sign in again", fiber.StatusUnauthorized)
+	}
+
+	user, err := model.VerifySecondFactor(*claims.UserID, body.Code)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), loginErrorStatus(c, err))
+	}
+	//the first step token is spent
+	if err := middleware.InvalidateToken(body.Token); err != nil {
+		return utilities.ShowError(c, "failed to login", fiber.StatusInternalServerError)
+	}
+	tokenString, err := startSession(c, user, "", sessionLifetime)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "successfully logged in", fiber.StatusOK, loginResponse{Token: tokenString})
+}
+
+// BeginTwoFactorSetupHandler returns a new authenticator secret and its QR provisioning URI
+func BeginTwoFactorSetupHandler(c *fiber.Ctx) error {
+	response, err := model.BeginTwoFactorSetup(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), twoFactorErrorStatus(c, err))
+	}
+	return utilities.ShowSuccess(c, "scan the code with your authenticator app, then confirm a code from it", fiber.StatusOK, response)
+}
+
+// EnableTwoFactorHandler turns two-factor authentication on and returns the recovery codes
+func EnableTwoFactorHandler(c *fiber.Ctx) error {
+	codes, err := model.EnableTwoFactor(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), twoFactorErrorStatus(c, err))
+	}
+	response := recoveryCodesResponse{RecoveryCodes: codes}
+
+	//a user made to set up two-factor authentication at login is now signed in fully
+	if c.Locals("scope") == middleware.ScopeTwoFactorSetup {
+		id, err := model.GetAuthUserID(c)
+		if err != nil {
+			return utilities.ShowError(c, err.Error(), fiber.StatusUnauthorized)
+		}
+		user := &model.User{BaseModel: model.BaseModel{ID: id}, UserRole: model.GetAuthUser(c)}
+		if err := middleware.InvalidateToken(requestToken(c)); err != nil {
+			return utilities.ShowError(c, "failed to invalidate the token", fiber.StatusInternalServerError)
+		}
+		response.Token, err = startSession(c, user, "", sessionLifetime)
+		if err != nil {
+			return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+		}
+	}
+	return utilities.ShowSuccess(c, "two-factor authentication turned on, keep your recovery codes somewhere safe", fiber.StatusOK, response)
+}
+
+// DisableTwoFactorHandler turns two-factor authentication off with the password and a code
+func DisableTwoFactorHandler(c *fiber.Ctx) error {
+	if err := model.DisableTwoFactor(c); err != nil {
+		return utilities.ShowError(c, err.Error(), twoFactorErrorStatus(c, err))
+	}
+	return utilities.ShowMessage(c, "two-factor authentication turned off", fiber.StatusOK)
+}
+
+// RegenerateRecoveryCodesHandler replaces the user's recovery codes with the password and a code
+func RegenerateRecoveryCodesHandler(c *fiber.Ctx) error {
+	codes, err := model.RegenerateRecoveryCodes(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), twoFactorErrorStatus(c, err))
+	}
+	return utilities.ShowSuccess(c, "recovery codes replaced, the old ones no longer work", fiber.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// GetTwoFactorPoliciesHandler lists for admins whether each role has to use two-factor authentication
+func GetTwoFactorPoliciesHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	response, err := model.GetTwoFactorPolicies()
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusInternalServerError)
+	}
+	return utilities.ShowSuccess(c, "two-factor policies retrieved successfully", fiber.StatusOK, response)
+}
+
+// SetTwoFactorPolicyHandler lets an admin require two-factor authentication for the admin or seller role
+func SetTwoFactorPolicyHandler(c *fiber.Ctx) error {
+	if model.GetAuthUser(c) != "admin" {
+		return utilities.ShowError(c, "unauthorized - admin access required", fiber.StatusUnauthorized)
+	}
+	response, err := model.SetTwoFactorPolicy(c)
+	if err != nil {
+		return utilities.ShowError(c, err.Error(), fiber.StatusBadRequest)
+	}
+	return utilities.ShowSuccess(c, "two-factor policy saved successfully", fiber.StatusOK, response)
+}
+
+func twoFactorErrorStatus(c *fiber.Ctx, err error) int {
+	var blocked *model.LoginBlockedError
+	switch {
+	case errors.As(err, &blocked):
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(blocked.RetryAfter.Seconds())+1))
+		return fiber.StatusTooManyRequests
+	case errors.Is(err, model.ErrInvalidTwoFactorCode), errors.Is(err, model.ErrInvalidCredentials):
+		return fiber.StatusUnauthorized
+	case errors.Is(err, model.ErrTwoFactorEnabled), errors.Is(err, model.ErrTwoFactorNotEnabled), errors.Is(err, model.ErrTwoFactorNotStarted):
+		return fiber.StatusConflict
+	case errors.Is(err, model.ErrTwoFactorRequired):
+		return fiber.StatusForbidden
+	}
+	return fiber.StatusBadRequest
+}
@@ -0,0 +1,51 @@
+package user
+
+import (
+	"errors"
+
+	"github.com/dancankarani/palace/model"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+)
+
+// VerifyEmailHandler verifies an email address from the link in the verification email
+func VerifyEmailHandler(c *fiber.Ctx) error {
+	if err := model.VerifyEmailToken(c.Query("token")); err != nil {
+		return utilities.ShowError(c, err.Error(), verificationErrorStatus(err))
+	}
+	return utilities.ShowMessage(c, "email address verified successfully", fiber.StatusOK)
+}
+
+// ResendEmailVerificationHandler emails the user a new verification link
+func ResendEmailVerificationHandler(c *fiber.Ctx) error {
+	if err := model.ResendEmailVerification(c); err != nil {
+		return utilities.ShowError(c, err.Error(), verificationErrorStatus(err))
+	}
+	return utilities.ShowMessage(c, "verification email sent", fiber.StatusOK)
+}
+
+// SendPhoneVerificationHandler texts the user a new verification code
+func SendPhoneVerificationHandler(c *fiber.Ctx) error {
+	if err := model.ResendPhoneVerification(c); err != nil {
+		return utilities.ShowError(c, err.Error(), verificationErrorStatus(err))
+	}
+	return utilities.ShowMessage(c, "verification code sent", fiber.StatusOK)
+}
+
+// VerifyPhoneHandler verifies the user's phone number with the texted code
+func VerifyPhoneHandler(c *fiber.Ctx) error {
+	if err := model.VerifyPhoneCode(c); err != nil {
+		return utilities.ShowError(c, err.Error(), verificationErrorStatus(err))
+	}
+	return utilities.ShowMessage(c, "phone number verified successfully", fiber.StatusOK)
+}
+
+func verificationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, model.ErrTooManyCodes):
+		return fiber.StatusTooManyRequests
+	case errors.Is(err, model.ErrAlreadyVerified):
+		return fiber.StatusConflict
+	}
+	return fiber.StatusBadRequest
+}
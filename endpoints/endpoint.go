@@ -1,18 +1,26 @@
 package endpoints
 
 import (
+	"os"
+
+	"github.com/dancankarani/palace/routes/admin"
 	"github.com/dancankarani/palace/routes/carts"
+	"github.com/dancankarani/palace/routes/categories"
 	"github.com/dancankarani/palace/routes/orders"
 	"github.com/dancankarani/palace/routes/payments"
 	"github.com/dancankarani/palace/routes/product"
+	"github.com/dancankarani/palace/routes/sellers"
 	"github.com/dancankarani/palace/routes/service"
 	"github.com/dancankarani/palace/routes/users"
+	"github.com/dancankarani/palace/utilities"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 )
 
 func CreateEndpoint() {
-	app := fiber.New()
+	app := fiber.New(fiber.Config{
+		BodyLimit: 256 << 20, // room for product CSV imports with a zip of images
+	})
 	
 	// Add CORS middleware
 	app.Use(cors.New(cors.Config{
@@ -20,12 +28,20 @@ func CreateEndpoint() {
 		AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH,OPTIONS",
 		AllowHeaders: "Origin, Content-Type, Accept, Authorization", 
 	}))
+	// serve uploads when they are kept on the local filesystem
+	if os.Getenv("BLOB_STORE") == "local" {
+		app.Static(utilities.LocalStorageURL(), utilities.LocalStorageDir())
+	}
 	users.SetUserRoutes(app)
 	product.SetProductsRoutes(app)
+	categories.SetCategoryRoutes(app)
 	carts.SetCartRoutes(app)
 	orders.SetOrdersRoutes(app)
 	service.SetServicesRoutes(app)
 	payments.SetPaymentsRoutes(app)
+	sellers.SetStorefrontRoutes(app)
+	sellers.SetSellerRoutes(app)
+	admin.SetAdminRoutes(app)
 	//port
 	app.Listen(":8000")
 }
\ No newline at end of file
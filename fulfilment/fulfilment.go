@@ -0,0 +1,60 @@
+/*
+Package fulfilment moves an order from being placed to being received. A seller can only
+mark an order shipped; it is delivered once the buyer confirms they received it, or once the
+window they had to confirm it has passed. Only a delivered order is paid out to its seller.
+*/
+package fulfilment
+
+import (
+	"errors"
+	"time"
+)
+
+// Status is where an order is in its fulfilment
+type Status string
+
+const (
+	Processing Status = "Processing"
+	Shipped    Status = "Shipped" // sent or done by the seller, waiting for the buyer to confirm
+	Delivered  Status = "Delivered"
+	Cancelled  Status = "Cancelled"
+)
+
+// Event is something that happens to an order
+type Event string
+
+const (
+	Ship        Event = "ship"         // the seller sent the items or delivered the service
+	Confirm     Event = "confirm"      // the buyer received the order
+	AutoConfirm Event = "auto confirm" // the buyer did not confirm within the window
+	Cancel      Event = "cancel"
+)
+
+// DefaultConfirmWindow is how long a buyer has to confirm a shipped order
+const DefaultConfirmWindow = 7 * 24 * time.Hour
+
+var ErrTransition = errors.New("the order can't be moved to that status")
+
+var transitions = map[Status]map[Event]Status{
+	Processing: {Ship: Shipped, Cancel: Cancelled},
+	Shipped:    {Confirm: Delivered, AutoConfirm: Delivered},
+}
+
+/*
+Next returns the status an order moves to on an event, or ErrTransition when the event
+can't happen to an order in its current status
+@params current status
+@params event
+*/
+func Next(current Status, event Event) (Status, error) {
+	next, ok := transitions[current][event]
+	if !ok {
+		return current, ErrTransition
+	}
+	return next, nil
+}
+
+// Received reports whether the buyer has an order, so its seller is paid for it and its items can be reviewed
+func Received(status Status) bool {
+	return status == Delivered
+}
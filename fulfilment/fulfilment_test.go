@@ -0,0 +1,108 @@
+package fulfilment
+
+import (
+	"errors"
+	"testing"
+	"testing/quick"
+)
+
+func TestNext(t *testing.T) {
+	cases := []struct {
+		from  Status
+		event Event
+		to    Status
+		ok    bool
+	}{
+		{Processing, Ship, Shipped, true},
+		{Processing, Cancel, Cancelled, true},
+		{Processing, Confirm, Processing, false},
+		{Processing, AutoConfirm, Processing, false},
+		{Shipped, Confirm, Delivered, true},
+		{Shipped, AutoConfirm, Delivered, true},
+		{Shipped, Ship, Shipped, false},
+		{Shipped, Cancel, Shipped, false},
+		{Delivered, Confirm, Delivered, false},
+		{Delivered, Cancel, Delivered, false},
+		{Cancelled, Ship, Cancelled, false},
+		{Cancelled, Confirm, Cancelled, false},
+	}
+	for _, tc := range cases {
+		to, err := Next(tc.from, tc.event)
+		if tc.ok && (err != nil || to != tc.to) {
+			t.Errorf("%s on %s: got %s, %v, want %s", tc.event, tc.from, to, err, tc.to)
+		}
+		if !tc.ok && (!errors.Is(err, ErrTransition) || to != tc.from) {
+			t.Errorf("%s on %s: got %s, %v, want ErrTransition", tc.event, tc.from, to, err)
+		}
+	}
+}
+
+func TestReceived(t *testing.T) {
+	for status, want := range map[Status]bool{Processing: false, Shipped: false, Delivered: true, Cancelled: false} {
+		if got := Received(status); got != want {
+			t.Errorf("Received(%s) = %v, want %v", status, got, want)
+		}
+	}
+}
+
+// an order is only paid out once it was shipped and then confirmed by its buyer or their
+// window ran out, whatever happens to it in between
+func TestOrderIsReceivedOnlyAfterShippingAndConfirmation(t *testing.T) {
+	events := []Event{Ship, Confirm, AutoConfirm, Cancel}
+	property := func(picks []uint8) bool {
+		status := Processing
+		shipped, confirmed := false, false
+		for _, pick := range picks {
+			event := events[int(pick)%len(events)]
+			next, err := Next(status, event)
+			if err != nil {
+				continue
+			}
+			switch {
+			case event == Ship:
+				shipped = true
+			case (event == Confirm || event == AutoConfirm) && shipped:
+				confirmed = true
+			}
+			status = next
+		}
+		return Received(status) == (shipped && confirmed)
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// the seller can't get an order paid out by themselves
+func TestSellerAloneCannotDeliver(t *testing.T) {
+	status := Processing
+	for i := 0; i < 3; i++ {
+		if next, err := Next(status, Ship); err == nil {
+			status = next
+		}
+	}
+	if Received(status) {
+		t.Errorf("shipping alone made the order %s", status)
+	}
+}
+
+// a buyer can review an item as a verified purchase only once they have it
+func TestItemsAreReviewableOnceReceived(t *testing.T) {
+	for _, confirm := range []Event{Confirm, AutoConfirm} {
+		status := Processing
+		if Received(status) {
+			t.Fatalf("reviewable while %s", status)
+		}
+		status, _ = Next(status, Ship)
+		if Received(status) {
+			t.Fatalf("reviewable while %s", status)
+		}
+		status, _ = Next(status, confirm)
+		if !Received(status) {
+			t.Errorf("not reviewable after %s", confirm)
+		}
+	}
+	if cancelled, _ := Next(Processing, Cancel); Received(cancelled) {
+		t.Error("reviewable after the order was cancelled")
+	}
+}
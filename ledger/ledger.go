@@ -0,0 +1,118 @@
+/*
+Package ledger builds the double-entry journal entries the marketplace posts. Every entry
+debits exactly what it credits, so the balances of all accounts always sum to zero.
+*/
+package ledger
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dancankarani/palace/money"
+	"github.com/google/uuid"
+)
+
+// well-known ledger accounts
+const (
+	MpesaClearingAccount   = "mpesa:clearing"
+	PlatformRevenueAccount = "platform:revenue"
+	RefundsPayableAccount  = "refunds:payable" // refunds owed to customers until they are sent
+)
+
+func CustomerAccount(userID uuid.UUID) string { return "customer:" + userID.String() }
+func SellerAccount(sellerID uuid.UUID) string { return "seller:" + sellerID.String() }
+
+// Line is one side of a journal entry before it is posted
+type Line struct {
+	AccountCode string
+	Debit       money.Money
+	Credit      money.Money
+}
+
+/*
+Validate checks a journal entry has at least two lines, each a positive debit or a positive
+credit, and that its debits equal its credits
+@params lines
+*/
+func Validate(lines ...Line) error {
+	if len(lines) < 2 {
+		return errors.New("a journal entry needs at least two lines")
+	}
+	debits, credits := money.New(0), money.New(0)
+	for _, line := range lines {
+		if line.Debit.IsNegative() || line.Credit.IsNegative() || line.Debit.IsZero() == line.Credit.IsZero() {
+			return fmt.Errorf("invalid ledger line for %s: exactly one of debit and credit must be positive", line.AccountCode)
+		}
+		var err error
+		if debits, err = debits.Add(line.Debit); err != nil {
+			return err
+		}
+		if credits, err = credits.Add(line.Credit); err != nil {
+			return err
+		}
+	}
+	if cmp, err := debits.Cmp(credits); err != nil || cmp != 0 {
+		return fmt.Errorf("unbalanced journal entry: debits %s != credits %s", debits, credits)
+	}
+	return nil
+}
+
+// PaymentReceived moves money received from a customer into the M-Pesa clearing account
+func PaymentReceived(customerID uuid.UUID, amount money.Money) []Line {
+	return []Line{
+		{AccountCode: MpesaClearingAccount, Debit: amount},
+		{AccountCode: CustomerAccount(customerID), Credit: amount},
+	}
+}
+
+// RefundOwed takes a refund off the customer's funds until it is sent back to them
+func RefundOwed(customerID uuid.UUID, amount money.Money) []Line {
+	return []Line{
+		{AccountCode: CustomerAccount(customerID), Debit: amount},
+		{AccountCode: RefundsPayableAccount, Credit: amount},
+	}
+}
+
+// RefundSent records a refund leaving the M-Pesa clearing account
+func RefundSent(amount money.Money) []Line {
+	return []Line{
+		{AccountCode: RefundsPayableAccount, Debit: amount},
+		{AccountCode: MpesaClearingAccount, Credit: amount},
+	}
+}
+
+/*
+Sale moves a delivered item's value from the customer to the seller, net of the platform
+commission, which goes to the platform's revenue
+@params customer_id
+@params seller_id
+@params gross
+@params net
+@params commission
+*/
+func Sale(customerID, sellerID uuid.UUID, gross, net, commission money.Money) []Line {
+	lines := []Line{
+		{AccountCode: CustomerAccount(customerID), Debit: gross},
+		{AccountCode: SellerAccount(sellerID), Credit: net},
+	}
+	if commission.IsPositive() {
+		lines = append(lines, Line{AccountCode: PlatformRevenueAccount, Credit: commission})
+	}
+	return lines
+}
+
+// Payout records a seller payout leaving the M-Pesa clearing account
+func Payout(sellerID uuid.UUID, amount money.Money) []Line {
+	return []Line{
+		{AccountCode: SellerAccount(sellerID), Debit: amount},
+		{AccountCode: MpesaClearingAccount, Credit: amount},
+	}
+}
+
+// PayoutReversal returns a failed payout to the seller
+func PayoutReversal(sellerID uuid.UUID, amount money.Money) []Line {
+	return []Line{
+		{AccountCode: MpesaClearingAccount, Debit: amount},
+		{AccountCode: SellerAccount(sellerID), Credit: amount},
+	}
+}
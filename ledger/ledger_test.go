@@ -0,0 +1,128 @@
+package ledger
+
+import (
+	"testing"
+	"testing/quick"
+
+	"github.com/dancankarani/palace/money"
+	"github.com/google/uuid"
+)
+
+// books applies journal entries to account balances, debits positive and credits negative
+type books map[string]int64
+
+func (b books) post(t *testing.T, lines []Line) bool {
+	t.Helper()
+	if err := Validate(lines...); err != nil {
+		t.Log(err)
+		return false
+	}
+	var sum int64
+	for _, line := range lines {
+		b[line.AccountCode] += line.Debit.Minor - line.Credit.Minor
+		sum += line.Debit.Minor - line.Credit.Minor
+	}
+	return sum == 0
+}
+
+func (b books) total() int64 {
+	var total int64
+	for _, balance := range b {
+		total += balance
+	}
+	return total
+}
+
+// the customer pays for an item, it is delivered and the seller is paid out, then a payout
+// that failed is returned to them
+func TestSaleAndPayoutEntriesBalance(t *testing.T) {
+	customer, seller := uuid.New(), uuid.New()
+	property := func(price uint32, basisPoints uint16, payoutFails bool) bool {
+		gross := money.New(int64(price) + 1)
+		// commission below half, so the seller always has something to be paid out
+		commission := gross.Percent(int64(basisPoints % 5000))
+		net, err := gross.Sub(commission)
+		if err != nil {
+			return false
+		}
+
+		b := books{}
+		ok := b.post(t, PaymentReceived(customer, gross)) &&
+			b.post(t, Sale(customer, seller, gross, net, commission)) &&
+			b.post(t, Payout(seller, net))
+		if payoutFails {
+			ok = ok && b.post(t, PayoutReversal(seller, net))
+		}
+		if !ok || b.total() != 0 {
+			return false
+		}
+
+		// the customer's funds went to the seller and the platform, and what was paid out left M-Pesa
+		sellerOwed := int64(0)
+		if payoutFails {
+			sellerOwed = -net.Minor
+		}
+		return b[CustomerAccount(customer)] == 0 &&
+			b[SellerAccount(seller)] == sellerOwed &&
+			b[PlatformRevenueAccount] == -commission.Minor &&
+			b[MpesaClearingAccount] == gross.Minor-net.Minor-sellerOwed
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+// the customer pays, the payment is refunded and the refund is sent back to them
+func TestRefundEntriesBalance(t *testing.T) {
+	customer := uuid.New()
+	property := func(price uint32, sent bool) bool {
+		amount := money.New(int64(price) + 1)
+		b := books{}
+		ok := b.post(t, PaymentReceived(customer, amount)) && b.post(t, RefundOwed(customer, amount))
+		if !ok || b.total() != 0 {
+			return false
+		}
+		// until it is sent the refund is owed and the money is still held
+		if b[CustomerAccount(customer)] != 0 || b[RefundsPayableAccount] != -amount.Minor || b[MpesaClearingAccount] != amount.Minor {
+			return false
+		}
+		if !sent {
+			return true
+		}
+		if !b.post(t, RefundSent(amount)) || b.total() != 0 {
+			return false
+		}
+		return b[RefundsPayableAccount] == 0 && b[MpesaClearingAccount] == 0
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSaleWithoutCommissionHasTwoLines(t *testing.T) {
+	gross := money.New(1000)
+	lines := Sale(uuid.New(), uuid.New(), gross, gross, money.New(0))
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lines))
+	}
+	if err := Validate(lines...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidateRejectsBadEntries(t *testing.T) {
+	kes, usd := money.Money{Minor: 100, Currency: "KES"}, money.Money{Minor: 100, Currency: "USD"}
+	cases := map[string][]Line{
+		"one line":       {{AccountCode: MpesaClearingAccount, Debit: kes}},
+		"unbalanced":     {{AccountCode: MpesaClearingAccount, Debit: kes}, {AccountCode: PlatformRevenueAccount, Credit: money.Money{Minor: 99, Currency: "KES"}}},
+		"both sides":     {{AccountCode: MpesaClearingAccount, Debit: kes, Credit: kes}, {AccountCode: PlatformRevenueAccount, Credit: kes}},
+		"neither side":   {{AccountCode: MpesaClearingAccount}, {AccountCode: PlatformRevenueAccount, Credit: kes}},
+		"negative":       {{AccountCode: MpesaClearingAccount, Debit: kes.Neg()}, {AccountCode: PlatformRevenueAccount, Credit: kes.Neg()}},
+		"two currencies": {{AccountCode: MpesaClearingAccount, Debit: kes}, {AccountCode: PlatformRevenueAccount, Credit: usd}},
+	}
+	for name, lines := range cases {
+		if err := Validate(lines...); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+}
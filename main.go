@@ -3,6 +3,10 @@ package main
 import (
 	"fmt"
 
+	"github.com/dancankarani/palace/controllers/booking"
+	"github.com/dancankarani/palace/controllers/payment"
+	"github.com/dancankarani/palace/controllers/product"
+	"github.com/dancankarani/palace/controllers/user"
 	"github.com/dancankarani/palace/database"
 	"github.com/dancankarani/palace/endpoints"
 	"github.com/dancankarani/palace/model"
@@ -11,6 +15,12 @@ import (
 func main() {
 	fmt.Println(".....")
 	model.MigrateDB()
+	model.ReindexProducts()
+	model.FailInterruptedImports()
+	go payment.StartPayoutScheduler()
+	go products.StartProductScheduler()
+	go booking.StartBookingReminderScheduler()
+	go user.StartNotificationEmailScheduler()
     endpoints.CreateEndpoint()
     database.ConnectDB()
 }
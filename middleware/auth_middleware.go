@@ -13,9 +13,18 @@ import (
 	"github.com/joho/godotenv"
 )
 
+const revokedTokensKey = "revoked_tokens"
+
+//scopes of tokens handed out part way through a two-factor login
+const (
+	ScopeTwoFactor      = "2fa"       //password checked, waiting for the authenticator code
+	ScopeTwoFactorSetup = "2fa_setup" //signed in, but has to set up two-factor authentication first
+)
+
 type Claims struct {
 	UserID *uuid.UUID `json:"user_id"`
 	Role string `json:"role"`
+	Scope string `json:"scope,omitempty"`
 	jwt.StandardClaims
 }
 //the function loads .env and return the secretKey
@@ -37,6 +46,7 @@ func GenerateToken(claims Claims,expiration_time time.Duration) (string, error)
 	my_secret_key := LoadSecretKey()
 	claims.StandardClaims = jwt.StandardClaims{
 		ExpiresAt: time.Now().Add(expiration_time).Unix(),
+		IssuedAt:  time.Now().Unix(),
 		Issuer:    "pentabyte",
 		
 	}
@@ -66,13 +76,22 @@ func ValidateToken(tokenString string)(*Claims,error){
 	if ! ok{
 		return nil, errors.New("invalid user token")
 	}
-	isRevoked, err := database.RedisClient().SIsMember(context.Background(),"revoked_tokens",tokenString).Result()
+	redisClient := database.RedisClient()
+	isRevoked, err := redisClient.SIsMember(context.Background(),revokedTokensKey,tokenString).Result()
 	if err != nil{
 		return nil, err
 	}
 	if isRevoked{
 		return nil, errors.New("user token is revoked")
 	}
+	//tokens issued before the user's sessions were revoked, for example by a password reset.
+	//IssuedAt is in whole seconds, so a token from the second of the revocation is refused too
+	if claims.UserID != nil{
+		revokedBefore, err := redisClient.Get(context.Background(),revokedBeforeKey(*claims.UserID)).Int64()
+		if err == nil && claims.IssuedAt <= revokedBefore{
+			return nil, errors.New("user token is revoked")
+		}
+	}
 	return claims,nil
 }
 
@@ -81,13 +100,26 @@ Invalidates token when logged out
 @params tokenString
 */
 func InvalidateToken(tokenString string)error{
-	err := database.RedisClient().SAdd(context.Background(), "revoked_token", tokenString).Err()
+	err := database.RedisClient().SAdd(context.Background(), revokedTokensKey, tokenString).Err()
 	if err != nil{
 		return err
 	}
 	return nil
 }
 
+/*
+Revokes every token of a user issued until now, signing them out everywhere
+@params user_id
+*/
+func RevokeUserTokens(userID uuid.UUID)error{
+	//kept longer than any token lives
+	return database.RedisClient().Set(context.Background(), revokedBeforeKey(userID), time.Now().Unix(), 30*24*time.Hour).Err()
+}
+
+func revokedBeforeKey(userID uuid.UUID)string{
+	return "tokens_revoked_before:"+userID.String()
+}
+
 /*
 gets the users id from the token
 @params claims *Claims
@@ -0,0 +1,743 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dancankarani/palace/fulfilment"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	bookingSlotStep        = 30 * time.Minute
+	bookingHorizon         = 90 * 24 * time.Hour // how far ahead customers can book
+	defaultReminderBefore  = 24 * time.Hour
+	defaultCancelNotice    = 24 * time.Hour
+	defaultPaymentWindow   = time.Hour // how long a pending booking waits to be paid
+	defaultBookingTimeZone = "Africa/Nairobi"
+)
+
+var (
+	ErrBookingNotFound   = errors.New("booking not found")
+	ErrSlotUnavailable   = errors.New("the seller is not available at that time")
+	ErrNotBookingParty   = errors.New("you are not part of this booking")
+	ErrBookingState      = errors.New("the booking cannot be changed in its current state")
+	ErrServiceNotFound   = errors.New("service not found")
+	ErrOwnServiceBooking = errors.New("you cannot book your own service")
+	ErrBookingUnpaid     = errors.New("the booking has to be paid first")
+)
+
+// BookingLocation is the time zone availability windows are given in, read from BOOKING_TIMEZONE
+func BookingLocation() *time.Location {
+	name := os.Getenv("BOOKING_TIMEZONE")
+	if name == "" {
+		name = defaultBookingTimeZone
+	}
+	if location, err := time.LoadLocation(name); err == nil {
+		return location
+	}
+	// East Africa Time when the zone database is missing
+	return time.FixedZone("EAT", 3*60*60)
+}
+
+// durationEnv reads a duration such as "24h" from an environment variable
+func durationEnv(key string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(os.Getenv(key)); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+// parseClock turns "09:30" into minutes after midnight
+func parseClock(s string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", s)
+	}
+	hours, err1 := strconv.Atoi(parts[0])
+	minutes, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || hours < 0 || hours > 24 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("invalid time %q, use HH:MM", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+type availabilityRequest struct {
+	Windows []struct {
+		Weekday int    `json:"weekday"`
+		Start   string `json:"start"`
+		End     string `json:"end"`
+	} `json:"windows"`
+}
+
+/*
+replaces the seller's weekly availability
+*/
+func SetAvailability(c *fiber.Ctx) (*[]AvailabilityWindow, error) {
+	sellerID, _ := GetAuthUserID(c)
+	body := availabilityRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing availability:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	windows := []AvailabilityWindow{}
+	for _, w := range body.Windows {
+		if w.Weekday < 0 || w.Weekday > 6 {
+			return nil, errors.New("weekday must be between 0 (Sunday) and 6 (Saturday)")
+		}
+		start, err := parseClock(w.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(w.End)
+		if err != nil {
+			return nil, err
+		}
+		if end <= start {
+			return nil, errors.New("a window must end after it starts")
+		}
+		for _, other := range windows {
+			if other.Weekday == w.Weekday && start < other.EndMinute && other.StartMinute < end {
+				return nil, errors.New("windows on the same day must not overlap")
+			}
+		}
+		windows = append(windows, AvailabilityWindow{BaseModel: BaseModel{ID: uuid.New()}, SellerID: sellerID, Weekday: w.Weekday, StartMinute: start, EndMinute: end})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("seller_id = ?", sellerID).Delete(&AvailabilityWindow{}).Error; err != nil {
+			return err
+		}
+		if len(windows) == 0 {
+			return nil
+		}
+		return tx.Create(&windows).Error
+	})
+	if err != nil {
+		log.Println("error saving availability:", err.Error())
+		return nil, errors.New("failed to save availability")
+	}
+	return &windows, nil
+}
+
+// Availability is a seller's weekly windows and upcoming time off
+type Availability struct {
+	TimeZone string               `json:"time_zone"`
+	Windows  []AvailabilityWindow `json:"windows"`
+	TimeOff  []TimeOff            `json:"time_off"`
+}
+
+/*
+gets a seller's weekly availability and upcoming time off
+@params seller_id
+*/
+func GetAvailability(sellerID uuid.UUID) (*Availability, error) {
+	availability := Availability{TimeZone: BookingLocation().String(), Windows: []AvailabilityWindow{}, TimeOff: []TimeOff{}}
+	if err := db.Where("seller_id = ?", sellerID).Order("weekday, start_minute").Find(&availability.Windows).Error; err != nil {
+		log.Println("error fetching availability:", err.Error())
+		return nil, errors.New("failed to fetch availability")
+	}
+	if err := db.Where("seller_id = ? AND ends_at > ?", sellerID, time.Now()).Order("starts_at").Find(&availability.TimeOff).Error; err != nil {
+		log.Println("error fetching time off:", err.Error())
+		return nil, errors.New("failed to fetch availability")
+	}
+	return &availability, nil
+}
+
+/*
+blocks a period of the seller's calendar
+*/
+func AddTimeOff(c *fiber.Ctx) (*TimeOff, error) {
+	sellerID, _ := GetAuthUserID(c)
+	timeOff := TimeOff{}
+	if err := c.BodyParser(&timeOff); err != nil {
+		log.Println("error parsing time off:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	if !timeOff.EndsAt.After(timeOff.StartsAt) {
+		return nil, errors.New("ends_at must be after starts_at")
+	}
+	timeOff.BaseModel = BaseModel{ID: uuid.New()}
+	timeOff.SellerID = sellerID
+	if err := db.Create(&timeOff).Error; err != nil {
+		log.Println("error saving time off:", err.Error())
+		return nil, errors.New("failed to save time off")
+	}
+	return &timeOff, nil
+}
+
+/*
+removes one of the seller's time off periods
+@params time_off_id
+*/
+func DeleteTimeOff(c *fiber.Ctx, timeOffID uuid.UUID) error {
+	sellerID, _ := GetAuthUserID(c)
+	result := db.Unscoped().Where("id = ? AND seller_id = ?", timeOffID, sellerID).Delete(&TimeOff{})
+	if result.Error != nil {
+		log.Println("error deleting time off:", result.Error.Error())
+		return errors.New("failed to delete time off")
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("time off not found")
+	}
+	return nil
+}
+
+/*
+checks that a seller can take an appointment: inside one of their windows, outside
+their time off and not overlapping another pending or confirmed booking
+@params seller_id
+@params start
+@params end
+*/
+func slotAvailable(tx *gorm.DB, sellerID uuid.UUID, start, end time.Time) error {
+	local, localEnd := start.In(BookingLocation()), end.In(BookingLocation())
+	startMinute := local.Hour()*60 + local.Minute()
+	endMinute := startMinute + int(end.Sub(start)/time.Minute)
+	if localEnd.YearDay() != local.YearDay() && !(localEnd.Hour() == 0 && localEnd.Minute() == 0) {
+		return ErrSlotUnavailable
+	}
+
+	var inWindow int64
+	err := tx.Model(&AvailabilityWindow{}).
+		Where("seller_id = ? AND weekday = ? AND start_minute <= ? AND end_minute >= ?", sellerID, int(local.Weekday()), startMinute, endMinute).
+		Count(&inWindow).Error
+	if err != nil {
+		return err
+	}
+	if inWindow == 0 {
+		return ErrSlotUnavailable
+	}
+
+	var blocked int64
+	if err := tx.Model(&TimeOff{}).Where("seller_id = ? AND starts_at < ? AND ends_at > ?", sellerID, end, start).Count(&blocked).Error; err != nil {
+		return err
+	}
+	if blocked > 0 {
+		return ErrSlotUnavailable
+	}
+	if err := tx.Model(&Booking{}).
+		Where("seller_id = ? AND status IN ? AND starts_at < ? AND ends_at > ?", sellerID, []BookingStatus{BookingPending, BookingConfirmed}, end, start).
+		Count(&blocked).Error; err != nil {
+		return err
+	}
+	if blocked > 0 {
+		return ErrSlotUnavailable
+	}
+	return nil
+}
+
+// Slot is a bookable appointment time
+type Slot struct {
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+}
+
+/*
+lists the free appointment times of a service over the given number of days
+@params service_id
+@params from
+@params days
+*/
+func GetServiceSlots(serviceID uuid.UUID, from time.Time, days int) ([]Slot, error) {
+	var service Service
+	if err := db.First(&service, "id = ? AND is_active = ?", serviceID, true).Error; err != nil {
+		return nil, ErrServiceNotFound
+	}
+	if days <= 0 || days > 31 {
+		days = 7
+	}
+	duration := time.Duration(service.DurationMinutes) * time.Minute
+	if duration <= 0 {
+		duration = time.Hour
+	}
+
+	var windows []AvailabilityWindow
+	if err := db.Where("seller_id = ?", service.SellerID).Find(&windows).Error; err != nil {
+		log.Println("error fetching availability:", err.Error())
+		return nil, errors.New("failed to fetch available times")
+	}
+	location := BookingLocation()
+	from = from.In(location)
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, location)
+	until := day.AddDate(0, 0, days)
+
+	var busy []Slot
+	db.Model(&Booking{}).Select("starts_at, ends_at").
+		Where("seller_id = ? AND status IN ? AND starts_at < ? AND ends_at > ?", service.SellerID, []BookingStatus{BookingPending, BookingConfirmed}, until, day).
+		Scan(&busy)
+	var timeOff []Slot
+	db.Model(&TimeOff{}).Select("starts_at, ends_at").
+		Where("seller_id = ? AND starts_at < ? AND ends_at > ?", service.SellerID, until, day).
+		Scan(&timeOff)
+	busy = append(busy, timeOff...)
+
+	earliest := time.Now()
+	latest := earliest.Add(bookingHorizon)
+	slots := []Slot{}
+	for ; day.Before(until); day = day.AddDate(0, 0, 1) {
+		for _, window := range windows {
+			if window.Weekday != int(day.Weekday()) {
+				continue
+			}
+			windowEnd := day.Add(time.Duration(window.EndMinute) * time.Minute)
+			for start := day.Add(time.Duration(window.StartMinute) * time.Minute); !start.Add(duration).After(windowEnd); start = start.Add(bookingSlotStep) {
+				slot := Slot{StartsAt: start, EndsAt: start.Add(duration)}
+				if slot.StartsAt.Before(earliest) || slot.StartsAt.After(latest) {
+					continue
+				}
+				free := true
+				for _, b := range busy {
+					if b.StartsAt.Before(slot.EndsAt) && b.EndsAt.After(slot.StartsAt) {
+						free = false
+						break
+					}
+				}
+				if free {
+					slots = append(slots, slot)
+				}
+			}
+		}
+	}
+	return slots, nil
+}
+
+type bookingRequest struct {
+	StartsAt      time.Time `json:"starts_at"`
+	Notes         string    `json:"notes"`
+	PaymentMethod string    `json:"payment_method"`
+}
+
+/*
+books a service for the authenticated customer and opens the order it is paid through.
+The booking waits for the seller to confirm it.
+@params service_id
+*/
+func CreateBooking(c *fiber.Ctx, serviceID uuid.UUID) (*Booking, error) {
+	customerID, _ := GetAuthUserID(c)
+	body := bookingRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing booking:", err.Error())
+		return nil, errors.New("error parsing request data, starts_at must be an RFC 3339 time")
+	}
+	var service Service
+	if err := db.First(&service, "id = ? AND is_active = ?", serviceID, true).Error; err != nil {
+		return nil, ErrServiceNotFound
+	}
+	if service.SellerID == customerID {
+		return nil, ErrOwnServiceBooking
+	}
+	if err := RequireVerified(customerID); err != nil {
+		return nil, err
+	}
+	if body.StartsAt.Before(time.Now()) || body.StartsAt.After(time.Now().Add(bookingHorizon)) {
+		return nil, errors.New("starts_at must be in the next 90 days")
+	}
+	if body.PaymentMethod == "" {
+		body.PaymentMethod = "mpesa"
+	}
+	duration := time.Duration(service.DurationMinutes) * time.Minute
+	if duration <= 0 {
+		duration = time.Hour
+	}
+
+	booking := Booking{
+		BaseModel:  BaseModel{ID: uuid.New()},
+		ServiceID:  service.ID,
+		SellerID:   service.SellerID,
+		CustomerID: customerID,
+		StartsAt:   body.StartsAt.UTC(),
+		EndsAt:     body.StartsAt.Add(duration).UTC(),
+		Status:     BookingPending,
+		Notes:      strings.TrimSpace(body.Notes),
+		Price:      service.Price,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// bookings of a seller are made one at a time so two customers cannot take the same slot
+		var seller User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&seller, "id = ?", service.SellerID).Error; err != nil {
+			return err
+		}
+		if err := slotAvailable(tx, service.SellerID, booking.StartsAt, booking.EndsAt); err != nil {
+			return err
+		}
+		order, err := placeServiceOrder(tx, customerID, body.PaymentMethod, OrderItem{
+			ServiceID: &service.ID,
+			BookingID: &booking.ID,
+			Price:     service.Price,
+		})
+		if err != nil {
+			return err
+		}
+		booking.OrderID = &order.ID
+		if err := tx.Create(&booking).Error; err != nil {
+			return err
+		}
+		return notifyUser(tx, Notification{
+			UserID:    service.SellerID,
+			Kind:      "booking_requested",
+			Title:     "New booking: " + service.Name,
+			Body:      fmt.Sprintf("%s was booked for %s. Confirm or cancel the booking.", service.Name, formatBookingTime(booking.StartsAt)),
+			SendEmail: true,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, ErrSlotUnavailable) {
+			return nil, err
+		}
+		log.Println("error creating booking:", err.Error())
+		return nil, errors.New("failed to create booking")
+	}
+	booking.Service = service
+	return &booking, nil
+}
+
+// formatBookingTime shows an appointment time in the booking time zone
+func formatBookingTime(t time.Time) string {
+	return t.In(BookingLocation()).Format("Mon 2 Jan 2006 15:04 MST")
+}
+
+// BookingFilter narrows the bookings a user sees
+type BookingFilter struct {
+	Role   string `query:"role"` // customer (default) or seller
+	Status string `query:"status"`
+}
+
+/*
+gets the bookings the user made, or as a seller the bookings of their services
+@params user_id
+@params filter
+*/
+func GetBookings(userID uuid.UUID, filter BookingFilter) (*[]Booking, error) {
+	bookings := []Booking{}
+	query := db.Preload("Service").Where("customer_id = ?", userID)
+	if filter.Role == "seller" {
+		query = db.Preload("Service").Where("seller_id = ?", userID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if err := query.Order("starts_at").Find(&bookings).Error; err != nil {
+		log.Println("error fetching bookings:", err.Error())
+		return nil, errors.New("failed to fetch bookings")
+	}
+	return &bookings, nil
+}
+
+// findBooking loads a booking the authenticated user is the customer or seller of
+func findBooking(c *fiber.Ctx, bookingID uuid.UUID) (*Booking, uuid.UUID, error) {
+	userID, _ := GetAuthUserID(c)
+	var booking Booking
+	if err := db.Preload("Service").First(&booking, "id = ?", bookingID).Error; err != nil {
+		return nil, userID, ErrBookingNotFound
+	}
+	if booking.CustomerID != userID && booking.SellerID != userID && GetAuthUser(c) != "admin" {
+		return nil, userID, ErrNotBookingParty
+	}
+	return &booking, userID, nil
+}
+
+/*
+moves a booking from one status to another, failing when another request changed it first
+@params booking
+@params from
+@params updates
+*/
+func transitionBooking(tx *gorm.DB, booking *Booking, from []BookingStatus, updates map[string]interface{}) error {
+	result := tx.Model(&Booking{}).Where("id = ? AND status IN ?", booking.ID, from).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrBookingState
+	}
+	return nil
+}
+
+// requireBookingPaid refuses to go on with a booking whose order is not fully paid
+func requireBookingPaid(tx *gorm.DB, booking *Booking) error {
+	if booking.OrderID == nil {
+		return nil
+	}
+	var order Order
+	if err := tx.Select("payment_status").First(&order, "id = ?", *booking.OrderID).Error; err != nil {
+		return err
+	}
+	if order.PaymentStatus != PaymentPaid {
+		return ErrBookingUnpaid
+	}
+	return nil
+}
+
+/*
+confirms a paid pending booking of one of the seller's services
+@params booking_id
+*/
+func ConfirmBooking(c *fiber.Ctx, bookingID uuid.UUID) (*Booking, error) {
+	booking, userID, err := findBooking(c, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	if booking.SellerID != userID {
+		return nil, ErrNotBookingParty
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := requireBookingPaid(tx, booking); err != nil {
+			return err
+		}
+		if err := transitionBooking(tx, booking, []BookingStatus{BookingPending}, map[string]interface{}{"status": BookingConfirmed}); err != nil {
+			return err
+		}
+		return notifyUser(tx, Notification{
+			UserID:    booking.CustomerID,
+			Kind:      "booking_confirmed",
+			Title:     "Booking confirmed: " + booking.Service.Name,
+			Body:      fmt.Sprintf("Your booking of %s for %s is confirmed.", booking.Service.Name, formatBookingTime(booking.StartsAt)),
+			SendEmail: true,
+		})
+	})
+	if err != nil {
+		return nil, bookingError("confirming", err)
+	}
+	booking.Status = BookingConfirmed
+	return booking, nil
+}
+
+/*
+cancels a booking. Sellers can cancel at any time; customers can cancel a pending
+booking, or a confirmed one up to BOOKING_CANCEL_NOTICE (default 24h) before it starts.
+The booking's order is cancelled with it and every payment made for it is refunded.
+@params booking_id
+*/
+func CancelBooking(c *fiber.Ctx, bookingID uuid.UUID) (*Booking, error) {
+	booking, userID, err := findBooking(c, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	body := struct {
+		Reason string `json:"reason"`
+	}{}
+	c.BodyParser(&body)
+	if len(body.Reason) > 255 {
+		return nil, errors.New("reason must be at most 255 characters")
+	}
+	if booking.CustomerID == userID && booking.Status == BookingConfirmed &&
+		time.Until(booking.StartsAt) < durationEnv("BOOKING_CANCEL_NOTICE", defaultCancelNotice) {
+		return nil, errors.New("it is too late to cancel this booking, contact the seller")
+	}
+
+	other := booking.SellerID
+	if userID == booking.SellerID {
+		other = booking.CustomerID
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := transitionBooking(tx, booking, []BookingStatus{BookingPending, BookingConfirmed}, map[string]interface{}{
+			"status":        BookingCancelled,
+			"cancelled_by":  userID,
+			"cancel_reason": body.Reason,
+		})
+		if err != nil {
+			return err
+		}
+		if err := cancelBookingOrder(tx, booking); err != nil {
+			return err
+		}
+		return notifyUser(tx, Notification{
+			UserID:    other,
+			Kind:      "booking_cancelled",
+			Title:     "Booking cancelled: " + booking.Service.Name,
+			Body:      fmt.Sprintf("The booking of %s for %s was cancelled. %s", booking.Service.Name, formatBookingTime(booking.StartsAt), body.Reason),
+			SendEmail: true,
+		})
+	})
+	if err != nil {
+		return nil, bookingError("cancelling", err)
+	}
+	booking.Status, booking.CancelledBy, booking.CancelReason = BookingCancelled, &userID, body.Reason
+	return booking, nil
+}
+
+// cancelBookingOrder cancels the order of a booking, refunding what was paid for it
+func cancelBookingOrder(tx *gorm.DB, booking *Booking) error {
+	if booking.OrderID == nil {
+		return nil
+	}
+	var payments []Payment
+	if err := tx.Where("order_id = ? AND payment_status = ?", *booking.OrderID, PaymentPaid).Find(&payments).Error; err != nil {
+		return err
+	}
+	for i := range payments {
+		if err := refundPayment(tx, &payments[i]); err != nil {
+			return err
+		}
+	}
+	return tx.Model(&Order{}).Where("id = ? AND order_status = ?", *booking.OrderID, OrderProcessing).
+		Update("order_status", OrderCancelled).Error
+}
+
+/*
+marks a paid, confirmed booking as done once it has started. Its order is then waiting for
+the customer to confirm it, or for OrderConfirmWindow to pass, before the seller is paid out.
+@params booking_id
+*/
+func CompleteBooking(c *fiber.Ctx, bookingID uuid.UUID) (*Booking, error) {
+	booking, userID, err := findBooking(c, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	if booking.SellerID != userID {
+		return nil, ErrNotBookingParty
+	}
+	if time.Now().Before(booking.StartsAt) {
+		return nil, errors.New("a booking can only be completed once it has started")
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := requireBookingPaid(tx, booking); err != nil {
+			return err
+		}
+		if err := transitionBooking(tx, booking, []BookingStatus{BookingConfirmed}, map[string]interface{}{"status": BookingCompleted}); err != nil {
+			return err
+		}
+		if booking.OrderID == nil {
+			return nil
+		}
+		// the customer confirms the appointment took place before the seller is paid for it
+		next, _ := fulfilment.Next(OrderProcessing, fulfilment.Ship)
+		now := time.Now()
+		result := tx.Model(&Order{}).Where("id = ? AND order_status = ?", *booking.OrderID, OrderProcessing).
+			Updates(map[string]interface{}{"order_status": next, "shipped_at": now})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if err := tx.Model(&OrderItem{}).Where("order_id = ?", *booking.OrderID).Update("shipped_at", now).Error; err != nil {
+			return err
+		}
+		var order Order
+		if err := tx.First(&order, "id = ?", *booking.OrderID).Error; err != nil {
+			return err
+		}
+		return notifyServiceDelivered(tx, order, now)
+	})
+	if err != nil {
+		return nil, bookingError("completing", err)
+	}
+	booking.Status = BookingCompleted
+	return booking, nil
+}
+
+func bookingError(action string, err error) error {
+	if errors.Is(err, ErrBookingState) || errors.Is(err, ErrBookingUnpaid) {
+		return err
+	}
+	log.Println("error "+action+" booking:", err.Error())
+	return errors.New("failed to update booking")
+}
+
+/*
+reminds customers and sellers of confirmed bookings starting within
+BOOKING_REMINDER_BEFORE (default 24h). Each booking is reminded once.
+*/
+func SendBookingReminders() (int, error) {
+	now := time.Now()
+	var due []Booking
+	err := db.Preload("Service").
+		Where("status = ? AND reminder_sent_at IS NULL AND starts_at > ? AND starts_at <= ?", BookingConfirmed, now, now.Add(durationEnv("BOOKING_REMINDER_BEFORE", defaultReminderBefore))).
+		Find(&due).Error
+	if err != nil {
+		log.Println("error fetching bookings to remind:", err.Error())
+		return 0, errors.New("failed to send booking reminders")
+	}
+	sent := 0
+	for _, booking := range due {
+		body := fmt.Sprintf("Reminder: %s is booked for %s.", booking.Service.Name, formatBookingTime(booking.StartsAt))
+		err := db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&Booking{}).Where("id = ? AND reminder_sent_at IS NULL", booking.ID).Update("reminder_sent_at", now)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			for _, userID := range []uuid.UUID{booking.CustomerID, booking.SellerID} {
+				err := notifyUser(tx, Notification{
+					UserID:    userID,
+					Kind:      "booking_reminder",
+					Title:     "Upcoming booking: " + booking.Service.Name,
+					Body:      body,
+					SendEmail: true,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Println("error reminding booking", booking.ID, ":", err.Error())
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+/*
+cancels pending bookings whose order has not been paid within BOOKING_PAYMENT_WINDOW
+(default 1h) of booking, freeing the slot for other customers
+*/
+func ExpireUnpaidBookings() (int, error) {
+	var due []Booking
+	err := db.Preload("Service").
+		Joins("JOIN orders ON orders.id = bookings.order_id").
+		Where("bookings.status = ? AND bookings.created_at < ? AND orders.payment_status = ?",
+			BookingPending, time.Now().Add(-durationEnv("BOOKING_PAYMENT_WINDOW", defaultPaymentWindow)), PaymentPending).
+		Find(&due).Error
+	if err != nil {
+		log.Println("error fetching unpaid bookings:", err.Error())
+		return 0, errors.New("failed to expire unpaid bookings")
+	}
+	expired := 0
+	for _, booking := range due {
+		booking := booking
+		reason := "The booking was not paid in time."
+		err := db.Transaction(func(tx *gorm.DB) error {
+			err := transitionBooking(tx, &booking, []BookingStatus{BookingPending}, map[string]interface{}{
+				"status":        BookingCancelled,
+				"cancel_reason": reason,
+			})
+			if err != nil {
+				return err
+			}
+			if err := cancelBookingOrder(tx, &booking); err != nil {
+				return err
+			}
+			for _, userID := range []uuid.UUID{booking.CustomerID, booking.SellerID} {
+				err := notifyUser(tx, Notification{
+					UserID:    userID,
+					Kind:      "booking_cancelled",
+					Title:     "Booking cancelled: " + booking.Service.Name,
+					Body:      fmt.Sprintf("The booking of %s for %s was cancelled. %s", booking.Service.Name, formatBookingTime(booking.StartsAt), reason),
+					SendEmail: true,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, ErrBookingState) {
+			continue
+		}
+		if err != nil {
+			log.Println("error expiring booking", booking.ID, ":", err.Error())
+			continue
+		}
+		expired++
+	}
+	return expired, nil
+}
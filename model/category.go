@@ -0,0 +1,536 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCategoryNotFound = errors.New("category not found")
+	ErrCategoryCycle    = errors.New("a category cannot be moved under itself or one of its descendants")
+)
+
+// Slugify turns a name into a lowercase, hyphenated URL slug
+func Slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(strings.TrimSpace(name)) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+			hyphen = false
+		case !hyphen && b.Len() > 0:
+			b.WriteRune('-')
+			hyphen = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+/*
+returns a slug that is not used by another category, prefixing the parent's slug
+and then adding a number when the plain slug is taken
+@params slug
+@params parent
+@params except
+*/
+func uniqueCategorySlug(tx *gorm.DB, slug string, parent *Category, except uuid.UUID) (string, error) {
+	candidates := []string{slug}
+	if parent != nil {
+		candidates = append(candidates, parent.Slug+"-"+slug)
+	}
+	base := candidates[len(candidates)-1]
+	for i := 2; i < 100; i++ {
+		candidates = append(candidates, fmt.Sprintf("%s-%d", base, i))
+	}
+	for _, candidate := range candidates {
+		var count int64
+		if err := tx.Unscoped().Model(&Category{}).Where("slug = ? AND id <> ?", candidate, except).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("could not find a free slug for this category")
+}
+
+// categoryTree is every category of a kind indexed by id and by parent
+type categoryTree struct {
+	byID     map[uuid.UUID]*Category
+	children map[uuid.UUID][]*Category // uuid.Nil holds the roots
+}
+
+func loadCategoryTree(kind CategoryKind) (*categoryTree, error) {
+	var categories []Category
+	query := db.Order("position, name")
+	if kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if err := query.Find(&categories).Error; err != nil {
+		log.Println("error loading categories:", err.Error())
+		return nil, errors.New("failed to load categories")
+	}
+	tree := &categoryTree{byID: map[uuid.UUID]*Category{}, children: map[uuid.UUID][]*Category{}}
+	for i := range categories {
+		category := &categories[i]
+		tree.byID[category.ID] = category
+		parent := uuid.Nil
+		if category.ParentID != nil {
+			parent = *category.ParentID
+		}
+		tree.children[parent] = append(tree.children[parent], category)
+	}
+	return tree, nil
+}
+
+// descendants returns the id of a category and of every category below it
+func (t *categoryTree) descendants(id uuid.UUID) []uuid.UUID {
+	ids := []uuid.UUID{id}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range t.children[ids[i]] {
+			ids = append(ids, child.ID)
+		}
+	}
+	return ids
+}
+
+// build returns the categories under parent with their children filled in
+func (t *categoryTree) build(parent uuid.UUID) []Category {
+	nodes := []Category{}
+	for _, child := range t.children[parent] {
+		node := *child
+		node.Children = t.build(child.ID)
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+/*
+finds a category by id, slug or name
+@params kind
+@params ref
+*/
+func FindCategory(kind CategoryKind, ref string) (*Category, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, ErrCategoryNotFound
+	}
+	var category Category
+	query := db.Where("kind = ?", kind)
+	if id, err := uuid.Parse(ref); err == nil {
+		query = query.Where("id = ?", id)
+	} else {
+		query = query.Where("(slug = ? OR LOWER(name) = ?)", Slugify(ref), strings.ToLower(ref))
+	}
+	if err := query.Order("parent_id IS NOT NULL").First(&category).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %s", ErrCategoryNotFound, ref)
+		}
+		log.Println("error finding category:", err.Error())
+		return nil, errors.New("failed to find category")
+	}
+	return &category, nil
+}
+
+/*
+returns the ids of a category, given by id, slug or name, and all of its descendants
+@params kind
+@params ref
+*/
+func CategoryWithDescendants(kind CategoryKind, ref string) ([]uuid.UUID, error) {
+	category, err := FindCategory(kind, ref)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := loadCategoryTree(kind)
+	if err != nil {
+		return nil, err
+	}
+	return tree.descendants(category.ID), nil
+}
+
+/*
+sets the category of a product or service from either its category_id or its
+category name or slug, keeping the denormalised name in step with the node
+@params kind
+@params category_id
+@params name
+*/
+func resolveCategory(kind CategoryKind, categoryID *uuid.UUID, name string) (*uuid.UUID, string, error) {
+	ref := name
+	if categoryID != nil && *categoryID != uuid.Nil {
+		ref = categoryID.String()
+	}
+	if strings.TrimSpace(ref) == "" {
+		return nil, "", nil
+	}
+	category, err := FindCategory(kind, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	return &category.ID, category.Name, nil
+}
+
+/*
+gets the category tree of a kind, product by default
+@params kind
+*/
+func GetCategoryTree(kind CategoryKind) ([]Category, error) {
+	if kind == "" {
+		kind = CategoryProduct
+	}
+	tree, err := loadCategoryTree(kind)
+	if err != nil {
+		return nil, err
+	}
+	return tree.build(uuid.Nil), nil
+}
+
+// CategoryDetails is a category with its subtree and the path from the root to it
+type CategoryDetails struct {
+	Category
+	Ancestors []Category `json:"ancestors"`
+}
+
+/*
+gets a category by id or slug with its children and ancestors
+@params ref
+*/
+func GetCategory(ref string) (*CategoryDetails, error) {
+	var category Category
+	query := db.Where("slug = ?", ref)
+	if id, err := uuid.Parse(ref); err == nil {
+		query = db.Where("id = ?", id)
+	}
+	if err := query.First(&category).Error; err != nil {
+		return nil, ErrCategoryNotFound
+	}
+	tree, err := loadCategoryTree(category.Kind)
+	if err != nil {
+		return nil, err
+	}
+	details := CategoryDetails{Category: category, Ancestors: []Category{}}
+	details.Children = tree.build(category.ID)
+	for parent := category.ParentID; parent != nil; {
+		node, ok := tree.byID[*parent]
+		if !ok {
+			break
+		}
+		details.Ancestors = append([]Category{*node}, details.Ancestors...)
+		parent = node.ParentID
+	}
+	return &details, nil
+}
+
+type categoryRequest struct {
+	Name     string       `json:"name"`
+	Slug     string       `json:"slug"`
+	Kind     CategoryKind `json:"kind"`
+	ParentID *uuid.UUID   `json:"parent_id"`
+	Position *int         `json:"position"`
+}
+
+/*
+creates a category, under a parent when parent_id is given
+*/
+func CreateCategory(c *fiber.Ctx) (*Category, error) {
+	body := categoryRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing category request:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		return nil, errors.New("name is required")
+	}
+	if body.Kind == "" {
+		body.Kind = CategoryProduct
+	}
+	if body.Kind != CategoryProduct && body.Kind != CategoryService {
+		return nil, errors.New("kind must be product or service")
+	}
+
+	category := Category{BaseModel: BaseModel{ID: uuid.New()}, Name: body.Name, Kind: body.Kind}
+	if body.Position != nil {
+		category.Position = *body.Position
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var parent *Category
+		if body.ParentID != nil && *body.ParentID != uuid.Nil {
+			parent = &Category{}
+			if err := tx.First(parent, "id = ? AND kind = ?", *body.ParentID, body.Kind).Error; err != nil {
+				return ErrCategoryNotFound
+			}
+			category.ParentID = &parent.ID
+		}
+		slug := Slugify(body.Slug)
+		if slug == "" {
+			slug = Slugify(body.Name)
+		}
+		if slug == "" {
+			return errors.New("name must contain letters or digits")
+		}
+		var err error
+		if category.Slug, err = uniqueCategorySlug(tx, slug, parent, category.ID); err != nil {
+			return err
+		}
+		return tx.Create(&category).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrCategoryNotFound) {
+			return nil, errors.New("parent category not found")
+		}
+		log.Println("error creating category:", err.Error())
+		return nil, errors.New("failed to create category")
+	}
+	return &category, nil
+}
+
+/*
+renames, re-slugs, reorders or moves a category
+@params category_id
+*/
+func UpdateCategory(c *fiber.Ctx, categoryID uuid.UUID) (*Category, error) {
+	body := categoryRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing category request:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	var category Category
+	if err := db.First(&category, "id = ?", categoryID).Error; err != nil {
+		return nil, ErrCategoryNotFound
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var parent *Category
+		if body.ParentID != nil {
+			if *body.ParentID == uuid.Nil {
+				category.ParentID = nil
+			} else {
+				tree, err := loadCategoryTree(category.Kind)
+				if err != nil {
+					return err
+				}
+				for _, id := range tree.descendants(category.ID) {
+					if id == *body.ParentID {
+						return ErrCategoryCycle
+					}
+				}
+				node, ok := tree.byID[*body.ParentID]
+				if !ok {
+					return ErrCategoryNotFound
+				}
+				parent = node
+				category.ParentID = &node.ID
+			}
+		}
+		if name := strings.TrimSpace(body.Name); name != "" {
+			category.Name = name
+		}
+		if body.Position != nil {
+			category.Position = *body.Position
+		}
+		if slug := Slugify(body.Slug); slug != "" && slug != category.Slug {
+			var err error
+			if category.Slug, err = uniqueCategorySlug(tx, slug, parent, category.ID); err != nil {
+				return err
+			}
+		}
+		err := tx.Model(&category).Updates(map[string]interface{}{
+			"name":      category.Name,
+			"slug":      category.Slug,
+			"parent_id": category.ParentID,
+			"position":  category.Position,
+		}).Error
+		if err != nil {
+			return err
+		}
+		// keep the denormalised names of products and services in step
+		if err := tx.Model(&Product{}).Where("category_id = ?", category.ID).Update("category", category.Name).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Service{}).Where("category_id = ?", category.ID).Update("category", category.Name).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrCategoryCycle) {
+			return nil, err
+		}
+		if errors.Is(err, ErrCategoryNotFound) {
+			return nil, errors.New("parent category not found")
+		}
+		log.Println("error updating category:", err.Error())
+		return nil, errors.New("failed to update category")
+	}
+	reindexCategory(category.ID)
+	return &category, nil
+}
+
+/*
+deletes a category. Its children, products and services move up to its parent.
+@params category_id
+*/
+func DeleteCategory(categoryID uuid.UUID) error {
+	var category Category
+	if err := db.First(&category, "id = ?", categoryID).Error; err != nil {
+		return ErrCategoryNotFound
+	}
+	parentName := ""
+	if category.ParentID != nil {
+		var parent Category
+		if err := db.First(&parent, "id = ?", *category.ParentID).Error; err == nil {
+			parentName = parent.Name
+		}
+	}
+
+	var affected []uuid.UUID
+	db.Model(&Product{}).Where("category_id = ?", category.ID).Pluck("id", &affected)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Category{}).Where("parent_id = ?", category.ID).Update("parent_id", category.ParentID).Error; err != nil {
+			return err
+		}
+		moved := map[string]interface{}{"category_id": category.ParentID, "category": parentName}
+		if err := tx.Model(&Product{}).Where("category_id = ?", category.ID).Updates(moved).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Service{}).Where("category_id = ?", category.ID).Updates(moved).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&category).Error
+	})
+	if err != nil {
+		log.Println("error deleting category:", err.Error())
+		return errors.New("failed to delete category")
+	}
+	for _, id := range affected {
+		indexProduct(id)
+	}
+	return nil
+}
+
+// reindexCategory refreshes the search index entries of a category's products after a rename
+func reindexCategory(categoryID uuid.UUID) {
+	var ids []uuid.UUID
+	db.Model(&Product{}).Where("category_id = ?", categoryID).Pluck("id", &ids)
+	for _, id := range ids {
+		indexProduct(id)
+	}
+}
+
+/*
+creates a top level category for every free-text category of products and
+services that is not linked to a node yet. Safe to run on every start.
+*/
+func migrateCategoryNames() {
+	for _, table := range []struct {
+		model interface{}
+		kind  CategoryKind
+	}{
+		{&Product{}, CategoryProduct},
+		{&Service{}, CategoryService},
+	} {
+		var names []string
+		err := db.Model(table.model).
+			Where("category_id IS NULL AND category IS NOT NULL AND category <> ''").
+			Distinct().Pluck("category", &names).Error
+		if err != nil {
+			log.Println("error reading category names:", err.Error())
+			continue
+		}
+		for _, name := range names {
+			category, err := categoryForName(table.kind, name)
+			if err != nil {
+				continue
+			}
+			err = db.Model(table.model).
+				Where("category_id IS NULL AND category = ?", name).
+				Updates(map[string]interface{}{"category_id": category.ID, "category": category.Name}).Error
+			if err != nil {
+				log.Println("error linking", name, "to its category:", err.Error())
+			}
+		}
+	}
+}
+
+/*
+finds the category a free-text name refers to, creating a top level one when there
+is none. Names with nothing to slugify stay ErrCategoryNotFound.
+@params kind
+@params name
+*/
+func categoryForName(kind CategoryKind, name string) (*Category, error) {
+	category, err := FindCategory(kind, name)
+	if err == nil || !errors.Is(err, ErrCategoryNotFound) {
+		return category, err
+	}
+	slug := Slugify(name)
+	if slug == "" {
+		return nil, err
+	}
+	category = &Category{BaseModel: BaseModel{ID: uuid.New()}, Name: strings.TrimSpace(name), Kind: kind}
+	if category.Slug, err = uniqueCategorySlug(db, slug, nil, category.ID); err != nil {
+		log.Println("error creating category for", name, ":", err.Error())
+		return nil, err
+	}
+	if err := db.Create(category).Error; err != nil {
+		log.Println("error creating category for", name, ":", err.Error())
+		return nil, err
+	}
+	return category, nil
+}
+
+/*
+links category attributes that were defined against a free-text category name to
+the category node of that name, and drops the old unique index on the name so
+categories that share a name can each have their own attributes. Safe to run on
+every start.
+*/
+func migrateCategoryAttributes() {
+	migrator := db.Migrator()
+	if migrator.HasIndex(&CategoryAttribute{}, "idx_category_attribute") {
+		if err := migrator.DropIndex(&CategoryAttribute{}, "idx_category_attribute"); err != nil {
+			log.Println("error dropping category attribute name index:", err.Error())
+		}
+	}
+
+	var attributes []CategoryAttribute
+	if err := db.Where("category_id IS NULL").Find(&attributes).Error; err != nil {
+		log.Println("error reading unlinked category attributes:", err.Error())
+		return
+	}
+	for _, attribute := range attributes {
+		category, err := categoryForName(CategoryProduct, attribute.Category)
+		if err != nil {
+			log.Println("error finding category for attribute", attribute.ID, ":", err.Error())
+			continue
+		}
+		// names that differ only in case now resolve to one node; the first one linked wins
+		var count int64
+		err = db.Model(&CategoryAttribute{}).
+			Where("category_id = ? AND name = ?", category.ID, attribute.Name).
+			Count(&count).Error
+		if err != nil {
+			log.Println("error checking category attribute", attribute.ID, ":", err.Error())
+			continue
+		}
+		if count > 0 {
+			log.Println("dropping duplicate", attribute.Name, "attribute of", attribute.Category)
+			if err := db.Unscoped().Delete(&attribute).Error; err != nil {
+				log.Println("error dropping category attribute", attribute.ID, ":", err.Error())
+			}
+			continue
+		}
+		err = db.Model(&attribute).
+			Updates(map[string]interface{}{"category_id": category.ID, "category": category.Name}).Error
+		if err != nil {
+			log.Println("error linking category attribute", attribute.ID, ":", err.Error())
+		}
+	}
+}
@@ -4,10 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"time"
 
 	"github.com/dancankarani/palace/database"
-	"github.com/dancankarani/palace/utilities"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -42,47 +40,6 @@ func UserExist(c *fiber.Ctx, phoneNumber, userRole string) (bool, *User, error)
     log.Printf("User found: %+v", existingUser)
     return true, &existingUser, nil
 }
-/*
-updates the reset password code in the database
-@params phone_number
-@params email
-@params reset_code
-@paarams expiration_time
-*/
-func AddResetCode(c *fiber.Ctx,phone_number,email,code string,exp_time time.Time) error {
-	user := User{}
-	db.AutoMigrate(&user)
-	result:=db.Where("phone_number = ? AND email = ?",phone_number,email).First(&user)
-	if result.Error != nil {
-		return utilities.ShowError(c,"failed to get user",fiber.StatusInternalServerError)
-	}
-	user.ResetCode=code
-	user.CodeExpirationTime=exp_time
-	result = db.Save(&user)
-	if result.Error != nil {
-		return utilities.ShowError(c,"failed to save data",fiber.StatusInternalServerError)
-	}
-	return utilities.ShowMessage(c,"code sent",fiber.StatusOK)
-}
-/*
-finds if the user with the given email and phone number is registered
-@params email
-@params phone_number
-*/
-func FindUser(email, phoneNumber string) (User, error) {
-	user := User{}
-	err_str := fmt.Sprintf("user with email %s and phone number %s does not exist", email, phoneNumber)
-	err := db.Where("phone_number = ? AND email = ?", phoneNumber, email).First(&user).Error
-	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			
-			return user, errors.New(err_str)
-		}
-		return user, errors.New(err_str)
-	}
-	return user, nil
-}
-
 /*
 finds dependants using phone number only
 @params phone_number
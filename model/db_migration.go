@@ -1,15 +1,236 @@
 package model
 
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/dancankarani/palace/utilities"
+	"github.com/google/uuid"
+)
+
 func MigrateDB(){
+	dedupeRatings()
+	dedupePayoutEntries()
+	verificationAdded := !db.Migrator().HasColumn(&User{}, "email_verified_at")
+	startingPriceAdded := !db.Migrator().HasColumn(&Service{}, "starting_price_minor")
 	db.AutoMigrate(
 		&User{},
 		&Rating{},
+		&SellerRatingSummary{},
+		&ShopProfile{},
+		&SellerApplication{},
+		&VerificationCode{},
+		&PasswordResetToken{},
+		&LoginEvent{},
+		&RecoveryCode{},
+		&TwoFactorPolicy{},
+		&OAuthIdentity{},
+		&ShopFollower{},
+		&Category{},
 		&Product{},
+		&ProductVariant{},
+		&ProductImage{},
+		&ImportJob{},
+		&InventoryMovement{},
+		&ProductReview{},
+		&ReviewVote{},
+		&Notification{},
+		&CategoryAttribute{},
 		&Order{},
 		&Service{},
+		&AvailabilityWindow{},
+		&TimeOff{},
+		&Booking{},
+		&ServicePackage{},
+		&QuoteRequest{},
+		&CustomOffer{},
 		&OrderItem{},
 		&Cart{},
 		&CartItem{},
 		&Payment{},
+		&SellerLedgerEntry{},
+		&Payout{},
+		&Account{},
+		&JournalEntry{},
+		&Posting{},
 	)
-}
\ No newline at end of file
+
+	// payments used to be stored as "Completed" rather than PaymentPaid
+	db.Model(&Payment{}).Where("payment_status = ?", "Completed").Update("payment_status", PaymentPaid)
+
+	migrateDecimalColumns()
+	migrateCategoryNames()
+	migrateCategoryAttributes()
+	migrateProductStatus()
+	dropResetCodeColumns()
+	moveIDDocumentsToPrivateStore()
+	rebuildSellerRatingSummaries()
+	if verificationAdded {
+		verifyExistingUsers()
+	}
+	if startingPriceAdded {
+		seedServiceStartingPrices()
+	}
+}
+
+/*
+replaces the old products.is_active flag with Product.Status, inactive products
+becoming archived. Safe to run on every start.
+*/
+func migrateProductStatus() {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(&Product{}, "is_active") {
+		return
+	}
+	err := db.Exec("UPDATE products SET status = ? WHERE is_active = ?", ProductArchived, false).Error
+	if err != nil {
+		log.Println("error migrating products.is_active to status:", err.Error())
+		return
+	}
+	if err := migrator.DropColumn(&Product{}, "is_active"); err != nil {
+		log.Println("error dropping column products.is_active:", err.Error())
+	}
+}
+
+/*
+drops the plaintext reset code columns replaced by PasswordResetToken. Safe to run on every start.
+*/
+func dropResetCodeColumns() {
+	migrator := db.Migrator()
+	for _, column := range []string{"reset_code", "code_expiration_time"} {
+		if !migrator.HasColumn(&User{}, column) {
+			continue
+		}
+		if err := migrator.DropColumn(&User{}, column); err != nil {
+			log.Println("error dropping column users."+column, ":", err.Error())
+		}
+	}
+}
+
+/*
+moves ID documents uploaded to the public blob store into the private one and drops the
+old seller_applications.id_document_url column once they are all moved. Safe to run on
+every start.
+*/
+func moveIDDocumentsToPrivateStore() {
+	migrator := db.Migrator()
+	if !migrator.HasColumn(&SellerApplication{}, "id_document_url") {
+		return
+	}
+	var rows []struct {
+		ID            uuid.UUID
+		IDDocumentURL string
+	}
+	err := db.Table("seller_applications").Select("id, id_document_url").
+		Where("id_document_url <> '' AND (id_document_key IS NULL OR id_document_key = '')").
+		Scan(&rows).Error
+	if err != nil {
+		log.Println("error finding public ID documents:", err.Error())
+		return
+	}
+	public, ok := utilities.NewBlobStore().(utilities.PrivateBlobStore)
+	if !ok {
+		return
+	}
+	private := utilities.NewPrivateBlobStore()
+	moved := 0
+	for _, row := range rows {
+		i := strings.Index(row.IDDocumentURL, "kyc/")
+		if i < 0 {
+			log.Println("skipping ID document with an unexpected url:", row.ID)
+			continue
+		}
+		key := row.IDDocumentURL[i:]
+		data, contentType, err := public.Get(key)
+		if err != nil {
+			log.Println("error reading public ID document", row.ID, ":", err.Error())
+			continue
+		}
+		if _, err := private.Put(key, contentType, data); err != nil {
+			log.Println("error moving ID document", row.ID, ":", err.Error())
+			continue
+		}
+		if err := db.Model(&SellerApplication{}).Where("id = ?", row.ID).Update("id_document_key", key).Error; err != nil {
+			log.Println("error moving ID document", row.ID, ":", err.Error())
+			continue
+		}
+		public.Delete(key)
+		moved++
+	}
+	if moved < len(rows) {
+		return
+	}
+	if err := migrator.DropColumn(&SellerApplication{}, "id_document_url"); err != nil {
+		log.Println("error dropping column seller_applications.id_document_url:", err.Error())
+	}
+}
+
+/*
+marks accounts created before email and phone verification existed as verified,
+so they are not locked out of checkout and selling
+*/
+func verifyExistingUsers() {
+	err := db.Exec("UPDATE users SET email_verified_at = created_at, phone_verified_at = created_at WHERE email_verified_at IS NULL").Error
+	if err != nil {
+		log.Println("error verifying existing users:", err.Error())
+	}
+}
+
+/*
+fills the starting price of existing services from their price, which used to be kept
+at the cheapest package
+*/
+func seedServiceStartingPrices() {
+	err := db.Exec("UPDATE services SET starting_price_minor = price_minor, starting_price_currency = price_currency").Error
+	if err != nil {
+		log.Println("error setting service starting prices:", err.Error())
+	}
+}
+
+/*
+moves amounts from the old decimal columns into the integer minor unit columns
+used by Money and drops the decimal columns. Safe to run on every start.
+*/
+func migrateDecimalColumns() {
+	columns := []struct {
+		model  interface{}
+		table  string
+		column string
+	}{
+		{&Product{}, "products", "price"},
+		{&Service{}, "services", "price"},
+		{&Order{}, "orders", "total_amount"},
+		{&Order{}, "orders", "amount_paid"},
+		{&OrderItem{}, "order_items", "price"},
+		{&OrderItem{}, "order_items", "total_price"},
+		{&Cart{}, "carts", "total_amount"},
+		{&CartItem{}, "cart_items", "price"},
+		{&CartItem{}, "cart_items", "total_price"},
+		{&Payment{}, "payments", "cost"},
+		{&SellerLedgerEntry{}, "seller_ledger_entries", "gross_amount"},
+		{&SellerLedgerEntry{}, "seller_ledger_entries", "commission"},
+		{&SellerLedgerEntry{}, "seller_ledger_entries", "amount"},
+		{&Payout{}, "payouts", "amount"},
+		{&Posting{}, "postings", "debit"},
+		{&Posting{}, "postings", "credit"},
+	}
+
+	migrator := db.Migrator()
+	for _, c := range columns {
+		if !migrator.HasColumn(c.model, c.column) {
+			continue
+		}
+		err := db.Exec(fmt.Sprintf(
+			"UPDATE %s SET %s_minor = ROUND(COALESCE(%s, 0) * 100), %s_currency = ?",
+			c.table, c.column, c.column, c.column,
+		), DefaultCurrency()).Error
+		if err != nil {
+			log.Println("error migrating", c.table+"."+c.column, "to minor units:", err.Error())
+			continue
+		}
+		if err := migrator.DropColumn(c.model, c.column); err != nil {
+			log.Println("error dropping decimal column", c.table+"."+c.column, ":", err.Error())
+		}
+	}
+}
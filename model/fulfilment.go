@@ -0,0 +1,170 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dancankarani/palace/fulfilment"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrOrderNotFound       = errors.New("order not found")
+	ErrOrderNotShippable   = errors.New("only paid orders that are still processing can be shipped")
+	ErrOrderNotConfirmable = errors.New("only shipped orders can be confirmed as delivered")
+)
+
+// OrderConfirmWindow is how long a buyer has to confirm a shipped order before it is
+// confirmed for them, read from ORDER_CONFIRM_DAYS
+func OrderConfirmWindow() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("ORDER_CONFIRM_DAYS"))
+	if err != nil || days <= 0 {
+		return fulfilment.DefaultConfirmWindow
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+/*
+marks the seller's items in a paid order as shipped, or every item for an admin. Once all
+its items are shipped the order is too, and the buyer has OrderConfirmWindow to confirm
+they received it.
+@params order_id
+*/
+func ShipOrder(c *fiber.Ctx, orderID uuid.UUID) (*Order, error) {
+	sellerID, _ := GetAuthUserID(c)
+	admin := GetAuthUser(c) == "admin"
+	var order Order
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Items.Product", func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }).
+			First(&order, "id = ?", orderID).Error
+		if err != nil {
+			return err
+		}
+		// services are delivered through DeliverServiceOrder or their booking
+		var ownItem bool
+		var unshipped []uuid.UUID
+		for _, item := range order.Items {
+			if item.ProductID == nil || (item.Product.SellerID != sellerID && !admin) {
+				continue
+			}
+			ownItem = true
+			if item.ShippedAt == nil {
+				unshipped = append(unshipped, item.ID)
+			}
+		}
+		if !ownItem {
+			return gorm.ErrRecordNotFound
+		}
+		if order.PaymentStatus != PaymentPaid || order.OrderStatus != OrderProcessing || len(unshipped) == 0 {
+			return ErrOrderNotShippable
+		}
+
+		now := time.Now()
+		if err := tx.Model(&OrderItem{}).Where("id IN ?", unshipped).Update("shipped_at", now).Error; err != nil {
+			return err
+		}
+		var waiting int64
+		if err := tx.Model(&OrderItem{}).Where("order_id = ? AND shipped_at IS NULL", order.ID).Count(&waiting).Error; err != nil {
+			return err
+		}
+		if waiting > 0 {
+			return nil
+		}
+		next, err := fulfilment.Next(order.OrderStatus, fulfilment.Ship)
+		if err != nil {
+			return ErrOrderNotShippable
+		}
+		if err := tx.Model(&Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{"order_status": next, "shipped_at": now}).Error; err != nil {
+			return err
+		}
+		order.OrderStatus, order.ShippedAt = next, &now
+		return notifyUser(tx, Notification{
+			UserID:    order.UserID,
+			Kind:      "order_shipped",
+			Title:     "Order " + order.OrderNumber + " shipped",
+			Body:      fmt.Sprintf("Your order %s is on its way. Confirm you received it by %s, after which it is confirmed for you.", order.OrderNumber, now.Add(OrderConfirmWindow()).Format("2 Jan 2006")),
+			SendEmail: true,
+		})
+	})
+	if err != nil {
+		return nil, fulfilmentError("shipping", err)
+	}
+	return &order, nil
+}
+
+/*
+confirms the buyer received one of their shipped orders, which releases its sellers' payouts
+@params order_id
+*/
+func ConfirmDelivery(c *fiber.Ctx, orderID uuid.UUID) (*Order, error) {
+	userID, _ := GetAuthUserID(c)
+	var order Order
+	if err := db.First(&order, "id = ? AND user_id = ?", orderID, userID).Error; err != nil {
+		return nil, fulfilmentError("confirming", err)
+	}
+	if err := deliverOrder(db, &order, fulfilment.Confirm); err != nil {
+		return nil, fulfilmentError("confirming", err)
+	}
+	return &order, nil
+}
+
+/*
+confirms shipped orders whose buyers did not confirm them within OrderConfirmWindow
+*/
+func AutoConfirmDeliveries() (int, error) {
+	var orders []Order
+	err := db.Where("order_status = ? AND shipped_at <= ?", OrderShipped, time.Now().Add(-OrderConfirmWindow())).Find(&orders).Error
+	if err != nil {
+		log.Println("error fetching shipped orders:", err.Error())
+		return 0, errors.New("failed to fetch shipped orders")
+	}
+	confirmed := 0
+	for i := range orders {
+		if err := deliverOrder(db, &orders[i], fulfilment.AutoConfirm); err != nil {
+			if !errors.Is(err, ErrOrderNotConfirmable) {
+				log.Println("error confirming order", orders[i].ID, ":", err.Error())
+			}
+			continue
+		}
+		confirmed++
+	}
+	return confirmed, nil
+}
+
+// deliverOrder moves a shipped order to delivered unless it has moved on since it was loaded
+func deliverOrder(tx *gorm.DB, order *Order, event fulfilment.Event) error {
+	next, err := fulfilment.Next(order.OrderStatus, event)
+	if err != nil {
+		return ErrOrderNotConfirmable
+	}
+	now := time.Now()
+	result := tx.Model(&Order{}).Where("id = ? AND order_status = ?", order.ID, order.OrderStatus).
+		Updates(map[string]interface{}{"order_status": next, "delivered_at": now})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOrderNotConfirmable
+	}
+	order.OrderStatus, order.DeliveredAt = next, &now
+	return nil
+}
+
+func fulfilmentError(action string, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrOrderNotFound
+	}
+	if errors.Is(err, ErrOrderNotShippable) || errors.Is(err, ErrOrderNotConfirmable) {
+		return err
+	}
+	log.Println("error "+action+" order:", err.Error())
+	return errors.New("failed to update order")
+}
@@ -0,0 +1,306 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const defaultLowStockThreshold = 5
+
+// LowStockThreshold is the stock level sellers are alerted at, read from LOW_STOCK_THRESHOLD.
+// A product can override it with its own threshold.
+func LowStockThreshold() int {
+	if threshold, err := strconv.Atoi(os.Getenv("LOW_STOCK_THRESHOLD")); err == nil && threshold >= 0 {
+		return threshold
+	}
+	return defaultLowStockThreshold
+}
+
+/*
+moves the stock of a product, or of one of its variants, by movement.Change and
+records the movement. Stock never goes below zero: a change that would take it
+there fails with ErrInsufficientStock. The seller is alerted when the stock
+drops to the low stock threshold.
+@params movement
+*/
+func changeStock(tx *gorm.DB, movement *InventoryMovement) error {
+	if movement.Change == 0 {
+		return nil
+	}
+	var product Product
+	if err := tx.Unscoped().First(&product, "id = ?", movement.ProductID).Error; err != nil {
+		return err
+	}
+
+	var before int
+	if movement.VariantID != nil {
+		var variant ProductVariant
+		if err := tx.First(&variant, "id = ? AND product_id = ?", *movement.VariantID, product.ID).Error; err != nil {
+			return ErrVariantNotFound
+		}
+		before = variant.Stock
+		result := tx.Model(&ProductVariant{}).
+			Where("id = ? AND stock + ? >= 0", variant.ID, movement.Change).
+			Update("stock", gorm.Expr("stock + ?", movement.Change))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInsufficientStock
+		}
+		if err := syncProductStock(tx, product.ID); err != nil {
+			return err
+		}
+		if err := tx.Model(&ProductVariant{}).Where("id = ?", variant.ID).Pluck("stock", &movement.StockAfter).Error; err != nil {
+			return err
+		}
+	} else {
+		// the stock of a product with variants is the sum of theirs
+		var variants int64
+		if err := tx.Model(&ProductVariant{}).Where("product_id = ?", product.ID).Count(&variants).Error; err != nil {
+			return err
+		}
+		if variants > 0 {
+			return ErrVariantRequired
+		}
+		before = product.Stock
+		result := tx.Model(&Product{}).
+			Where("id = ? AND stock + ? >= 0", product.ID, movement.Change).
+			Update("stock", gorm.Expr("stock + ?", movement.Change))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInsufficientStock
+		}
+		if err := tx.Model(&Product{}).Unscoped().Where("id = ?", product.ID).Pluck("stock", &movement.StockAfter).Error; err != nil {
+			return err
+		}
+	}
+
+	movement.ID = uuid.New()
+	movement.SellerID = product.SellerID
+	if err := tx.Create(movement).Error; err != nil {
+		return err
+	}
+
+	threshold := LowStockThreshold()
+	if product.LowStockThreshold != nil {
+		threshold = *product.LowStockThreshold
+	}
+	// alert once, when the stock crosses the threshold on its way down
+	if before > threshold && movement.StockAfter <= threshold {
+		return notifyLowStock(tx, product, *movement)
+	}
+	return nil
+}
+
+/*
+sets the stock of a product or variant to an absolute level, recording the
+difference as a movement
+@params movement
+@params stock
+*/
+func setStock(tx *gorm.DB, movement *InventoryMovement, stock int) error {
+	if stock < 0 {
+		return errors.New("stock cannot be negative")
+	}
+	var current int
+	query := tx.Model(&Product{}).Unscoped().Where("id = ?", movement.ProductID)
+	if movement.VariantID != nil {
+		query = tx.Model(&ProductVariant{}).Where("id = ?", *movement.VariantID)
+	}
+	if err := query.Pluck("stock", &current).Error; err != nil {
+		return err
+	}
+	movement.Change = stock - current
+	return changeStock(tx, movement)
+}
+
+// notifyLowStock leaves the seller an in-app notification, emailed by SendNotificationEmails
+func notifyLowStock(tx *gorm.DB, product Product, movement InventoryMovement) error {
+	name := product.Name
+	if movement.VariantID != nil {
+		var variant ProductVariant
+		if err := tx.First(&variant, "id = ?", *movement.VariantID).Error; err == nil {
+			name = fmt.Sprintf("%s (%s %s)", product.Name, variant.Colour, variant.Size)
+		}
+	}
+	notification := Notification{
+		UserID:    product.SellerID,
+		Kind:      "low_stock",
+		Title:     "Low stock: " + name,
+		Body:      fmt.Sprintf("%s is down to %d in stock. Restock it to keep it on sale.", name, movement.StockAfter),
+		ProductID: &product.ID,
+		SendEmail: true,
+	}
+	return notifyUser(tx, notification)
+}
+
+// notifyUser saves a notification in the transaction that caused it
+func notifyUser(tx *gorm.DB, notification Notification) error {
+	notification.ID = uuid.New()
+	return tx.Create(&notification).Error
+}
+
+type stockAdjustmentRequest struct {
+	VariantID *uuid.UUID      `json:"variant_id"`
+	Change    int             `json:"change"`
+	Reason    InventoryReason `json:"reason"`
+	Note      string          `json:"note"`
+}
+
+/*
+adds or removes stock of one of the seller's products. Sales and cancellations
+are recorded by orders, so a seller gives restock, return or adjustment.
+@params product_id
+*/
+func AdjustStock(c *fiber.Ctx, productID uuid.UUID) (*InventoryMovement, error) {
+	product, err := ownedProduct(c, productID)
+	if err != nil {
+		return nil, err
+	}
+	body := stockAdjustmentRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing stock adjustment:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	switch body.Reason {
+	case InventoryRestock, InventoryReturn, InventoryAdjustment:
+	case "":
+		return nil, errors.New("reason is required")
+	default:
+		return nil, errors.New("reason must be restock, return or adjustment")
+	}
+	if body.Change == 0 {
+		return nil, errors.New("change must not be 0")
+	}
+	if (body.Reason == InventoryRestock || body.Reason == InventoryReturn) && body.Change < 0 {
+		return nil, errors.New("a restock or return must add stock")
+	}
+	if len(body.Note) > 255 {
+		return nil, errors.New("note must be at most 255 characters")
+	}
+
+	userID, _ := GetAuthUserID(c)
+	movement := InventoryMovement{
+		ProductID: product.ID,
+		VariantID: body.VariantID,
+		Change:    body.Change,
+		Reason:    body.Reason,
+		Note:      body.Note,
+		ActorID:   &userID,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return changeStock(tx, &movement)
+	})
+	if err != nil {
+		if errors.Is(err, ErrInsufficientStock) || errors.Is(err, ErrVariantRequired) || errors.Is(err, ErrVariantNotFound) {
+			return nil, err
+		}
+		log.Println("error adjusting stock:", err.Error())
+		return nil, errors.New("failed to adjust stock")
+	}
+	return &movement, nil
+}
+
+// InventoryFilter narrows a seller's inventory movements
+type InventoryFilter struct {
+	ProductID string `query:"product_id"`
+	Reason    string `query:"reason"`
+	Limit     int    `query:"limit"`
+}
+
+/*
+gets the seller's inventory movements, newest first
+@params seller_id
+@params filter
+*/
+func GetInventoryMovements(sellerID uuid.UUID, filter InventoryFilter) (*[]InventoryMovement, error) {
+	movements := []InventoryMovement{}
+	query := db.Where("seller_id = ?", sellerID)
+	if filter.ProductID != "" {
+		id, err := uuid.Parse(filter.ProductID)
+		if err != nil {
+			return nil, errors.New("invalid product id")
+		}
+		query = query.Where("product_id = ?", id)
+	}
+	if filter.Reason != "" {
+		query = query.Where("reason = ?", filter.Reason)
+	}
+	if filter.Limit <= 0 || filter.Limit > 200 {
+		filter.Limit = 50
+	}
+	if err := query.Order("created_at DESC").Limit(filter.Limit).Find(&movements).Error; err != nil {
+		log.Println("error fetching inventory movements:", err.Error())
+		return nil, errors.New("failed to fetch inventory movements")
+	}
+	return &movements, nil
+}
+
+/*
+gets the user's notifications, newest first
+@params user_id
+*/
+func GetNotifications(userID uuid.UUID) (*[]Notification, error) {
+	notifications := []Notification{}
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC").Limit(100).Find(&notifications).Error; err != nil {
+		log.Println("error fetching notifications:", err.Error())
+		return nil, errors.New("failed to fetch notifications")
+	}
+	return &notifications, nil
+}
+
+/*
+marks one of the user's notifications as read
+@params user_id
+@params notification_id
+*/
+func MarkNotificationRead(userID, notificationID uuid.UUID) error {
+	result := db.Model(&Notification{}).
+		Where("id = ? AND user_id = ? AND read_at IS NULL", notificationID, userID).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		log.Println("error marking notification read:", result.Error.Error())
+		return errors.New("failed to update notification")
+	}
+	return nil
+}
+
+/*
+emails the notifications that ask for it and have not been sent yet. Notifications
+are written inside the transaction that caused them, so they are only mailed once
+that change has been committed.
+*/
+func SendNotificationEmails() (int, error) {
+	var pending []Notification
+	if err := db.Where("send_email = ? AND emailed_at IS NULL", true).Order("created_at").Limit(100).Find(&pending).Error; err != nil {
+		log.Println("error fetching notifications to email:", err.Error())
+		return 0, errors.New("failed to send notification emails")
+	}
+	sent := 0
+	for _, notification := range pending {
+		var user User
+		if err := db.First(&user, "id = ?", notification.UserID).Error; err != nil {
+			log.Println("error finding user for notification", notification.ID, ":", err.Error())
+			continue
+		}
+		if err := utilities.SendEmail(user.Email, notification.Title, notification.Body); err != nil {
+			log.Println("error emailing notification", notification.ID, ":", err.Error())
+			continue
+		}
+		db.Model(&notification).Update("emailed_at", time.Now())
+		sent++
+	}
+	return sent, nil
+}
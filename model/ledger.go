@@ -0,0 +1,334 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/dancankarani/palace/ledger"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// well-known ledger accounts
+const (
+	MpesaClearingAccount   = ledger.MpesaClearingAccount
+	PlatformRevenueAccount = ledger.PlatformRevenueAccount
+	RefundsPayableAccount  = ledger.RefundsPayableAccount
+)
+
+var ErrLedgerImmutable = errors.New("ledger entries cannot be changed once posted")
+
+// journal entries and postings are append-only
+func (JournalEntry) BeforeUpdate(tx *gorm.DB) error { return ErrLedgerImmutable }
+func (JournalEntry) BeforeDelete(tx *gorm.DB) error { return ErrLedgerImmutable }
+func (Posting) BeforeUpdate(tx *gorm.DB) error      { return ErrLedgerImmutable }
+func (Posting) BeforeDelete(tx *gorm.DB) error      { return ErrLedgerImmutable }
+
+// LedgerLine is one side of a journal entry before it is posted
+type LedgerLine = ledger.Line
+
+func CustomerAccount(userID uuid.UUID) string { return ledger.CustomerAccount(userID) }
+func SellerAccount(sellerID uuid.UUID) string { return ledger.SellerAccount(sellerID) }
+
+/*
+posts a balanced journal entry inside the given transaction
+@params tx
+@params reference_type
+@params reference_id
+@params description
+@params lines
+*/
+func PostJournal(tx *gorm.DB, referenceType string, referenceID uuid.UUID, description string, lines ...LedgerLine) (*JournalEntry, error) {
+	if err := ledger.Validate(lines...); err != nil {
+		return nil, err
+	}
+
+	entry := JournalEntry{
+		ID:            uuid.New(),
+		ReferenceType: referenceType,
+		ReferenceID:   referenceID,
+		Description:   description,
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return nil, fmt.Errorf("failed to create journal entry: %v", err)
+	}
+	for _, line := range lines {
+		account, err := ensureAccount(tx, line.AccountCode)
+		if err != nil {
+			return nil, err
+		}
+		posting := Posting{
+			ID:             uuid.New(),
+			JournalEntryID: entry.ID,
+			AccountID:      account.ID,
+			Debit:          line.Debit,
+			Credit:         line.Credit,
+		}
+		if err := tx.Create(&posting).Error; err != nil {
+			return nil, fmt.Errorf("failed to create posting: %v", err)
+		}
+		entry.Postings = append(entry.Postings, posting)
+	}
+	return &entry, nil
+}
+
+// ensureAccount finds the account with the given code, creating it on first use
+func ensureAccount(tx *gorm.DB, code string) (*Account, error) {
+	var account Account
+	err := tx.Where("code = ?", code).First(&account).Error
+	if err == nil {
+		return &account, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to find account %s: %v", code, err)
+	}
+
+	account = Account{ID: uuid.New(), Code: code}
+	switch code {
+	case MpesaClearingAccount:
+		account.Name, account.Type = "M-Pesa clearing", AccountAsset
+	case PlatformRevenueAccount:
+		account.Name, account.Type = "Platform commission revenue", AccountRevenue
+	case RefundsPayableAccount:
+		account.Name, account.Type = "Refunds payable", AccountLiability
+	default:
+		kind, owner, found := strings.Cut(code, ":")
+		if !found {
+			return nil, fmt.Errorf("unknown account code %s", code)
+		}
+		ownerID, err := uuid.Parse(owner)
+		if err != nil {
+			return nil, fmt.Errorf("invalid account owner in %s", code)
+		}
+		account.OwnerID = &ownerID
+		switch kind {
+		case "customer":
+			account.Name, account.Type = "Customer funds", AccountLiability
+		case "seller":
+			account.Name, account.Type = "Seller payable", AccountLiability
+		default:
+			return nil, fmt.Errorf("unknown account code %s", code)
+		}
+	}
+	if err := tx.Create(&account).Error; err != nil {
+		return nil, fmt.Errorf("failed to create account %s: %v", code, err)
+	}
+	return &account, nil
+}
+
+// postPaymentReceived records money received from a customer into the M-Pesa clearing account
+func postPaymentReceived(tx *gorm.DB, payment *Payment) error {
+	_, err := PostJournal(tx, "payment", payment.ID, "payment "+payment.TransactionID,
+		ledger.PaymentReceived(payment.CustomerID, payment.Cost)...)
+	return err
+}
+
+// postRefundOwed records a refund the customer is owed until it is sent back to them
+func postRefundOwed(tx *gorm.DB, payment *Payment, amount Money) error {
+	_, err := PostJournal(tx, "refund", payment.ID, "refund of payment "+payment.TransactionID,
+		ledger.RefundOwed(payment.CustomerID, amount)...)
+	return err
+}
+
+// postRefundSent records a refund leaving the M-Pesa clearing account
+func postRefundSent(tx *gorm.DB, payment *Payment, amount Money) error {
+	_, err := PostJournal(tx, "refund_sent", payment.ID, "refund of payment "+payment.TransactionID+" sent as "+payment.RefundReference,
+		ledger.RefundSent(amount)...)
+	return err
+}
+
+// postSale moves a delivered item's value from the customer to the seller and the platform commission
+func postSale(tx *gorm.DB, entry *SellerLedgerEntry, customerID uuid.UUID) error {
+	_, err := PostJournal(tx, "sale", entry.ID, entry.Description,
+		ledger.Sale(customerID, entry.SellerID, entry.GrossAmount, entry.Amount, entry.Commission)...)
+	return err
+}
+
+// postPayout records a seller payout leaving the M-Pesa clearing account
+func postPayout(tx *gorm.DB, payout *Payout) error {
+	_, err := PostJournal(tx, "payout", payout.ID, "payout to "+payout.PhoneNumber,
+		ledger.Payout(payout.SellerID, payout.Amount)...)
+	return err
+}
+
+// postPayoutReversal returns a failed payout to the seller
+func postPayoutReversal(tx *gorm.DB, payout *Payout) error {
+	_, err := PostJournal(tx, "payout_reversal", payout.ID, "reversal of failed payout to "+payout.PhoneNumber,
+		ledger.PayoutReversal(payout.SellerID, payout.Amount)...)
+	return err
+}
+
+/*
+refunds a paid payment in full. The customer is owed the refund until an admin sends it
+back to them over M-Pesa and settles it with SettleRefund.
+@params payment_id
+*/
+func RefundPayment(paymentID uuid.UUID) (*Payment, error) {
+	var payment Payment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&payment, "id = ?", paymentID).Error; err != nil {
+			return err
+		}
+		return refundPayment(tx, &payment)
+	})
+	if err != nil {
+		log.Println("error refunding payment", paymentID, ":", err.Error())
+		return nil, errors.New("failed to refund payment: " + err.Error())
+	}
+	return &payment, nil
+}
+
+// refundPayment takes a paid payment off its order and posts the refund the customer is owed
+func refundPayment(tx *gorm.DB, payment *Payment) error {
+	if payment.PaymentStatus != PaymentPaid {
+		return fmt.Errorf("only paid payments can be refunded, payment is %s", payment.PaymentStatus)
+	}
+	result := tx.Model(&Payment{}).Where("id = ? AND payment_status = ?", payment.ID, PaymentPaid).Update("payment_status", PaymentRefundPending)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("the payment was refunded already")
+	}
+	payment.PaymentStatus = PaymentRefundPending
+	if payment.OrderID != uuid.Nil {
+		var order Order
+		if err := tx.First(&order, "id = ?", payment.OrderID).Error; err != nil {
+			return err
+		}
+		if err := applyPaymentToOrder(tx, &order, payment.Cost.Neg()); err != nil {
+			return err
+		}
+	}
+	if err := postRefundOwed(tx, payment, payment.Cost); err != nil {
+		return err
+	}
+	return notifyUser(tx, Notification{
+		UserID:    payment.CustomerID,
+		Kind:      "refund_pending",
+		Title:     "Refund on its way",
+		Body:      fmt.Sprintf("Your payment %s of %s is being refunded to %s.", payment.TransactionID, payment.Cost, payment.CustomerPhone),
+		SendEmail: true,
+	})
+}
+
+// GetPendingRefunds lists the refunds customers are owed that have not been sent yet
+func GetPendingRefunds() ([]Payment, error) {
+	var payments []Payment
+	if err := db.Where("payment_status = ?", PaymentRefundPending).Order("updated_at").Find(&payments).Error; err != nil {
+		log.Println("error getting pending refunds:", err.Error())
+		return nil, errors.New("failed to get pending refunds")
+	}
+	return payments, nil
+}
+
+/*
+settles a pending refund once it has been sent back to the customer, recording the M-Pesa
+receipt of the money sent and taking it out of the clearing account
+@params payment_id
+@params reference
+*/
+func SettleRefund(paymentID uuid.UUID, reference string) (*Payment, error) {
+	reference = strings.TrimSpace(reference)
+	if reference == "" {
+		return nil, errors.New("the M-Pesa receipt of the refund is required")
+	}
+	var payment Payment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&payment, "id = ?", paymentID).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&Payment{}).Where("id = ? AND payment_status = ?", payment.ID, PaymentRefundPending).
+			Updates(map[string]interface{}{"payment_status": PaymentRefunded, "refund_reference": reference})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("only pending refunds can be settled, payment is %s", payment.PaymentStatus)
+		}
+		payment.PaymentStatus, payment.RefundReference = PaymentRefunded, reference
+		return postRefundSent(tx, &payment, payment.Cost)
+	})
+	if err != nil {
+		log.Println("error settling refund of payment", paymentID, ":", err.Error())
+		return nil, errors.New("failed to settle refund: " + err.Error())
+	}
+	return &payment, nil
+}
+
+type TrialBalanceLine struct {
+	AccountID uuid.UUID   `json:"account_id"`
+	Code      string      `json:"code"`
+	Name      string      `json:"name"`
+	Type      AccountType `json:"type"`
+	Debit     Money       `json:"debit"`
+	Credit    Money       `json:"credit"`
+	Balance   Money       `json:"balance"` // In the account's normal direction
+}
+
+type TrialBalance struct {
+	Accounts    []TrialBalanceLine `json:"accounts"`
+	TotalDebit  Money              `json:"total_debit"`
+	TotalCredit Money              `json:"total_credit"`
+	Balanced    bool               `json:"balanced"`
+}
+
+/*
+sums every posting per account. Total debits always equal total credits
+when the ledger is consistent.
+*/
+func GetTrialBalance() (*TrialBalance, error) {
+	var rows []struct {
+		AccountID   uuid.UUID
+		Code        string
+		Name        string
+		Type        AccountType
+		Currency    string
+		DebitMinor  int64
+		CreditMinor int64
+	}
+	err := db.Model(&Posting{}).
+		Select("accounts.id AS account_id, accounts.code, accounts.name, accounts.type, postings.debit_currency AS currency, " +
+			"COALESCE(SUM(postings.debit_minor), 0) AS debit_minor, COALESCE(SUM(postings.credit_minor), 0) AS credit_minor").
+		Joins("JOIN accounts ON accounts.id = postings.account_id").
+		Group("accounts.id, accounts.code, accounts.name, accounts.type, postings.debit_currency").
+		Scan(&rows).Error
+	if err != nil {
+		log.Println("error computing trial balance:", err.Error())
+		return nil, errors.New("failed to compute trial balance")
+	}
+
+	report := TrialBalance{TotalDebit: NewMoney(0), TotalCredit: NewMoney(0)}
+	for _, row := range rows {
+		line := TrialBalanceLine{
+			AccountID: row.AccountID,
+			Code:      row.Code,
+			Name:      row.Name,
+			Type:      row.Type,
+			Debit:     Money{Minor: row.DebitMinor, Currency: row.Currency},
+			Credit:    Money{Minor: row.CreditMinor, Currency: row.Currency},
+		}
+		if line.Type == AccountAsset || line.Type == AccountExpense {
+			line.Balance, _ = line.Debit.Sub(line.Credit)
+		} else {
+			line.Balance, _ = line.Credit.Sub(line.Debit)
+		}
+		// postings are only made in the default currency, so totals across currencies are a fault
+		if report.TotalDebit, err = report.TotalDebit.Add(line.Debit); err != nil {
+			log.Println("error computing trial balance:", err.Error())
+			return nil, errors.New("failed to compute trial balance")
+		}
+		if report.TotalCredit, err = report.TotalCredit.Add(line.Credit); err != nil {
+			log.Println("error computing trial balance:", err.Error())
+			return nil, errors.New("failed to compute trial balance")
+		}
+		report.Accounts = append(report.Accounts, line)
+	}
+	sort.Slice(report.Accounts, func(i, j int) bool { return report.Accounts[i].Code < report.Accounts[j].Code })
+	cmp, _ := report.TotalDebit.Cmp(report.TotalCredit)
+	report.Balanced = cmp == 0
+	return &report, nil
+}
@@ -0,0 +1,248 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dancankarani/palace/database"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	accountFreeLoginAttempts = 3  // failures on one account before backoff starts
+	accountLockoutAttempts   = 10 // failures that lock the account
+	ipFreeLoginAttempts      = 10
+	ipLockoutAttempts        = 50
+	loginLockoutDuration     = 15 * time.Minute
+	loginFailureWindow       = time.Hour // failures are forgotten after an hour without another
+)
+
+// ErrInvalidCredentials is returned for an unknown account and a wrong password alike
+var ErrInvalidCredentials = errors.New("invalid login details")
+
+// LoginBlockedError is returned while an account or address has to wait before trying again
+type LoginBlockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginBlockedError) Error() string {
+	return fmt.Sprintf("too many failed sign-in attempts, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+type LoginRequest struct {
+	Login       string `json:"login"` // email address or phone number
+	Email       string `json:"email"`
+	PhoneNumber string `json:"phone_number"`
+	Password    string `json:"password"`
+}
+
+// loginThrottle counts failed sign-ins for one account or address in redis
+type loginThrottle struct {
+	key     string
+	free    int
+	lockout int
+}
+
+func (t loginThrottle) failuresKey() string { return "login_failures:" + t.key }
+func (t loginThrottle) blockedKey() string  { return "login_blocked:" + t.key }
+
+// blockedFor is how long until the next attempt is allowed
+func (t loginThrottle) blockedFor(ctx context.Context, rdb *redis.Client) time.Duration {
+	ttl, err := rdb.TTL(ctx, t.blockedKey()).Result()
+	if err != nil {
+		log.Println("error checking login throttle:", err.Error())
+		return 0
+	}
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
+
+// failures is the number of failed attempts in the current window
+func (t loginThrottle) failures(ctx context.Context, rdb *redis.Client) int {
+	failures, err := rdb.Get(ctx, t.failuresKey()).Int()
+	if err != nil {
+		return 0
+	}
+	return failures
+}
+
+// fail records a failed attempt and blocks the next one for an exponentially growing delay
+func (t loginThrottle) fail(ctx context.Context, rdb *redis.Client) int {
+	failures, err := rdb.Incr(ctx, t.failuresKey()).Result()
+	if err != nil {
+		log.Println("error counting failed login:", err.Error())
+		return 0
+	}
+	rdb.Expire(ctx, t.failuresKey(), loginFailureWindow)
+	if delay := utilities.Backoff(int(failures), t.free, t.lockout, loginLockoutDuration); delay > 0 {
+		rdb.Set(ctx, t.blockedKey(), 1, delay)
+	}
+	return int(failures)
+}
+
+func (t loginThrottle) reset(ctx context.Context, rdb *redis.Client) {
+	rdb.Del(ctx, t.failuresKey(), t.blockedKey())
+}
+
+var (
+	dummyHash     string
+	dummyHashOnce sync.Once
+)
+
+// dummyPasswordHash is compared against for unknown accounts so they take as long as known ones
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = utilities.HashPassword(uuid.NewString())
+	})
+	return dummyHash
+}
+
+/*
+finds the account signing in by email address or phone number and checks its password.
+Failed attempts are counted per account and per address, each failure past the free ones
+doubling the wait before the next, until the account is locked for a while.
+@params request
+@params ip_address
+@params user_agent
+*/
+func Authenticate(req LoginRequest, ip, userAgent string) (*User, error) {
+	login := strings.TrimSpace(req.Login)
+	if login == "" {
+		login = strings.TrimSpace(req.Email)
+	}
+	if login == "" {
+		login = strings.TrimSpace(req.PhoneNumber)
+	}
+	if login == "" || req.Password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	// registration stores phone numbers as typed, so they are matched in every form they
+	// could have been typed in as well as the normalized one
+	column, logins := "email", []string{login}
+	if !strings.Contains(login, "@") {
+		column = "phone_number"
+		if normalized, err := utilities.ValidatePhoneNumber(login, "KE"); err == nil {
+			login = normalized
+			logins = append(logins, utilities.PhoneNumberForms(login, "KE")...)
+		}
+	}
+
+	ctx := context.Background()
+	rdb := database.RedisClient()
+	// keyed by the login rather than the user, so unknown accounts lock the same way
+	account := loginThrottle{key: "account:" + strings.ToLower(login), free: accountFreeLoginAttempts, lockout: accountLockoutAttempts}
+	address := loginThrottle{key: "ip:" + ip, free: ipFreeLoginAttempts, lockout: ipLockoutAttempts}
+	for _, throttle := range []loginThrottle{account, address} {
+		if wait := throttle.blockedFor(ctx, rdb); wait > 0 {
+			return nil, &LoginBlockedError{RetryAfter: wait}
+		}
+	}
+
+	var user User
+	err := db.Order("created_at").First(&user, column+" IN ?", logins).Error
+	// inactive accounts fail exactly like a wrong password
+	found := err == nil && user.IsActive
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Println("error finding user to log in:", err.Error())
+		return nil, errors.New("failed to login")
+	}
+	hash := user.Password
+	if !found {
+		hash = dummyPasswordHash()
+	}
+	if err := utilities.CompareHashAndPassowrd(hash, req.Password); err != nil || !found {
+		failures := account.fail(ctx, rdb)
+		address.fail(ctx, rdb)
+		if found && failures == accountLockoutAttempts {
+			notifyLoginLockout(user)
+		}
+		return nil, ErrInvalidCredentials
+	}
+
+	priorFailures := account.failures(ctx, rdb)
+	account.reset(ctx, rdb)
+	recordLogin(user, ip, userAgent, priorFailures)
+	return &user, nil
+}
+
+// notifyLoginLockout tells the owner their account was locked by failed sign-ins
+func notifyLoginLockout(user User) {
+	err := notifyUser(db, Notification{
+		UserID:    user.ID,
+		Kind:      "login_locked",
+		Title:     "Sign-in temporarily locked",
+		Body:      fmt.Sprintf("There were %d failed attempts to sign in to your account, so sign-in is locked for %d minutes. If this was not you, reset your password.", accountLockoutAttempts, int(loginLockoutDuration.Minutes())),
+		SendEmail: true,
+	})
+	if err != nil {
+		log.Println("error notifying login lockout:", err.Error())
+	}
+}
+
+/*
+saves a successful sign-in and warns the user when it came from an address they have not
+signed in from before, or right after a run of failed attempts
+@params user
+@params ip_address
+@params user_agent
+@params prior_failures
+*/
+func recordLogin(user User, ip, userAgent string, priorFailures int) {
+	var previous, fromAddress int64
+	if err := db.Model(&LoginEvent{}).Where("user_id = ?", user.ID).Count(&previous).Error; err != nil {
+		log.Println("error counting logins:", err.Error())
+		return
+	}
+	if err := db.Model(&LoginEvent{}).Where("user_id = ? AND ip_address = ?", user.ID, ip).Count(&fromAddress).Error; err != nil {
+		log.Println("error counting logins:", err.Error())
+		return
+	}
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	err := db.Create(&LoginEvent{
+		BaseModel: BaseModel{ID: uuid.New()},
+		UserID:    user.ID,
+		IPAddress: ip,
+		UserAgent: userAgent,
+	}).Error
+	if err != nil {
+		log.Println("error recording login:", err.Error())
+	}
+
+	var reasons []string
+	// the first sign-in has nothing to compare against
+	if previous > 0 && fromAddress == 0 {
+		reasons = append(reasons, "from a new address ("+ip+")")
+	}
+	if priorFailures >= accountFreeLoginAttempts {
+		reasons = append(reasons, fmt.Sprintf("after %d failed attempts", priorFailures))
+	}
+	if len(reasons) == 0 {
+		return
+	}
+	if userAgent == "" {
+		userAgent = "an unknown device"
+	}
+	err = notifyUser(db, Notification{
+		UserID:    user.ID,
+		Kind:      "suspicious_login",
+		Title:     "New sign-in to your account",
+		Body:      fmt.Sprintf("Your account was signed in to %s at %s using %s. If this was not you, reset your password.", strings.Join(reasons, " and "), time.Now().Format("2006-01-02 15:04"), userAgent),
+		SendEmail: true,
+	})
+	if err != nil {
+		log.Println("error notifying suspicious login:", err.Error())
+	}
+}
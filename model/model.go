@@ -2,6 +2,7 @@ package model
 
 import (
 	"time"
+	"github.com/dancankarani/palace/fulfilment"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
@@ -25,8 +26,13 @@ type User struct {
     PhoneNumber      string     `json:"phone_number" gorm:"size:20" validate:"omitempty,numeric"`
     UserRole         string     `json:"user_role" gorm:"size:50;default:'customer';not null" validate:"oneof=customer admin seller"`
     IsActive         bool       `json:"is_active" gorm:"default:true"`
-    ResetCode        string     `json:"-" gorm:"size:10"` // Hidden from JSON
-    CodeExpirationTime time.Time `json:"-"` // Hidden from JSON
+    SellerVerified   bool       `json:"seller_verified" gorm:"default:false"` // Approved through a seller application
+    EmailVerifiedAt  *time.Time `json:"email_verified_at"`
+    PhoneVerifiedAt  *time.Time `json:"phone_verified_at"`
+    PayoutPhone      string     `json:"-" gorm:"size:20"` // M-Pesa number verified for payouts
+    TOTPSecret       string     `json:"-" gorm:"size:64"` // Authenticator app secret, set when 2FA setup starts
+    TOTPLastStep     int64      `json:"-"` // Time step of the last accepted code, so a code works once
+    TwoFactorEnabledAt *time.Time `json:"two_factor_enabled_at"`
     
     // Relationships
     Services         []Service  `json:"services,omitempty" gorm:"foreignKey:SellerID"`
@@ -45,38 +51,318 @@ type Product struct {
     BaseModel
     Name        string    `json:"name" gorm:"size:255"`
     Description string    `json:"description" gorm:"type:text"`
-    Price       float64   `json:"price" gorm:"type:decimal(10,2)"`
-    Category    string    `json:"category" gorm:"size:100"` // Example: Shirts, Trousers, etc.
+    Price       Money     `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+    Category    string    `json:"category" gorm:"size:100"` // Name of the category node, kept for display and search
+    CategoryID  *uuid.UUID `json:"category_id" gorm:"type:varchar(36);index"` // Example: Men > Shirts
     Stock       int       `json:"stock"`                  // Available stock count
     ImageURL    string    `json:"image_url" gorm:"size:255"` // URL for the clothing item image
-    IsActive    bool      `json:"is_active" gorm:"default:true"` // Active status for display
+    Status      ProductStatus `json:"status" gorm:"size:20;index;default:active"` // Only active products are shown to buyers
+    AverageRating float64 `json:"average_rating"` // Mean stars of the product's reviews
+    ReviewCount   int     `json:"review_count"`
+    LowStockThreshold *int `json:"low_stock_threshold"` // Alert the seller at or below this stock, LOW_STOCK_THRESHOLD when unset
+    PublishAt   *time.Time `json:"publish_at"`   // When a draft goes live
+    UnpublishAt *time.Time `json:"unpublish_at"` // When an active product is archived
     SellerID    uuid.UUID `json:"seller_id" gorm:"type:uuid;index"` // Foreign key to associate with Seller
     User      User      `gorm:"foreignKey:SellerID;references:ID"` // Relationship to User
+    Variants    []ProductVariant `json:"variants,omitempty" gorm:"foreignKey:ProductID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
+    Images      []ProductImage   `json:"images,omitempty" gorm:"foreignKey:ProductID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
     OrderItems  []OrderItem `gorm:"foreignKey:ProductID;constraint:onUpdate:CASCADE,onDelete:SET NULL"`
     CartItems   []CartItem  `gorm:"foreignKey:ProductID;constraint:onUpdate:CASCADE,onDelete:SET NULL"`
 }
 
+// ProductReview is a buyer's review of a product they received, one per order item
+type ProductReview struct {
+	BaseModel
+	ProductID    uuid.UUID  `json:"product_id" gorm:"type:varchar(36);index;not null"`
+	OrderItemID  uuid.UUID  `json:"order_item_id" gorm:"type:varchar(36);uniqueIndex;not null"`
+	UserID       uuid.UUID  `json:"user_id" gorm:"type:varchar(36);index;not null"`
+	VariantID    *uuid.UUID `json:"variant_id" gorm:"type:varchar(36)"`
+	Stars        int        `json:"stars" gorm:"not null;check:stars>=1 AND stars<=5"`
+	Title        string     `json:"title" gorm:"size:150"`
+	Body         string     `json:"body" gorm:"type:text"`
+	Photos       StringList `json:"photos" gorm:"type:text"` // URLs of the buyer's photos
+	SellerReply  string     `json:"seller_reply" gorm:"type:text"`
+	RepliedAt    *time.Time `json:"replied_at"`
+	HelpfulCount int        `json:"helpful_count"`
+	ReviewerName string     `json:"reviewer_name" gorm:"-"` // First name and initial, filled when listing
+}
+
+// ReviewVote is a user marking a review as helpful
+type ReviewVote struct {
+	BaseModel
+	ReviewID uuid.UUID `json:"review_id" gorm:"type:varchar(36);uniqueIndex:idx_review_vote;not null"`
+	UserID   uuid.UUID `json:"user_id" gorm:"type:varchar(36);uniqueIndex:idx_review_vote;not null"`
+}
+
+// InventoryMovement records one change to the stock of a product or variant
+type InventoryMovement struct {
+	BaseModel
+	ProductID  uuid.UUID       `json:"product_id" gorm:"type:varchar(36);index;not null"`
+	VariantID  *uuid.UUID      `json:"variant_id" gorm:"type:varchar(36);index"`
+	SellerID   uuid.UUID       `json:"seller_id" gorm:"type:varchar(36);index;not null"`
+	Change     int             `json:"change"`      // Negative when stock went out
+	StockAfter int             `json:"stock_after"` // Stock of the product, or the variant when set, after the change
+	Reason     InventoryReason `json:"reason" gorm:"size:20;index;not null"`
+	Note       string          `json:"note" gorm:"size:255"`
+	OrderID    *uuid.UUID      `json:"order_id" gorm:"type:varchar(36);index"`
+	ActorID    *uuid.UUID      `json:"actor_id" gorm:"type:varchar(36)"` // User who made the change, empty for the system
+}
+
+type InventoryReason string
+
+const (
+	InventorySale         InventoryReason = "sale"
+	InventoryRestock      InventoryReason = "restock"
+	InventoryReturn       InventoryReason = "return"
+	InventoryAdjustment   InventoryReason = "adjustment"
+	InventoryCancellation InventoryReason = "cancellation"
+)
+
+// Notification is an in-app message to a user, optionally also sent by email
+type Notification struct {
+	BaseModel
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:varchar(36);index;not null"`
+	Kind      string     `json:"kind" gorm:"size:50;index"`
+	Title     string     `json:"title" gorm:"size:255"`
+	Body      string     `json:"body" gorm:"type:text"`
+	ProductID *uuid.UUID `json:"product_id,omitempty" gorm:"type:varchar(36)"`
+	ReadAt    *time.Time `json:"read_at"`
+	SendEmail bool       `json:"-"`
+	EmailedAt *time.Time `json:"-"`
+}
+
+type ProductStatus string
+
+const (
+	ProductDraft    ProductStatus = "draft"
+	ProductActive   ProductStatus = "active"
+	ProductArchived ProductStatus = "archived"
+)
+
+// ProductVariant is a sellable size and colour of a product with its own SKU and stock.
+// When a product has variants its Stock is the sum of their stock.
+type ProductVariant struct {
+	BaseModel
+	ProductID     uuid.UUID         `json:"product_id" gorm:"type:varchar(36);index;not null"`
+	SKU           string            `json:"sku" gorm:"size:64;uniqueIndex;not null"`
+	Size          string            `json:"size" gorm:"size:20;index"`
+	Colour        string            `json:"colour" gorm:"size:50"`
+	Gender        string            `json:"gender" gorm:"size:20;index"` // men, women, unisex, kids
+	PriceOverride Money             `json:"price_override" gorm:"embedded;embeddedPrefix:price_override_"` // Zero means the product price applies
+	Stock         int               `json:"stock"`
+	Attributes    VariantAttributes `json:"attributes" gorm:"type:text"` // Other category attributes such as material or waist
+}
+
+// ProductImage is an uploaded product photo with its thumbnails. Blobs are stored under
+// the SHA-256 of the original upload, so the same photo uploaded twice is stored once.
+type ProductImage struct {
+	BaseModel
+	ProductID  uuid.UUID `json:"product_id" gorm:"type:varchar(36);index;not null"`
+	Hash       string    `json:"hash" gorm:"size:64;index"`
+	URL        string    `json:"url" gorm:"size:255"` // Original upload
+	SmallURL   string    `json:"small_url" gorm:"size:255"`
+	MediumURL  string    `json:"medium_url" gorm:"size:255"`
+	LargeURL   string    `json:"large_url" gorm:"size:255"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	Position   int       `json:"position"` // Display order, starting at 0
+	IsPrimary  bool      `json:"is_primary"` // Shown in listings and mirrored to Product.ImageURL
+}
+
+// ImportJob tracks a background CSV import of a seller's products
+type ImportJob struct {
+	BaseModel
+	SellerID      uuid.UUID       `json:"seller_id" gorm:"type:varchar(36);index;not null"`
+	Filename      string          `json:"filename" gorm:"size:255"`
+	Status        ImportStatus    `json:"status" gorm:"size:50;index"`
+	TotalRows     int             `json:"total_rows"`
+	ProcessedRows int             `json:"processed_rows"`
+	CreatedRows   int             `json:"created_rows"`
+	UpdatedRows   int             `json:"updated_rows"`
+	FailedRows    int             `json:"failed_rows"`
+	Errors        ImportRowErrors `json:"errors" gorm:"type:mediumtext"` // One entry per rejected row
+	CompletedAt   *time.Time      `json:"completed_at"`
+}
+
+type ImportStatus string
+
+const (
+	ImportPending   ImportStatus = "pending"
+	ImportRunning   ImportStatus = "running"
+	ImportCompleted ImportStatus = "completed"
+	ImportFailed    ImportStatus = "failed"
+)
+
+// CategoryAttribute defines an attribute that variants of products in a category carry,
+// for example shoe sizes 36-46 for Shoes or S-XXL for Shirts
+type CategoryAttribute struct {
+	BaseModel
+	CategoryID *uuid.UUID `json:"category_id" gorm:"type:varchar(36);uniqueIndex:idx_category_node_attribute"`
+	Category   string     `json:"category" gorm:"size:100;not null"` // Name of the category node, kept for display
+	Name       string     `json:"name" gorm:"size:50;uniqueIndex:idx_category_node_attribute;not null"` // size, colour, gender or a custom attribute
+	Required   bool       `json:"required"`
+	Options    StringList `json:"options" gorm:"type:text"` // Allowed values, empty allows any value
+}
+
+// Category is a node of the product or service taxonomy, such as Men > Shirts > Formal
+type Category struct {
+	BaseModel
+	Name     string       `json:"name" gorm:"size:100;not null"`
+	Slug     string       `json:"slug" gorm:"size:120;uniqueIndex;not null"`
+	Kind     CategoryKind `json:"kind" gorm:"size:20;index;not null"`
+	ParentID *uuid.UUID   `json:"parent_id" gorm:"type:varchar(36);index"`
+	Position int          `json:"position"` // Display order among siblings
+	Children []Category   `json:"children,omitempty" gorm:"foreignKey:ParentID"`
+}
+
+type CategoryKind string
+
+const (
+	CategoryProduct CategoryKind = "product"
+	CategoryService CategoryKind = "service"
+)
+
 type Service struct {
     BaseModel
     Name        string    `json:"name" gorm:"size:255"`
     Description string    `json:"description" gorm:"type:text"`
-    Price       float64   `json:"price" gorm:"type:decimal(10,2)"`
-    Category    string    `json:"category" gorm:"size:100"` // Example: Graphic Design, Tutoring, etc.
+    Price       Money     `json:"price" gorm:"embedded;embeddedPrefix:price_"` // What a booking is charged
+    StartingPrice Money   `json:"starting_price" gorm:"embedded;embeddedPrefix:starting_price_"` // Cheapest package, or Price without packages; shown in listings
+    Category    string    `json:"category" gorm:"size:100"` // Name of the category node, kept for display and search
+    CategoryID  *uuid.UUID `json:"category_id" gorm:"type:varchar(36);index"` // Example: Design > Graphic Design
     IsActive    bool      `json:"is_active" gorm:"default:true"` // Active status for display
+    DurationMinutes int   `json:"duration_minutes" gorm:"default:60"` // Length of a booked appointment
     SellerID    uuid.UUID `json:"seller_id" gorm:"type:uuid;index"` // Foreign key to associate with Seller
     User        User      `gorm:"foreignKey:SellerID;references:ID"` // Relationship to User
+    Packages    []ServicePackage `json:"packages,omitempty" gorm:"foreignKey:ServiceID"` // Priced tiers, the cheapest is the starting price
+}
+
+// AvailabilityWindow is a weekly period in which a seller takes bookings, in the booking time zone
+type AvailabilityWindow struct {
+	BaseModel
+	SellerID    uuid.UUID `json:"seller_id" gorm:"type:varchar(36);index;not null"`
+	Weekday     int       `json:"weekday"`      // 0 is Sunday
+	StartMinute int       `json:"start_minute"` // Minutes after midnight
+	EndMinute   int       `json:"end_minute"`
+}
+
+// TimeOff blocks a seller's calendar, for example for a holiday
+type TimeOff struct {
+	BaseModel
+	SellerID uuid.UUID `json:"seller_id" gorm:"type:varchar(36);index;not null"`
+	StartsAt time.Time `json:"starts_at" gorm:"index"`
+	EndsAt   time.Time `json:"ends_at"`
+	Reason   string    `json:"reason" gorm:"size:255"`
+}
+
+// Booking is an appointment for a service, paid through its order
+type Booking struct {
+	BaseModel
+	ServiceID      uuid.UUID     `json:"service_id" gorm:"type:varchar(36);index;not null"`
+	Service        Service       `json:"service" gorm:"foreignKey:ServiceID;references:ID"`
+	SellerID       uuid.UUID     `json:"seller_id" gorm:"type:varchar(36);index:idx_booking_seller_time;not null"`
+	CustomerID     uuid.UUID     `json:"customer_id" gorm:"type:varchar(36);index;not null"`
+	StartsAt       time.Time     `json:"starts_at" gorm:"index:idx_booking_seller_time"`
+	EndsAt         time.Time     `json:"ends_at"`
+	Status         BookingStatus `json:"status" gorm:"size:20;index"`
+	Notes          string        `json:"notes" gorm:"type:text"`
+	Price          Money         `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	OrderID        *uuid.UUID    `json:"order_id" gorm:"type:varchar(36);index"`
+	CancelledBy    *uuid.UUID    `json:"cancelled_by" gorm:"type:varchar(36)"`
+	CancelReason   string        `json:"cancel_reason" gorm:"size:255"`
+	ReminderSentAt *time.Time    `json:"-"`
+}
+
+type BookingStatus string
+
+const (
+	BookingPending   BookingStatus = "pending"   // Waiting for the seller to confirm
+	BookingConfirmed BookingStatus = "confirmed"
+	BookingCompleted BookingStatus = "completed"
+	BookingCancelled BookingStatus = "cancelled"
+)
+
+// ServicePackage is one priced tier of a service, such as basic, standard or premium
+type ServicePackage struct {
+	BaseModel
+	ServiceID    uuid.UUID   `json:"service_id" gorm:"type:varchar(36);uniqueIndex:idx_service_tier;not null"`
+	Tier         PackageTier `json:"tier" gorm:"size:20;uniqueIndex:idx_service_tier"`
+	Name         string      `json:"name" gorm:"size:100"`
+	Description  string      `json:"description" gorm:"type:text"`
+	Deliverables StringList  `json:"deliverables" gorm:"type:text"`
+	DeliveryDays int         `json:"delivery_days"`
+	Revisions    int         `json:"revisions"`
+	Price        Money       `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+}
+
+type PackageTier string
+
+const (
+	PackageBasic    PackageTier = "basic"
+	PackageStandard PackageTier = "standard"
+	PackagePremium  PackageTier = "premium"
+)
+
+// QuoteRequest is a buyer describing a job to the seller of a service
+type QuoteRequest struct {
+	BaseModel
+	ServiceID   uuid.UUID     `json:"service_id" gorm:"type:varchar(36);index;not null"`
+	Service     Service       `json:"service" gorm:"foreignKey:ServiceID;references:ID"`
+	SellerID    uuid.UUID     `json:"seller_id" gorm:"type:varchar(36);index;not null"`
+	CustomerID  uuid.UUID     `json:"customer_id" gorm:"type:varchar(36);index;not null"`
+	Description string        `json:"description" gorm:"type:text"`
+	Budget      Money         `json:"budget" gorm:"embedded;embeddedPrefix:budget_"` // What the buyer expects to spend, zero if they did not say
+	NeededBy    *time.Time    `json:"needed_by"`
+	Status      QuoteStatus   `json:"status" gorm:"size:20;index"`
+	Offers      []CustomOffer `json:"offers,omitempty" gorm:"foreignKey:QuoteRequestID"`
+}
+
+type QuoteStatus string
+
+const (
+	QuoteOpen     QuoteStatus = "open"     // Waiting for an offer
+	QuoteOffered  QuoteStatus = "offered"  // The seller has made an offer
+	QuoteAccepted QuoteStatus = "accepted" // An offer was accepted and ordered
+	QuoteClosed   QuoteStatus = "closed"   // Withdrawn by the buyer or declined by the seller
+)
+
+// CustomOffer is a seller's priced answer to a quote request; accepting it orders the service
+type CustomOffer struct {
+	BaseModel
+	QuoteRequestID uuid.UUID   `json:"quote_request_id" gorm:"type:varchar(36);index;not null"`
+	ServiceID      uuid.UUID   `json:"service_id" gorm:"type:varchar(36);index;not null"`
+	SellerID       uuid.UUID   `json:"seller_id" gorm:"type:varchar(36);index;not null"`
+	CustomerID     uuid.UUID   `json:"customer_id" gorm:"type:varchar(36);index;not null"`
+	Description    string      `json:"description" gorm:"type:text"`
+	Deliverables   StringList  `json:"deliverables" gorm:"type:text"`
+	DeliveryDays   int         `json:"delivery_days"`
+	Price          Money       `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	ExpiresAt      *time.Time  `json:"expires_at"`
+	Status         OfferStatus `json:"status" gorm:"size:20;index"`
+	OrderID        *uuid.UUID  `json:"order_id" gorm:"type:varchar(36);index"`
 }
 
+type OfferStatus string
+
+const (
+	OfferPending   OfferStatus = "pending"
+	OfferAccepted  OfferStatus = "accepted"
+	OfferDeclined  OfferStatus = "declined"
+	OfferWithdrawn OfferStatus = "withdrawn"
+)
+
 type Order struct {
 	BaseModel
 	OrderNumber   string          `json:"order_number" gorm:"size:100;unique;"`
 	UserID        uuid.UUID       `json:"user_id" gorm:"index;"`
 	User          User            `json:"user" gorm:"foreignKey:UserID;references:ID;constraint:onUpdate:CASCADE,onDelete:SET NULL"`
-	TotalAmount   float64         `json:"total_amount" gorm:"type:decimal(10,2)"`
+	TotalAmount   Money           `json:"total_amount" gorm:"embedded;embeddedPrefix:total_amount_"`
+	AmountPaid    Money           `json:"amount_paid" gorm:"embedded;embeddedPrefix:amount_paid_"` // Sum of confirmed payments, may exceed TotalAmount
 	PaymentStatus PaymentStatus   `json:"payment_status" gorm:"size:50"`
 	PaymentMethod string          `json:"payment_method" gorm:"size:50"`
 	ShippingAddress string        `json:"shipping_address" gorm:"type:text"`
 	OrderStatus   OrderStatus     `json:"order_status" gorm:"size:50"`
+	ShippedAt     *time.Time      `json:"shipped_at"` // When the last item was shipped, the buyer's confirmation window starts here
 	DeliveredAt   *time.Time     `json:"delivered_at"`
 	Items         []OrderItem     `json:"items" gorm:"foreignKey:OrderID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
 }
@@ -85,35 +371,47 @@ type OrderItem struct {
 	BaseModel
 	OrderID     uuid.UUID  `json:"order_id" gorm:"index;"`
 	Order       Order      `json:"order" gorm:"foreignKey:OrderID;references:ID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
-	ProductID   uuid.UUID  `json:"product_id" gorm:"index;"`
+	ProductID   *uuid.UUID `json:"product_id" gorm:"index;"` // Empty for a service booking
 	Product     Product    `json:"product" gorm:"foreignKey:ProductID;references:ID;constraint:onUpdate:CASCADE,onDelete:SET NULL"`
+	ServiceID   *uuid.UUID `json:"service_id" gorm:"type:varchar(36);index"`
+	Service     *Service   `json:"service,omitempty" gorm:"foreignKey:ServiceID;references:ID;constraint:onUpdate:CASCADE,onDelete:SET NULL"`
+	BookingID   *uuid.UUID `json:"booking_id" gorm:"type:varchar(36);index"`
+	PackageID   *uuid.UUID `json:"package_id" gorm:"type:varchar(36);index"` // Set when a service package was bought
+	OfferID     *uuid.UUID `json:"offer_id" gorm:"type:varchar(36);index"`   // Set when a custom offer was accepted
+	VariantID   *uuid.UUID `json:"variant_id" gorm:"type:varchar(36);index"`
+	Variant     *ProductVariant `json:"variant,omitempty" gorm:"foreignKey:VariantID;references:ID;constraint:onUpdate:CASCADE,onDelete:SET NULL"`
 	Quantity    int        `json:"quantity" gorm:"int"`
-	Price       float64    `json:"price" gorm:"type:decimal(10,2)"`
-	TotalPrice  float64    `json:"total_price" gorm:"type:decimal(10,2)"` // Optional: Can be calculated dynamically
+	Price       Money      `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	TotalPrice  Money      `json:"total_price" gorm:"embedded;embeddedPrefix:total_price_"`
+	ShippedAt   *time.Time `json:"shipped_at"` // Set by the item's seller
 }
 
 // PaymentStatus and OrderStatus enums
 type PaymentStatus string
 
 const (
-	PaymentPending PaymentStatus = "Pending"
-	PaymentPaid    PaymentStatus = "Paid"
-	PaymentFailed  PaymentStatus = "Failed"
+	PaymentPending  PaymentStatus = "Pending"
+	PaymentPartial  PaymentStatus = "Partially Paid"
+	PaymentPaid     PaymentStatus = "Paid"
+	PaymentFailed   PaymentStatus = "Failed"
+	PaymentRefunded PaymentStatus = "Refunded"
+	PaymentRefundPending PaymentStatus = "Refund Pending" // refunded in the ledger, waiting to be sent back over M-Pesa
 )
 
-type OrderStatus string
+// OrderStatus is where an order is in its fulfilment, see the fulfilment package
+type OrderStatus = fulfilment.Status
 
 const (
-	OrderProcessing OrderStatus = "Processing"
-	OrderShipped    OrderStatus = "Shipped"
-	OrderDelivered  OrderStatus = "Delivered"
-	OrderCancelled  OrderStatus = "Cancelled"
+	OrderProcessing = fulfilment.Processing
+	OrderShipped    = fulfilment.Shipped // waiting for the buyer to confirm delivery
+	OrderDelivered  = fulfilment.Delivered
+	OrderCancelled  = fulfilment.Cancelled
 )
 type Cart struct {
 	BaseModel
 	UserID        uuid.UUID     `json:"user_id" gorm:"type:varchar(36);"` // Reference to the user who owns the cart
 	User          User          `json:"user" gorm:"foreignKey:UserID;references:ID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
-	TotalAmount   float64       `json:"total_amount" gorm:"type:decimal(10,2)"`
+	TotalAmount   Money         `json:"total_amount" gorm:"embedded;embeddedPrefix:total_amount_"`
 	Items         []CartItem    `json:"items" gorm:"foreignKey:CartID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
 }
 
@@ -123,35 +421,128 @@ type CartItem struct {
 	Cart        Cart       `json:"cart" gorm:"foreignKey:CartID;references:ID;constraint:onUpdate:CASCADE,onDelete:CASCADE"`
 	ProductID   uuid.UUID  `json:"product_id" gorm:"type:varchar(36);"` // Foreign key to Product
 	Product     Product    `json:"product" gorm:"foreignKey:ProductID;references:ID;constraint:onUpdate:CASCADE,onDelete:SET NULL"`
+	VariantID   *uuid.UUID `json:"variant_id" gorm:"type:varchar(36);"` // Size and colour chosen, required when the product has variants
+	Variant     *ProductVariant `json:"variant,omitempty" gorm:"foreignKey:VariantID;references:ID;constraint:onUpdate:CASCADE,onDelete:SET NULL"`
 	Quantity    int        `json:"quantity" gorm:"int"`
-	Price       float64    `json:"price" gorm:"type:decimal(10,2);"`
-	TotalPrice  float64    `json:"total_price" gorm:"type:decimal(10,2);"`
+	Price       Money      `json:"price" gorm:"embedded;embeddedPrefix:price_"`
+	TotalPrice  Money      `json:"total_price" gorm:"embedded;embeddedPrefix:total_price_"`
 }
 
 type Payment struct {
 	ID              uuid.UUID `json:"id" gorm:"type:varchar(36);primaryKey;"` // Unique identifier for the payment
 	BillingID       uuid.UUID `json:"billing_id" gorm:"type:varchar(36)"` // Foreign key to the Billing table
+	OrderID         uuid.UUID `json:"order_id" gorm:"type:varchar(36);index"` // Order the payment was made against
 	CustomerID       uuid.UUID `json:"patient_id" gorm:"type:varchar(36)"` // Foreign key to the Patients table
-	Cost            float64   `json:"cost" gorm:"type:decimal(10,2);"`        // Cost of the payment
+	Cost            Money     `json:"cost" gorm:"embedded;embeddedPrefix:cost_"` // Cost of the payment
 	PaymentMethod   string    `json:"payment_method" gorm:"type:varchar(50);"` // Payment method (e.g., M-Pesa, Credit Card)
-	TransactionID   string    `json:"transaction_id" gorm:"type:varchar(100);"` // Transaction ID from the payment gateway
-	PaymentStatus   string    `json:"payment_status" gorm:"type:varchar(50);"` // Payment status (e.g., Pending, Completed, Failed)
+	TransactionID   string    `json:"transaction_id" gorm:"type:varchar(100);index"` // Transaction ID from the payment gateway
+	PaymentStatus   PaymentStatus `json:"payment_status" gorm:"type:varchar(50);"` // Payment status (e.g., Pending, Paid, Failed)
 	CallbackURL     string    `json:"callback_url" gorm:"type:varchar(255);"`  // Callback URL for payment notifications
 	CustomerPhone   string    `json:"customer_phone" gorm:"type:varchar(20);"` // Customer's phone number
 	CustomerName    string    `json:"customer_name" gorm:"type:varchar(100);"` // Customer's name
 	AccountReference string   `json:"account_reference" gorm:"type:varchar(100);"` // Account reference (e.g., order ID)
 	TransactionDesc string    `json:"transaction_desc" gorm:"type:varchar(255);"` // Transaction description	
 	TransactionDate string	  `json:"transaction_date" gorm:"type:varchar(255);"`
+	RefundReference string    `json:"refund_reference" gorm:"type:varchar(100);"` // M-Pesa receipt of the money sent back for a refund
+	CheckoutRequestID string  `json:"-" gorm:"type:varchar(100);index"` // STK push the payment was requested with
 	CreatedAt       time.Time `json:"created_at" gorm:"type:timestamp;default:CURRENT_TIMESTAMP;"` // Timestamp when the payment was created
 	UpdatedAt       time.Time `json:"updated_at" gorm:"type:timestamp;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP;"` // Timestamp when the payment was last updated
 	// Relationships
 }
 
+// SellerLedgerEntry is a signed movement on a seller's balance. Sales are credited
+// net of the platform commission and payouts are debited when they are sent.
+type SellerLedgerEntry struct {
+	BaseModel
+	SellerID    uuid.UUID       `json:"seller_id" gorm:"type:varchar(36);index;not null"`
+	EntryType   LedgerEntryType `json:"entry_type" gorm:"size:50;not null;uniqueIndex:idx_payout_entry,priority:2"`
+	OrderItemID *uuid.UUID      `json:"order_item_id,omitempty" gorm:"type:varchar(36);uniqueIndex"`                         // Set for sale credits only
+	PayoutID    *uuid.UUID      `json:"payout_id,omitempty" gorm:"type:varchar(36);uniqueIndex:idx_payout_entry,priority:1"` // Set for payout debits and reversals, once each
+	GrossAmount Money           `json:"gross_amount" gorm:"embedded;embeddedPrefix:gross_amount_"`
+	Commission  Money           `json:"commission" gorm:"embedded;embeddedPrefix:commission_"`
+	Amount      Money           `json:"amount" gorm:"embedded;embeddedPrefix:amount_"` // Net effect on the balance, negative for debits
+	Description string          `json:"description" gorm:"size:255"`
+}
+
+type LedgerEntryType string
+
+const (
+	LedgerSale           LedgerEntryType = "sale"
+	LedgerPayout         LedgerEntryType = "payout"
+	LedgerPayoutReversal LedgerEntryType = "payout_reversal"
+)
+
+// Payout is a B2C disbursement of a seller's balance to their M-Pesa number
+type Payout struct {
+	BaseModel
+	SellerID                 uuid.UUID    `json:"seller_id" gorm:"type:varchar(36);index;not null"`
+	Amount                   Money        `json:"amount" gorm:"embedded;embeddedPrefix:amount_"`
+	PhoneNumber              string       `json:"phone_number" gorm:"size:20"`
+	Status                   PayoutStatus `json:"status" gorm:"size:50;index"`
+	ConversationID           string       `json:"conversation_id" gorm:"size:100;index"`
+	OriginatorConversationID string       `json:"originator_conversation_id" gorm:"size:100;index"`
+	StatusConversationID     string       `json:"-" gorm:"size:100;index"` // of the last transaction status query
+	TransactionID            string       `json:"transaction_id" gorm:"size:100"`
+	ResultDesc               string       `json:"result_desc" gorm:"size:255"`
+	CompletedAt              *time.Time   `json:"completed_at"`
+}
+
+type PayoutStatus string
+
+const (
+	PayoutPending    PayoutStatus = "Pending"
+	PayoutProcessing PayoutStatus = "Processing"
+	PayoutPaid       PayoutStatus = "Paid"
+	PayoutFailed     PayoutStatus = "Failed"
+	PayoutUnknown    PayoutStatus = "Unknown" // timed out or lost, settled by a transaction status query
+)
+
+// Account is a ledger account. Customer and seller accounts are created on first use
+// and are identified by a code such as "customer:<user id>".
+type Account struct {
+	ID        uuid.UUID   `json:"id" gorm:"type:varchar(36);primaryKey"`
+	Code      string      `json:"code" gorm:"size:100;uniqueIndex;not null"`
+	Name      string      `json:"name" gorm:"size:255"`
+	Type      AccountType `json:"type" gorm:"size:50;not null"`
+	OwnerID   *uuid.UUID  `json:"owner_id,omitempty" gorm:"type:varchar(36);index"`
+	CreatedAt time.Time   `json:"created_at" gorm:"autoCreateTime"`
+}
+
+type AccountType string
+
+const (
+	AccountAsset     AccountType = "asset"
+	AccountLiability AccountType = "liability"
+	AccountRevenue   AccountType = "revenue"
+	AccountExpense   AccountType = "expense"
+)
+
+// JournalEntry is an immutable, balanced set of postings recording one business event
+type JournalEntry struct {
+	ID            uuid.UUID `json:"id" gorm:"type:varchar(36);primaryKey"`
+	ReferenceType string    `json:"reference_type" gorm:"size:50;index:idx_journal_reference"` // payment, refund, sale, payout, payout_reversal
+	ReferenceID   uuid.UUID `json:"reference_id" gorm:"type:varchar(36);index:idx_journal_reference"`
+	Description   string    `json:"description" gorm:"size:255"`
+	CreatedAt     time.Time `json:"created_at" gorm:"autoCreateTime"`
+	Postings      []Posting `json:"postings" gorm:"foreignKey:JournalEntryID"`
+}
+
+// Posting is one side of a journal entry. Exactly one of Debit and Credit is non-zero.
+type Posting struct {
+	ID             uuid.UUID `json:"id" gorm:"type:varchar(36);primaryKey"`
+	JournalEntryID uuid.UUID `json:"journal_entry_id" gorm:"type:varchar(36);index;not null"`
+	AccountID      uuid.UUID `json:"account_id" gorm:"type:varchar(36);index;not null"`
+	Account        Account   `json:"account" gorm:"foreignKey:AccountID;references:ID"`
+	Debit          Money     `json:"debit" gorm:"embedded;embeddedPrefix:debit_"`
+	Credit         Money     `json:"credit" gorm:"embedded;embeddedPrefix:credit_"`
+	CreatedAt      time.Time `json:"created_at" gorm:"autoCreateTime"`
+}
+
 //ratings
 type Rating struct {
 	ID        uuid.UUID      `json:"id" gorm:"type:varchar(36);primary_key"`
-	SellerID   uuid.UUID      `json:"seller_id" gorm:"type:varchar(36);"` // Reference to seller
-    UserID    uuid.UUID      `json:"user_id" gorm:"type:varchar(36);"`   // Who left the rating
+	SellerID   uuid.UUID      `json:"seller_id" gorm:"type:varchar(36);uniqueIndex:idx_seller_rating"` // Reference to seller
+    UserID    uuid.UUID      `json:"user_id" gorm:"type:varchar(36);uniqueIndex:idx_seller_rating"`   // Who left the rating, once per seller
     Stars      int            `json:"stars" gorm:"not null;check:stars>=1 AND stars<=5"`
     Comment    string         `json:"comment" gorm:"type:text"`                  // Optional feedback
 	User       User           `json:"user" gorm:"foreignKey:UserID;references:ID"`
@@ -163,3 +554,130 @@ type Rating struct {
     DeletedAt  gorm.DeletedAt `json:"deleted_at" gorm:"index"`
 }
 
+// RecoveryCode is a one-time code that stands in for the authenticator app.
+// Only a hash of the code is stored.
+type RecoveryCode struct {
+	BaseModel
+	UserID   uuid.UUID  `json:"user_id" gorm:"type:varchar(36);index;not null"`
+	CodeHash string     `json:"-" gorm:"size:64;index"`
+	UsedAt   *time.Time `json:"used_at"`
+}
+
+// TwoFactorPolicy is whether accounts with a role have to use two-factor authentication
+type TwoFactorPolicy struct {
+	Role      string     `json:"role" gorm:"primaryKey;size:50"`
+	Required  bool       `json:"required"`
+	UpdatedBy *uuid.UUID `json:"updated_by" gorm:"type:varchar(36)"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// OAuthIdentity links an account to the user's identity at a sign-in provider such as Google
+type OAuthIdentity struct {
+	BaseModel
+	UserID   uuid.UUID `json:"user_id" gorm:"type:varchar(36);index;not null"`
+	Provider string    `json:"provider" gorm:"size:30;uniqueIndex:idx_oauth_provider_subject"`
+	Subject  string    `json:"-" gorm:"size:255;uniqueIndex:idx_oauth_provider_subject"` // The provider's id for the user
+	Email    string    `json:"email" gorm:"size:100"`
+}
+
+// LoginEvent records a successful sign-in, used to spot sign-ins from new addresses
+type LoginEvent struct {
+	BaseModel
+	UserID    uuid.UUID `json:"user_id" gorm:"type:varchar(36);index:idx_login_user_ip;not null"`
+	IPAddress string    `json:"ip_address" gorm:"size:45;index:idx_login_user_ip"`
+	UserAgent string    `json:"user_agent" gorm:"size:255"`
+}
+
+// PasswordResetToken is a single-use link token for resetting a forgotten password.
+// Only a hash of the token is stored.
+type PasswordResetToken struct {
+	BaseModel
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:varchar(36);index;not null"`
+	TokenHash string     `json:"-" gorm:"size:64;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// VerificationCode is a single-use code proving a user controls their email or phone.
+// Only a hash of the code is stored.
+type VerificationCode struct {
+	BaseModel
+	UserID    uuid.UUID           `json:"user_id" gorm:"type:varchar(36);index:idx_verification_user_channel;not null"`
+	Channel   VerificationChannel `json:"channel" gorm:"size:20;index:idx_verification_user_channel"`
+	Target    string              `json:"target" gorm:"size:100"` // The address or number the code was sent to
+	CodeHash  string              `json:"-" gorm:"size:64;index"`
+	ExpiresAt time.Time           `json:"expires_at"`
+	UsedAt    *time.Time          `json:"used_at"`
+	Attempts  int                 `json:"-"`
+}
+
+type VerificationChannel string
+
+const (
+	VerifyEmail VerificationChannel = "email"
+	VerifyPhone VerificationChannel = "phone"
+)
+
+// SellerApplication is a customer's request to sell, reviewed by an admin
+type SellerApplication struct {
+	BaseModel
+	UserID                uuid.UUID         `json:"user_id" gorm:"type:varchar(36);index;not null"`
+	BusinessName          string            `json:"business_name" gorm:"size:255"`
+	BusinessType          string            `json:"business_type" gorm:"size:20"`        // individual or company
+	RegistrationNumber    string            `json:"registration_number" gorm:"size:100"` // Required for companies
+	TaxPIN                string            `json:"tax_pin" gorm:"size:20"`
+	BusinessAddress       string            `json:"business_address" gorm:"size:255"`
+	IDNumber              string            `json:"id_number" gorm:"size:50"`
+	IDDocumentKey         string            `json:"-" gorm:"size:255"` // In the private blob store, read by admins through the API
+	PayoutPhone           string            `json:"payout_phone" gorm:"size:20"`
+	PayoutPhoneVerifiedAt *time.Time        `json:"payout_phone_verified_at"`
+	PayoutCodeHash        string            `json:"-" gorm:"size:64"`
+	PayoutCodeExpiresAt   *time.Time        `json:"-"`
+	PayoutCodeAttempts    int               `json:"-"`
+	Status                ApplicationStatus `json:"status" gorm:"size:20;index"`
+	SubmittedAt           *time.Time        `json:"submitted_at"`
+	ReviewedBy            *uuid.UUID        `json:"reviewed_by" gorm:"type:varchar(36)"`
+	ReviewedAt            *time.Time        `json:"reviewed_at"`
+	RejectionReason       string            `json:"rejection_reason" gorm:"size:255"`
+}
+
+type ApplicationStatus string
+
+const (
+	ApplicationDraft     ApplicationStatus = "draft"     // Being filled in by the applicant
+	ApplicationSubmitted ApplicationStatus = "submitted" // Waiting for an admin
+	ApplicationApproved  ApplicationStatus = "approved"
+	ApplicationRejected  ApplicationStatus = "rejected"
+)
+
+// ShopProfile is how a seller presents their shop on the public storefront
+type ShopProfile struct {
+	SellerID    uuid.UUID `json:"seller_id" gorm:"type:varchar(36);primaryKey"`
+	Slug        string    `json:"slug" gorm:"size:100;uniqueIndex"` // Storefront address, /sellers/<slug>
+	DisplayName string    `json:"display_name" gorm:"size:100"`
+	Bio         string    `json:"bio" gorm:"type:text"`
+	BannerURL   string    `json:"banner_url" gorm:"size:255"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ShopFollower is a user following a seller's shop
+type ShopFollower struct {
+	SellerID  uuid.UUID `json:"seller_id" gorm:"type:varchar(36);primaryKey"`
+	UserID    uuid.UUID `json:"user_id" gorm:"type:varchar(36);primaryKey;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SellerRatingSummary is kept up to date as ratings are added, changed and removed
+type SellerRatingSummary struct {
+	SellerID  uuid.UUID `json:"seller_id" gorm:"type:varchar(36);primaryKey"`
+	Count     int       `json:"count"`
+	Total     int       `json:"total"` // Sum of the stars of every rating
+	Stars1    int       `json:"stars_1"`
+	Stars2    int       `json:"stars_2"`
+	Stars3    int       `json:"stars_3"`
+	Stars4    int       `json:"stars_4"`
+	Stars5    int       `json:"stars_5"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
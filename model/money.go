@@ -0,0 +1,27 @@
+package model
+
+import "github.com/dancankarani/palace/money"
+
+// Money and the functions below are those of the money package, kept here so models can use them unqualified
+type Money = money.Money
+
+var (
+	ErrCurrencyMismatch    = money.ErrCurrencyMismatch
+	ErrUnsupportedCurrency = money.ErrUnsupportedCurrency
+)
+
+func DefaultCurrency() string {
+	return money.DefaultCurrency()
+}
+
+func NewMoney(minor int64) Money {
+	return money.New(minor)
+}
+
+func ParseMoney(amount string) (Money, error) {
+	return money.Parse(amount)
+}
+
+func MoneyFromFloat(amount float64) Money {
+	return money.FromFloat(amount)
+}
@@ -0,0 +1,336 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dancankarani/palace/database"
+	"github.com/dancankarani/palace/oidc"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	oidcStateKeyPrefix = "oidc_state:"
+	oidcLinkKeyPrefix  = "oidc_link:"
+)
+
+var (
+	ErrOIDCStateInvalid    = oidc.ErrStateInvalid
+	ErrOIDCEmailUnverified = oidc.ErrEmailUnverified
+)
+
+// OIDCLinkRequiredError is returned when a provider identity matches an existing account
+// by email address, which the owner has to confirm with their password before it is linked
+type OIDCLinkRequiredError struct {
+	Provider string
+	Token    string // sent back with the password to LinkOIDCIdentity
+}
+
+func (e *OIDCLinkRequiredError) Error() string {
+	return fmt.Sprintf("an account with this email address already exists, enter its password to sign in with %s from now on", e.Provider)
+}
+
+var (
+	oidcProviders   = map[string]*oidc.Provider{}
+	oidcProvidersMu sync.Mutex
+)
+
+// redisStateStore keeps sign-ins waiting for the provider's callback in redis
+type redisStateStore struct{}
+
+func (redisStateStore) Save(ctx context.Context, state string, value []byte, lifetime time.Duration) error {
+	return database.RedisClient().Set(ctx, oidcStateKeyPrefix+state, value, lifetime).Err()
+}
+
+// Take reads and deletes the state in one transaction so a callback can't be replayed
+func (redisStateStore) Take(ctx context.Context, state string) ([]byte, error) {
+	pipe := database.RedisClient().TxPipeline()
+	get := pipe.Get(ctx, oidcStateKeyPrefix+state)
+	pipe.Del(ctx, oidcStateKeyPrefix+state)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	data, err := get.Bytes()
+	if err != nil {
+		return nil, oidc.ErrStateInvalid
+	}
+	return data, nil
+}
+
+// oidcLink is a provider identity waiting for the owner of the matching account to confirm it
+type oidcLink struct {
+	UserID   uuid.UUID `json:"user_id"`
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	Email    string    `json:"email"`
+}
+
+// oidcProvider returns the configured provider, kept so its keys stay cached
+func oidcProvider(name string) (*oidc.Provider, error) {
+	oidcProvidersMu.Lock()
+	defer oidcProvidersMu.Unlock()
+	if provider, ok := oidcProviders[name]; ok {
+		return provider, nil
+	}
+	provider, err := oidc.ProviderFromEnv(name, AppURL()+"/api/v1/user/oidc/"+name+"/callback")
+	if err != nil {
+		return nil, err
+	}
+	oidcProviders[name] = provider
+	return provider, nil
+}
+
+/*
+starts signing in with a provider, returning the address to send the user to and the state
+to tie to their browser. The PKCE verifier and nonce wait in redis for the callback.
+@params provider
+*/
+func StartOIDCLogin(providerName string) (authURL, state string, err error) {
+	provider, err := oidcProvider(providerName)
+	if err != nil {
+		return "", "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	authURL, state, err = provider.Begin(ctx, redisStateStore{})
+	if err != nil {
+		log.Println("error starting oidc sign-in:", err.Error())
+		return "", "", errors.New("failed to start sign-in")
+	}
+	return authURL, state, nil
+}
+
+/*
+finishes signing in with a provider once it sends the user back with a code. The user is
+found by their identity at the provider, else given a new customer account. An identity
+whose verified email address belongs to an existing account is only linked to it once
+the owner confirms with their password, see LinkOIDCIdentity.
+@params provider
+@params code
+@params state
+@params ip_address
+@params user_agent
+*/
+func FinishOIDCLogin(providerName, code, state, ip, userAgent string) (*User, error) {
+	provider, err := oidcProvider(providerName)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	token, err := provider.Finish(ctx, redisStateStore{}, state, code)
+	if err != nil {
+		if errors.Is(err, oidc.ErrStateInvalid) {
+			return nil, err
+		}
+		log.Println("error finishing oidc sign-in:", err.Error())
+		return nil, errors.New("failed to sign in with " + providerName)
+	}
+
+	user, link, err := oidcUser(providerName, token)
+	if err != nil {
+		if errors.Is(err, ErrOIDCEmailUnverified) || errors.Is(err, ErrInvalidCredentials) {
+			return nil, err
+		}
+		log.Println("error finding oidc user:", err.Error())
+		return nil, errors.New("failed to sign in with " + providerName)
+	}
+	if link != nil {
+		return nil, saveOIDCLink(ctx, *link)
+	}
+	recordLogin(*user, ip, userAgent, 0)
+	return user, nil
+}
+
+/*
+oidcUser finds or creates the account for a provider identity. An existing account with the
+identity's email address is returned as a link to confirm instead.
+*/
+func oidcUser(providerName string, token *oidc.IDToken) (*User, *oidcLink, error) {
+	var user User
+	var identity OAuthIdentity
+	err := db.First(&identity, "provider = ? AND subject = ?", providerName, token.Subject).Error
+	if err == nil {
+		if err := db.First(&user, "id = ?", identity.UserID).Error; err != nil {
+			return nil, nil, err
+		}
+		if !user.IsActive {
+			return nil, nil, ErrInvalidCredentials
+		}
+		return &user, nil, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, err
+	}
+
+	// an unverified address could belong to someone else's account
+	email, err := token.LinkableEmail()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = db.First(&user, "email = ?", email).Error
+	if err == nil {
+		if !user.IsActive {
+			return nil, nil, ErrInvalidCredentials
+		}
+		return nil, &oidcLink{UserID: user.ID, Provider: providerName, Subject: token.Subject, Email: email}, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, err
+	}
+
+	// the account has no password of its own until the user resets one
+	secret, err := utilities.RandomToken(32)
+	if err != nil {
+		return nil, nil, err
+	}
+	password, err := utilities.HashPassword(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	firstName, lastName := token.GivenName, token.FamilyName
+	if firstName == "" {
+		firstName = token.Name
+	}
+	now := time.Now()
+	user = User{
+		BaseModel:       BaseModel{ID: uuid.New()},
+		FirstName:       firstName,
+		LastName:        lastName,
+		Email:           email,
+		Password:        password,
+		UserRole:        "customer",
+		EmailVerifiedAt: &now,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&OAuthIdentity{
+			BaseModel: BaseModel{ID: uuid.New()},
+			UserID:    user.ID,
+			Provider:  providerName,
+			Subject:   token.Subject,
+			Email:     email,
+		}).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &user, nil, nil
+}
+
+// saveOIDCLink keeps an identity waiting for the account owner's password
+func saveOIDCLink(ctx context.Context, link oidcLink) error {
+	token, err := utilities.RandomToken(32)
+	if err != nil {
+		log.Println("error generating oidc link token:", err.Error())
+		return errors.New("failed to sign in with " + link.Provider)
+	}
+	data, _ := json.Marshal(link)
+	if err := database.RedisClient().Set(ctx, oidcLinkKeyPrefix+token, data, oidc.StateLifetime).Err(); err != nil {
+		log.Println("error saving oidc link:", err.Error())
+		return errors.New("failed to sign in with " + link.Provider)
+	}
+	return &OIDCLinkRequiredError{Provider: link.Provider, Token: token}
+}
+
+/*
+links a provider identity to the existing account with its email address once the owner
+enters the account's password, and signs them in. Wrong passwords are throttled like
+those of a normal sign-in.
+@params link token
+@params password
+@params ip_address
+@params user_agent
+*/
+func LinkOIDCIdentity(token, password, ip, userAgent string) (*User, error) {
+	if token == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	ctx := context.Background()
+	rdb := database.RedisClient()
+	data, err := rdb.Get(ctx, oidcLinkKeyPrefix+token).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Println("error reading oidc link:", err.Error())
+			return nil, errors.New("failed to link sign-in")
+		}
+		return nil, ErrOIDCStateInvalid
+	}
+	var link oidcLink
+	if json.Unmarshal(data, &link) != nil {
+		return nil, ErrOIDCStateInvalid
+	}
+
+	var user User
+	if err := db.First(&user, "id = ?", link.UserID).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("error finding user to link:", err.Error())
+			return nil, errors.New("failed to link sign-in")
+		}
+		return nil, ErrOIDCStateInvalid
+	}
+	account := loginThrottle{key: "account:" + strings.ToLower(user.Email), free: accountFreeLoginAttempts, lockout: accountLockoutAttempts}
+	address := loginThrottle{key: "ip:" + ip, free: ipFreeLoginAttempts, lockout: ipLockoutAttempts}
+	for _, throttle := range []loginThrottle{account, address} {
+		if wait := throttle.blockedFor(ctx, rdb); wait > 0 {
+			return nil, &LoginBlockedError{RetryAfter: wait}
+		}
+	}
+	if err := utilities.CompareHashAndPassowrd(user.Password, password); err != nil || !user.IsActive {
+		if failures := account.fail(ctx, rdb); failures == accountLockoutAttempts {
+			notifyLoginLockout(user)
+		}
+		address.fail(ctx, rdb)
+		return nil, ErrInvalidCredentials
+	}
+	// spent only now, so a mistyped password can be tried again
+	if deleted, err := rdb.Del(ctx, oidcLinkKeyPrefix+token).Result(); err != nil || deleted == 0 {
+		return nil, ErrOIDCStateInvalid
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if user.EmailVerifiedAt == nil {
+			if err := tx.Model(&user).Update("email_verified_at", time.Now()).Error; err != nil {
+				return err
+			}
+		}
+		err := tx.Create(&OAuthIdentity{
+			BaseModel: BaseModel{ID: uuid.New()},
+			UserID:    user.ID,
+			Provider:  link.Provider,
+			Subject:   link.Subject,
+			Email:     link.Email,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return notifyUser(tx, Notification{
+			UserID:    user.ID,
+			Kind:      "sign_in_linked",
+			Title:     "Sign-in with " + link.Provider + " linked",
+			Body:      "Your " + link.Provider + " account with the address " + link.Email + " can now sign in to your account. If this was not you, reset your password and contact support.",
+			SendEmail: true,
+		})
+	})
+	if err != nil {
+		log.Println("error linking oidc identity:", err.Error())
+		return nil, errors.New("failed to link sign-in")
+	}
+
+	priorFailures := account.failures(ctx, rdb)
+	account.reset(ctx, rdb)
+	recordLogin(user, ip, userAgent, priorFailures)
+	return &user, nil
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"errors"
 	"fmt"
+	"log"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,6 +17,9 @@ import (
 		if userID == uuid.Nil {
 			return nil, errors.New("user ID is required")
 		}
+		if err := RequireVerified(userID); err != nil {
+			return nil, err
+		}
 		if len(items) == 0 {
 			return nil, errors.New("at least one item is required")
 		}
@@ -39,7 +43,7 @@ import (
 			BaseModel:       BaseModel{ID: uuid.New()},
 			OrderNumber:     generateOrderNumber(),
 			UserID:          userID,
-			TotalAmount:     0, // Will be calculated
+			TotalAmount:     NewMoney(0), // Will be calculated
 			PaymentStatus:   PaymentPending,
 			PaymentMethod:   paymentMethod,
 			ShippingAddress: shippingAddress,
@@ -52,17 +56,42 @@ import (
 			return nil, fmt.Errorf("failed to create order: %v", err)
 		}
 	
-		var totalAmount float64
+		// the order is in the currency of its first item, the others have to match it
+		var totalAmount Money
 		// Create order items and update product stock
 		for _, itemReq := range items {
 			// Get product details
 			var product Product
-			if err := tx.First(&product, "id = ?", itemReq.ProductID).Error; err != nil {
+			if itemReq.ProductID == nil {
+				tx.Rollback()
+				return nil, errors.New("product ID is required for every item")
+			}
+			if err := tx.First(&product, "id = ?", *itemReq.ProductID).Error; err != nil {
 				tx.Rollback()
 				return nil, fmt.Errorf("product not found: %v", err)
 			}
+			if product.Status != ProductActive {
+				tx.Rollback()
+				return nil, fmt.Errorf("%w: %s", ErrProductUnavailable, product.Name)
+			}
+	
+			if itemReq.Quantity <= 0 {
+				tx.Rollback()
+				return nil, fmt.Errorf("quantity for product %s must be greater than 0", product.Name)
+			}
+
+			// Resolve the size/colour and its price
+			variant, unitPrice, err := resolveVariant(tx, product, itemReq.VariantID)
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
 	
 			// Check stock
+			if variant != nil && variant.Stock < itemReq.Quantity {
+				tx.Rollback()
+				return nil, fmt.Errorf("not enough stock for product %s (%s %s)", product.Name, variant.Colour, variant.Size)
+			}
 			if product.Stock < itemReq.Quantity {
 				tx.Rollback()
 				return nil, fmt.Errorf("not enough stock for product %s", product.Name)
@@ -72,10 +101,13 @@ import (
 			orderItem := OrderItem{
 				BaseModel:   BaseModel{ID: uuid.New()},
 				OrderID:     order.ID,
-				ProductID:   product.ID,
+				ProductID:   &product.ID,
 				Quantity:    itemReq.Quantity,
-				Price:       product.Price,
-				TotalPrice:  product.Price * float64(itemReq.Quantity),
+				Price:       unitPrice,
+				TotalPrice:  unitPrice.Mul(int64(itemReq.Quantity)),
+			}
+			if variant != nil {
+				orderItem.VariantID = &variant.ID
 			}
 	
 			if err := tx.Create(&orderItem).Error; err != nil {
@@ -83,21 +115,37 @@ import (
 				return nil, fmt.Errorf("failed to create order item: %v", err)
 			}
 	
-			// Update product stock
-			if err := tx.Model(&Product{}).
-				Where("id = ?", product.ID).
-				Update("stock", gorm.Expr("stock - ?", itemReq.Quantity)).Error; err != nil {
+			// Take the items out of stock, refusing to go below zero
+			err = changeStock(tx, &InventoryMovement{
+				ProductID: product.ID,
+				VariantID: orderItem.VariantID,
+				Change:    -itemReq.Quantity,
+				Reason:    InventorySale,
+				OrderID:   &order.ID,
+				ActorID:   &userID,
+			})
+			if err != nil {
 				tx.Rollback()
-				return nil, fmt.Errorf("failed to update product stock: %v", err)
+				return nil, fmt.Errorf("failed to update stock for product %s: %w", product.Name, ErrInsufficientStock)
 			}
 	
-			totalAmount += orderItem.TotalPrice
+			if totalAmount.IsZero() {
+				totalAmount.Currency = orderItem.TotalPrice.CurrencyCode()
+			}
+			if totalAmount, err = totalAmount.Add(orderItem.TotalPrice); err != nil {
+				tx.Rollback()
+				return nil, fmt.Errorf("product %s can't be ordered with the other items: %w", product.Name, err)
+			}
 		}
 	
 		// Update order with total amount
 		if err := tx.Model(&Order{}).
 			Where("id = ?", order.ID).
-			Update("total_amount", totalAmount).Error; err != nil {
+			Updates(map[string]interface{}{
+				"total_amount_minor":    totalAmount.Minor,
+				"total_amount_currency": totalAmount.Currency,
+				"amount_paid_currency":  totalAmount.Currency,
+			}).Error; err != nil {
 			tx.Rollback()
 			return nil, fmt.Errorf("failed to update order total: %v", err)
 		}
@@ -115,6 +163,81 @@ import (
 		return &order, nil
 	}
 
+var ErrOrderNotCancellable = errors.New("only unpaid orders that are still processing can be cancelled")
+
+/*
+cancels one of the user's orders, or any order for an admin, and puts its items
+back in stock. Paid orders have to be refunded first.
+@params order_id
+*/
+func CancelOrder(c *fiber.Ctx, orderID uuid.UUID) (*Order, error) {
+	userID, _ := GetAuthUserID(c)
+	var order Order
+	if err := db.Preload("Items").First(&order, "id = ?", orderID).Error; err != nil {
+		return nil, errors.New("order not found")
+	}
+	if order.UserID != userID && GetAuthUser(c) != "admin" {
+		return nil, errors.New("order not found")
+	}
+	if order.OrderStatus != OrderProcessing || order.AmountPaid.IsPositive() {
+		return nil, ErrOrderNotCancellable
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Order{}).
+			Where("id = ? AND order_status = ?", order.ID, OrderProcessing).
+			Update("order_status", OrderCancelled)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOrderNotCancellable
+		}
+		for _, item := range order.Items {
+			if item.ProductID == nil {
+				continue
+			}
+			err := changeStock(tx, &InventoryMovement{
+				ProductID: *item.ProductID,
+				VariantID: item.VariantID,
+				Change:    item.Quantity,
+				Reason:    InventoryCancellation,
+				OrderID:   &order.ID,
+				ActorID:   &userID,
+			})
+			// a variant deleted since the order was placed has no stock to return to
+			if errors.Is(err, ErrVariantNotFound) || errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+		}
+		// a booked service is released along with its order
+		return tx.Model(&Booking{}).
+			Where("order_id = ? AND status IN ?", order.ID, []BookingStatus{BookingPending, BookingConfirmed}).
+			Updates(map[string]interface{}{"status": BookingCancelled, "cancelled_by": userID}).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrOrderNotCancellable) {
+			return nil, err
+		}
+		log.Println("error cancelling order:", err.Error())
+		return nil, errors.New("failed to cancel order")
+	}
+	order.OrderStatus = OrderCancelled
+	return &order, nil
+}
+
+// Listing returns the name and seller of the product or service an order item is for.
+// Product, or Service for a booking, has to be preloaded; seller is only set when its User is too.
+func (item OrderItem) Listing() (name string, sellerID uuid.UUID, seller User) {
+	if item.Service != nil {
+		return item.Service.Name, item.Service.SellerID, item.Service.User
+	}
+	return item.Product.Name, item.Product.SellerID, item.Product.User
+}
+
 func generateOrderNumber() string {
 	return fmt.Sprintf("ORD-%d", time.Now().UnixNano())
 }
@@ -201,11 +324,15 @@ func GetOrders(c *fiber.Ctx) error {
 
 	// Calculate summary statistics
 	totalOrders := len(orders)
-	var totalRevenue float64
+	totalRevenue := NewMoney(0)
 	var totalItems int
 
 	for _, order := range orders {
-		totalRevenue += order.TotalAmount
+		if totalRevenue, err = totalRevenue.Add(order.TotalAmount); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Orders are in more than one currency",
+			})
+		}
 		for _, item := range order.Items {
 			totalItems += item.Quantity
 		}
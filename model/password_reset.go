@@ -0,0 +1,201 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dancankarani/palace/database"
+	"github.com/dancankarani/palace/middleware"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	resetLinkLifetime      = 30 * time.Minute
+	maxResetLinksPerHour   = 3
+	maxResetFailures       = 10 // invalid tokens tried from one address before it is blocked
+	resetFailureWindow     = 15 * time.Minute
+	minPasswordLength      = 8
+	resetFailuresKeyPrefix = "reset_failures:"
+)
+
+var (
+	ErrInvalidResetToken    = errors.New("the reset link is invalid or has expired, request a new one")
+	ErrTooManyResetAttempts = errors.New("too many attempts, try again later")
+	ErrWeakPassword         = fmt.Errorf("password must be at least %d characters", minPasswordLength)
+)
+
+// resetPasswordURL is the page the reset link opens, read from RESET_PASSWORD_URL
+func resetPasswordURL() string {
+	if url := os.Getenv("RESET_PASSWORD_URL"); url != "" {
+		return url
+	}
+	return AppURL() + "/reset-password"
+}
+
+/*
+emails a password reset link to the account with the given email address. Nothing is
+returned so the caller cannot tell whether the account exists; failures are logged.
+At most three links an hour are sent per account and each new link replaces the last.
+@params email
+*/
+func RequestPasswordReset(email string) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return
+	}
+	var user User
+	if err := db.First(&user, "email = ?", email).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("error finding user for password reset:", err.Error())
+		}
+		return
+	}
+	if !user.IsActive {
+		return
+	}
+
+	token, err := utilities.RandomToken(32)
+	if err != nil {
+		log.Println("error generating password reset token:", err.Error())
+		return
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var recent int64
+		err := tx.Model(&PasswordResetToken{}).
+			Where("user_id = ? AND created_at > ?", user.ID, time.Now().Add(-time.Hour)).
+			Count(&recent).Error
+		if err != nil {
+			return err
+		}
+		if recent >= maxResetLinksPerHour {
+			return ErrTooManyCodes
+		}
+		now := time.Now()
+		err = tx.Model(&PasswordResetToken{}).Where("user_id = ? AND used_at IS NULL", user.ID).
+			Update("used_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&PasswordResetToken{
+			BaseModel: BaseModel{ID: uuid.New()},
+			UserID:    user.ID,
+			TokenHash: utilities.ContentHash([]byte(token)),
+			ExpiresAt: now.Add(resetLinkLifetime),
+		}).Error
+	})
+	if errors.Is(err, ErrTooManyCodes) {
+		log.Println("password reset limit reached for user", user.ID.String())
+		return
+	}
+	if err != nil {
+		log.Println("error issuing password reset token:", err.Error())
+		return
+	}
+
+	link := resetPasswordURL() + "?token=" + token
+	body := fmt.Sprintf("Hi %s,\n\nReset your password by opening this link:\n%s\n\nThe link expires in 30 minutes and can only be used once. If you did not ask to reset your password you can ignore this email.", user.FirstName, link)
+	if err := utilities.SendEmail(user.Email, "Reset your password", body); err != nil {
+		log.Println("error sending password reset email:", err.Error())
+	}
+}
+
+/*
+sets a new password using the token from a reset link and signs the user out of every
+session. Addresses that try too many invalid tokens are blocked for a while.
+@params token
+@params password
+@params ip_address
+*/
+func ResetPasswordWithToken(token, password, ip string) error {
+	ctx := context.Background()
+	redisClient := database.RedisClient()
+	failuresKey := resetFailuresKeyPrefix + ip
+	failures, err := redisClient.Get(ctx, failuresKey).Int()
+	if err == nil && failures >= maxResetFailures {
+		return ErrTooManyResetAttempts
+	}
+	if len(password) < minPasswordLength {
+		return ErrWeakPassword
+	}
+
+	recordFailure := func() {
+		if err := redisClient.Incr(ctx, failuresKey).Err(); err != nil {
+			log.Println("error counting password reset failure:", err.Error())
+			return
+		}
+		redisClient.Expire(ctx, failuresKey, resetFailureWindow)
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return ErrInvalidResetToken
+	}
+	var reset PasswordResetToken
+	err = db.First(&reset, "token_hash = ? AND used_at IS NULL", utilities.ContentHash([]byte(token))).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			recordFailure()
+			return ErrInvalidResetToken
+		}
+		log.Println("error finding password reset token:", err.Error())
+		return errors.New("failed to reset password")
+	}
+	if time.Now().After(reset.ExpiresAt) {
+		recordFailure()
+		return ErrInvalidResetToken
+	}
+
+	hashed, err := utilities.HashPassword(password)
+	if err != nil {
+		log.Println("error hashing password:", err.Error())
+		return errors.New("failed to reset password")
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		// the token can only be spent once, even by two requests racing each other
+		result := tx.Model(&PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", reset.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidResetToken
+		}
+		if err := tx.Model(&User{}).Where("id = ?", reset.UserID).Update("password", hashed).Error; err != nil {
+			return err
+		}
+		err := tx.Model(&PasswordResetToken{}).Where("user_id = ? AND used_at IS NULL", reset.UserID).
+			Update("used_at", now).Error
+		if err != nil {
+			return err
+		}
+		if err := notifyUser(tx, Notification{
+			UserID:    reset.UserID,
+			Kind:      "password_changed",
+			Title:     "Your password was changed",
+			Body:      "Your password was reset and every device was signed out. If this was not you, reset your password again and contact support.",
+			SendEmail: true,
+		}); err != nil {
+			return err
+		}
+		// signed out last, so the reset is rolled back and can be tried again when it fails
+		return middleware.RevokeUserTokens(reset.UserID)
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidResetToken) {
+			return err
+		}
+		log.Println("error resetting password:", err.Error())
+		return errors.New("failed to reset password")
+	}
+	redisClient.Del(ctx, failuresKey)
+	return nil
+}
@@ -0,0 +1,357 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// C2BTransaction is the payload Safaricom posts to the C2B validation and confirmation URLs
+type C2BTransaction struct {
+	TransactionType   string `json:"TransactionType"`
+	TransID           string `json:"TransID"`
+	TransTime         string `json:"TransTime"`
+	TransAmount       string `json:"TransAmount"`
+	BusinessShortCode string `json:"BusinessShortCode"`
+	BillRefNumber     string `json:"BillRefNumber"`
+	InvoiceNumber     string `json:"InvoiceNumber"`
+	OrgAccountBalance string `json:"OrgAccountBalance"`
+	ThirdPartyTransID string `json:"ThirdPartyTransID"`
+	MSISDN            string `json:"MSISDN"`
+	FirstName         string `json:"FirstName"`
+	MiddleName        string `json:"MiddleName"`
+	LastName          string `json:"LastName"`
+}
+
+// MpesaCurrency is the only currency M-Pesa takes payments and pays out in
+const MpesaCurrency = "KES"
+
+var (
+	ErrCurrencyNotPayable = errors.New("M-Pesa only takes payments in " + MpesaCurrency)
+	ErrUnknownOrder       = errors.New("unknown order reference")
+	ErrInvalidAmount      = errors.New("invalid payment amount")
+	ErrOrderSettled       = errors.New("order is already paid")
+	ErrOrderClosed        = errors.New("order is cancelled")
+	ErrUnknownCheckout    = errors.New("unknown STK push request")
+)
+
+/*
+checks that a paybill/till payment references an order that can still be paid
+@params order_number
+@params amount
+*/
+func ValidateC2BPayment(orderNumber string, amount Money) error {
+	if !amount.IsPositive() {
+		return ErrInvalidAmount
+	}
+	amount.Currency = MpesaCurrency
+
+	var order Order
+	if err := db.Where("order_number = ?", strings.TrimSpace(orderNumber)).First(&order).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUnknownOrder
+		}
+		log.Println("error finding order for c2b validation:", err.Error())
+		return errors.New("failed to validate payment")
+	}
+	if order.OrderStatus == OrderCancelled {
+		return ErrOrderClosed
+	}
+	balance, err := order.Balance()
+	if err != nil {
+		log.Println("error validating c2b payment for", order.OrderNumber+":", err.Error())
+		return errors.New("failed to validate payment")
+	}
+	if !balance.IsPositive() {
+		return ErrOrderSettled
+	}
+	// an order in another currency can't be paid for with M-Pesa
+	if over, err := amount.Cmp(balance); err != nil || over > 0 {
+		return ErrInvalidAmount
+	}
+	return nil
+}
+
+/*
+records a confirmed paybill/till payment and applies it to the referenced order.
+Repeated confirmations for the same M-Pesa transaction are ignored, and payments to
+cancelled orders are refused.
+@params txn
+@params amount
+*/
+func RecordC2BPayment(txn C2BTransaction, amount Money) (*Payment, error) {
+	if !amount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+	amount.Currency = MpesaCurrency
+
+	tx := db.Begin()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+		}
+	}()
+
+	// Safaricom may deliver the same confirmation more than once
+	var existing Payment
+	err := tx.Where("transaction_id = ?", txn.TransID).First(&existing).Error
+	if err == nil {
+		tx.Rollback()
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to check existing payment: %v", err)
+	}
+
+	var order Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("order_number = ?", strings.TrimSpace(txn.BillRefNumber)).
+		First(&order).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownOrder
+		}
+		return nil, fmt.Errorf("failed to find order: %v", err)
+	}
+	if order.OrderStatus == OrderCancelled {
+		tx.Rollback()
+		return nil, ErrOrderClosed
+	}
+
+	payment := Payment{
+		ID:               uuid.New(),
+		OrderID:          order.ID,
+		CustomerID:       order.UserID,
+		Cost:             amount,
+		PaymentMethod:    "M-Pesa C2B",
+		TransactionID:    txn.TransID,
+		PaymentStatus:    PaymentPaid,
+		CustomerPhone:    txn.MSISDN,
+		CustomerName:     strings.TrimSpace(strings.Join([]string{txn.FirstName, txn.MiddleName, txn.LastName}, " ")),
+		AccountReference: txn.BillRefNumber,
+		TransactionDesc:  txn.TransactionType,
+		TransactionDate:  txn.TransTime,
+	}
+	if err := tx.Create(&payment).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to save payment: %v", err)
+	}
+	paidOff, err := settleOrderPayment(tx, &order, &payment)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("transaction commit failed: %v", err)
+	}
+
+	if paidOff {
+		go EmailReceipt(order.ID)
+	}
+	return &payment, nil
+}
+
+/*
+settles a paid M-Pesa payment against its order, whichever way it was paid: the payment is
+posted to the ledger and added to what has been paid on the order. It reports whether the
+payment paid the order off, so the receipt is emailed once, after the transaction commits.
+@params tx
+@params order
+@params payment
+*/
+func settleOrderPayment(tx *gorm.DB, order *Order, payment *Payment) (bool, error) {
+	if err := postPaymentReceived(tx, payment); err != nil {
+		return false, fmt.Errorf("failed to post payment to ledger: %v", err)
+	}
+	previousStatus := order.PaymentStatus
+	if err := applyPaymentToOrder(tx, order, payment.Cost); err != nil {
+		return false, err
+	}
+	return previousStatus != PaymentPaid && order.PaymentStatus == PaymentPaid, nil
+}
+
+/*
+finds the customer's order an STK push is for and the balance to ask them for
+@params user_id
+@params order_number
+*/
+func PrepareSTKPayment(userID uuid.UUID, orderNumber string) (*Order, Money, error) {
+	var order Order
+	err := db.Where("order_number = ? AND user_id = ?", strings.TrimSpace(orderNumber), userID).First(&order).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, Money{}, ErrUnknownOrder
+		}
+		log.Println("error finding order for stk push:", err.Error())
+		return nil, Money{}, errors.New("failed to start payment")
+	}
+	if order.OrderStatus == OrderCancelled {
+		return nil, Money{}, ErrOrderClosed
+	}
+	balance, err := order.Balance()
+	if err != nil {
+		log.Println("error starting stk payment for", order.OrderNumber+":", err.Error())
+		return nil, Money{}, errors.New("failed to start payment")
+	}
+	if !balance.IsPositive() {
+		return nil, Money{}, ErrOrderSettled
+	}
+	if balance.CurrencyCode() != MpesaCurrency {
+		return nil, Money{}, ErrCurrencyNotPayable
+	}
+	return &order, balance, nil
+}
+
+/*
+saves an STK push M-Pesa accepted as a pending payment on the order, for its callback to
+be matched to
+@params order
+@params amount requested
+@params phone_number
+@params checkout_request_id
+*/
+func SaveSTKPayment(order *Order, amount Money, phone, checkoutRequestID string) (*Payment, error) {
+	payment := Payment{
+		ID:                uuid.New(),
+		OrderID:           order.ID,
+		CustomerID:        order.UserID,
+		Cost:              amount,
+		PaymentMethod:     "M-Pesa",
+		PaymentStatus:     PaymentPending,
+		CustomerPhone:     phone,
+		AccountReference:  order.OrderNumber,
+		TransactionDesc:   "Payment for order " + order.OrderNumber,
+		CheckoutRequestID: checkoutRequestID,
+	}
+	if err := db.Create(&payment).Error; err != nil {
+		log.Println("error saving stk payment:", err.Error())
+		return nil, errors.New("failed to save payment details")
+	}
+	return &payment, nil
+}
+
+// STKResult is what the callback of an STK push reports
+type STKResult struct {
+	CheckoutRequestID string
+	ResultCode        int
+	ResultDesc        string
+	Amount            Money
+	ReceiptNumber     string
+	PhoneNumber       string
+	TransactionDate   string
+}
+
+/*
+records the result of an STK push. A successful one is settled against its order the same
+way as a paybill payment. Repeated callbacks for the same push are ignored.
+@params result
+*/
+func RecordSTKResult(result STKResult) (*Payment, error) {
+	var payment Payment
+	paidOff := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&payment, "checkout_request_id = ?", result.CheckoutRequestID).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrUnknownCheckout
+			}
+			return err
+		}
+		if payment.PaymentStatus != PaymentPending {
+			return nil
+		}
+		if result.ResultCode != 0 {
+			payment.PaymentStatus = PaymentFailed
+			return tx.Model(&payment).Updates(map[string]interface{}{
+				"payment_status":   PaymentFailed,
+				"transaction_desc": result.ResultDesc,
+			}).Error
+		}
+
+		var order Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", payment.OrderID).Error; err != nil {
+			return fmt.Errorf("failed to find order: %v", err)
+		}
+		if order.OrderStatus == OrderCancelled {
+			return ErrOrderClosed
+		}
+		if result.Amount.IsPositive() {
+			payment.Cost = Money{Minor: result.Amount.Minor, Currency: MpesaCurrency}
+		}
+		payment.PaymentStatus = PaymentPaid
+		payment.TransactionID = result.ReceiptNumber
+		payment.TransactionDate = result.TransactionDate
+		if result.PhoneNumber != "" {
+			payment.CustomerPhone = result.PhoneNumber
+		}
+		err = tx.Model(&Payment{}).Where("id = ?", payment.ID).Updates(map[string]interface{}{
+			"payment_status":   payment.PaymentStatus,
+			"transaction_id":   payment.TransactionID,
+			"transaction_date": payment.TransactionDate,
+			"customer_phone":   payment.CustomerPhone,
+			"cost_minor":       payment.Cost.Minor,
+			"cost_currency":    payment.Cost.CurrencyCode(),
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to save payment: %v", err)
+		}
+		paidOff, err = settleOrderPayment(tx, &order, &payment)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if paidOff {
+		go EmailReceipt(payment.OrderID)
+	}
+	return &payment, nil
+}
+
+// applyPaymentToOrder adds amount to what has been paid on the order and updates its payment status
+func applyPaymentToOrder(tx *gorm.DB, order *Order, amount Money) error {
+	paid, err := order.AmountPaid.Add(amount)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+	}
+	status, err := paymentStatusFor(order.TotalAmount, paid)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+	}
+	order.AmountPaid, order.PaymentStatus = paid, status
+	if err := tx.Model(&Order{}).Where("id = ?", order.ID).Updates(map[string]interface{}{
+		"amount_paid_minor":    order.AmountPaid.Minor,
+		"amount_paid_currency": order.AmountPaid.CurrencyCode(),
+		"payment_status":       order.PaymentStatus,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update order payment: %v", err)
+	}
+	return nil
+}
+
+// paymentStatusFor derives an order's payment status from its total and the amount paid so far
+func paymentStatusFor(total, paid Money) (PaymentStatus, error) {
+	cmp, err := paid.Cmp(total)
+	switch {
+	case err != nil:
+		return "", err
+	case !paid.IsPositive():
+		return PaymentPending, nil
+	case cmp < 0:
+		return PaymentPartial, nil
+	default:
+		return PaymentPaid, nil
+	}
+}
+
+// Balance returns what is still owed on the order. A negative balance is an overpayment.
+func (o Order) Balance() (Money, error) {
+	return o.TotalAmount.Sub(o.AmountPaid)
+}
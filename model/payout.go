@@ -0,0 +1,532 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dancankarani/palace/ledger"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	defaultCommissionBasisPoints = 1000  // 10%
+	defaultMinimumPayout         = 10000 // 100.00
+)
+
+// CommissionBasisPoints is the share of each sale kept by the platform in hundredths of a
+// percent, read from PLATFORM_COMMISSION_RATE as a fraction such as "0.10"
+func CommissionBasisPoints() int64 {
+	rate, err := strconv.ParseFloat(os.Getenv("PLATFORM_COMMISSION_RATE"), 64)
+	if err != nil || rate < 0 || rate >= 1 {
+		return defaultCommissionBasisPoints
+	}
+	return int64(math.Round(rate * 10000))
+}
+
+// MinimumPayout is the smallest balance paid out to a seller, read from PAYOUT_MINIMUM
+func MinimumPayout() Money {
+	minimum, err := ParseMoney(os.Getenv("PAYOUT_MINIMUM"))
+	if err != nil || !minimum.IsPositive() {
+		return NewMoney(defaultMinimumPayout)
+	}
+	return minimum
+}
+
+/*
+credits sellers for every delivered and paid order item that has not been credited yet. An
+order is delivered once its buyer confirms it, or OrderConfirmWindow after it was shipped.
+*/
+func CreditDeliveredOrderItems() (int, error) {
+	var items []OrderItem
+	err := db.Model(&OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Joins("LEFT JOIN seller_ledger_entries ON seller_ledger_entries.order_item_id = order_items.id").
+		Where("orders.order_status = ? AND orders.payment_status = ? AND seller_ledger_entries.id IS NULL", OrderDelivered, PaymentPaid).
+		Preload("Product", func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }).
+		Preload("Service", func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }).
+		Preload("Order").
+		Find(&items).Error
+	if err != nil {
+		log.Println("error fetching delivered order items:", err.Error())
+		return 0, errors.New("failed to fetch delivered order items")
+	}
+
+	basisPoints := CommissionBasisPoints()
+	credited := 0
+	for _, item := range items {
+		name, sellerID, _ := item.Listing()
+		if sellerID == uuid.Nil {
+			log.Println("skipping order item without seller:", item.ID)
+			continue
+		}
+		gross := item.TotalPrice
+		commission := gross.Percent(basisPoints)
+		net, err := gross.Sub(commission)
+		if err != nil {
+			log.Println("error crediting order item", item.ID, ":", err.Error())
+			continue
+		}
+		itemID := item.ID
+		entry := SellerLedgerEntry{
+			BaseModel:   BaseModel{ID: uuid.New()},
+			SellerID:    sellerID,
+			EntryType:   LedgerSale,
+			OrderItemID: &itemID,
+			GrossAmount: gross,
+			Commission:  commission,
+			Amount:      net,
+			Description: fmt.Sprintf("%d x %s", item.Quantity, name),
+		}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&entry).Error; err != nil {
+				return err
+			}
+			return postSale(tx, &entry, item.Order.UserID)
+		})
+		if err != nil {
+			log.Println("error crediting order item", item.ID, ":", err.Error())
+			continue
+		}
+		credited++
+	}
+	return credited, nil
+}
+
+// sumByCurrency adds up a Money column of the rows of a query, one amount per currency
+func sumByCurrency(query *gorm.DB, column string) ([]Money, error) {
+	amounts := []Money{}
+	err := query.
+		Select(column + "_currency AS currency, COALESCE(SUM(" + column + "_minor), 0) AS minor").
+		Group(column + "_currency").
+		Order(column + "_currency").
+		Scan(&amounts).Error
+	return amounts, err
+}
+
+// SellerBalance returns the amounts currently available for payout to the seller, one per currency
+func SellerBalance(sellerID uuid.UUID) ([]Money, error) {
+	balance, err := sumByCurrency(db.Model(&SellerLedgerEntry{}).Where("seller_id = ?", sellerID), "amount")
+	if err != nil {
+		log.Println("error computing seller balance:", err.Error())
+		return nil, errors.New("failed to get seller balance")
+	}
+	return balance, nil
+}
+
+// SellerBalanceSummary holds one amount per currency the seller has sold in
+type SellerBalanceSummary struct {
+	Available       []Money             `json:"available"`
+	TotalEarned     []Money             `json:"total_earned"`
+	TotalCommission []Money             `json:"total_commission"`
+	TotalPaidOut    []Money             `json:"total_paid_out"`
+	InFlight        []Money             `json:"in_flight"`
+	Entries         []SellerLedgerEntry `json:"entries"`
+	Payouts         []Payout            `json:"payouts"`
+}
+
+/*
+gets a seller's balance together with their ledger and payout history
+@params seller_id
+@params limit
+*/
+func GetSellerBalanceSummary(sellerID uuid.UUID, limit int) (*SellerBalanceSummary, error) {
+	summary := SellerBalanceSummary{}
+	var err error
+	if summary.Available, err = SellerBalance(sellerID); err != nil {
+		return nil, err
+	}
+
+	sales := func() *gorm.DB {
+		return db.Model(&SellerLedgerEntry{}).Where("seller_id = ? AND entry_type = ?", sellerID, LedgerSale)
+	}
+	if summary.TotalEarned, err = sumByCurrency(sales(), "amount"); err != nil {
+		log.Println("error computing seller earnings:", err.Error())
+		return nil, errors.New("failed to get seller balance")
+	}
+	if summary.TotalCommission, err = sumByCurrency(sales(), "commission"); err != nil {
+		log.Println("error computing seller earnings:", err.Error())
+		return nil, errors.New("failed to get seller balance")
+	}
+
+	paid := db.Model(&Payout{}).Where("seller_id = ? AND status = ?", sellerID, PayoutPaid)
+	if summary.TotalPaidOut, err = sumByCurrency(paid, "amount"); err != nil {
+		log.Println("error computing seller payouts:", err.Error())
+		return nil, errors.New("failed to get seller balance")
+	}
+	inFlight := db.Model(&Payout{}).Where("seller_id = ? AND status IN ?", sellerID, []PayoutStatus{PayoutPending, PayoutProcessing, PayoutUnknown})
+	if summary.InFlight, err = sumByCurrency(inFlight, "amount"); err != nil {
+		log.Println("error computing seller payouts:", err.Error())
+		return nil, errors.New("failed to get seller balance")
+	}
+
+	if err := db.Where("seller_id = ?", sellerID).Order("created_at DESC").Limit(limit).Find(&summary.Entries).Error; err != nil {
+		log.Println("error fetching seller ledger:", err.Error())
+		return nil, errors.New("failed to get seller ledger")
+	}
+	if err := db.Where("seller_id = ?", sellerID).Order("created_at DESC").Limit(limit).Find(&summary.Payouts).Error; err != nil {
+		log.Println("error fetching seller payouts:", err.Error())
+		return nil, errors.New("failed to get seller payouts")
+	}
+	return &summary, nil
+}
+
+/*
+creates a pending payout for every seller whose balance in M-Pesa's currency has reached the
+minimum payout. The payout amount is debited from the balance straight away so it cannot be
+paid twice. Balances in other currencies are held, as M-Pesa can't pay them out.
+*/
+func CreatePendingPayouts() ([]Payout, error) {
+	var sellerIDs []uuid.UUID
+	err := db.Model(&SellerLedgerEntry{}).
+		Where("amount_currency = ?", MpesaCurrency).
+		Group("seller_id").
+		Having("SUM(amount_minor) >= ?", MinimumPayout().Minor).
+		Pluck("seller_id", &sellerIDs).Error
+	if err != nil {
+		log.Println("error fetching seller balances:", err.Error())
+		return nil, errors.New("failed to fetch seller balances")
+	}
+
+	var payouts []Payout
+	for _, sellerID := range sellerIDs {
+		payout, err := createPendingPayout(sellerID)
+		if errors.Is(err, errNoPayoutDue) {
+			continue
+		}
+		if err != nil {
+			log.Println("error creating payout for seller", sellerID, ":", err.Error())
+			continue
+		}
+		payouts = append(payouts, *payout)
+	}
+	return payouts, nil
+}
+
+// errNoPayoutDue is returned by createPendingPayout for a seller who is not to be paid this run
+var errNoPayoutDue = errors.New("no payout due")
+
+/*
+createPendingPayout pays out a seller's balance in M-Pesa's currency. The seller row is locked while the
+balance is read and the payout is created so two runs cannot pay the same balance twice.
+*/
+func createPendingPayout(sellerID uuid.UUID) (*Payout, error) {
+	var payout Payout
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var seller User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&seller, "id = ?", sellerID).Error; err != nil {
+			return err
+		}
+		var inFlight int64
+		err := tx.Model(&Payout{}).
+			Where("seller_id = ? AND amount_currency = ? AND status IN ?", sellerID, MpesaCurrency, []PayoutStatus{PayoutPending, PayoutProcessing, PayoutUnknown}).
+			Count(&inFlight).Error
+		if err != nil {
+			return err
+		}
+		if inFlight > 0 {
+			return errNoPayoutDue
+		}
+		var balance int64
+		err = tx.Model(&SellerLedgerEntry{}).
+			Where("seller_id = ? AND amount_currency = ?", sellerID, MpesaCurrency).
+			Select("COALESCE(SUM(amount_minor), 0)").
+			Scan(&balance).Error
+		if err != nil {
+			return err
+		}
+		if balance < MinimumPayout().Minor {
+			return errNoPayoutDue
+		}
+
+		// the number verified when the seller was approved, or their account number for older sellers
+		phoneNumber := seller.PayoutPhone
+		if phoneNumber == "" {
+			phoneNumber = seller.PhoneNumber
+		}
+		if phoneNumber == "" {
+			log.Println("seller has no payout phone number:", sellerID)
+			return errNoPayoutDue
+		}
+
+		payout = Payout{
+			BaseModel:   BaseModel{ID: uuid.New()},
+			SellerID:    sellerID,
+			Amount:      Money{Minor: balance / 100 * 100, Currency: MpesaCurrency}, // M-Pesa only disburses whole shillings
+			PhoneNumber: phoneNumber,
+			Status:      PayoutPending,
+		}
+		if err := tx.Create(&payout).Error; err != nil {
+			return err
+		}
+		payoutID := payout.ID
+		if err := tx.Create(&SellerLedgerEntry{
+			BaseModel:   BaseModel{ID: uuid.New()},
+			SellerID:    payout.SellerID,
+			EntryType:   LedgerPayout,
+			PayoutID:    &payoutID,
+			GrossAmount: payout.Amount,
+			Amount:      payout.Amount.Neg(),
+			Description: "M-Pesa payout to " + payout.PhoneNumber,
+		}).Error; err != nil {
+			return err
+		}
+		return postPayout(tx, &payout)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &payout, nil
+}
+
+/*
+records that a payout request was accepted by M-Pesa
+@params payout_id
+@params conversation_id
+@params originator_conversation_id
+*/
+func MarkPayoutSubmitted(payoutID uuid.UUID, conversationID, originatorConversationID string) error {
+	err := db.Model(&Payout{}).Where("id = ?", payoutID).Updates(map[string]interface{}{
+		"status":                     PayoutProcessing,
+		"conversation_id":            conversationID,
+		"originator_conversation_id": originatorConversationID,
+	}).Error
+	if err != nil {
+		log.Println("error updating payout", payoutID, ":", err.Error())
+		return errors.New("failed to update payout")
+	}
+	return nil
+}
+
+/*
+completes a payout from the B2C result callback. Failed payouts are credited back to the
+seller's balance so they are retried on the next run.
+@params conversation_id
+@params success
+@params transaction_id
+@params result_desc
+*/
+func CompletePayout(conversationID string, success bool, transactionID, resultDesc string) (*Payout, error) {
+	if conversationID == "" {
+		return nil, errors.New("failed to complete payout")
+	}
+	var payout Payout
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("conversation_id = ?", conversationID).First(&payout).Error; err != nil {
+			return err
+		}
+		// callbacks can be delivered more than once
+		if payout.Status == PayoutPaid || payout.Status == PayoutFailed {
+			return nil
+		}
+		return finishPayout(tx, &payout, success, transactionID, resultDesc)
+	})
+	if err != nil {
+		log.Println("error completing payout", conversationID, ":", err.Error())
+		return nil, errors.New("failed to complete payout")
+	}
+	return &payout, nil
+}
+
+/*
+marks a payout whose outcome is not known, after its request timed out in the M-Pesa queue
+or the answer to it was lost. Its balance stays held until a transaction status query
+settles it.
+@params payout_id
+@params reason
+*/
+func MarkPayoutUnknown(payoutID uuid.UUID, reason string) error {
+	err := db.Model(&Payout{}).
+		Where("id = ? AND status IN ?", payoutID, []PayoutStatus{PayoutPending, PayoutProcessing}).
+		Updates(map[string]interface{}{"status": PayoutUnknown, "result_desc": reason}).Error
+	if err != nil {
+		log.Println("error updating payout", payoutID, ":", err.Error())
+		return errors.New("failed to update payout")
+	}
+	return nil
+}
+
+/*
+marks the payout a B2C timeout callback is for as unknown
+@params conversation_id
+@params reason
+*/
+func TimeOutPayout(conversationID, reason string) error {
+	var payout Payout
+	if conversationID == "" {
+		return errors.New("failed to update payout")
+	}
+	if err := db.Where("conversation_id = ?", conversationID).First(&payout).Error; err != nil {
+		log.Println("error finding timed out payout", conversationID, ":", err.Error())
+		return errors.New("failed to update payout")
+	}
+	return MarkPayoutUnknown(payout.ID, reason)
+}
+
+// payoutStatusQueryAfter is how long a submitted payout may wait for its result before it is queried
+const payoutStatusQueryAfter = time.Hour
+
+// GetPayoutsAwaitingStatus returns payouts whose outcome has to be looked up with M-Pesa
+func GetPayoutsAwaitingStatus() ([]Payout, error) {
+	var payouts []Payout
+	err := db.Where("status = ? OR (status = ? AND updated_at < ?)", PayoutUnknown, PayoutProcessing, time.Now().Add(-payoutStatusQueryAfter)).
+		Find(&payouts).Error
+	if err != nil {
+		log.Println("error fetching payouts awaiting status:", err.Error())
+		return nil, errors.New("failed to fetch payouts")
+	}
+	return payouts, nil
+}
+
+/*
+records the conversation id of a transaction status query sent for a payout
+@params payout_id
+@params status_conversation_id
+*/
+func MarkPayoutStatusQueried(payoutID uuid.UUID, statusConversationID string) error {
+	err := db.Model(&Payout{}).Where("id = ?", payoutID).Update("status_conversation_id", statusConversationID).Error
+	if err != nil {
+		log.Println("error updating payout", payoutID, ":", err.Error())
+		return errors.New("failed to update payout")
+	}
+	return nil
+}
+
+/*
+settles a payout from the result of a transaction status query. Only a completed or a
+failed transaction settles it, anything else leaves it to be queried again.
+@params status_conversation_id
+@params transaction_status as reported by M-Pesa
+@params transaction_id
+@params result_desc
+*/
+func SettlePayoutStatus(statusConversationID, transactionStatus, transactionID, resultDesc string) (*Payout, error) {
+	if statusConversationID == "" {
+		return nil, errors.New("failed to settle payout")
+	}
+	var payout Payout
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("status_conversation_id = ?", statusConversationID).First(&payout).Error; err != nil {
+			return err
+		}
+		if payout.Status == PayoutPaid || payout.Status == PayoutFailed {
+			return nil
+		}
+		switch strings.ToLower(transactionStatus) {
+		case "completed":
+			return finishPayout(tx, &payout, true, transactionID, resultDesc)
+		case "failed", "cancelled", "declined", "expired":
+			return finishPayout(tx, &payout, false, transactionID, resultDesc)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("error settling payout", statusConversationID, ":", err.Error())
+		return nil, errors.New("failed to settle payout")
+	}
+	return &payout, nil
+}
+
+/*
+fails a payout that could not be submitted to M-Pesa
+@params payout_id
+@params reason
+*/
+func FailPayout(payoutID uuid.UUID, reason string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var payout Payout
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&payout, "id = ?", payoutID).Error; err != nil {
+			return err
+		}
+		return finishPayout(tx, &payout, false, "", reason)
+	})
+}
+
+/*
+finishPayout settles a payout that is still in flight, crediting a failed one back to the
+seller. A payout already paid or failed is reloaded and left as it is.
+*/
+func finishPayout(tx *gorm.DB, payout *Payout, success bool, transactionID, resultDesc string) error {
+	status := PayoutPaid
+	if !success {
+		status = PayoutFailed
+	}
+	now := time.Now()
+	result := tx.Model(&Payout{}).
+		Where("id = ? AND status IN ?", payout.ID, []PayoutStatus{PayoutPending, PayoutProcessing, PayoutUnknown}).
+		Updates(map[string]interface{}{
+			"status":         status,
+			"completed_at":   now,
+			"transaction_id": transactionID,
+			"result_desc":    resultDesc,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return tx.First(payout, "id = ?", payout.ID).Error
+	}
+	payout.Status, payout.CompletedAt = status, &now
+	payout.TransactionID, payout.ResultDesc = transactionID, resultDesc
+	if success {
+		return nil
+	}
+
+	payoutID := payout.ID
+	if err := tx.Create(&SellerLedgerEntry{
+		BaseModel:   BaseModel{ID: uuid.New()},
+		SellerID:    payout.SellerID,
+		EntryType:   LedgerPayoutReversal,
+		PayoutID:    &payoutID,
+		GrossAmount: payout.Amount,
+		Amount:      payout.Amount,
+		Description: "reversal of failed payout: " + resultDesc,
+	}).Error; err != nil {
+		return err
+	}
+	return postPayoutReversal(tx, payout)
+}
+
+/*
+removes payout entries written twice for the same payout before the unique index on them is
+created. The journal is append-only, so each duplicate is undone there with a correcting
+entry. Safe to run on every start.
+*/
+func dedupePayoutEntries() {
+	if !db.Migrator().HasTable(&SellerLedgerEntry{}) || db.Migrator().HasIndex(&SellerLedgerEntry{}, "idx_payout_entry") {
+		return
+	}
+	var duplicates []SellerLedgerEntry
+	err := db.Raw(`SELECT newer.* FROM seller_ledger_entries newer JOIN seller_ledger_entries older
+		ON newer.payout_id = older.payout_id AND newer.entry_type = older.entry_type
+		AND (older.created_at < newer.created_at OR (older.created_at = newer.created_at AND older.id < newer.id))`).
+		Scan(&duplicates).Error
+	if err != nil {
+		log.Println("error finding duplicate payout entries:", err.Error())
+		return
+	}
+	for _, entry := range duplicates {
+		// a duplicate reversal credited the seller twice, a duplicate payout debited them twice
+		lines := ledger.Payout(entry.SellerID, entry.GrossAmount)
+		if entry.EntryType == LedgerPayout {
+			lines = ledger.PayoutReversal(entry.SellerID, entry.GrossAmount)
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Unscoped().Delete(&SellerLedgerEntry{}, "id = ?", entry.ID).Error; err != nil {
+				return err
+			}
+			_, err := PostJournal(tx, "payout_correction", *entry.PayoutID, "correction of duplicate "+string(entry.EntryType), lines...)
+			return err
+		})
+		if err != nil {
+			log.Println("error removing duplicate payout entry", entry.ID, ":", err.Error())
+		}
+	}
+}
@@ -7,31 +7,100 @@ import (
 	"strings"
 	"time"
 
-	"github.com/dancankarani/palace/utilities"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
+// productRequest is what a seller sends for a product. Ratings and review counts are kept
+// by the server, and the state and stock are only taken when the product is added.
+type productRequest struct {
+	Name              string        `json:"name" form:"name"`
+	Description       string        `json:"description" form:"description"`
+	Price             Money         `json:"price" form:"price"`
+	Category          string        `json:"category" form:"category"`
+	CategoryID        *uuid.UUID    `json:"category_id" form:"category_id"`
+	LowStockThreshold *int          `json:"low_stock_threshold" form:"low_stock_threshold"`
+	Stock             int           `json:"stock" form:"stock"`
+	Status            ProductStatus `json:"status" form:"status"`
+	PublishAt         *time.Time    `json:"publish_at" form:"publish_at"`
+	UnpublishAt       *time.Time    `json:"unpublish_at" form:"unpublish_at"`
+}
+
+// details returns the fields of the request a seller may change on an existing product
+func (r productRequest) details() Product {
+	return Product{
+		Name:              r.Name,
+		Description:       r.Description,
+		Price:             r.Price,
+		Category:          r.Category,
+		CategoryID:        r.CategoryID,
+		LowStockThreshold: r.LowStockThreshold,
+	}
+}
+
 func AddProduct(c *fiber.Ctx)(*Product,error){
 	user_id,_:= GetAuthUserID(c)
 	log.Println(user_id)
-	product := Product{BaseModel: BaseModel{ID: uuid.New()},SellerID: user_id}
-	
+	if err := RequireVerified(user_id); err != nil{
+		return nil, err
+	}
+
 	//get request body
-	if err := c.BodyParser(&product); err != nil{
+	body := productRequest{}
+	if err := c.BodyParser(&body); err != nil{
 		log.Println("error parsing product body request:",err.Error())
 		return nil, errors.New("error parsing request data")
 	}
-	url, err := utilities.SaveFile(c,"image")
+	product := body.details()
+	product.BaseModel, product.SellerID = BaseModel{ID: uuid.New()}, user_id
+	product.Stock, product.Status, product.PublishAt, product.UnpublishAt = body.Stock, body.Status, body.PublishAt, body.UnpublishAt
+	categoryID, category, err := resolveCategory(CategoryProduct, product.CategoryID, product.Category)
 	if err != nil{
-		return nil, errors.New(err.Error())
+		return nil, err
+	}
+	product.CategoryID, product.Category = categoryID, category
+	//new products go live straight away unless saved as a draft or scheduled
+	lifecycle := productLifecycle{Status: product.Status, PublishAt: product.PublishAt, UnpublishAt: product.UnpublishAt}
+	if lifecycle.Status == ""{
+		lifecycle.Status = ProductActive
+		if lifecycle.PublishAt != nil{
+			lifecycle.Status = ProductDraft
+		}
 	}
-	product.ImageURL = url
-	//add to database
-	if err := db.Create(&product).Error; err != nil{
+	if err := lifecycle.validate(); err != nil{
+		return nil, err
+	}
+	product.Status, product.PublishAt, product.UnpublishAt = lifecycle.Status, lifecycle.PublishAt, lifecycle.UnpublishAt
+	files := uploadedImages(c)
+	if len(files) == 0{
+		return nil, errors.New("at least one image is required")
+	}
+	if product.Stock < 0{
+		return nil, errors.New("stock cannot be negative")
+	}
+	//add to database, logging the opening stock as a restock
+	stock := product.Stock
+	product.Stock = 0
+	err = db.Transaction(func(tx *gorm.DB) error{
+		if err := tx.Create(&product).Error; err != nil{
+			return err
+		}
+		return changeStock(tx, &InventoryMovement{ProductID: product.ID, Change: stock, Reason: InventoryRestock, Note: "opening stock", ActorID: &user_id})
+	})
+	if err != nil{
 		log.Println("error adding cloth:",err.Error())
 		return nil, errors.New("failed to add cloth")
 	}
+	//upload the images, the first one becomes the primary image
+	images, err := attachImages(product.ID, files)
+	if err != nil{
+		db.Unscoped().Delete(&product)
+		return nil, err
+	}
+	product.Images = images
+	db.First(&product, "id = ?", product.ID)
+	indexProduct(product.ID)
 
 	return &product,nil
 }
@@ -41,25 +110,36 @@ update cloth
 @parans clothe_id
 */
 func UpdateProduct(c *fiber.Ctx, product_id uuid.UUID)(*Product, error){
-	product := new(Product)
-	body := Product{}
-	//find the clothe
-	err := db.First(product,"id = ?",product_id).Error
+	//only the seller of the clothe or an admin may change it
+	product, err := ownedProduct(c, product_id)
 	if err != nil{
-		log.Println("error finding product for the update:",err.Error())
-		return nil, errors.New("failed to update clothe")
+		return nil, err
 	}
 
 	//get request body
-	if err := c.BodyParser(&body); err != nil{
+	request := productRequest{}
+	if err := c.BodyParser(&request); err != nil{
 		log.Println("failed to parse request body:",err.Error())
 		return nil,errors.New("failed to parse request body")
 	}
+	body := request.details()
+	//move to another category when one is given
+	if body.CategoryID != nil || body.Category != ""{
+		categoryID, category, err := resolveCategory(CategoryProduct, body.CategoryID, body.Category)
+		if err != nil{
+			return nil, err
+		}
+		body.CategoryID, body.Category = categoryID, category
+	}
+	//the state is changed through SetProductStatus and the stock through AdjustStock,
+	//so every change of either is checked and logged
+
 	//update clothe
-	if err = db.Model(product).Updates(&body).Error; err != nil{
+	if err := db.Model(product).Updates(&body).Error; err != nil{
 		log.Println("failed to update clothe:",err.Error())
 		return nil, errors.New("failed to update clothe")
 	}
+	indexProduct(product.ID)
 
 	//return
 	return product,nil
@@ -70,11 +150,10 @@ delete clothe
 @params clothe_id
 */
 func DeleteProduct(c *fiber.Ctx, product_id uuid.UUID)error{
-	product := new(Product)
-	//get clothe
-	if err := db.First(product, "id = ?",product_id).Error; err != nil{
-		log.Println("error finding clothe for deleting:",err.Error())
-		return errors.New("failed to delete clothe")
+	//only the seller of the clothe or an admin may delete it
+	product, err := ownedProduct(c, product_id)
+	if err != nil{
+		return err
 	}
 
 	//delete clothe
@@ -82,6 +161,7 @@ func DeleteProduct(c *fiber.Ctx, product_id uuid.UUID)error{
 		log.Println("error deleting clothe:",err.Error())
 		return errors.New("failed to delete clothe")
 	}
+	indexProduct(product.ID)
 
 	return nil
 }
@@ -92,8 +172,8 @@ gets all the clothes
 func GetAllProducts() (*[]Product, error) {
 	var products []Product
 
-	// Get all clothes
-	if err := db.Find(&products).Error; err != nil {
+	// Get all clothes that are on sale
+	if err := db.Where("status = ?", ProductActive).Find(&products).Error; err != nil {
 		log.Println("error fetching clothes:", err.Error())
 		return nil, errors.New("failed to fetch clothes")
 	}
@@ -111,10 +191,10 @@ func GetAllProducts() (*[]Product, error) {
 gets clothes by price
 @params price
 */
-func GetProductsByPrice(price float64) (*[]Product, error) {
+func GetProductsByPrice(price Money) (*[]Product, error) {
 	var products []Product
 	// Query the database for clothes with price less than or equal to the given price
-	if err := db.Where("price <= ?", price).Find(&products).Error; err != nil {
+	if err := db.Where("status = ? AND price_minor <= ?", ProductActive, price.Minor).Find(&products).Error; err != nil {
 		log.Println("error fetching clothes by price:", err.Error())
 		return nil, errors.New("failed to get clothes by price")
 	}
@@ -134,8 +214,9 @@ gets clothes by gender
 */
 func GetProductsByGender(gender string) (*[]Product, error) {
 	var product []Product
-	// Query the database for clothes with the specified gender
-	if err := db.Where("gender = ?", gender).Find(&product).Error; err != nil {
+	// Gender is an attribute of the variants, so find clothes with a variant for it
+	variants := db.Model(&ProductVariant{}).Select("product_id").Where("gender = ?", gender)
+	if err := db.Where("status = ? AND id IN (?)", ProductActive, variants).Find(&product).Error; err != nil {
 		log.Println("error fetching clothes by gender:", err.Error())
 		return nil, errors.New("failed to get clothes by gender")
 	}
@@ -155,8 +236,12 @@ gets clothes by category
 */
 func GetProductsByCategory(category string) (*[]Product, error) {
 	var products []Product
-	// Query the database for clothes with the specified category
-	if err := db.Where("category = ?", category).Find(&products).Error; err != nil {
+	// Include clothes in the subcategories of the category
+	categoryIDs, err := CategoryWithDescendants(CategoryProduct, category)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Where("status = ? AND category_id IN ?", ProductActive, categoryIDs).Find(&products).Error; err != nil {
 		log.Println("error fetching clothes by category:", err.Error())
 		return nil, errors.New("failed to get clothes by category")
 	}
@@ -176,21 +261,31 @@ search clothes by various attributes
 @params searchQuery
 */
 func SearchProducts(searchQuery string) (*[]Product, error) {
-	var products []Product
-	// Use a case-insensitive search for the search query
-	searchQuery = strings.ToLower(searchQuery)
-	
-	// Query the database to find clothes that match the search query in the name or description
-	if err := db.Where("LOWER(name) LIKE ? OR LOWER(description) LIKE ?", "%"+searchQuery+"%", "%"+searchQuery+"%").Find(&products).Error; err != nil {
+	products := []Product{}
+	ids, _, err := searchProductIDs(searchQuery)
+	if err != nil {
 		log.Println("error searching clothes:", err.Error())
 		return nil, errors.New("failed to search clothes")
 	}
+	if len(ids) == 0 {
+		return &products, nil
+	}
 
-	// Shuffle the clothes slice to return them in random order
-	rand.Seed(time.Now().UnixNano()) // Seed the random number generator
-	rand.Shuffle(len(products), func(i, j int) {
-		products[i], products[j] = products[j], products[i]
-	})
+	// Load the matching clothes and return them in order of relevance
+	var found []Product
+	if err := db.Where("status = ? AND id IN ?", ProductActive, ids).Find(&found).Error; err != nil {
+		log.Println("error searching clothes:", err.Error())
+		return nil, errors.New("failed to search clothes")
+	}
+	byID := make(map[uuid.UUID]Product, len(found))
+	for _, product := range found {
+		byID[product.ID] = product
+	}
+	for _, id := range ids {
+		if product, ok := byID[id]; ok {
+			products = append(products, product)
+		}
+	}
 
 	return &products, nil
 }
@@ -199,29 +294,33 @@ func SearchProducts(searchQuery string) (*[]Product, error) {
 search and filter clothes by category and sort by price
 @params category, minPrice, maxPrice, sortBy
 */
-func SearchAndFilterClothes(category string, minPrice, maxPrice float64, sortBy string) (*[]Product, error) {
+func SearchAndFilterClothes(category string, minPrice, maxPrice Money, sortBy string) (*[]Product, error) {
 	var clothes []Product
-	query := db.Model(&Product{})
+	query := db.Model(&Product{}).Where("status = ?", ProductActive)
 
-	// Apply category filter if specified
+	// Apply category filter if specified, including subcategories
 	if category != "" {
-		query = query.Where("category = ?", category)
+		categoryIDs, err := CategoryWithDescendants(CategoryProduct, category)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("category_id IN ?", categoryIDs)
 	}
 
 	// Apply price range filter if specified
-	if minPrice > 0 {
-		query = query.Where("price >= ?", minPrice)
+	if minPrice.IsPositive() {
+		query = query.Where("price_minor >= ?", minPrice.Minor)
 	}
-	if maxPrice > 0 {
-		query = query.Where("price <= ?", maxPrice)
+	if maxPrice.IsPositive() {
+		query = query.Where("price_minor <= ?", maxPrice.Minor)
 	}
 
 	// Apply sorting if specified
 	switch strings.ToLower(sortBy) {
 	case "price_asc":
-		query = query.Order("price ASC")
+		query = query.Order("price_minor ASC")
 	case "price_desc":
-		query = query.Order("price DESC")
+		query = query.Order("price_minor DESC")
 	default:
 		query = query.Order("created_at DESC") // Default sorting
 	}
@@ -0,0 +1,306 @@
+package model
+
+import (
+	"errors"
+	"log"
+	"mime/multipart"
+	"path/filepath"
+
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const maxProductImages = 10
+
+var ErrImageNotFound = errors.New("image not found")
+
+// imageKey is where a blob of an image is stored; name is "original" or a thumbnail size
+func imageKey(hash, name, extension string) string {
+	return "products/" + hash[:2] + "/" + hash + "/" + name + extension
+}
+
+// uploadedImages returns the files sent in the "images" and "image" form fields
+func uploadedImages(c *fiber.Ctx) []*multipart.FileHeader {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil
+	}
+	return append(form.File["images"], form.File["image"]...)
+}
+
+/*
+validates, thumbnails and uploads an image, skipping the upload when the same
+content is already stored
+@params data
+*/
+func storeImage(data []byte) (*ProductImage, error) {
+	processed, err := utilities.ProcessImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	image := ProductImage{BaseModel: BaseModel{ID: uuid.New()}, Hash: processed.Hash, Width: processed.Width, Height: processed.Height}
+	var existing ProductImage
+	if err := db.Where("hash = ?", processed.Hash).First(&existing).Error; err == nil {
+		image.URL, image.SmallURL, image.MediumURL, image.LargeURL = existing.URL, existing.SmallURL, existing.MediumURL, existing.LargeURL
+		return &image, nil
+	}
+
+	store := utilities.NewBlobStore()
+	if image.URL, err = store.Put(imageKey(processed.Hash, "original", processed.Extension), processed.ContentType, processed.Original); err != nil {
+		return nil, err
+	}
+	urls := map[string]*string{"small": &image.SmallURL, "medium": &image.MediumURL, "large": &image.LargeURL}
+	for name, thumbnail := range processed.Thumbnails {
+		url, err := store.Put(imageKey(processed.Hash, name, ".jpg"), "image/jpeg", thumbnail)
+		if err != nil {
+			return nil, err
+		}
+		*urls[name] = url
+	}
+	return &image, nil
+}
+
+/*
+removes an image's blobs unless another image still uses the same content
+@params image
+*/
+func deleteImageBlobs(image ProductImage) {
+	var others int64
+	db.Model(&ProductImage{}).Where("hash = ? AND id <> ?", image.Hash, image.ID).Count(&others)
+	if others > 0 || image.Hash == "" {
+		return
+	}
+	keys := []string{imageKey(image.Hash, "original", filepath.Ext(image.URL))}
+	for name := range utilities.ThumbnailSizes {
+		keys = append(keys, imageKey(image.Hash, name, ".jpg"))
+	}
+	store := utilities.NewBlobStore()
+	for _, key := range keys {
+		if err := store.Delete(key); err != nil {
+			log.Println("error deleting image blob:", err.Error())
+		}
+	}
+}
+
+/*
+sets Product.ImageURL to the primary image, used by listings that do not load images
+@params product_id
+*/
+func syncPrimaryImage(tx *gorm.DB, productID uuid.UUID) error {
+	var primary ProductImage
+	err := tx.Where("product_id = ?", productID).Order("is_primary DESC, position").First(&primary).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return tx.Model(&Product{}).Where("id = ?", productID).Update("image_url", "").Error
+	}
+	if err != nil {
+		return err
+	}
+	if !primary.IsPrimary {
+		if err := tx.Model(&primary).Update("is_primary", true).Error; err != nil {
+			return err
+		}
+	}
+	url := primary.MediumURL
+	if url == "" {
+		url = primary.URL
+	}
+	return tx.Model(&Product{}).Where("id = ?", productID).Update("image_url", url).Error
+}
+
+/*
+adds the uploaded images to a product after the existing ones
+@params product_id
+*/
+func attachImages(productID uuid.UUID, files []*multipart.FileHeader) ([]ProductImage, error) {
+	uploads := make([][]byte, len(files))
+	for i, file := range files {
+		data, err := utilities.ReadFormFile(file, utilities.MaxImageSize())
+		if err != nil {
+			return nil, err
+		}
+		uploads[i] = data
+	}
+	return attachImageData(productID, uploads)
+}
+
+/*
+adds images to a product after the existing ones; the first image of a product becomes its primary image
+@params product_id
+@params uploads
+*/
+func attachImageData(productID uuid.UUID, uploads [][]byte) ([]ProductImage, error) {
+	var count int64
+	if err := db.Model(&ProductImage{}).Where("product_id = ?", productID).Count(&count).Error; err != nil {
+		log.Println("error counting product images:", err.Error())
+		return nil, errors.New("failed to add images")
+	}
+	if int(count)+len(uploads) > maxProductImages {
+		return nil, errors.New("a product can have at most 10 images")
+	}
+
+	// the same photo is only attached to a product once
+	var hashes []string
+	if err := db.Model(&ProductImage{}).Where("product_id = ?", productID).Pluck("hash", &hashes).Error; err != nil {
+		log.Println("error fetching product image hashes:", err.Error())
+		return nil, errors.New("failed to add images")
+	}
+	attached := map[string]bool{}
+	for _, hash := range hashes {
+		attached[hash] = true
+	}
+
+	images := []ProductImage{}
+	for _, data := range uploads {
+		hash := utilities.ContentHash(data)
+		if attached[hash] {
+			continue
+		}
+		image, err := storeImage(data)
+		if err != nil {
+			return nil, err
+		}
+		image.ProductID = productID
+		image.Position = int(count) + len(images)
+		images = append(images, *image)
+		attached[hash] = true
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if len(images) > 0 {
+			if err := tx.Create(&images).Error; err != nil {
+				return err
+			}
+		}
+		return syncPrimaryImage(tx, productID)
+	})
+	if err != nil {
+		log.Println("error saving product images:", err.Error())
+		return nil, errors.New("failed to add images")
+	}
+	return images, nil
+}
+
+/*
+gets a product's images in display order
+@params product_id
+*/
+func GetProductImages(productID uuid.UUID) (*[]ProductImage, error) {
+	images := []ProductImage{}
+	if err := db.Where("product_id = ?", productID).Order("position").Find(&images).Error; err != nil {
+		log.Println("error fetching product images:", err.Error())
+		return nil, errors.New("failed to fetch product images")
+	}
+	return &images, nil
+}
+
+/*
+uploads images for one of the seller's products
+@params product_id
+*/
+func AddProductImages(c *fiber.Ctx, productID uuid.UUID) ([]ProductImage, error) {
+	if _, err := ownedProduct(c, productID); err != nil {
+		return nil, err
+	}
+	files := uploadedImages(c)
+	if len(files) == 0 {
+		return nil, errors.New("no images uploaded, send them in the images field")
+	}
+	return attachImages(productID, files)
+}
+
+/*
+sets the display order of a product's images from a list of image ids
+@params product_id
+*/
+func ReorderProductImages(c *fiber.Ctx, productID uuid.UUID) (*[]ProductImage, error) {
+	if _, err := ownedProduct(c, productID); err != nil {
+		return nil, err
+	}
+	body := struct {
+		ImageIDs []uuid.UUID `json:"image_ids"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing image order:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+
+	var found int64
+	if err := db.Model(&ProductImage{}).Where("id IN ? AND product_id = ?", body.ImageIDs, productID).Count(&found).Error; err != nil {
+		log.Println("error checking product images:", err.Error())
+		return nil, errors.New("failed to reorder images")
+	}
+	if len(body.ImageIDs) == 0 || int(found) != len(body.ImageIDs) {
+		return nil, ErrImageNotFound
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for position, id := range body.ImageIDs {
+			if err := tx.Model(&ProductImage{}).Where("id = ?", id).Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("error reordering product images:", err.Error())
+		return nil, errors.New("failed to reorder images")
+	}
+	return GetProductImages(productID)
+}
+
+/*
+makes an image the primary image of its product
+@params image_id
+*/
+func SetPrimaryProductImage(c *fiber.Ctx, imageID uuid.UUID) (*ProductImage, error) {
+	var image ProductImage
+	if err := db.First(&image, "id = ?", imageID).Error; err != nil {
+		return nil, ErrImageNotFound
+	}
+	if _, err := ownedProduct(c, image.ProductID); err != nil {
+		return nil, err
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&ProductImage{}).Where("product_id = ?", image.ProductID).Update("is_primary", false).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&image).Update("is_primary", true).Error; err != nil {
+			return err
+		}
+		return syncPrimaryImage(tx, image.ProductID)
+	})
+	if err != nil {
+		log.Println("error setting primary image:", err.Error())
+		return nil, errors.New("failed to set primary image")
+	}
+	return &image, nil
+}
+
+/*
+deletes a product image, promoting the next image when it was the primary one
+@params image_id
+*/
+func DeleteProductImage(c *fiber.Ctx, imageID uuid.UUID) error {
+	var image ProductImage
+	if err := db.First(&image, "id = ?", imageID).Error; err != nil {
+		return ErrImageNotFound
+	}
+	if _, err := ownedProduct(c, image.ProductID); err != nil {
+		return err
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&image).Error; err != nil {
+			return err
+		}
+		return syncPrimaryImage(tx, image.ProductID)
+	})
+	if err != nil {
+		log.Println("error deleting product image:", err.Error())
+		return errors.New("failed to delete image")
+	}
+	deleteImageBlobs(image)
+	return nil
+}
@@ -0,0 +1,536 @@
+package model
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dancankarani/palace/money"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	maxImportRows      = 5000
+	maxImportFileSize  = 10 << 20
+	maxImportZipSize   = 200 << 20
+	importImageTimeout = 20 * time.Second
+	importImageSep     = "|"
+)
+
+// ProductCSVColumns are the columns of a product export, which an import also accepts.
+// A row with an id updates that product, a row without one creates a product.
+var ProductCSVColumns = []string{"id", "name", "description", "price", "currency", "category", "stock", "status", "image_urls", "images"}
+
+// ImportRowError explains why a row of an import was rejected. Row counts the header as row 1.
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type ImportRowErrors []ImportRowError
+
+func (e ImportRowErrors) Value() (driver.Value, error) {
+	if e == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(e)
+	return string(data), err
+}
+
+func (e *ImportRowErrors) Scan(value interface{}) error {
+	return scanJSON(value, e)
+}
+
+// importHTTPClient only reaches public addresses, as the image urls come from sellers
+var importHTTPClient = utilities.PublicHTTPClient(importImageTimeout)
+
+/*
+validates an uploaded CSV, and an optional zip of the images it names, and
+starts importing it in the background
+*/
+func StartProductImport(c *fiber.Ctx) (*ImportJob, error) {
+	sellerID, err := GetAuthUserID(c)
+	if err != nil {
+		return nil, errors.New("unauthorized")
+	}
+	if err := RequireVerified(sellerID); err != nil {
+		return nil, err
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		return nil, errors.New("upload the CSV in the file field")
+	}
+	data, err := utilities.ReadFormFile(file, maxImportFileSize)
+	if err != nil {
+		return nil, fmt.Errorf("the CSV could not be read, the limit is %d MB", maxImportFileSize>>20)
+	}
+	if _, err := readImportHeader(csv.NewReader(bytes.NewReader(data))); err != nil {
+		return nil, err
+	}
+
+	var archive []byte
+	if zipFile, err := c.FormFile("images"); err == nil {
+		if archive, err = utilities.ReadFormFile(zipFile, maxImportZipSize); err != nil {
+			return nil, fmt.Errorf("the images zip could not be read, the limit is %d MB", maxImportZipSize>>20)
+		}
+		if _, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive))); err != nil {
+			return nil, errors.New("images must be a zip file")
+		}
+	}
+
+	job := ImportJob{BaseModel: BaseModel{ID: uuid.New()}, SellerID: sellerID, Filename: file.Filename, Status: ImportPending}
+	if err := db.Create(&job).Error; err != nil {
+		log.Println("error creating import job:", err.Error())
+		return nil, errors.New("failed to start import")
+	}
+	go runProductImport(job, data, archive)
+	return &job, nil
+}
+
+/*
+gets an import job of the authenticated seller with its row errors
+@params job_id
+*/
+func GetImportJob(c *fiber.Ctx, jobID uuid.UUID) (*ImportJob, error) {
+	sellerID, _ := GetAuthUserID(c)
+	var job ImportJob
+	if err := db.First(&job, "id = ? AND seller_id = ?", jobID, sellerID).Error; err != nil {
+		return nil, errors.New("import job not found")
+	}
+	return &job, nil
+}
+
+/*
+marks imports that were running when the server stopped as failed
+*/
+func FailInterruptedImports() {
+	err := db.Model(&ImportJob{}).
+		Where("status IN ?", []ImportStatus{ImportPending, ImportRunning}).
+		Updates(map[string]interface{}{"status": ImportFailed, "completed_at": time.Now()}).Error
+	if err != nil {
+		log.Println("error failing interrupted imports:", err.Error())
+	}
+}
+
+// readImportHeader reads the header row and maps column names to their index
+func readImportHeader(reader *csv.Reader) (map[string]int, error) {
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("the CSV is empty or malformed")
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		columns[name] = i
+	}
+	for _, required := range []string{"name", "price"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("the CSV has no %s column, expected columns are %s", required, strings.Join(ProductCSVColumns, ", "))
+		}
+	}
+	return columns, nil
+}
+
+// runProductImport imports every row of a CSV, recording progress and rejected rows on the job
+func runProductImport(job ImportJob, data, archive []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("import job", job.ID, "panicked:", r)
+			now := time.Now()
+			job.Status, job.CompletedAt = ImportFailed, &now
+			db.Save(&job)
+		}
+	}()
+
+	images := map[string]*zip.File{}
+	if archive != nil {
+		zipReader, _ := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		for _, file := range zipReader.File {
+			if !file.FileInfo().IsDir() {
+				images[strings.ToLower(path.Base(file.Name))] = file
+			}
+		}
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	columns, _ := readImportHeader(reader)
+	records, err := reader.ReadAll()
+	job.Status = ImportRunning
+	job.Errors = ImportRowErrors{}
+	if err != nil {
+		job.Errors = append(job.Errors, ImportRowError{Message: "the CSV is malformed: " + err.Error()})
+		records = nil
+	}
+	if len(records) > maxImportRows {
+		job.Errors = append(job.Errors, ImportRowError{Message: fmt.Sprintf("only the first %d rows were imported", maxImportRows)})
+		records = records[:maxImportRows]
+	}
+	job.TotalRows = len(records)
+	db.Save(&job)
+
+	for i, record := range records {
+		row := i + 2
+		value := func(name string) string {
+			if index, ok := columns[name]; ok && index < len(record) {
+				return strings.TrimSpace(record[index])
+			}
+			return ""
+		}
+		created, rowErrors := importProductRow(job.SellerID, value, images)
+		switch {
+		case len(rowErrors) > 0:
+			job.FailedRows++
+			for _, rowError := range rowErrors {
+				rowError.Row = row
+				job.Errors = append(job.Errors, rowError)
+			}
+		case created:
+			job.CreatedRows++
+		default:
+			job.UpdatedRows++
+		}
+		job.ProcessedRows++
+		if job.ProcessedRows%25 == 0 {
+			db.Save(&job)
+		}
+	}
+
+	now := time.Now()
+	job.Status, job.CompletedAt = ImportCompleted, &now
+	if err := db.Save(&job).Error; err != nil {
+		log.Println("error saving import job:", err.Error())
+	}
+}
+
+/*
+validates one CSV row and creates or updates the product it describes
+@params seller_id
+@params value
+@params images
+*/
+func importProductRow(sellerID uuid.UUID, value func(string) string, images map[string]*zip.File) (bool, []ImportRowError) {
+	var rowErrors []ImportRowError
+	reject := func(field, message string) {
+		rowErrors = append(rowErrors, ImportRowError{Field: field, Message: message})
+	}
+
+	name := value("name")
+	if name == "" {
+		reject("name", "name is required")
+	} else if len([]rune(name)) > 255 {
+		reject("name", "name must be at most 255 characters")
+	}
+	categoryID, category, err := resolveCategory(CategoryProduct, nil, value("category"))
+	if err != nil {
+		reject("category", err.Error())
+	}
+	price, err := ParseMoney(value("price"))
+	if err != nil {
+		reject("price", err.Error())
+	} else if !price.IsPositive() {
+		reject("price", "price must be greater than 0")
+	}
+	if currency := value("currency"); currency != "" {
+		code, err := money.NormalizeCurrency(currency)
+		if err != nil {
+			reject("currency", err.Error())
+		}
+		price.Currency = code
+	}
+	stock := 0
+	if s := value("stock"); s != "" {
+		if stock, err = strconv.Atoi(s); err != nil || stock < 0 {
+			reject("stock", "stock must be a whole number of at least 0")
+		}
+	}
+	// files from before product states have an is_active column instead of status
+	var status ProductStatus
+	if s := value("is_active"); s != "" {
+		if isActive, err := strconv.ParseBool(s); err != nil {
+			reject("is_active", "is_active must be true or false")
+		} else if isActive {
+			status = ProductActive
+		} else {
+			status = ProductArchived
+		}
+	}
+	if s := strings.ToLower(value("status")); s != "" {
+		status = ProductStatus(s)
+		if status != ProductDraft && status != ProductActive && status != ProductArchived {
+			reject("status", "status must be draft, active or archived")
+		}
+	}
+	var existing *Product
+	if s := value("id"); s != "" {
+		id, err := uuid.Parse(s)
+		if err != nil {
+			reject("id", "id is not a valid product id")
+		} else {
+			var product Product
+			if err := db.First(&product, "id = ? AND seller_id = ?", id, sellerID).Error; err != nil {
+				reject("id", "no product of yours has this id")
+			} else {
+				existing = &product
+			}
+		}
+	}
+	if len(rowErrors) > 0 {
+		return false, rowErrors
+	}
+
+	// fetch every image before changing anything so a bad image rejects the whole row
+	var uploads [][]byte
+	for _, url := range splitImageList(value("image_urls")) {
+		data, err := downloadImage(url)
+		if err != nil {
+			reject("image_urls", url+": "+err.Error())
+			continue
+		}
+		uploads = append(uploads, data)
+	}
+	for _, filename := range splitImageList(value("images")) {
+		file, ok := images[strings.ToLower(path.Base(filename))]
+		if !ok {
+			reject("images", filename+": not found in the images zip")
+			continue
+		}
+		data, err := readZipImage(file)
+		if err != nil {
+			reject("images", filename+": "+err.Error())
+			continue
+		}
+		uploads = append(uploads, data)
+	}
+	for _, data := range uploads {
+		if err := utilities.ValidateImage(data); err != nil {
+			reject("images", err.Error())
+		}
+	}
+	if len(rowErrors) > 0 {
+		return false, rowErrors
+	}
+
+	// like AddProduct, nothing goes on sale without an image: new rows with neither a status
+	// nor images are imported as drafts, and rows asking for an active product without one fail
+	if len(uploads) == 0 && (status == ProductActive || (existing == nil && status == "")) {
+		var images int64
+		if existing != nil {
+			if err := db.Model(&ProductImage{}).Where("product_id = ?", existing.ID).Count(&images).Error; err != nil {
+				log.Println("error counting product images:", err.Error())
+				return false, []ImportRowError{{Message: "failed to save product"}}
+			}
+		}
+		switch {
+		case images > 0:
+		case status == "":
+			status = ProductDraft
+		default:
+			return false, []ImportRowError{{Field: "images", Message: "at least one image is required for an active product"}}
+		}
+	}
+
+	product := existing
+	if product == nil {
+		product = &Product{BaseModel: BaseModel{ID: uuid.New()}, SellerID: sellerID}
+	}
+	product.Name, product.Description = name, value("description")
+	product.CategoryID, product.Category = categoryID, category
+	product.Price = price
+	// rows without a status create active products, or drafts without images, and leave
+	// existing ones as they are
+	statusChanged := status != "" && status != product.Status
+	if existing == nil && status == "" {
+		status = ProductActive
+	}
+	if status != "" {
+		product.Status = status
+	}
+
+	// stock changes are logged as a restock for new products and an adjustment otherwise
+	movement := InventoryMovement{ProductID: product.ID, Reason: InventoryAdjustment, Note: "CSV import", ActorID: &sellerID}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if existing == nil {
+			if err := tx.Create(product).Error; err != nil {
+				return err
+			}
+			movement.Reason = InventoryRestock
+			movement.Change = stock
+			return changeStock(tx, &movement)
+		}
+		// a map so that a stock of 0 is written too
+		updates := map[string]interface{}{
+			"name":           product.Name,
+			"description":    product.Description,
+			"category":       product.Category,
+			"category_id":    product.CategoryID,
+			"price_minor":    product.Price.Minor,
+			"price_currency": product.Price.Currency,
+		}
+		// a change of state drops any publish schedule
+		if statusChanged {
+			for column, value := range (productLifecycle{Status: product.Status}).updates() {
+				updates[column] = value
+			}
+		}
+		if err := tx.Model(product).Updates(updates).Error; err != nil {
+			return err
+		}
+		// the stock of a product with variants is the sum of theirs
+		var variants int64
+		if err := tx.Model(&ProductVariant{}).Where("product_id = ?", product.ID).Count(&variants).Error; err != nil {
+			return err
+		}
+		if variants > 0 || value("stock") == "" {
+			return nil
+		}
+		return setStock(tx, &movement, stock)
+	})
+	if err != nil {
+		log.Println("error importing product:", err.Error())
+		return false, []ImportRowError{{Message: "failed to save product"}}
+	}
+	if len(uploads) > 0 {
+		if _, err := attachImageData(product.ID, uploads); err != nil {
+			indexProduct(product.ID)
+			return false, []ImportRowError{{Field: "images", Message: "product saved but images failed: " + err.Error()}}
+		}
+	}
+	indexProduct(product.ID)
+	return existing == nil, nil
+}
+
+func splitImageList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, importImageSep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+/*
+downloads an image for an import, refusing anything but http(s) to public addresses and
+files over the image size limit
+@params url
+*/
+func downloadImage(url string) ([]byte, error) {
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return nil, errors.New("image urls must start with http:// or https://")
+	}
+	resp, err := importHTTPClient.Get(url)
+	if err != nil {
+		if errors.Is(err, utilities.ErrPrivateAddress) {
+			return nil, utilities.ErrPrivateAddress
+		}
+		return nil, errors.New("could not download the image")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, utilities.MaxImageSize()+1))
+	if err != nil {
+		return nil, errors.New("could not download the image")
+	}
+	if int64(len(data)) > utilities.MaxImageSize() {
+		return nil, utilities.ErrImageTooLarge
+	}
+	return data, nil
+}
+
+func readZipImage(file *zip.File) ([]byte, error) {
+	if file.UncompressedSize64 > uint64(utilities.MaxImageSize()) {
+		return nil, utilities.ErrImageTooLarge
+	}
+	src, err := file.Open()
+	if err != nil {
+		return nil, errors.New("could not read the image from the zip")
+	}
+	defer src.Close()
+	data, err := io.ReadAll(io.LimitReader(src, utilities.MaxImageSize()+1))
+	if err != nil {
+		return nil, errors.New("could not read the image from the zip")
+	}
+	if int64(len(data)) > utilities.MaxImageSize() {
+		return nil, utilities.ErrImageTooLarge
+	}
+	return data, nil
+}
+
+/*
+writes a seller's catalogue as CSV, loading products in batches so large catalogues are streamed
+@params seller_id
+@params w
+*/
+func ExportProductsCSV(sellerID uuid.UUID, w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(ProductCSVColumns); err != nil {
+		return err
+	}
+
+	// categories are exported by slug, which unlike names is unique across the tree
+	slugs := map[uuid.UUID]string{}
+	var categories []Category
+	db.Where("kind = ?", CategoryProduct).Find(&categories)
+	for _, category := range categories {
+		slugs[category.ID] = category.Slug
+	}
+
+	var batch []Product
+	err := db.Where("seller_id = ?", sellerID).
+		Preload("Images", func(tx *gorm.DB) *gorm.DB { return tx.Order("position") }).
+		Order("created_at").
+		FindInBatches(&batch, 200, func(tx *gorm.DB, _ int) error {
+			for _, product := range batch {
+				category := product.Category
+				if product.CategoryID != nil && slugs[*product.CategoryID] != "" {
+					category = slugs[*product.CategoryID]
+				}
+				urls := make([]string, len(product.Images))
+				for i, image := range product.Images {
+					urls[i] = image.URL
+				}
+				err := writer.Write([]string{
+					product.ID.String(),
+					product.Name,
+					product.Description,
+					product.Price.Decimal(),
+					product.Price.Currency,
+					category,
+					strconv.Itoa(product.Stock),
+					string(product.Status),
+					strings.Join(urls, importImageSep),
+					"",
+				})
+				if err != nil {
+					return err
+				}
+			}
+			writer.Flush()
+			return writer.Error()
+		}).Error
+	if err != nil {
+		log.Println("error exporting products:", err.Error())
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,190 @@
+package model
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var ErrProductUnavailable = errors.New("product is not available")
+
+// productLifecycle is the part of a product request that sets its state and schedule
+type productLifecycle struct {
+	Status      ProductStatus `json:"status"`
+	PublishAt   *time.Time    `json:"publish_at"`
+	UnpublishAt *time.Time    `json:"unpublish_at"`
+}
+
+/*
+checks a product state and schedule. A draft with publish_at goes live at that
+time and an active or scheduled product with unpublish_at is archived at that
+time; archiving clears the schedule.
+@params lifecycle
+*/
+func (l *productLifecycle) validate() error {
+	now := time.Now()
+	switch l.Status {
+	case ProductDraft, ProductActive:
+	case ProductArchived:
+		l.PublishAt, l.UnpublishAt = nil, nil
+		return nil
+	default:
+		return errors.New("status must be draft, active or archived")
+	}
+	if l.PublishAt != nil {
+		if l.Status != ProductDraft {
+			return errors.New("publish_at can only be set on a draft")
+		}
+		if !l.PublishAt.After(now) {
+			return errors.New("publish_at must be in the future")
+		}
+	}
+	if l.UnpublishAt != nil {
+		if !l.UnpublishAt.After(now) {
+			return errors.New("unpublish_at must be in the future")
+		}
+		if l.PublishAt != nil && !l.UnpublishAt.After(*l.PublishAt) {
+			return errors.New("unpublish_at must be after publish_at")
+		}
+	}
+	return nil
+}
+
+// updates returns the columns a lifecycle change writes, nil schedule times clearing the schedule
+func (l productLifecycle) updates() map[string]interface{} {
+	return map[string]interface{}{
+		"status":       l.Status,
+		"publish_at":   l.PublishAt,
+		"unpublish_at": l.UnpublishAt,
+	}
+}
+
+/*
+changes the state of one of the seller's products and schedules when it is
+published or unpublished
+@params product_id
+*/
+func SetProductStatus(c *fiber.Ctx, productID uuid.UUID) (*Product, error) {
+	product, err := ownedProduct(c, productID)
+	if err != nil {
+		return nil, err
+	}
+	body := productLifecycle{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing product status:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	if err := body.validate(); err != nil {
+		return nil, err
+	}
+	if err := db.Model(product).Updates(body.updates()).Error; err != nil {
+		log.Println("error updating product status:", err.Error())
+		return nil, errors.New("failed to update product status")
+	}
+	product.Status, product.PublishAt, product.UnpublishAt = body.Status, body.PublishAt, body.UnpublishAt
+	indexProduct(product.ID)
+	return product, nil
+}
+
+/*
+gets the seller's archived and deleted products
+@params seller_id
+*/
+func GetArchivedProducts(sellerID uuid.UUID) (*[]Product, error) {
+	products := []Product{}
+	err := db.Unscoped().
+		Where("seller_id = ? AND (status = ? OR deleted_at IS NOT NULL)", sellerID, ProductArchived).
+		Order("updated_at DESC").
+		Find(&products).Error
+	if err != nil {
+		log.Println("error fetching archived products:", err.Error())
+		return nil, errors.New("failed to fetch archived products")
+	}
+	return &products, nil
+}
+
+/*
+brings back an archived or deleted product of the seller as an active product
+@params product_id
+*/
+func RestoreProduct(c *fiber.Ctx, productID uuid.UUID) (*Product, error) {
+	var product Product
+	if err := db.Unscoped().First(&product, "id = ?", productID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("product not found")
+		}
+		log.Println("error finding product to restore:", err.Error())
+		return nil, errors.New("failed to restore product")
+	}
+	userID, _ := GetAuthUserID(c)
+	if product.SellerID != userID && GetAuthUser(c) != "admin" {
+		return nil, ErrNotProductOwner
+	}
+	if !product.DeletedAt.Valid && product.Status != ProductArchived {
+		return nil, errors.New("product is neither archived nor deleted")
+	}
+
+	updates := productLifecycle{Status: ProductActive}.updates()
+	updates["deleted_at"] = nil
+	if err := db.Unscoped().Model(&product).Updates(updates).Error; err != nil {
+		log.Println("error restoring product:", err.Error())
+		return nil, errors.New("failed to restore product")
+	}
+	indexProduct(product.ID)
+	if err := db.First(&product, "id = ?", product.ID).Error; err != nil {
+		log.Println("error reloading restored product:", err.Error())
+		return nil, errors.New("failed to restore product")
+	}
+	return &product, nil
+}
+
+/*
+publishes drafts whose publish_at has passed and archives active products whose
+unpublish_at has passed. Run periodically by the product scheduler.
+*/
+func RunProductSchedule() (published, unpublished int, err error) {
+	now := time.Now()
+	var due []Product
+	if err := db.Where("status = ? AND publish_at <= ?", ProductDraft, now).Find(&due).Error; err != nil {
+		log.Println("error fetching products due to publish:", err.Error())
+		return 0, 0, errors.New("failed to publish scheduled products")
+	}
+	for _, product := range due {
+		// a product can be scheduled to go live and to come down again
+		lifecycle := productLifecycle{Status: ProductActive, UnpublishAt: product.UnpublishAt}
+		if product.UnpublishAt != nil && !product.UnpublishAt.After(now) {
+			lifecycle = productLifecycle{Status: ProductArchived}
+		}
+		result := db.Model(&Product{}).Where("id = ? AND status = ?", product.ID, ProductDraft).Updates(lifecycle.updates())
+		if result.Error != nil {
+			log.Println("error publishing product", product.ID, ":", result.Error.Error())
+			continue
+		}
+		if result.RowsAffected > 0 && lifecycle.Status == ProductActive {
+			published++
+		}
+		indexProduct(product.ID)
+	}
+
+	due = nil
+	if err := db.Where("status = ? AND unpublish_at <= ?", ProductActive, now).Find(&due).Error; err != nil {
+		log.Println("error fetching products due to unpublish:", err.Error())
+		return published, 0, errors.New("failed to unpublish scheduled products")
+	}
+	for _, product := range due {
+		result := db.Model(&Product{}).Where("id = ? AND status = ?", product.ID, ProductActive).Updates(productLifecycle{Status: ProductArchived}.updates())
+		if result.Error != nil {
+			log.Println("error unpublishing product", product.ID, ":", result.Error.Error())
+			continue
+		}
+		if result.RowsAffected > 0 {
+			unpublished++
+		}
+		indexProduct(product.ID)
+	}
+	return published, unpublished, nil
+}
@@ -0,0 +1,371 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultProductPageSize = 20
+	maxProductPageSize     = 100
+)
+
+// ProductQuery holds the filters, sort order and page of a catalogue query
+type ProductQuery struct {
+	Query    string    `query:"q"`
+	Category string    `query:"category"` // id, slug or name, includes subcategories
+	SellerID uuid.UUID `query:"seller_id"`
+	MinPrice Money     `query:"min_price"`
+	MaxPrice Money     `query:"max_price"`
+	InStock  bool      `query:"in_stock"`
+	Gender   string    `query:"gender"`
+	Size     string    `query:"size"`
+	Colour   string    `query:"colour"`
+	Sort     string    `query:"sort"` // relevance, newest, oldest, price_asc, price_desc, name_asc, name_desc
+	Cursor   string    `query:"cursor"`
+	Limit    int       `query:"limit"`
+
+	matches     []uuid.UUID // products matching Query, by relevance
+	categoryIDs []uuid.UUID // Category and its descendants
+}
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"`
+	Count int64  `json:"count"`
+}
+
+type ProductFacets struct {
+	Categories  []FacetCount `json:"categories"`
+	PriceRanges []FacetCount `json:"price_ranges"`
+	InStock     int64        `json:"in_stock"`
+}
+
+type ProductPage struct {
+	Products   []Product     `json:"products"`
+	Total      int64         `json:"total"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+	Facets     ProductFacets `json:"facets"`
+	// matched words of the text query wrapped in <mark></mark>, by product id and field
+	Highlights map[uuid.UUID]map[string]string `json:"highlights,omitempty"`
+}
+
+// productSort describes an ORDER BY on one column with the product id as tie-breaker
+type productSort struct {
+	column string
+	desc   bool
+}
+
+var productSorts = map[string]productSort{
+	"newest":     {"created_at", true},
+	"oldest":     {"created_at", false},
+	"price_asc":  {"price_minor", false},
+	"price_desc": {"price_minor", true},
+	"name_asc":   {"name", false},
+	"name_desc":  {"name", true},
+}
+
+// price buckets for the price facet, in minor units; the last bucket is open ended
+var priceFacetBuckets = []struct {
+	label    string
+	min, max int64
+}{
+	{"0-500", 0, 50000},
+	{"500-1000", 50000, 100000},
+	{"1000-2500", 100000, 250000},
+	{"2500-5000", 250000, 500000},
+	{"5000+", 500000, -1},
+}
+
+// productCursor is the position after the last product of a page
+type productCursor struct {
+	Sort      string    `json:"s"`
+	Name      string    `json:"n,omitempty"`
+	Price     int64     `json:"p,omitempty"`
+	CreatedAt time.Time `json:"c,omitempty"`
+	Offset    int       `json:"o,omitempty"` // relevance order has no column, so it pages by position
+	ID        uuid.UUID `json:"i"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+func encodeProductCursor(sort string, p Product) string {
+	cursor := productCursor{Sort: sort, ID: p.ID}
+	switch productSorts[sort].column {
+	case "name":
+		cursor.Name = p.Name
+	case "price_minor":
+		cursor.Price = p.Price.Minor
+	default:
+		cursor.CreatedAt = p.CreatedAt
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeProductCursor(s string) (*productCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor productCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// applyProductFilters narrows a product query to the public catalogue and the requested filters.
+// Filters named in skip are left out, which is how facet counts ignore their own filter.
+func applyProductFilters(tx *gorm.DB, q ProductQuery, skip ...string) *gorm.DB {
+	skipped := func(name string) bool {
+		for _, s := range skip {
+			if s == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	tx = tx.Where("products.status = ?", ProductActive)
+	if q.Query != "" {
+		if len(q.matches) == 0 {
+			return tx.Where("1 = 0")
+		}
+		tx = tx.Where("products.id IN ?", q.matches)
+	}
+	if q.Category != "" && !skipped("category") {
+		tx = tx.Where("products.category_id IN ?", q.categoryIDs)
+	}
+	if q.SellerID != uuid.Nil {
+		tx = tx.Where("products.seller_id = ?", q.SellerID)
+	}
+	if !skipped("price") {
+		if q.MinPrice.IsPositive() {
+			tx = tx.Where("products.price_minor >= ?", q.MinPrice.Minor)
+		}
+		if q.MaxPrice.IsPositive() {
+			tx = tx.Where("products.price_minor <= ?", q.MaxPrice.Minor)
+		}
+	}
+	if q.InStock {
+		tx = tx.Where("products.stock > 0")
+	}
+	if q.Gender != "" || q.Size != "" || q.Colour != "" {
+		variants := db.Model(&ProductVariant{}).Select("product_id")
+		if q.Gender != "" {
+			variants = variants.Where("gender = ?", q.Gender)
+		}
+		if q.Size != "" {
+			variants = variants.Where("size = ?", q.Size)
+		}
+		if q.Colour != "" {
+			variants = variants.Where("colour = ?", q.Colour)
+		}
+		if q.InStock {
+			variants = variants.Where("stock > 0")
+		}
+		tx = tx.Where("products.id IN (?)", variants)
+	}
+	return tx
+}
+
+/*
+queries the public product catalogue with filters, a stable sort order and cursor pagination
+@params query
+*/
+func QueryProducts(q ProductQuery) (*ProductPage, error) {
+	q.Query = strings.TrimSpace(q.Query)
+	if q.Sort == "" {
+		q.Sort = "newest"
+		if q.Query != "" {
+			q.Sort = "relevance"
+		}
+	}
+	sort, ok := productSorts[q.Sort]
+	if !ok && (q.Sort != "relevance" || q.Query == "") {
+		return nil, errors.New("invalid sort, use one of relevance (with q), newest, oldest, price_asc, price_desc, name_asc, name_desc")
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultProductPageSize
+	}
+	if q.Limit > maxProductPageSize {
+		q.Limit = maxProductPageSize
+	}
+
+	if q.Category != "" {
+		categoryIDs, err := CategoryWithDescendants(CategoryProduct, q.Category)
+		if err != nil {
+			return nil, err
+		}
+		q.categoryIDs = categoryIDs
+	}
+
+	page := ProductPage{Products: []Product{}}
+	if q.Query != "" {
+		ids, highlights, err := searchProductIDs(q.Query)
+		if err != nil {
+			log.Println("error searching products:", err.Error())
+			return nil, errors.New("failed to search products")
+		}
+		q.matches = ids
+		page.Highlights = highlights
+	}
+	if err := applyProductFilters(db.Model(&Product{}), q).Count(&page.Total).Error; err != nil {
+		log.Println("error counting products:", err.Error())
+		return nil, errors.New("failed to query products")
+	}
+
+	if q.Sort == "relevance" {
+		if err := pageByRelevance(q, &page); err != nil {
+			return nil, err
+		}
+	} else if err := pageBySort(q, sort, &page); err != nil {
+		return nil, err
+	}
+
+	// only keep highlights for the products on this page
+	if page.Highlights != nil {
+		highlights := map[uuid.UUID]map[string]string{}
+		for _, product := range page.Products {
+			if h, ok := page.Highlights[product.ID]; ok {
+				highlights[product.ID] = h
+			}
+		}
+		page.Highlights = highlights
+	}
+
+	facets, err := productFacets(q)
+	if err != nil {
+		return nil, err
+	}
+	page.Facets = *facets
+	return &page, nil
+}
+
+// pageBySort loads a page ordered by a column using a keyset cursor
+func pageBySort(q ProductQuery, sort productSort, page *ProductPage) error {
+	query := applyProductFilters(db.Model(&Product{}), q)
+	if q.Cursor != "" {
+		cursor, err := decodeProductCursor(q.Cursor)
+		if err != nil || cursor.Sort != q.Sort {
+			return ErrInvalidCursor
+		}
+		var value interface{}
+		switch sort.column {
+		case "name":
+			value = cursor.Name
+		case "price_minor":
+			value = cursor.Price
+		default:
+			value = cursor.CreatedAt
+		}
+		op := ">"
+		if sort.desc {
+			op = "<"
+		}
+		query = query.Where("(products."+sort.column+" "+op+" ? OR (products."+sort.column+" = ? AND products.id "+op+" ?))", value, value, cursor.ID)
+	}
+	direction := " ASC"
+	if sort.desc {
+		direction = " DESC"
+	}
+	// fetch one extra row to know whether there is a next page
+	err := query.Order("products." + sort.column + direction).
+		Order("products.id" + direction).
+		Limit(q.Limit + 1).
+		Find(&page.Products).Error
+	if err != nil {
+		log.Println("error querying products:", err.Error())
+		return errors.New("failed to query products")
+	}
+	if len(page.Products) > q.Limit {
+		page.Products = page.Products[:q.Limit]
+		page.NextCursor = encodeProductCursor(q.Sort, page.Products[q.Limit-1])
+	}
+	return nil
+}
+
+// pageByRelevance loads the matching products and pages through them in search rank order
+func pageByRelevance(q ProductQuery, page *ProductPage) error {
+	offset := 0
+	if q.Cursor != "" {
+		cursor, err := decodeProductCursor(q.Cursor)
+		if err != nil || cursor.Sort != q.Sort || cursor.Offset < 0 {
+			return ErrInvalidCursor
+		}
+		offset = cursor.Offset
+	}
+
+	var products []Product
+	if err := applyProductFilters(db.Model(&Product{}), q).Find(&products).Error; err != nil {
+		log.Println("error querying products:", err.Error())
+		return errors.New("failed to query products")
+	}
+	byID := make(map[uuid.UUID]Product, len(products))
+	for _, product := range products {
+		byID[product.ID] = product
+	}
+	ranked := make([]Product, 0, len(products))
+	for _, id := range q.matches {
+		if product, ok := byID[id]; ok {
+			ranked = append(ranked, product)
+		}
+	}
+
+	if offset > len(ranked) {
+		offset = len(ranked)
+	}
+	end := offset + q.Limit
+	if end < len(ranked) {
+		cursor := productCursor{Sort: q.Sort, Offset: end, ID: ranked[end-1].ID}
+		data, _ := json.Marshal(cursor)
+		page.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+	} else {
+		end = len(ranked)
+	}
+	page.Products = ranked[offset:end]
+	return nil
+}
+
+// productFacets counts matching products per category, price range and stock
+func productFacets(q ProductQuery) (*ProductFacets, error) {
+	facets := ProductFacets{Categories: []FacetCount{}, PriceRanges: []FacetCount{}}
+
+	err := applyProductFilters(db.Model(&Product{}), q, "category").
+		Joins("JOIN categories ON categories.id = products.category_id").
+		Select("categories.slug AS value, categories.name AS label, COUNT(*) AS count").
+		Group("categories.slug, categories.name").
+		Order("count DESC").
+		Scan(&facets.Categories).Error
+	if err != nil {
+		log.Println("error counting category facets:", err.Error())
+		return nil, errors.New("failed to query products")
+	}
+
+	for _, bucket := range priceFacetBuckets {
+		var count int64
+		tx := applyProductFilters(db.Model(&Product{}), q, "price").Where("products.price_minor >= ?", bucket.min)
+		if bucket.max >= 0 {
+			tx = tx.Where("products.price_minor < ?", bucket.max)
+		}
+		if err := tx.Count(&count).Error; err != nil {
+			log.Println("error counting price facets:", err.Error())
+			return nil, errors.New("failed to query products")
+		}
+		facets.PriceRanges = append(facets.PriceRanges, FacetCount{Value: bucket.label, Count: count})
+	}
+
+	if err := applyProductFilters(db.Model(&Product{}), q).Where("products.stock > 0").Count(&facets.InStock).Error; err != nil {
+		log.Println("error counting stock facet:", err.Error())
+		return nil, errors.New("failed to query products")
+	}
+	return &facets, nil
+}
@@ -0,0 +1,393 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrQuoteNotFound = errors.New("quote request not found")
+	ErrOfferNotFound = errors.New("offer not found")
+	ErrNotQuoteParty = errors.New("you are not part of this quote request")
+	ErrQuoteState    = errors.New("the quote request is no longer open")
+	ErrOfferState    = errors.New("the offer is no longer open")
+)
+
+type quoteRequestBody struct {
+	Description string     `json:"description"`
+	Budget      Money      `json:"budget"`
+	NeededBy    *time.Time `json:"needed_by"`
+}
+
+/*
+asks the seller of a service for a quote on a job the customer describes
+@params service_id
+*/
+func CreateQuoteRequest(c *fiber.Ctx, serviceID uuid.UUID) (*QuoteRequest, error) {
+	customerID, _ := GetAuthUserID(c)
+	body := quoteRequestBody{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing quote request:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	body.Description = strings.TrimSpace(body.Description)
+	if body.Description == "" {
+		return nil, errors.New("describe the job you need done")
+	}
+	if len(body.Description) > 5000 {
+		return nil, errors.New("description must be at most 5000 characters")
+	}
+	if body.Budget.IsNegative() {
+		return nil, errors.New("budget cannot be negative")
+	}
+	if body.NeededBy != nil && body.NeededBy.Before(time.Now()) {
+		return nil, errors.New("needed_by must be in the future")
+	}
+	var service Service
+	if err := db.First(&service, "id = ? AND is_active = ?", serviceID, true).Error; err != nil {
+		return nil, ErrServiceNotFound
+	}
+	if service.SellerID == customerID {
+		return nil, errors.New("you cannot request a quote for your own service")
+	}
+
+	quote := QuoteRequest{
+		BaseModel:   BaseModel{ID: uuid.New()},
+		ServiceID:   service.ID,
+		SellerID:    service.SellerID,
+		CustomerID:  customerID,
+		Description: body.Description,
+		Budget:      body.Budget,
+		NeededBy:    body.NeededBy,
+		Status:      QuoteOpen,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&quote).Error; err != nil {
+			return err
+		}
+		return notifyUser(tx, Notification{
+			UserID:    service.SellerID,
+			Kind:      "quote_requested",
+			Title:     "Quote request: " + service.Name,
+			Body:      "A buyer asked for a quote on " + service.Name + ". Send them a custom offer.",
+			SendEmail: true,
+		})
+	})
+	if err != nil {
+		log.Println("error creating quote request:", err.Error())
+		return nil, errors.New("failed to create quote request")
+	}
+	quote.Service = service
+	return &quote, nil
+}
+
+// QuoteFilter narrows the quote requests a user sees
+type QuoteFilter struct {
+	Role   string `query:"role"` // customer (default) or seller
+	Status string `query:"status"`
+}
+
+/*
+gets the quote requests the user made, or as a seller the ones sent to them, with their offers
+@params user_id
+@params filter
+*/
+func GetQuoteRequests(userID uuid.UUID, filter QuoteFilter) (*[]QuoteRequest, error) {
+	quotes := []QuoteRequest{}
+	query := db.Preload("Service").Preload("Offers", func(db *gorm.DB) *gorm.DB {
+		return db.Order("created_at DESC")
+	})
+	if filter.Role == "seller" {
+		query = query.Where("seller_id = ?", userID)
+	} else {
+		query = query.Where("customer_id = ?", userID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if err := query.Order("created_at DESC").Find(&quotes).Error; err != nil {
+		log.Println("error fetching quote requests:", err.Error())
+		return nil, errors.New("failed to fetch quote requests")
+	}
+	return &quotes, nil
+}
+
+// findQuoteRequest loads a quote request the authenticated user is the customer or seller of
+func findQuoteRequest(c *fiber.Ctx, quoteID uuid.UUID) (*QuoteRequest, uuid.UUID, error) {
+	userID, _ := GetAuthUserID(c)
+	var quote QuoteRequest
+	if err := db.Preload("Service").First(&quote, "id = ?", quoteID).Error; err != nil {
+		return nil, userID, ErrQuoteNotFound
+	}
+	if quote.CustomerID != userID && quote.SellerID != userID {
+		return nil, userID, ErrNotQuoteParty
+	}
+	return &quote, userID, nil
+}
+
+/*
+closes a quote request: the customer withdraws it or the seller declines the job.
+Offers still waiting on it are closed too.
+@params quote_id
+*/
+func CloseQuoteRequest(c *fiber.Ctx, quoteID uuid.UUID) (*QuoteRequest, error) {
+	quote, userID, err := findQuoteRequest(c, quoteID)
+	if err != nil {
+		return nil, err
+	}
+	offerStatus, other := OfferDeclined, quote.SellerID
+	if userID == quote.SellerID {
+		offerStatus, other = OfferWithdrawn, quote.CustomerID
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&QuoteRequest{}).
+			Where("id = ? AND status IN ?", quote.ID, []QuoteStatus{QuoteOpen, QuoteOffered}).
+			Update("status", QuoteClosed)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrQuoteState
+		}
+		err := tx.Model(&CustomOffer{}).Where("quote_request_id = ? AND status = ?", quote.ID, OfferPending).
+			Update("status", offerStatus).Error
+		if err != nil {
+			return err
+		}
+		return notifyUser(tx, Notification{
+			UserID: other,
+			Kind:   "quote_closed",
+			Title:  "Quote request closed: " + quote.Service.Name,
+			Body:   "The quote request for " + quote.Service.Name + " was closed.",
+		})
+	})
+	if err != nil {
+		return nil, quoteError("closing quote request", err)
+	}
+	quote.Status = QuoteClosed
+	return quote, nil
+}
+
+type customOfferBody struct {
+	Description  string     `json:"description"`
+	Deliverables StringList `json:"deliverables"`
+	DeliveryDays int        `json:"delivery_days"`
+	Price        Money      `json:"price"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+}
+
+/*
+answers a quote request with a custom offer the customer can accept and pay for
+@params quote_id
+*/
+func SendCustomOffer(c *fiber.Ctx, quoteID uuid.UUID) (*CustomOffer, error) {
+	quote, userID, err := findQuoteRequest(c, quoteID)
+	if err != nil {
+		return nil, err
+	}
+	if quote.SellerID != userID {
+		return nil, ErrNotQuoteParty
+	}
+	body := customOfferBody{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing custom offer:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	body.Description = strings.TrimSpace(body.Description)
+	if body.Description == "" {
+		return nil, errors.New("describe what the offer includes")
+	}
+	if !body.Price.IsPositive() {
+		return nil, errors.New("price must be greater than 0")
+	}
+	if body.Price, err = inServiceCurrency(quote.Service, body.Price); err != nil {
+		return nil, err
+	}
+	if body.DeliveryDays <= 0 {
+		return nil, errors.New("delivery_days must be at least 1")
+	}
+	if body.ExpiresAt != nil && body.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("expires_at must be in the future")
+	}
+
+	offer := CustomOffer{
+		BaseModel:      BaseModel{ID: uuid.New()},
+		QuoteRequestID: quote.ID,
+		ServiceID:      quote.ServiceID,
+		SellerID:       quote.SellerID,
+		CustomerID:     quote.CustomerID,
+		Description:    body.Description,
+		Deliverables:   body.Deliverables,
+		DeliveryDays:   body.DeliveryDays,
+		Price:          body.Price,
+		ExpiresAt:      body.ExpiresAt,
+		Status:         OfferPending,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&QuoteRequest{}).
+			Where("id = ? AND status IN ?", quote.ID, []QuoteStatus{QuoteOpen, QuoteOffered}).
+			Update("status", QuoteOffered)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrQuoteState
+		}
+		if err := tx.Create(&offer).Error; err != nil {
+			return err
+		}
+		return notifyUser(tx, Notification{
+			UserID:    quote.CustomerID,
+			Kind:      "offer_received",
+			Title:     "New offer: " + quote.Service.Name,
+			Body:      fmt.Sprintf("The seller of %s sent you an offer of %s, delivered in %d days.", quote.Service.Name, offer.Price, offer.DeliveryDays),
+			SendEmail: true,
+		})
+	})
+	if err != nil {
+		return nil, quoteError("sending custom offer", err)
+	}
+	return &offer, nil
+}
+
+// findOffer loads a pending offer the authenticated user is the customer or seller of
+func findOffer(c *fiber.Ctx, offerID uuid.UUID) (*CustomOffer, uuid.UUID, error) {
+	userID, _ := GetAuthUserID(c)
+	var offer CustomOffer
+	if err := db.First(&offer, "id = ?", offerID).Error; err != nil {
+		return nil, userID, ErrOfferNotFound
+	}
+	if offer.CustomerID != userID && offer.SellerID != userID {
+		return nil, userID, ErrOfferNotFound
+	}
+	if offer.Status != OfferPending {
+		return nil, userID, ErrOfferState
+	}
+	return &offer, userID, nil
+}
+
+/*
+moves a pending offer to another status, failing when another request changed it first
+@params offer_id
+@params updates
+*/
+func closeOffer(tx *gorm.DB, offerID uuid.UUID, updates map[string]interface{}) error {
+	result := tx.Model(&CustomOffer{}).Where("id = ? AND status = ?", offerID, OfferPending).Updates(updates)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrOfferState
+	}
+	return nil
+}
+
+/*
+accepts a custom offer, turning it into an order the customer pays through the usual payment flow
+@params offer_id
+*/
+func AcceptCustomOffer(c *fiber.Ctx, offerID uuid.UUID) (*Order, error) {
+	offer, userID, err := findOffer(c, offerID)
+	if err != nil {
+		return nil, err
+	}
+	if offer.CustomerID != userID {
+		return nil, ErrNotQuoteParty
+	}
+	if offer.ExpiresAt != nil && offer.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("the offer has expired, ask the seller for a new one")
+	}
+	if err := RequireVerified(userID); err != nil {
+		return nil, err
+	}
+	body := struct {
+		PaymentMethod string `json:"payment_method"`
+	}{}
+	c.BodyParser(&body)
+	var service Service
+	if err := db.Unscoped().First(&service, "id = ?", offer.ServiceID).Error; err != nil {
+		return nil, ErrServiceNotFound
+	}
+
+	var order *Order
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		order, err = placeServiceOrder(tx, userID, body.PaymentMethod, OrderItem{
+			ServiceID: &offer.ServiceID,
+			OfferID:   &offer.ID,
+			Price:     offer.Price,
+		})
+		if err != nil {
+			return err
+		}
+		if err := closeOffer(tx, offer.ID, map[string]interface{}{"status": OfferAccepted, "order_id": order.ID}); err != nil {
+			return err
+		}
+		// the other offers on the same request are no longer needed
+		err = tx.Model(&CustomOffer{}).Where("quote_request_id = ? AND status = ?", offer.QuoteRequestID, OfferPending).
+			Update("status", OfferDeclined).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&QuoteRequest{}).Where("id = ?", offer.QuoteRequestID).Update("status", QuoteAccepted).Error; err != nil {
+			return err
+		}
+		return notifyUser(tx, Notification{
+			UserID:    offer.SellerID,
+			Kind:      "offer_accepted",
+			Title:     "Offer accepted: " + service.Name,
+			Body:      fmt.Sprintf("Your offer of %s for %s was accepted (order %s).", offer.Price, service.Name, order.OrderNumber),
+			SendEmail: true,
+		})
+	})
+	if err != nil {
+		return nil, quoteError("accepting custom offer", err)
+	}
+	return order, nil
+}
+
+/*
+declines a custom offer for the customer, or withdraws it for the seller
+@params offer_id
+*/
+func RejectCustomOffer(c *fiber.Ctx, offerID uuid.UUID) (*CustomOffer, error) {
+	offer, userID, err := findOffer(c, offerID)
+	if err != nil {
+		return nil, err
+	}
+	status, other := OfferDeclined, offer.SellerID
+	if userID == offer.SellerID {
+		status, other = OfferWithdrawn, offer.CustomerID
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := closeOffer(tx, offer.ID, map[string]interface{}{"status": status}); err != nil {
+			return err
+		}
+		return notifyUser(tx, Notification{
+			UserID: other,
+			Kind:   "offer_" + string(status),
+			Title:  "Offer " + string(status),
+			Body:   fmt.Sprintf("The offer of %s was %s.", offer.Price, status),
+		})
+	})
+	if err != nil {
+		return nil, quoteError("closing custom offer", err)
+	}
+	offer.Status = status
+	return offer, nil
+}
+
+func quoteError(action string, err error) error {
+	if errors.Is(err, ErrQuoteState) || errors.Is(err, ErrOfferState) {
+		return err
+	}
+	log.Println("error "+action+":", err.Error())
+	return errors.New("failed to update quote request")
+}
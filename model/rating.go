@@ -1,17 +1,32 @@
 package model
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"math"
+	"os"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const defaultRatingPriorWeight = 5
+
+var (
+	ErrRatingNotFound  = errors.New("rating not found")
+	ErrSelfRating      = errors.New("you cannot rate yourself")
+	ErrNotASeller      = errors.New("only sellers can be rated")
+	ErrAlreadyRated    = errors.New("you have already rated this seller, edit your rating instead")
+	ErrRatingNotEarned = errors.New("you can only rate sellers you have received an order from")
 )
 
 func CreateRatings(c *fiber.Ctx) error {
-    // Initialize rating and get IDs
-    rating := new(Rating)
+    // Get IDs
     sellerID := c.Params("id")
     userID, err := GetAuthUserID(c)
     
@@ -23,8 +38,12 @@ func CreateRatings(c *fiber.Ctx) error {
         })
     }
 
-    // Parse request body
-    if err := c.BodyParser(rating); err != nil {
+    // Parse request body, only the stars and comment come from the rater
+    body := struct {
+        Stars   int    `json:"stars"`
+        Comment string `json:"comment"`
+    }{}
+    if err := c.BodyParser(&body); err != nil {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
             "error": "Bad Request",
             "message": "Could not parse rating data",
@@ -33,7 +52,7 @@ func CreateRatings(c *fiber.Ctx) error {
     }
 
     // Validate input
-    if rating.Stars < 1 || rating.Stars > 5 {
+    if body.Stars < 1 || body.Stars > 5 {
         return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
             "error": "Bad Request",
             "message": "Rating must be between 1 and 5 stars",
@@ -41,16 +60,41 @@ func CreateRatings(c *fiber.Ctx) error {
     }
 
     // Set required fields
-    rating.SellerID = uuid.MustParse(sellerID)
-    rating.UserID = userID
-    rating.ID = uuid.New()
+    sellerUUID, err := uuid.Parse(sellerID)
+    if err != nil {
+        return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+            "error": "Bad Request",
+            "message": "Invalid seller ID",
+        })
+    }
+    rating := &Rating{
+        ID:       uuid.New(),
+        SellerID: sellerUUID,
+        UserID:   userID,
+        Stars:    body.Stars,
+        Comment:  body.Comment,
+    }
 
-    // Save to database
-    if err := db.Create(&rating).Error; err != nil {
-        return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+    // Only buyers who received an order from the seller can rate them, once
+    if err := canRateSeller(rating.SellerID, userID); err != nil {
+        return c.Status(ratingErrorStatus(err)).JSON(fiber.Map{
+            "error": "Forbidden",
+            "message": err.Error(),
+        })
+    }
+
+    // Save to database and count it in the seller's summary
+    err = db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Create(rating).Error; err != nil {
+            return err
+        }
+        return adjustSellerRating(tx, rating.SellerID, rating.Stars, 1)
+    })
+    if err != nil {
+        log.Println("error creating rating:", err.Error())
+        return c.Status(fiber.StatusConflict).JSON(fiber.Map{
             "error": "Database Error",
-            "message": "Could not create rating",
-            "details": err.Error(),
+            "message": "Could not create rating, you may have already rated this seller",
         })
     }
 
@@ -113,9 +157,16 @@ func GetRatings(c *fiber.Ctx) error {
     }
     countQuery.Count(&total)
 
+    // Include the seller's rating summary when filtering by seller
+    var summary *SellerRating
+    if id, err := uuid.Parse(sellerID); err == nil {
+        summary, _ = GetSellerRating(id)
+    }
+
     // Return response with pagination metadata
     return c.JSON(fiber.Map{
         "data": ratings,
+        "summary": summary,
         "meta": fiber.Map{
             "total":     total,
             "page":      page,
@@ -123,4 +174,289 @@ func GetRatings(c *fiber.Ctx) error {
             "totalPages": int(math.Ceil(float64(total) / float64(limit))),
         },
     })
-}
\ No newline at end of file
+}
+
+/*
+checks that a user may rate a seller: not themselves, a seller, not rated before and
+with a delivered order containing one of the seller's products or services
+@params seller_id
+@params user_id
+*/
+func canRateSeller(sellerID, userID uuid.UUID) error {
+	if sellerID == userID {
+		return ErrSelfRating
+	}
+	var seller User
+	if err := db.First(&seller, "id = ?", sellerID).Error; err != nil || seller.UserRole != "seller" {
+		return ErrNotASeller
+	}
+	var rated int64
+	db.Model(&Rating{}).Where("seller_id = ? AND user_id = ?", sellerID, userID).Count(&rated)
+	if rated > 0 {
+		return ErrAlreadyRated
+	}
+	var delivered int64
+	err := db.Model(&OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Joins("LEFT JOIN products ON products.id = order_items.product_id").
+		Joins("LEFT JOIN services ON services.id = order_items.service_id").
+		Where("orders.user_id = ? AND orders.order_status = ?", userID, OrderDelivered).
+		Where("products.seller_id = ? OR services.seller_id = ?", sellerID, sellerID).
+		Count(&delivered).Error
+	if err != nil {
+		log.Println("error checking orders for rating:", err.Error())
+		return errors.New("failed to check your orders")
+	}
+	if delivered == 0 {
+		return ErrRatingNotEarned
+	}
+	return nil
+}
+
+func ratingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrRatingNotFound), errors.Is(err, ErrNotASeller):
+		return fiber.StatusNotFound
+	case errors.Is(err, ErrAlreadyRated):
+		return fiber.StatusConflict
+	case errors.Is(err, ErrSelfRating), errors.Is(err, ErrRatingNotEarned):
+		return fiber.StatusForbidden
+	}
+	return fiber.StatusInternalServerError
+}
+
+/*
+adds (delta 1) or removes (delta -1) a rating of the given stars from a seller's summary
+@params seller_id
+@params stars
+@params delta
+*/
+func adjustSellerRating(tx *gorm.DB, sellerID uuid.UUID, stars, delta int) error {
+	if stars < 1 || stars > 5 {
+		return errors.New("stars must be between 1 and 5")
+	}
+	column := fmt.Sprintf("stars%d", stars)
+	summary := map[string]interface{}{"seller_id": sellerID, "count": delta, "total": delta * stars, column: delta, "updated_at": time.Now()}
+	return tx.Model(&SellerRatingSummary{}).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "seller_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"count":      gorm.Expr("count + ?", delta),
+			"total":      gorm.Expr("total + ?", delta*stars),
+			column:       gorm.Expr(column+" + ?", delta),
+			"updated_at": gorm.Expr("CURRENT_TIMESTAMP"),
+		}),
+	}).Create(summary).Error
+}
+
+// ownRating loads a rating left by the authenticated user
+func ownRating(c *fiber.Ctx) (*Rating, error) {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return nil, ErrRatingNotFound
+	}
+	userID, _ := GetAuthUserID(c)
+	var rating Rating
+	if err := db.First(&rating, "id = ? AND user_id = ?", id, userID).Error; err != nil {
+		return nil, ErrRatingNotFound
+	}
+	return &rating, nil
+}
+
+// UpdateRatings changes the stars or comment of the user's own rating
+func UpdateRatings(c *fiber.Ctx) error {
+	rating, err := ownRating(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "Not Found",
+			"message": err.Error(),
+		})
+	}
+	body := struct {
+		Stars   *int    `json:"stars"`
+		Comment *string `json:"comment"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "Could not parse rating data",
+		})
+	}
+	previous := rating.Stars
+	if body.Stars != nil {
+		if *body.Stars < 1 || *body.Stars > 5 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "Bad Request",
+				"message": "Rating must be between 1 and 5 stars",
+			})
+		}
+		rating.Stars = *body.Stars
+	}
+	if body.Comment != nil {
+		rating.Comment = *body.Comment
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(rating).Updates(map[string]interface{}{"stars": rating.Stars, "comment": rating.Comment}).Error; err != nil {
+			return err
+		}
+		if previous == rating.Stars {
+			return nil
+		}
+		if err := adjustSellerRating(tx, rating.SellerID, previous, -1); err != nil {
+			return err
+		}
+		return adjustSellerRating(tx, rating.SellerID, rating.Stars, 1)
+	})
+	if err != nil {
+		log.Println("error updating rating:", err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Database Error",
+			"message": "Could not update rating",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    rating,
+	})
+}
+
+// DeleteRatings removes the user's own rating
+func DeleteRatings(c *fiber.Ctx) error {
+	rating, err := ownRating(c)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error":   "Not Found",
+			"message": err.Error(),
+		})
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// removed outright so the seller can be rated again
+		if err := tx.Unscoped().Delete(rating).Error; err != nil {
+			return err
+		}
+		return adjustSellerRating(tx, rating.SellerID, rating.Stars, -1)
+	})
+	if err != nil {
+		log.Println("error deleting rating:", err.Error())
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Database Error",
+			"message": "Could not delete rating",
+		})
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"message": "rating deleted",
+	})
+}
+
+// SellerRating is a seller's rating summary as shown to buyers
+type SellerRating struct {
+	SellerID uuid.UUID `json:"seller_id"`
+	Average  float64   `json:"average"`
+	Count    int       `json:"count"`
+	// average pulled towards the mean of all sellers, so a few ratings do not outrank many
+	BayesianAverage float64     `json:"bayesian_average"`
+	Histogram       map[int]int `json:"histogram"`
+}
+
+// RatingPriorWeight is how many average ratings a seller's Bayesian average starts with, read from RATING_PRIOR_WEIGHT
+func RatingPriorWeight() float64 {
+	if weight, err := strconv.ParseFloat(os.Getenv("RATING_PRIOR_WEIGHT"), 64); err == nil && weight >= 0 {
+		return weight
+	}
+	return defaultRatingPriorWeight
+}
+
+/*
+gets a seller's rating summary
+@params seller_id
+*/
+func GetSellerRating(sellerID uuid.UUID) (*SellerRating, error) {
+	var summary SellerRatingSummary
+	if err := db.Where("seller_id = ?", sellerID).Limit(1).Find(&summary).Error; err != nil {
+		log.Println("error fetching seller rating:", err.Error())
+		return nil, errors.New("failed to fetch seller rating")
+	}
+	var overall struct {
+		Count int
+		Total int
+	}
+	db.Model(&SellerRatingSummary{}).Select("COALESCE(SUM(count), 0) AS count, COALESCE(SUM(total), 0) AS total").Scan(&overall)
+	prior := 3.0
+	if overall.Count > 0 {
+		prior = float64(overall.Total) / float64(overall.Count)
+	}
+
+	rating := SellerRating{
+		SellerID:  sellerID,
+		Count:     summary.Count,
+		Histogram: map[int]int{1: summary.Stars1, 2: summary.Stars2, 3: summary.Stars3, 4: summary.Stars4, 5: summary.Stars5},
+	}
+	if summary.Count > 0 {
+		rating.Average = math.Round(float64(summary.Total)/float64(summary.Count)*100) / 100
+	}
+	weight := RatingPriorWeight()
+	if weight+float64(summary.Count) > 0 {
+		rating.BayesianAverage = math.Round((weight*prior+float64(summary.Total))/(weight+float64(summary.Count))*100) / 100
+	}
+	return &rating, nil
+}
+
+// GetSellerRatingHandler returns the rating summary of a seller
+func GetSellerRatingHandler(c *fiber.Ctx) error {
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "Bad Request",
+			"message": "Invalid seller ID",
+		})
+	}
+	summary, err := GetSellerRating(id)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error":   "Database Error",
+			"message": err.Error(),
+		})
+	}
+	return c.JSON(fiber.Map{
+		"success": true,
+		"data":    summary,
+	})
+}
+
+/*
+keeps the newest rating of each buyer for each seller so that the unique index on
+ratings can be created. Soft deleted ratings are removed for the same reason.
+*/
+func dedupeRatings() {
+	if !db.Migrator().HasTable(&Rating{}) || db.Migrator().HasIndex(&Rating{}, "idx_seller_rating") {
+		return
+	}
+	if err := db.Exec("DELETE FROM ratings WHERE deleted_at IS NOT NULL").Error; err != nil {
+		log.Println("error removing deleted ratings:", err.Error())
+	}
+	err := db.Exec(`DELETE older FROM ratings older JOIN ratings newer
+		ON older.seller_id = newer.seller_id AND older.user_id = newer.user_id
+		AND (older.created_at < newer.created_at OR (older.created_at = newer.created_at AND older.id < newer.id))`).Error
+	if err != nil {
+		log.Println("error removing duplicate ratings:", err.Error())
+	}
+}
+
+/*
+fills the seller rating summaries from the ratings when they have not been built yet
+*/
+func rebuildSellerRatingSummaries() {
+	var summaries int64
+	db.Model(&SellerRatingSummary{}).Count(&summaries)
+	if summaries > 0 {
+		return
+	}
+	err := db.Exec(`INSERT INTO seller_rating_summaries (seller_id, count, total, stars1, stars2, stars3, stars4, stars5, updated_at)
+		SELECT seller_id, COUNT(*), SUM(stars),
+			SUM(stars = 1), SUM(stars = 2), SUM(stars = 3), SUM(stars = 4), SUM(stars = 5), NOW()
+		FROM ratings WHERE deleted_at IS NULL GROUP BY seller_id`).Error
+	if err != nil {
+		log.Println("error building seller rating summaries:", err.Error())
+	}
+}
@@ -0,0 +1,195 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/dancankarani/palace/utilities"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const defaultVATBasisPoints = 1600 // 16% Kenyan VAT
+
+// VATBasisPoints is the VAT rate included in prices, read from VAT_RATE as a fraction such as "0.16"
+func VATBasisPoints() int64 {
+	rate, err := strconv.ParseFloat(os.Getenv("VAT_RATE"), 64)
+	if err != nil || rate < 0 || rate >= 1 {
+		return defaultVATBasisPoints
+	}
+	return int64(math.Round(rate * 10000))
+}
+
+// vatIncluded returns the VAT portion of a VAT-inclusive amount
+func vatIncluded(amount Money, basisPoints int64) Money {
+	divisor := 10000 + basisPoints
+	return Money{Minor: (amount.Minor*basisPoints + divisor/2) / divisor, Currency: amount.CurrencyCode()}
+}
+
+var ErrOrderNotPaid = errors.New("receipts are only available for paid orders")
+
+/*
+loads a paid order with its customer, items, sellers and confirmed payments
+@params order_id
+*/
+func GetReceiptOrder(orderID uuid.UUID) (*Order, []Payment, error) {
+	var order Order
+	err := db.Preload("User").
+		Preload("Items.Product", func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }).
+		Preload("Items.Product.User").
+		Preload("Items.Service", func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }).
+		Preload("Items.Service.User").
+		First(&order, "id = ?", orderID).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, errors.New("order not found")
+		}
+		log.Println("error fetching order for receipt:", err.Error())
+		return nil, nil, errors.New("failed to fetch order")
+	}
+	if order.PaymentStatus != PaymentPaid {
+		return &order, nil, ErrOrderNotPaid
+	}
+
+	var payments []Payment
+	if err := db.Where("order_id = ? AND payment_status = ?", order.ID, PaymentPaid).Order("created_at").Find(&payments).Error; err != nil {
+		log.Println("error fetching payments for receipt:", err.Error())
+		return nil, nil, errors.New("failed to fetch payments")
+	}
+	return &order, payments, nil
+}
+
+/*
+renders the receipt of a paid order as a PDF
+@params order
+@params payments
+*/
+func RenderReceiptPDF(order *Order, payments []Payment) []byte {
+	pdf := utilities.NewPDF()
+	const left, right = 50.0, utilities.PageWidth - 50
+	y := utilities.PageHeight - 60
+	newline := func(step float64) {
+		y -= step
+		if y < 60 {
+			pdf.AddPage()
+			y = utilities.PageHeight - 60
+		}
+	}
+
+	pdf.Text(left, y, 20, true, "Palace")
+	pdf.TextRight(right, y, 16, true, "RECEIPT")
+	newline(28)
+	pdf.Text(left, y, 10, false, "Order: "+order.OrderNumber)
+	pdf.TextRight(right, y, 10, false, "Date: "+order.CreatedAt.Format("02 Jan 2006 15:04"))
+	newline(14)
+	pdf.Text(left, y, 10, false, fmt.Sprintf("Customer: %s %s", order.User.FirstName, order.User.LastName))
+	pdf.TextRight(right, y, 10, false, "Payment status: "+string(order.PaymentStatus))
+	newline(14)
+	if order.User.Email != "" || order.User.PhoneNumber != "" {
+		pdf.Text(left, y, 10, false, order.User.Email+"  "+order.User.PhoneNumber)
+		newline(14)
+	}
+	if order.ShippingAddress != "" {
+		pdf.Text(left, y, 10, false, "Ship to: "+order.ShippingAddress)
+		newline(14)
+	}
+
+	newline(10)
+	pdf.Text(left, y, 10, true, "Item")
+	pdf.Text(260, y, 10, true, "Seller")
+	pdf.TextRight(420, y, 10, true, "Qty")
+	pdf.TextRight(480, y, 10, true, "Unit price")
+	pdf.TextRight(right, y, 10, true, "Total")
+	newline(6)
+	pdf.Line(left, y, right, y)
+	newline(14)
+
+	// the items of an order are all in its currency and add up to its total
+	total := order.TotalAmount
+	for _, item := range order.Items {
+		name, _, seller := item.Listing()
+		pdf.Text(left, y, 10, false, truncate(name, 38))
+		pdf.Text(260, y, 10, false, truncate(seller.FirstName+" "+seller.LastName, 22))
+		pdf.TextRight(420, y, 10, false, strconv.Itoa(item.Quantity))
+		pdf.TextRight(480, y, 10, false, item.Price.Decimal())
+		pdf.TextRight(right, y, 10, false, item.TotalPrice.Decimal())
+		newline(12)
+		if seller.PhoneNumber != "" || seller.Email != "" {
+			pdf.Text(260, y, 8, false, truncate(seller.PhoneNumber+" "+seller.Email, 40))
+			newline(14)
+		}
+	}
+	pdf.Line(left, y+6, right, y+6)
+	newline(10)
+
+	basisPoints := VATBasisPoints()
+	vat := vatIncluded(total, basisPoints)
+	subtotal, _ := total.Sub(vat)
+	summary := []struct {
+		label string
+		value Money
+	}{
+		{"Subtotal (excl. VAT)", subtotal},
+		{fmt.Sprintf("VAT %s%%", strconv.FormatFloat(float64(basisPoints)/100, 'f', -1, 64)), vat},
+		{"Total (" + total.CurrencyCode() + ")", total},
+		{"Amount paid", order.AmountPaid},
+	}
+	for i, line := range summary {
+		bold := i == 2
+		pdf.TextRight(480, y, 10, bold, line.label)
+		pdf.TextRight(right, y, 10, bold, line.value.Decimal())
+		newline(14)
+	}
+
+	newline(10)
+	pdf.Text(left, y, 10, true, "Payments")
+	newline(14)
+	for _, payment := range payments {
+		pdf.Text(left, y, 10, false, fmt.Sprintf("%s  M-Pesa ref: %s  %s", payment.PaymentMethod, payment.TransactionID, payment.CreatedAt.Format("02 Jan 2006 15:04")))
+		pdf.TextRight(right, y, 10, false, payment.Cost.Decimal())
+		newline(14)
+	}
+
+	newline(20)
+	pdf.Text(left, y, 8, false, "Prices include VAT. Thank you for shopping with us.")
+	return pdf.Bytes()
+}
+
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+/*
+emails the receipt of a paid order to its customer
+@params order_id
+*/
+func EmailReceipt(orderID uuid.UUID) error {
+	order, payments, err := GetReceiptOrder(orderID)
+	if err != nil {
+		log.Println("error preparing receipt email for order", orderID, ":", err.Error())
+		return err
+	}
+	if order.User.Email == "" {
+		return errors.New("customer has no email address")
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nThank you for your payment of %s for order %s. Your receipt is attached.\n",
+		order.User.FirstName, order.AmountPaid, order.OrderNumber)
+	err = utilities.SendEmail(order.User.Email, "Receipt for order "+order.OrderNumber, body, utilities.Attachment{
+		Filename:    "receipt-" + order.OrderNumber + ".pdf",
+		ContentType: "application/pdf",
+		Data:        RenderReceiptPDF(order, payments),
+	})
+	if err != nil {
+		log.Println("error sending receipt email for order", orderID, ":", err.Error())
+	}
+	return err
+}
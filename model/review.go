@@ -0,0 +1,424 @@
+package model
+
+import (
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/dancankarani/palace/fulfilment"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const maxReviewPhotos = 5
+
+var (
+	ErrReviewNotFound   = errors.New("review not found")
+	ErrNotReviewAuthor  = errors.New("you can only change your own reviews")
+	ErrReviewNotAllowed = errors.New("you can only review items of your orders that have been delivered")
+	ErrAlreadyReviewed  = errors.New("you have already reviewed this item")
+	ErrAlreadyVoted     = errors.New("you have already marked this review as helpful")
+	ErrOwnReviewVote    = errors.New("you cannot vote on your own review")
+	ErrInvalidStars     = errors.New("stars must be between 1 and 5")
+)
+
+type reviewRequest struct {
+	OrderItemID string `json:"order_item_id" form:"order_item_id"`
+	Stars       int    `json:"stars" form:"stars"`
+	Title       string `json:"title" form:"title"`
+	Body        string `json:"body" form:"body"`
+}
+
+func (r *reviewRequest) validate() error {
+	r.Title, r.Body = strings.TrimSpace(r.Title), strings.TrimSpace(r.Body)
+	if r.Stars < 1 || r.Stars > 5 {
+		return ErrInvalidStars
+	}
+	if len([]rune(r.Title)) > 150 {
+		return errors.New("title must be at most 150 characters")
+	}
+	if len([]rune(r.Body)) > 5000 {
+		return errors.New("review must be at most 5000 characters")
+	}
+	return nil
+}
+
+/*
+stores review photos as re-encoded JPEGs, which also drops any location data in them
+@params c
+*/
+func uploadReviewPhotos(c *fiber.Ctx) ([]string, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, nil
+	}
+	files := form.File["photos"]
+	if len(files) > maxReviewPhotos {
+		return nil, errors.New("a review can have at most 5 photos")
+	}
+	store := utilities.NewBlobStore()
+	urls := []string{}
+	for _, file := range files {
+		data, err := utilities.ReadFormFile(file, utilities.MaxImageSize())
+		if err != nil {
+			return nil, err
+		}
+		processed, err := utilities.ProcessImage(data)
+		if err != nil {
+			return nil, err
+		}
+		url, err := store.Put("reviews/"+processed.Hash+".jpg", "image/jpeg", processed.Thumbnails["large"])
+		if err != nil {
+			log.Println("error uploading review photo:", err.Error())
+			return nil, errors.New("failed to upload photos")
+		}
+		urls = append(urls, url)
+	}
+	return urls, nil
+}
+
+/*
+sets a product's average rating and review count from its reviews
+@params product_id
+*/
+func syncProductRating(tx *gorm.DB, productID uuid.UUID) error {
+	var summary struct {
+		Average float64
+		Count   int
+	}
+	err := tx.Model(&ProductReview{}).
+		Select("COALESCE(AVG(stars), 0) AS average, COUNT(*) AS count").
+		Where("product_id = ?", productID).
+		Scan(&summary).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&Product{}).Unscoped().Where("id = ?", productID).Updates(map[string]interface{}{
+		"average_rating": math.Round(summary.Average*100) / 100,
+		"review_count":   summary.Count,
+	}).Error
+}
+
+/*
+reviews an item the user bought once its order has been delivered
+*/
+func CreateProductReview(c *fiber.Ctx) (*ProductReview, error) {
+	userID, _ := GetAuthUserID(c)
+	body := reviewRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing review:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	if err := body.validate(); err != nil {
+		return nil, err
+	}
+	itemID, err := uuid.Parse(body.OrderItemID)
+	if err != nil {
+		return nil, errors.New("order_item_id is required")
+	}
+
+	// only a buyer who confirmed they received the item, or whose window to confirm it passed, has bought it
+	var item OrderItem
+	err = db.Joins("Order").
+		Where("order_items.id = ? AND `Order`.user_id = ?", itemID, userID).
+		First(&item).Error
+	if err != nil || item.ProductID == nil || !fulfilment.Received(item.Order.OrderStatus) {
+		return nil, ErrReviewNotAllowed
+	}
+	var existing int64
+	db.Model(&ProductReview{}).Where("order_item_id = ?", item.ID).Count(&existing)
+	if existing > 0 {
+		return nil, ErrAlreadyReviewed
+	}
+
+	photos, err := uploadReviewPhotos(c)
+	if err != nil {
+		return nil, err
+	}
+	review := ProductReview{
+		BaseModel:   BaseModel{ID: uuid.New()},
+		ProductID:   *item.ProductID,
+		OrderItemID: item.ID,
+		UserID:      userID,
+		VariantID:   item.VariantID,
+		Stars:       body.Stars,
+		Title:       body.Title,
+		Body:        body.Body,
+		Photos:      photos,
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&review).Error; err != nil {
+			return err
+		}
+		return syncProductRating(tx, review.ProductID)
+	})
+	if err != nil {
+		log.Println("error creating review:", err.Error())
+		return nil, errors.New("failed to save review, the item may already be reviewed")
+	}
+	return &review, nil
+}
+
+// ownReview loads a review written by the authenticated user
+func ownReview(c *fiber.Ctx, reviewID uuid.UUID) (*ProductReview, error) {
+	var review ProductReview
+	if err := db.First(&review, "id = ?", reviewID).Error; err != nil {
+		return nil, ErrReviewNotFound
+	}
+	userID, _ := GetAuthUserID(c)
+	if review.UserID != userID {
+		return nil, ErrNotReviewAuthor
+	}
+	return &review, nil
+}
+
+/*
+edits the stars and text of one of the user's reviews, adding any uploaded photos
+@params review_id
+*/
+func UpdateProductReview(c *fiber.Ctx, reviewID uuid.UUID) (*ProductReview, error) {
+	review, err := ownReview(c, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	body := reviewRequest{Stars: review.Stars, Title: review.Title, Body: review.Body}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing review update:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	if err := body.validate(); err != nil {
+		return nil, err
+	}
+	photos, err := uploadReviewPhotos(c)
+	if err != nil {
+		return nil, err
+	}
+	if len(review.Photos)+len(photos) > maxReviewPhotos {
+		return nil, errors.New("a review can have at most 5 photos")
+	}
+
+	review.Stars, review.Title, review.Body = body.Stars, body.Title, body.Body
+	review.Photos = append(review.Photos, photos...)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(review).Updates(map[string]interface{}{
+			"stars":  review.Stars,
+			"title":  review.Title,
+			"body":   review.Body,
+			"photos": review.Photos,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return syncProductRating(tx, review.ProductID)
+	})
+	if err != nil {
+		log.Println("error updating review:", err.Error())
+		return nil, errors.New("failed to update review")
+	}
+	return review, nil
+}
+
+/*
+deletes one of the user's reviews; admins can delete any review
+@params review_id
+*/
+func DeleteProductReview(c *fiber.Ctx, reviewID uuid.UUID) error {
+	var review ProductReview
+	if err := db.First(&review, "id = ?", reviewID).Error; err != nil {
+		return ErrReviewNotFound
+	}
+	userID, _ := GetAuthUserID(c)
+	if review.UserID != userID && GetAuthUser(c) != "admin" {
+		return ErrNotReviewAuthor
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("review_id = ?", review.ID).Delete(&ReviewVote{}).Error; err != nil {
+			return err
+		}
+		// removed outright so the order item can be reviewed again
+		if err := tx.Unscoped().Delete(&review).Error; err != nil {
+			return err
+		}
+		return syncProductRating(tx, review.ProductID)
+	})
+	if err != nil {
+		log.Println("error deleting review:", err.Error())
+		return errors.New("failed to delete review")
+	}
+	return nil
+}
+
+/*
+sets the seller's public reply to a review of one of their products
+@params review_id
+*/
+func ReplyToProductReview(c *fiber.Ctx, reviewID uuid.UUID) (*ProductReview, error) {
+	var review ProductReview
+	if err := db.First(&review, "id = ?", reviewID).Error; err != nil {
+		return nil, ErrReviewNotFound
+	}
+	if _, err := ownedProduct(c, review.ProductID); err != nil {
+		return nil, err
+	}
+	body := struct {
+		Reply string `json:"reply"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing review reply:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	body.Reply = strings.TrimSpace(body.Reply)
+	if len([]rune(body.Reply)) > 2000 {
+		return nil, errors.New("reply must be at most 2000 characters")
+	}
+
+	// an empty reply removes it
+	var repliedAt *time.Time
+	if body.Reply != "" {
+		now := time.Now()
+		repliedAt = &now
+	}
+	err := db.Model(&review).Updates(map[string]interface{}{"seller_reply": body.Reply, "replied_at": repliedAt}).Error
+	if err != nil {
+		log.Println("error saving review reply:", err.Error())
+		return nil, errors.New("failed to save reply")
+	}
+	review.SellerReply, review.RepliedAt = body.Reply, repliedAt
+	return &review, nil
+}
+
+/*
+marks a review as helpful, once per user
+@params review_id
+*/
+func VoteReviewHelpful(c *fiber.Ctx, reviewID uuid.UUID) (*ProductReview, error) {
+	var review ProductReview
+	if err := db.First(&review, "id = ?", reviewID).Error; err != nil {
+		return nil, ErrReviewNotFound
+	}
+	userID, _ := GetAuthUserID(c)
+	if review.UserID == userID {
+		return nil, ErrOwnReviewVote
+	}
+	var existing int64
+	db.Model(&ReviewVote{}).Where("review_id = ? AND user_id = ?", review.ID, userID).Count(&existing)
+	if existing > 0 {
+		return nil, ErrAlreadyVoted
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&ReviewVote{BaseModel: BaseModel{ID: uuid.New()}, ReviewID: review.ID, UserID: userID}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&review).Update("helpful_count", gorm.Expr("helpful_count + 1")).Error
+	})
+	if err != nil {
+		log.Println("error voting on review:", err.Error())
+		return nil, ErrAlreadyVoted
+	}
+	review.HelpfulCount++
+	return &review, nil
+}
+
+/*
+takes back the user's helpful vote on a review
+@params review_id
+*/
+func RemoveReviewVote(c *fiber.Ctx, reviewID uuid.UUID) error {
+	userID, _ := GetAuthUserID(c)
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("review_id = ? AND user_id = ?", reviewID, userID).Delete(&ReviewVote{})
+		if result.Error != nil {
+			log.Println("error removing review vote:", result.Error.Error())
+			return errors.New("failed to remove vote")
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("you have not marked this review as helpful")
+		}
+		return tx.Model(&ProductReview{}).Where("id = ?", reviewID).Update("helpful_count", gorm.Expr("helpful_count - 1")).Error
+	})
+}
+
+// ReviewSummary is a product's rating with a page of its reviews
+type ReviewSummary struct {
+	AverageRating float64         `json:"average_rating"`
+	ReviewCount   int             `json:"review_count"`
+	Histogram     map[int]int     `json:"histogram"` // Number of reviews by stars
+	Reviews       []ProductReview `json:"reviews"`
+	Page          int             `json:"page"`
+	Limit         int             `json:"limit"`
+}
+
+/*
+gets a product's rating, star histogram and a page of reviews, newest or most helpful first
+@params product_id
+@params sort
+@params page
+@params limit
+*/
+func GetProductReviews(productID uuid.UUID, sort string, page, limit int) (*ReviewSummary, error) {
+	var product Product
+	if err := db.First(&product, "id = ?", productID).Error; err != nil {
+		return nil, errors.New("product not found")
+	}
+	if page < 1 {
+		page = 1
+	}
+	if limit <= 0 || limit > 50 {
+		limit = 10
+	}
+	summary := ReviewSummary{
+		AverageRating: product.AverageRating,
+		ReviewCount:   product.ReviewCount,
+		Histogram:     map[int]int{1: 0, 2: 0, 3: 0, 4: 0, 5: 0},
+		Reviews:       []ProductReview{},
+		Page:          page,
+		Limit:         limit,
+	}
+
+	var buckets []struct {
+		Stars int
+		Count int
+	}
+	if err := db.Model(&ProductReview{}).Select("stars, COUNT(*) AS count").Where("product_id = ?", productID).Group("stars").Scan(&buckets).Error; err != nil {
+		log.Println("error fetching review histogram:", err.Error())
+		return nil, errors.New("failed to fetch reviews")
+	}
+	for _, bucket := range buckets {
+		summary.Histogram[bucket.Stars] = bucket.Count
+	}
+
+	order := "created_at DESC"
+	if sort == "helpful" {
+		order = "helpful_count DESC, created_at DESC"
+	}
+	if err := db.Where("product_id = ?", productID).Order(order).Limit(limit).Offset((page - 1) * limit).Find(&summary.Reviews).Error; err != nil {
+		log.Println("error fetching reviews:", err.Error())
+		return nil, errors.New("failed to fetch reviews")
+	}
+
+	// show reviewers by first name and initial rather than exposing their accounts
+	var ids []uuid.UUID
+	for _, review := range summary.Reviews {
+		ids = append(ids, review.UserID)
+	}
+	var users []User
+	if len(ids) > 0 {
+		db.Select("id, first_name, last_name").Where("id IN ?", ids).Find(&users)
+	}
+	names := map[uuid.UUID]string{}
+	for _, user := range users {
+		name := user.FirstName
+		if initial := []rune(user.LastName); len(initial) > 0 {
+			name += " " + string(initial[0]) + "."
+		}
+		names[user.ID] = name
+	}
+	for i := range summary.Reviews {
+		summary.Reviews[i].ReviewerName = names[summary.Reviews[i].UserID]
+	}
+	return &summary, nil
+}
@@ -0,0 +1,91 @@
+package model
+
+import (
+	"log"
+	"os"
+
+	"github.com/dancankarani/palace/search"
+	"github.com/google/uuid"
+)
+
+// maximum number of ranked matches a text query considers
+const maxSearchHits = 1000
+
+/*
+productSearcher answers the text part of catalogue queries. SEARCH_BACKEND picks
+"mysql" for the FULLTEXT index or "memory" (the default) for the embedded index.
+*/
+var productSearcher = newProductSearcher()
+
+func newProductSearcher() search.Searcher {
+	if os.Getenv("SEARCH_BACKEND") == "mysql" {
+		return search.NewMySQLSearcher(db)
+	}
+	return search.NewMemorySearcher()
+}
+
+func productDocument(p Product) search.Document {
+	return search.Document{ID: p.ID, Name: p.Name, Description: p.Description, Category: p.Category}
+}
+
+/*
+re-reads a product and updates its search index entry, removing it when the product
+was deleted or is no longer active
+@params product_id
+*/
+func indexProduct(productID uuid.UUID) {
+	var product Product
+	if err := db.First(&product, "id = ? AND status = ?", productID, ProductActive).Error; err != nil {
+		if err := productSearcher.Delete(productID); err != nil {
+			log.Println("error removing product from search index:", err.Error())
+		}
+		return
+	}
+	if err := productSearcher.Index(productDocument(product)); err != nil {
+		log.Println("error indexing product:", err.Error())
+	}
+}
+
+/*
+creates the FULLTEXT index and loads every active product into the search index
+*/
+func ReindexProducts() {
+	if !db.Migrator().HasIndex(&Product{}, search.FullTextIndex) {
+		err := db.Exec("CREATE FULLTEXT INDEX " + search.FullTextIndex + " ON products (name, description, category)").Error
+		if err != nil {
+			log.Println("error creating product fulltext index:", err.Error())
+		}
+	}
+
+	var products []Product
+	if err := db.Where("status = ?", ProductActive).Find(&products).Error; err != nil {
+		log.Println("error loading products for the search index:", err.Error())
+		return
+	}
+	for _, product := range products {
+		if err := productSearcher.Index(productDocument(product)); err != nil {
+			log.Println("error indexing product:", err.Error())
+		}
+	}
+	log.Println("indexed", len(products), "products for search")
+}
+
+/*
+runs a full-text query and returns the ids of matching products by relevance with their highlights
+@params query
+*/
+func searchProductIDs(query string) ([]uuid.UUID, map[uuid.UUID]map[string]string, error) {
+	hits, err := productSearcher.Search(query, maxSearchHits)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids := make([]uuid.UUID, len(hits))
+	highlights := map[uuid.UUID]map[string]string{}
+	for i, hit := range hits {
+		ids[i] = hit.ID
+		if len(hit.Highlights) > 0 {
+			highlights[hit.ID] = hit.Highlights
+		}
+	}
+	return ids, highlights, nil
+}
@@ -0,0 +1,453 @@
+package model
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	payoutCodeLifetime    = 10 * time.Minute
+	maxPayoutCodeAttempts = 5
+	maxIDDocumentSize     = 10 << 20
+)
+
+var (
+	ErrApplicationNotFound = errors.New("seller application not found")
+	ErrApplicationState    = errors.New("the seller application cannot be changed in its current state")
+	ErrAlreadySeller       = errors.New("you are already a seller")
+	ErrIDDocumentNotFound  = errors.New("the application has no ID document")
+)
+
+type sellerApplicationRequest struct {
+	BusinessName       *string `json:"business_name" form:"business_name"`
+	BusinessType       *string `json:"business_type" form:"business_type"`
+	RegistrationNumber *string `json:"registration_number" form:"registration_number"`
+	TaxPIN             *string `json:"tax_pin" form:"tax_pin"`
+	BusinessAddress    *string `json:"business_address" form:"business_address"`
+	IDNumber           *string `json:"id_number" form:"id_number"`
+	PayoutPhone        *string `json:"payout_phone" form:"payout_phone"`
+}
+
+/*
+gets the user's latest seller application
+@params user_id
+*/
+func GetSellerApplication(userID uuid.UUID) (*SellerApplication, error) {
+	var application SellerApplication
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC").First(&application).Error; err != nil {
+		return nil, ErrApplicationNotFound
+	}
+	return &application, nil
+}
+
+// openApplication gets the user's application that is still being filled in, starting one if needed
+func openApplication(user User) (*SellerApplication, bool, error) {
+	if user.UserRole == "seller" || user.UserRole == "admin" {
+		return nil, false, ErrAlreadySeller
+	}
+	application, err := GetSellerApplication(user.ID)
+	if err == nil && application.Status == ApplicationDraft {
+		return application, false, nil
+	}
+	if err == nil && application.Status == ApplicationSubmitted {
+		return nil, false, ErrApplicationState
+	}
+	// a rejected applicant starts over with a fresh application
+	return &SellerApplication{
+		BaseModel: BaseModel{ID: uuid.New()},
+		UserID:    user.ID,
+		Status:    ApplicationDraft,
+	}, true, nil
+}
+
+/*
+saves the business details of the user's seller application. The ID document can be
+sent as the "id_document" file of a multipart form. Changing the payout number means
+it has to be verified again.
+*/
+func SaveSellerApplication(c *fiber.Ctx) (*SellerApplication, error) {
+	userID, _ := GetAuthUserID(c)
+	var user User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, errors.New("user not found")
+	}
+	application, isNew, err := openApplication(user)
+	if err != nil {
+		return nil, err
+	}
+	body := sellerApplicationRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing seller application:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+
+	set := func(field *string, value *string, max int, name string) error {
+		if value == nil {
+			return nil
+		}
+		v := strings.TrimSpace(*value)
+		if len(v) > max {
+			return errors.New(name + " is too long")
+		}
+		*field = v
+		return nil
+	}
+	for _, f := range []struct {
+		field *string
+		value *string
+		max   int
+		name  string
+	}{
+		{&application.BusinessName, body.BusinessName, 255, "business_name"},
+		{&application.RegistrationNumber, body.RegistrationNumber, 100, "registration_number"},
+		{&application.TaxPIN, body.TaxPIN, 20, "tax_pin"},
+		{&application.BusinessAddress, body.BusinessAddress, 255, "business_address"},
+		{&application.IDNumber, body.IDNumber, 50, "id_number"},
+	} {
+		if err := set(f.field, f.value, f.max, f.name); err != nil {
+			return nil, err
+		}
+	}
+	if body.BusinessType != nil {
+		switch *body.BusinessType {
+		case "individual", "company":
+			application.BusinessType = *body.BusinessType
+		default:
+			return nil, errors.New("business_type must be individual or company")
+		}
+	}
+	if body.PayoutPhone != nil {
+		phone, err := utilities.ValidatePhoneNumber(*body.PayoutPhone, "KE")
+		if err != nil {
+			return nil, err
+		}
+		if phone != application.PayoutPhone {
+			application.PayoutPhone = phone
+			application.PayoutPhoneVerifiedAt = nil
+			application.PayoutCodeHash, application.PayoutCodeExpiresAt, application.PayoutCodeAttempts = "", nil, 0
+		}
+	}
+	if file, err := c.FormFile("id_document"); err == nil {
+		data, err := utilities.ReadFormFile(file, maxIDDocumentSize)
+		if err != nil {
+			return nil, err
+		}
+		contentType := http.DetectContentType(data)
+		extensions := map[string]string{"image/jpeg": ".jpg", "image/png": ".png", "application/pdf": ".pdf"}
+		extension, ok := extensions[contentType]
+		if !ok {
+			return nil, errors.New("the ID document must be a JPEG, PNG or PDF file")
+		}
+		// identity documents are kept in the private store and only ever shown to admins
+		store := utilities.NewPrivateBlobStore()
+		key := "kyc/" + application.ID.String() + "/" + utilities.ContentHash(data) + extension
+		if _, err := store.Put(key, contentType, data); err != nil {
+			log.Println("error uploading ID document:", err.Error())
+			return nil, errors.New("failed to upload ID document")
+		}
+		if application.IDDocumentKey != "" && application.IDDocumentKey != key {
+			store.Delete(application.IDDocumentKey)
+		}
+		application.IDDocumentKey = key
+	}
+
+	if isNew {
+		err = db.Create(application).Error
+	} else {
+		err = db.Save(application).Error
+	}
+	if err != nil {
+		log.Println("error saving seller application:", err.Error())
+		return nil, errors.New("failed to save seller application")
+	}
+	return application, nil
+}
+
+/*
+texts a code to the payout number of the user's seller application
+*/
+func SendPayoutPhoneCode(c *fiber.Ctx) error {
+	userID, _ := GetAuthUserID(c)
+	application, err := GetSellerApplication(userID)
+	if err != nil {
+		return err
+	}
+	if application.Status != ApplicationDraft {
+		return ErrApplicationState
+	}
+	if application.PayoutPhone == "" {
+		return errors.New("add a payout_phone to the application first")
+	}
+	code, err := utilities.GenerateOTP(6)
+	if err != nil {
+		log.Println("error generating payout code:", err.Error())
+		return errors.New("failed to send code")
+	}
+	expires := time.Now().Add(payoutCodeLifetime)
+	err = db.Model(application).Updates(map[string]interface{}{
+		"payout_code_hash":       utilities.ContentHash([]byte(code)),
+		"payout_code_expires_at": expires,
+		"payout_code_attempts":   0,
+	}).Error
+	if err != nil {
+		log.Println("error saving payout code:", err.Error())
+		return errors.New("failed to send code")
+	}
+	message := "Your Palace payout number verification code is " + code + ". It expires in 10 minutes."
+	if err := utilities.NewSMSSender().Send(application.PayoutPhone, message); err != nil {
+		log.Println("error texting payout code:", err.Error())
+		return errors.New("failed to send code")
+	}
+	return nil
+}
+
+/*
+confirms the payout number of the user's seller application with the code texted to it
+*/
+func VerifyPayoutPhone(c *fiber.Ctx) (*SellerApplication, error) {
+	userID, _ := GetAuthUserID(c)
+	body := struct {
+		Code string `json:"code"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return nil, errors.New("error parsing request data")
+	}
+	application, err := GetSellerApplication(userID)
+	if err != nil {
+		return nil, err
+	}
+	if application.Status != ApplicationDraft {
+		return nil, ErrApplicationState
+	}
+	if application.PayoutCodeHash == "" || application.PayoutCodeExpiresAt == nil || application.PayoutCodeExpiresAt.Before(time.Now()) {
+		return nil, errors.New("the code has expired, request a new one")
+	}
+	// every guess uses up an attempt before it is checked, so guesses sent in parallel can't get past the limit
+	result := db.Model(&SellerApplication{}).
+		Where("id = ? AND payout_code_attempts < ?", application.ID, maxPayoutCodeAttempts).
+		Update("payout_code_attempts", gorm.Expr("payout_code_attempts + 1"))
+	if result.Error != nil {
+		log.Println("error verifying payout phone:", result.Error.Error())
+		return nil, errors.New("failed to verify payout number")
+	}
+	if result.RowsAffected != 1 {
+		return nil, errors.New("too many attempts, request a new code")
+	}
+	hash := utilities.ContentHash([]byte(strings.TrimSpace(body.Code)))
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(application.PayoutCodeHash)) != 1 {
+		return nil, errors.New("invalid code")
+	}
+	now := time.Now()
+	// only the code that was checked, not one sent again since
+	result = db.Model(&SellerApplication{}).
+		Where("id = ? AND payout_code_hash = ?", application.ID, hash).
+		Updates(map[string]interface{}{
+			"payout_phone_verified_at": now,
+			"payout_code_hash":         "",
+			"payout_code_expires_at":   nil,
+		})
+	if result.Error != nil {
+		log.Println("error verifying payout phone:", result.Error.Error())
+		return nil, errors.New("failed to verify payout number")
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("the code has expired, request a new one")
+	}
+	application.PayoutPhoneVerifiedAt = &now
+	return application, nil
+}
+
+/*
+sends the user's completed seller application to the admins for review
+*/
+func SubmitSellerApplication(c *fiber.Ctx) (*SellerApplication, error) {
+	userID, _ := GetAuthUserID(c)
+	application, err := GetSellerApplication(userID)
+	if err != nil {
+		return nil, err
+	}
+	if application.Status != ApplicationDraft {
+		return nil, ErrApplicationState
+	}
+	if err := RequireVerified(userID); err != nil {
+		return nil, err
+	}
+	missing := []string{}
+	for _, field := range []struct{ name, value string }{
+		{"business_name", application.BusinessName},
+		{"business_type", application.BusinessType},
+		{"business_address", application.BusinessAddress},
+		{"id_number", application.IDNumber},
+		{"id_document", application.IDDocumentKey},
+		{"payout_phone", application.PayoutPhone},
+	} {
+		if field.value == "" {
+			missing = append(missing, field.name)
+		}
+	}
+	if application.BusinessType == "company" && application.RegistrationNumber == "" {
+		missing = append(missing, "registration_number")
+	}
+	if len(missing) > 0 {
+		return nil, errors.New("the application is missing " + strings.Join(missing, ", "))
+	}
+	if application.PayoutPhoneVerifiedAt == nil {
+		return nil, errors.New("verify your payout number before submitting")
+	}
+
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&SellerApplication{}).Where("id = ? AND status = ?", application.ID, ApplicationDraft).
+			Updates(map[string]interface{}{"status": ApplicationSubmitted, "submitted_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrApplicationState
+		}
+		var admins []uuid.UUID
+		if err := tx.Model(&User{}).Where("user_role = ?", "admin").Pluck("id", &admins).Error; err != nil {
+			return err
+		}
+		for _, adminID := range admins {
+			err := notifyUser(tx, Notification{
+				UserID: adminID,
+				Kind:   "seller_application",
+				Title:  "Seller application: " + application.BusinessName,
+				Body:   application.BusinessName + " applied to sell and is waiting for review.",
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, applicationError("submitting seller application", err)
+	}
+	application.Status, application.SubmittedAt = ApplicationSubmitted, &now
+	return application, nil
+}
+
+/*
+lists seller applications for admins, oldest first so they are reviewed in order
+@params status
+*/
+func GetSellerApplications(status string) (*[]SellerApplication, error) {
+	applications := []SellerApplication{}
+	query := db.Order("submitted_at, created_at")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Find(&applications).Error; err != nil {
+		log.Println("error fetching seller applications:", err.Error())
+		return nil, errors.New("failed to fetch seller applications")
+	}
+	return &applications, nil
+}
+
+/*
+gets the ID document of a seller application from the private store, for admins
+@params application_id
+*/
+func GetSellerApplicationDocument(applicationID uuid.UUID) ([]byte, string, error) {
+	var application SellerApplication
+	if err := db.First(&application, "id = ?", applicationID).Error; err != nil {
+		return nil, "", ErrApplicationNotFound
+	}
+	if application.IDDocumentKey == "" {
+		return nil, "", ErrIDDocumentNotFound
+	}
+	data, contentType, err := utilities.NewPrivateBlobStore().Get(application.IDDocumentKey)
+	if errors.Is(err, utilities.ErrBlobNotFound) {
+		return nil, "", ErrIDDocumentNotFound
+	}
+	if err != nil {
+		return nil, "", errors.New("failed to get ID document")
+	}
+	return data, contentType, nil
+}
+
+/*
+approves or rejects a submitted seller application. Approval makes the applicant a
+verified seller paid out to the verified number; they sign in again to pick up the role.
+@params application_id
+@params approve
+*/
+func ReviewSellerApplication(c *fiber.Ctx, applicationID uuid.UUID, approve bool) (*SellerApplication, error) {
+	adminID, _ := GetAuthUserID(c)
+	body := struct {
+		Reason string `json:"reason"`
+	}{}
+	c.BodyParser(&body)
+	body.Reason = strings.TrimSpace(body.Reason)
+	if !approve && body.Reason == "" {
+		return nil, errors.New("a reason is required to reject an application")
+	}
+	if len(body.Reason) > 255 {
+		return nil, errors.New("reason must be at most 255 characters")
+	}
+	var application SellerApplication
+	if err := db.First(&application, "id = ?", applicationID).Error; err != nil {
+		return nil, ErrApplicationNotFound
+	}
+
+	now := time.Now()
+	status := ApplicationRejected
+	notification := Notification{
+		UserID:    application.UserID,
+		Kind:      "seller_application_rejected",
+		Title:     "Your seller application was not approved",
+		Body:      "Your application to sell on Palace was not approved: " + body.Reason + ". You can update it and apply again.",
+		SendEmail: true,
+	}
+	if approve {
+		status = ApplicationApproved
+		notification.Kind = "seller_application_approved"
+		notification.Title = "You are now a verified seller"
+		notification.Body = "Your application to sell on Palace was approved. Sign in again to start selling."
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&SellerApplication{}).Where("id = ? AND status = ?", application.ID, ApplicationSubmitted).
+			Updates(map[string]interface{}{"status": status, "reviewed_by": adminID, "reviewed_at": now, "rejection_reason": body.Reason})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrApplicationState
+		}
+		if approve {
+			err := tx.Model(&User{}).Where("id = ?", application.UserID).Updates(map[string]interface{}{
+				"user_role":       "seller",
+				"seller_verified": true,
+				"payout_phone":    application.PayoutPhone,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return notifyUser(tx, notification)
+	})
+	if err != nil {
+		return nil, applicationError("reviewing seller application", err)
+	}
+	application.Status, application.ReviewedBy, application.ReviewedAt, application.RejectionReason = status, &adminID, &now, body.Reason
+	return &application, nil
+}
+
+func applicationError(action string, err error) error {
+	if errors.Is(err, ErrApplicationState) {
+		return err
+	}
+	log.Println("error "+action+":", err.Error())
+	return errors.New("failed to update seller application")
+}
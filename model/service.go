@@ -22,6 +22,13 @@ func CreateService(c *fiber.Ctx) error {
 	}
 	log.Println("Authenticated user ID:", userID)
 
+	// Only verified accounts can sell
+	if err := RequireVerified(userID); err != nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
 	// Parse the request body into the Service struct
 	var service Service
 	if err := c.BodyParser(&service); err != nil {
@@ -31,12 +38,24 @@ func CreateService(c *fiber.Ctx) error {
 		})
 	}
 
+	// Link the service to its category node
+	categoryID, category, err := resolveCategory(CategoryService, service.CategoryID, service.Category)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	service.CategoryID, service.Category = categoryID, category
+
 	// Set the SellerID to the authenticated user's ID
 	service.SellerID = userID
 
 	// Generate a unique ID for the service
 	service.ID = uuid.New()
 
+	// A new service has no packages, so it starts from its own price
+	service.StartingPrice = service.Price
+
 
 
 
@@ -76,31 +95,6 @@ func GetService(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusOK).JSON(services)
 }
 
-// GetAllServicesHandler retrieves all services from the database
-func GetAllServicesHandler(c *fiber.Ctx) error {
-	// Extract query parameters for filtering
-	category := c.Query("category") // Get the category from query parameters
-
-	// Query the database for all services and preload the User details
-	var services []Service
-	query := db.Preload("User") // Preload the User details
-
-	// Apply category filter if provided
-	if category != "" {
-		query = query.Where("category = ?", category)
-	}
-
-	// Execute the query
-	if err := query.Find(&services).Error; err != nil {
-		log.Println("error fetching services:", err.Error())
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "failed to fetch services",
-		})
-	}
-
-	// Return the list of services with User details
-	return c.Status(fiber.StatusOK).JSON(services)
-}
 // UpdateServiceHandler updates an existing service
 func UpdateServiceHandler(c *fiber.Ctx) error {
 	// Get the authenticated user ID
@@ -143,8 +137,34 @@ func UpdateServiceHandler(c *fiber.Ctx) error {
 		})
 	}
 
-	// Update the service with the new data
-	if err := db.Model(&service).Updates(updateData).Error; err != nil {
+	// Resolve a new category to its node
+	categoryRef, hasID := updateData["category_id"].(string)
+	categoryName, hasName := updateData["category"].(string)
+	if hasID || hasName {
+		var categoryID *uuid.UUID
+		if id, err := uuid.Parse(categoryRef); err == nil {
+			categoryID = &id
+		}
+		resolvedID, name, err := resolveCategory(CategoryService, categoryID, categoryName)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		updateData["category_id"], updateData["category"] = resolvedID, name
+	}
+
+	// Update the service with the new data, keeping the starting price in step with its price
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&service).Updates(updateData).Error; err != nil {
+			return err
+		}
+		if err := syncServicePrice(tx, service.ID); err != nil {
+			return err
+		}
+		return tx.First(&service, "id = ?", service.ID).Error
+	})
+	if err != nil {
 		log.Println("error updating service:", err.Error())
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "failed to update service",
@@ -0,0 +1,325 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dancankarani/palace/fulfilment"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrPackageNotFound = errors.New("service package not found")
+	ErrNotServiceOwner = errors.New("you do not own this service")
+)
+
+/*
+opens a single line order for a service, paid through the usual payment flow
+@params customer_id
+@params payment_method
+@params item, the service line; its order, quantity and total are filled in
+*/
+func placeServiceOrder(tx *gorm.DB, customerID uuid.UUID, paymentMethod string, item OrderItem) (*Order, error) {
+	if paymentMethod == "" {
+		paymentMethod = "mpesa"
+	}
+	order := Order{
+		BaseModel:     BaseModel{ID: uuid.New()},
+		OrderNumber:   generateOrderNumber(),
+		UserID:        customerID,
+		TotalAmount:   item.Price,
+		AmountPaid:    Money{Currency: item.Price.CurrencyCode()},
+		PaymentStatus: PaymentPending,
+		PaymentMethod: paymentMethod,
+		OrderStatus:   OrderProcessing,
+	}
+	if err := tx.Create(&order).Error; err != nil {
+		return nil, err
+	}
+	item.ID = uuid.New()
+	item.OrderID = order.ID
+	item.Quantity = 1
+	item.TotalPrice = item.Price
+	if err := tx.Create(&item).Error; err != nil {
+		return nil, err
+	}
+	order.Items = []OrderItem{item}
+	return &order, nil
+}
+
+// ownedService loads a service of the authenticated seller, or any service for an admin
+func ownedService(c *fiber.Ctx, serviceID uuid.UUID) (*Service, error) {
+	var service Service
+	if err := db.First(&service, "id = ?", serviceID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrServiceNotFound
+		}
+		log.Println("error finding service:", err.Error())
+		return nil, errors.New("failed to find service")
+	}
+	userID, _ := GetAuthUserID(c)
+	if service.SellerID != userID && GetAuthUser(c) != "admin" {
+		return nil, ErrNotServiceOwner
+	}
+	return &service, nil
+}
+
+/*
+inServiceCurrency returns a price for a service in the currency of the service's own price.
+Amounts sent without a currency are read in the default one and are taken as the service's.
+@params service
+@params price
+*/
+func inServiceCurrency(service Service, price Money) (Money, error) {
+	currency := service.Price.CurrencyCode()
+	if code := price.CurrencyCode(); code != currency && code != DefaultCurrency() {
+		return Money{}, fmt.Errorf("price must be in %s, the currency of the service", currency)
+	}
+	price.Currency = currency
+	return price, nil
+}
+
+// syncServicePrice sets the starting price listings show to the cheapest package, or to the
+// service's own price once it has no packages. Service.Price itself is left alone.
+func syncServicePrice(tx *gorm.DB, serviceID uuid.UUID) error {
+	var service Service
+	if err := tx.First(&service, "id = ?", serviceID).Error; err != nil {
+		return err
+	}
+	starting := service.Price
+	var cheapest ServicePackage
+	err := tx.Where("service_id = ?", serviceID).Order("price_minor").First(&cheapest).Error
+	if err == nil {
+		starting = cheapest.Price
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return tx.Model(&Service{}).Where("id = ?", serviceID).Updates(map[string]interface{}{
+		"starting_price_minor":    starting.Minor,
+		"starting_price_currency": starting.CurrencyCode(),
+	}).Error
+}
+
+/*
+gets the packages of a service, cheapest first
+@params service_id
+*/
+func GetServicePackages(serviceID uuid.UUID) (*[]ServicePackage, error) {
+	packages := []ServicePackage{}
+	if err := db.Where("service_id = ?", serviceID).Order("price_minor").Find(&packages).Error; err != nil {
+		log.Println("error fetching service packages:", err.Error())
+		return nil, errors.New("failed to fetch service packages")
+	}
+	return &packages, nil
+}
+
+/*
+adds or replaces the basic, standard or premium package of one of the seller's services
+@params service_id
+@params tier
+*/
+func SaveServicePackage(c *fiber.Ctx, serviceID uuid.UUID, tier PackageTier) (*ServicePackage, error) {
+	service, err := ownedService(c, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	switch tier {
+	case PackageBasic, PackageStandard, PackagePremium:
+	default:
+		return nil, errors.New("tier must be basic, standard or premium")
+	}
+	body := ServicePackage{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing service package:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		body.Name = strings.ToUpper(string(tier[:1])) + string(tier[1:])
+	}
+	if !body.Price.IsPositive() {
+		return nil, errors.New("price must be greater than 0")
+	}
+	if body.Price, err = inServiceCurrency(*service, body.Price); err != nil {
+		return nil, err
+	}
+	if body.DeliveryDays <= 0 {
+		return nil, errors.New("delivery_days must be at least 1")
+	}
+	if body.Revisions < 0 {
+		return nil, errors.New("revisions cannot be negative")
+	}
+
+	var pkg ServicePackage
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("service_id = ? AND tier = ?", service.ID, tier).First(&pkg).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			pkg = ServicePackage{BaseModel: BaseModel{ID: uuid.New()}, ServiceID: service.ID, Tier: tier}
+		}
+		pkg.Name, pkg.Description, pkg.Deliverables = body.Name, body.Description, body.Deliverables
+		pkg.DeliveryDays, pkg.Revisions, pkg.Price = body.DeliveryDays, body.Revisions, body.Price
+		if err := tx.Save(&pkg).Error; err != nil {
+			return err
+		}
+		return syncServicePrice(tx, service.ID)
+	})
+	if err != nil {
+		log.Println("error saving service package:", err.Error())
+		return nil, errors.New("failed to save service package")
+	}
+	return &pkg, nil
+}
+
+/*
+removes a package of one of the seller's services
+@params service_id
+@params tier
+*/
+func DeleteServicePackage(c *fiber.Ctx, serviceID uuid.UUID, tier PackageTier) error {
+	service, err := ownedService(c, serviceID)
+	if err != nil {
+		return err
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Where("service_id = ? AND tier = ?", service.ID, tier).Delete(&ServicePackage{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrPackageNotFound
+		}
+		return syncServicePrice(tx, service.ID)
+	})
+	if err != nil {
+		if errors.Is(err, ErrPackageNotFound) {
+			return err
+		}
+		log.Println("error deleting service package:", err.Error())
+		return errors.New("failed to delete service package")
+	}
+	return nil
+}
+
+/*
+orders a service package for the authenticated customer
+@params package_id
+*/
+func OrderServicePackage(c *fiber.Ctx, packageID uuid.UUID) (*Order, error) {
+	customerID, _ := GetAuthUserID(c)
+	body := struct {
+		PaymentMethod string `json:"payment_method"`
+	}{}
+	c.BodyParser(&body)
+
+	var pkg ServicePackage
+	if err := db.First(&pkg, "id = ?", packageID).Error; err != nil {
+		return nil, ErrPackageNotFound
+	}
+	var service Service
+	if err := db.First(&service, "id = ? AND is_active = ?", pkg.ServiceID, true).Error; err != nil {
+		return nil, ErrServiceNotFound
+	}
+	if service.SellerID == customerID {
+		return nil, errors.New("you cannot order your own service")
+	}
+	if err := RequireVerified(customerID); err != nil {
+		return nil, err
+	}
+
+	var order *Order
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		order, err = placeServiceOrder(tx, customerID, body.PaymentMethod, OrderItem{
+			ServiceID: &service.ID,
+			PackageID: &pkg.ID,
+			Price:     pkg.Price,
+		})
+		if err != nil {
+			return err
+		}
+		return notifyUser(tx, Notification{
+			UserID:    service.SellerID,
+			Kind:      "package_ordered",
+			Title:     "New order: " + service.Name,
+			Body:      fmt.Sprintf("The %s package of %s was ordered (order %s). It is due %d days after payment.", pkg.Name, service.Name, order.OrderNumber, pkg.DeliveryDays),
+			SendEmail: true,
+		})
+	})
+	if err != nil {
+		log.Println("error ordering service package:", err.Error())
+		return nil, errors.New("failed to place order")
+	}
+	return order, nil
+}
+
+/*
+marks a paid order for one of the seller's packages or custom offers as delivered by the
+seller. The buyer then confirms it, or it is confirmed for them after OrderConfirmWindow,
+which releases the seller's payout. Booked appointments are completed through the booking.
+@params order_id
+*/
+func DeliverServiceOrder(c *fiber.Ctx, orderID uuid.UUID) (*Order, error) {
+	sellerID, _ := GetAuthUserID(c)
+	var order Order
+	if err := db.Preload("Items.Service").First(&order, "id = ?", orderID).Error; err != nil {
+		return nil, errors.New("order not found")
+	}
+	for _, item := range order.Items {
+		if item.Service == nil || item.BookingID != nil || (item.Service.SellerID != sellerID && GetAuthUser(c) != "admin") {
+			return nil, errors.New("order not found")
+		}
+	}
+	if len(order.Items) == 0 {
+		return nil, errors.New("order not found")
+	}
+	if order.PaymentStatus != PaymentPaid {
+		return nil, errors.New("the order has to be paid before it is delivered")
+	}
+	next, err := fulfilment.Next(order.OrderStatus, fulfilment.Ship)
+	if err != nil {
+		return nil, errors.New("only orders that are still processing can be delivered")
+	}
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Order{}).Where("id = ? AND order_status = ?", order.ID, OrderProcessing).
+			Updates(map[string]interface{}{"order_status": next, "shipped_at": now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOrderNotShippable
+		}
+		if err := tx.Model(&OrderItem{}).Where("order_id = ?", order.ID).Update("shipped_at", now).Error; err != nil {
+			return err
+		}
+		return notifyServiceDelivered(tx, order, now)
+	})
+	if errors.Is(err, ErrOrderNotShippable) {
+		return nil, errors.New("only orders that are still processing can be delivered")
+	}
+	if err != nil {
+		log.Println("error delivering service order:", err.Error())
+		return nil, errors.New("failed to update order")
+	}
+	order.OrderStatus, order.ShippedAt = next, &now
+	return &order, nil
+}
+
+// notifyServiceDelivered asks the buyer of a delivered service order to confirm it
+func notifyServiceDelivered(tx *gorm.DB, order Order, deliveredAt time.Time) error {
+	return notifyUser(tx, Notification{
+		UserID:    order.UserID,
+		Kind:      "order_shipped",
+		Title:     "Order " + order.OrderNumber + " delivered",
+		Body:      fmt.Sprintf("The seller has delivered your order %s. Confirm you received it by %s, after which it is confirmed for you.", order.OrderNumber, deliveredAt.Add(OrderConfirmWindow()).Format("2 Jan 2006")),
+		SendEmail: true,
+	})
+}
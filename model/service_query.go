@@ -0,0 +1,234 @@
+package model
+
+import (
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultServicePageSize = 20
+	maxServicePageSize     = 100
+)
+
+// ServiceQuery holds the filters, sort order and page of a public service search
+type ServiceQuery struct {
+	Query     string    `query:"q"`
+	Category  string    `query:"category"` // id, slug or name, includes subcategories
+	SellerID  uuid.UUID `query:"seller_id"`
+	MinPrice  Money     `query:"min_price"`
+	MaxPrice  Money     `query:"max_price"`
+	MinRating float64   `query:"min_rating"` // average seller rating, 1 to 5
+	City      string    `query:"city"`
+	Location  string    `query:"location"`
+	Sort      string    `query:"sort"` // newest, price_asc, price_desc, rating
+	Page      int       `query:"page"`
+	Limit     int       `query:"limit"`
+}
+
+// PublicSeller is what buyers see of a seller, without contact or account details
+type PublicSeller struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"` // First name and last initial
+	City        string    `json:"city"`
+	Location    string    `json:"location"`
+	Rating      float64   `json:"rating"`
+	RatingCount int       `json:"rating_count"`
+	MemberSince time.Time `json:"member_since"`
+	Verified    bool      `json:"verified"` // Approved through a seller application
+}
+
+// ServiceListing is a service as shown in public search results
+type ServiceListing struct {
+	ID              uuid.UUID        `json:"id"`
+	Name            string           `json:"name"`
+	Description     string           `json:"description"`
+	Price           Money            `json:"price"`          // What a booking is charged
+	StartingPrice   Money            `json:"starting_price"` // Cheapest package, or Price without packages
+	Category        string           `json:"category"`
+	CategoryID      *uuid.UUID       `json:"category_id"`
+	DurationMinutes int              `json:"duration_minutes"`
+	Packages        []ServicePackage `json:"packages"`
+	CreatedAt       time.Time        `json:"created_at"`
+	Seller          PublicSeller     `json:"seller"`
+}
+
+type ServicePage struct {
+	Services []ServiceListing `json:"services"`
+	Total    int64            `json:"total"`
+	Page     int              `json:"page"`
+	Pages    int              `json:"pages"`
+}
+
+var serviceSorts = map[string]string{
+	"newest":     "services.created_at DESC",
+	"price_asc":  "services.starting_price_minor ASC",
+	"price_desc": "services.starting_price_minor DESC",
+	"rating":     "seller_rating DESC",
+}
+
+// the average seller rating, 0 for sellers nobody has rated yet
+const sellerRatingColumn = "COALESCE(seller_rating_summaries.total / NULLIF(seller_rating_summaries.count, 0), 0)"
+
+// publicSeller trims a user down to the profile buyers see
+func publicSeller(user User, summary SellerRatingSummary) PublicSeller {
+	name := strings.TrimSpace(user.FirstName)
+	if last := strings.TrimSpace(user.LastName); last != "" {
+		name += " " + strings.ToUpper(string([]rune(last)[:1])) + "."
+	}
+	seller := PublicSeller{
+		ID:          user.ID,
+		Name:        name,
+		City:        user.City,
+		Location:    user.Location,
+		RatingCount: summary.Count,
+		MemberSince: user.CreatedAt,
+		Verified:    user.SellerVerified,
+	}
+	if summary.Count > 0 {
+		seller.Rating = math.Round(float64(summary.Total)/float64(summary.Count)*100) / 100
+	}
+	return seller
+}
+
+// likePattern matches text anywhere in a lower-cased column, escaping the wildcards
+// in it with MySQL's default LIKE escape character so they match themselves
+func likePattern(text string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(strings.TrimSpace(text)))
+	return "%" + escaped + "%"
+}
+
+/*
+searches the active services of active sellers by text, category, price, seller rating and location
+@params query
+*/
+func SearchServices(q ServiceQuery) (*ServicePage, error) {
+	q.Query = strings.TrimSpace(q.Query)
+	if q.Sort == "" {
+		q.Sort = "newest"
+	}
+	order, ok := serviceSorts[q.Sort]
+	if !ok {
+		return nil, errors.New("invalid sort, use one of newest, price_asc, price_desc, rating")
+	}
+	if q.MinRating < 0 || q.MinRating > 5 {
+		return nil, errors.New("min_rating must be between 0 and 5")
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultServicePageSize
+	}
+	if q.Limit > maxServicePageSize {
+		q.Limit = maxServicePageSize
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+
+	query := db.Model(&Service{}).
+		Joins("JOIN users ON users.id = services.seller_id AND users.deleted_at IS NULL").
+		Joins("LEFT JOIN seller_rating_summaries ON seller_rating_summaries.seller_id = services.seller_id").
+		Where("services.is_active = ? AND users.is_active = ?", true, true)
+	if q.Query != "" {
+		like := likePattern(q.Query)
+		query = query.Where("(LOWER(services.name) LIKE ? OR LOWER(services.description) LIKE ? OR LOWER(services.category) LIKE ?)", like, like, like)
+	}
+	if q.Category != "" {
+		categoryIDs, err := CategoryWithDescendants(CategoryService, q.Category)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("services.category_id IN ?", categoryIDs)
+	}
+	if q.SellerID != uuid.Nil {
+		query = query.Where("services.seller_id = ?", q.SellerID)
+	}
+	if q.MinPrice.IsPositive() {
+		query = query.Where("services.starting_price_minor >= ?", q.MinPrice.Minor)
+	}
+	if q.MaxPrice.IsPositive() {
+		query = query.Where("services.starting_price_minor <= ?", q.MaxPrice.Minor)
+	}
+	if q.MinRating > 0 {
+		query = query.Where(sellerRatingColumn+" >= ?", q.MinRating)
+	}
+	if q.City != "" {
+		query = query.Where("LOWER(users.city) = ?", strings.ToLower(strings.TrimSpace(q.City)))
+	}
+	if q.Location != "" {
+		query = query.Where("LOWER(users.location) LIKE ?", likePattern(q.Location))
+	}
+
+	page := ServicePage{Services: []ServiceListing{}, Page: q.Page}
+	if err := query.Session(&gorm.Session{}).Count(&page.Total).Error; err != nil {
+		log.Println("error counting services:", err.Error())
+		return nil, errors.New("failed to search services")
+	}
+	page.Pages = int((page.Total + int64(q.Limit) - 1) / int64(q.Limit))
+
+	var services []Service
+	err := query.Select("services.*, "+sellerRatingColumn+" AS seller_rating").
+		Preload("Packages", func(db *gorm.DB) *gorm.DB { return db.Order("price_minor") }).
+		Preload("User").
+		Order(order).Order("services.id").
+		Offset((q.Page - 1) * q.Limit).Limit(q.Limit).
+		Find(&services).Error
+	if err != nil {
+		log.Println("error searching services:", err.Error())
+		return nil, errors.New("failed to search services")
+	}
+
+	sellerIDs := make([]uuid.UUID, 0, len(services))
+	for _, service := range services {
+		sellerIDs = append(sellerIDs, service.SellerID)
+	}
+	var summaries []SellerRatingSummary
+	if len(sellerIDs) > 0 {
+		db.Where("seller_id IN ?", sellerIDs).Find(&summaries)
+	}
+	bySeller := make(map[uuid.UUID]SellerRatingSummary, len(summaries))
+	for _, summary := range summaries {
+		bySeller[summary.SellerID] = summary
+	}
+
+	for _, service := range services {
+		page.Services = append(page.Services, ServiceListing{
+			ID:              service.ID,
+			Name:            service.Name,
+			Description:     service.Description,
+			Price:           service.Price,
+			StartingPrice:   service.StartingPrice,
+			Category:        service.Category,
+			CategoryID:      service.CategoryID,
+			DurationMinutes: service.DurationMinutes,
+			Packages:        service.Packages,
+			CreatedAt:       service.CreatedAt,
+			Seller:          publicSeller(service.User, bySeller[service.SellerID]),
+		})
+	}
+	return &page, nil
+}
+
+/*
+returns every service matching the filters, ignoring the page, for callers of the old
+unpaginated listing
+@params query
+*/
+func AllServices(q ServiceQuery) ([]ServiceListing, error) {
+	services := []ServiceListing{}
+	q.Limit = maxServicePageSize
+	for q.Page = 1; ; q.Page++ {
+		page, err := SearchServices(q)
+		if err != nil {
+			return nil, err
+		}
+		services = append(services, page.Services...)
+		if q.Page >= page.Pages {
+			return services, nil
+		}
+	}
+}
@@ -24,8 +24,8 @@ func AddCart(c *fiber.Ctx, productID uuid.UUID) (*CartItem, error) {
     }
 
     // Validate cart item fields
-    if cartItem.Quantity <= 0 || cartItem.Price <= 0 {
-        return nil, fiber.NewError(fiber.StatusBadRequest, "quantity and price must be greater than 0")
+    if cartItem.Quantity <= 0 {
+        return nil, fiber.NewError(fiber.StatusBadRequest, "quantity must be greater than 0")
     }
 
     // Start a database transaction
@@ -44,7 +44,7 @@ func AddCart(c *fiber.Ctx, productID uuid.UUID) (*CartItem, error) {
             cart = Cart{
                 BaseModel:   BaseModel{ID: uuid.New()},
                 UserID:      userID,
-                TotalAmount: 0, // Initialize to 0
+                TotalAmount: NewMoney(0), // Initialize to 0
             }
             if err := tx.Create(&cart).Error; err != nil {
                 log.Println("Error creating new cart:", err.Error())
@@ -57,14 +57,38 @@ func AddCart(c *fiber.Ctx, productID uuid.UUID) (*CartItem, error) {
         }
     }
 
+    // Price the item from the product or the chosen size/colour
+    var product Product
+    if err := tx.First(&product, "id = ?", productID).Error; err != nil {
+        tx.Rollback()
+        return nil, fiber.NewError(fiber.StatusNotFound, "product not found")
+    }
+    if product.Status != ProductActive {
+        tx.Rollback()
+        return nil, fiber.NewError(fiber.StatusBadRequest, ErrProductUnavailable.Error())
+    }
+    variant, price, err := resolveVariant(tx, product, cartItem.VariantID)
+    if err != nil {
+        tx.Rollback()
+        return nil, fiber.NewError(fiber.StatusBadRequest, err.Error())
+    }
+    if variant != nil && variant.Stock < cartItem.Quantity {
+        tx.Rollback()
+        return nil, fiber.NewError(fiber.StatusBadRequest, ErrInsufficientStock.Error())
+    }
+
     // Create the cart item
     cartItem = CartItem{
         BaseModel:  BaseModel{ID: uuid.New()},
         CartID:     cart.ID,
         ProductID:  productID,
+        VariantID:  cartItem.VariantID,
         Quantity:   cartItem.Quantity,
-        Price:      cartItem.Price,
-        TotalPrice: float64(cartItem.Quantity) * cartItem.Price,
+        Price:      price,
+        TotalPrice: price.Mul(int64(cartItem.Quantity)),
+    }
+    if variant == nil {
+        cartItem.VariantID = nil
     }
 
     // Add the cart item to the database
@@ -74,8 +98,15 @@ func AddCart(c *fiber.Ctx, productID uuid.UUID) (*CartItem, error) {
         return nil, fiber.NewError(fiber.StatusInternalServerError, "failed to add cart item")
     }
 
-    // Update cart total amount
-    cart.TotalAmount += cartItem.TotalPrice
+    // Update cart total amount, an empty cart taking the currency of its first item
+    if cart.TotalAmount.IsZero() {
+        cart.TotalAmount.Currency = cartItem.TotalPrice.CurrencyCode()
+    }
+    cart.TotalAmount, err = cart.TotalAmount.Add(cartItem.TotalPrice)
+    if err != nil {
+        tx.Rollback()
+        return nil, fiber.NewError(fiber.StatusBadRequest, "the product is priced in a different currency from your cart")
+    }
     if err := tx.Save(&cart).Error; err != nil {
         log.Println("Error updating cart total amount:", err.Error())
         tx.Rollback()
@@ -106,6 +137,7 @@ func GetCartItems(c *fiber.Ctx) error {
 	// Query cart with items and preload product details
 	var cart Cart
 	result := db.Preload("Items.Product").
+		Preload("Items.Variant").
 		Where("user_id = ?", userID).
 		First(&cart)
 
@@ -120,7 +152,7 @@ func GetCartItems(c *fiber.Ctx) error {
 
 	// Calculate totals for response (without saving to DB)
 	for i := range cart.Items {
-		cart.Items[i].TotalPrice = cart.Items[i].Price * float64(cart.Items[i].Quantity)
+		cart.Items[i].TotalPrice = cart.Items[i].Price.Mul(int64(cart.Items[i].Quantity))
 	}
 
 	return c.Status(fiber.StatusOK).JSON(cart)
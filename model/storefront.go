@@ -0,0 +1,272 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSellerNotFound = errors.New("seller not found")
+	ErrShopSlugTaken  = errors.New("this shop address is already taken")
+)
+
+// Storefront is a seller's public shop page
+type Storefront struct {
+	SellerID      uuid.UUID     `json:"seller_id"`
+	Slug          string        `json:"slug"`
+	DisplayName   string        `json:"display_name"`
+	Bio           string        `json:"bio"`
+	BannerURL     string        `json:"banner_url"`
+	City          string        `json:"city"`
+	Location      string        `json:"location"`
+	MemberSince   time.Time     `json:"member_since"`
+	Verified      bool          `json:"verified"`
+	Rating        *SellerRating `json:"rating"`
+	FollowerCount int64         `json:"follower_count"`
+	Products      *ProductPage  `json:"products"`
+	Services      *ServicePage  `json:"services"`
+}
+
+// StorefrontQuery pages through the products and services of a storefront
+type StorefrontQuery struct {
+	ProductCursor string `query:"products_cursor"`
+	ServicePage   int    `query:"services_page"`
+	Limit         int    `query:"limit"`
+}
+
+/*
+finds an active seller by id or shop slug
+@params ref
+*/
+func findSeller(ref string) (*User, error) {
+	var seller User
+	query := db.Where("user_role = ? AND is_active = ?", "seller", true)
+	if id, err := uuid.Parse(ref); err == nil {
+		query = query.Where("id = ?", id)
+	} else {
+		query = query.Where("id = (?)", db.Model(&ShopProfile{}).Select("seller_id").Where("slug = ?", strings.ToLower(ref)))
+	}
+	if err := query.First(&seller).Error; err != nil {
+		return nil, ErrSellerNotFound
+	}
+	return &seller, nil
+}
+
+/*
+gets the public storefront of a seller with a page of their products and services
+@params ref, the seller's id or shop slug
+@params query
+*/
+func GetStorefront(ref string, q StorefrontQuery) (*Storefront, error) {
+	seller, err := findSeller(ref)
+	if err != nil {
+		return nil, err
+	}
+	var profile ShopProfile
+	if err := db.Where("seller_id = ?", seller.ID).Limit(1).Find(&profile).Error; err != nil {
+		log.Println("error fetching shop profile:", err.Error())
+		return nil, errors.New("failed to fetch storefront")
+	}
+	var summary SellerRatingSummary
+	db.Where("seller_id = ?", seller.ID).Limit(1).Find(&summary)
+
+	storefront := Storefront{
+		SellerID:    seller.ID,
+		Slug:        profile.Slug,
+		DisplayName: profile.DisplayName,
+		Bio:         profile.Bio,
+		BannerURL:   profile.BannerURL,
+		City:        seller.City,
+		Location:    seller.Location,
+		MemberSince: seller.CreatedAt,
+		Verified:    seller.SellerVerified,
+	}
+	if storefront.DisplayName == "" {
+		storefront.DisplayName = publicSeller(*seller, summary).Name
+	}
+	if storefront.Rating, err = GetSellerRating(seller.ID); err != nil {
+		return nil, err
+	}
+	if err := db.Model(&ShopFollower{}).Where("seller_id = ?", seller.ID).Count(&storefront.FollowerCount).Error; err != nil {
+		log.Println("error counting shop followers:", err.Error())
+		return nil, errors.New("failed to fetch storefront")
+	}
+	if storefront.Products, err = QueryProducts(ProductQuery{SellerID: seller.ID, Cursor: q.ProductCursor, Limit: q.Limit}); err != nil {
+		return nil, err
+	}
+	if storefront.Services, err = SearchServices(ServiceQuery{SellerID: seller.ID, Page: q.ServicePage, Limit: q.Limit}); err != nil {
+		return nil, err
+	}
+	return &storefront, nil
+}
+
+/*
+returns a shop slug no other seller uses, adding a number when the plain slug is taken
+@params slug
+@params seller_id
+*/
+func uniqueShopSlug(tx *gorm.DB, slug string, sellerID uuid.UUID) (string, error) {
+	if slug == "" {
+		slug = "shop"
+	}
+	for i := 1; i < 100; i++ {
+		candidate := slug
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-%d", slug, i)
+		}
+		var count int64
+		if err := tx.Model(&ShopProfile{}).Where("slug = ? AND seller_id <> ?", candidate, sellerID).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("could not find a free shop address")
+}
+
+type shopProfileRequest struct {
+	Slug        *string `json:"slug" form:"slug"`
+	DisplayName *string `json:"display_name" form:"display_name"`
+	Bio         *string `json:"bio" form:"bio"`
+}
+
+/*
+updates the authenticated seller's shop profile. A banner image can be sent as the
+"banner" file of a multipart form.
+*/
+func UpdateShopProfile(c *fiber.Ctx) (*ShopProfile, error) {
+	sellerID, _ := GetAuthUserID(c)
+	var seller User
+	if err := db.First(&seller, "id = ?", sellerID).Error; err != nil {
+		return nil, ErrSellerNotFound
+	}
+	body := shopProfileRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing shop profile:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+
+	var profile ShopProfile
+	if err := db.Where("seller_id = ?", sellerID).Limit(1).Find(&profile).Error; err != nil {
+		log.Println("error fetching shop profile:", err.Error())
+		return nil, errors.New("failed to update shop profile")
+	}
+	isNew := profile.SellerID == uuid.Nil
+	profile.SellerID = sellerID
+
+	if body.DisplayName != nil {
+		profile.DisplayName = strings.TrimSpace(*body.DisplayName)
+		if len(profile.DisplayName) > 100 {
+			return nil, errors.New("display_name must be at most 100 characters")
+		}
+	}
+	if body.Bio != nil {
+		profile.Bio = strings.TrimSpace(*body.Bio)
+		if len(profile.Bio) > 2000 {
+			return nil, errors.New("bio must be at most 2000 characters")
+		}
+	}
+	if body.Slug != nil {
+		slug := Slugify(*body.Slug)
+		if len(slug) < 3 || len(slug) > 60 {
+			return nil, errors.New("the shop address must be 3 to 60 letters, digits or hyphens")
+		}
+		// ids and slugs share the storefront path
+		if _, err := uuid.Parse(slug); err == nil {
+			return nil, errors.New("the shop address cannot look like an id")
+		}
+		var taken int64
+		db.Model(&ShopProfile{}).Where("slug = ? AND seller_id <> ?", slug, sellerID).Count(&taken)
+		if taken > 0 {
+			return nil, ErrShopSlugTaken
+		}
+		profile.Slug = slug
+	}
+	if file, err := c.FormFile("banner"); err == nil {
+		data, err := utilities.ReadFormFile(file, utilities.MaxImageSize())
+		if err != nil {
+			return nil, err
+		}
+		processed, err := utilities.ProcessImage(data)
+		if err != nil {
+			return nil, err
+		}
+		url, err := utilities.NewBlobStore().Put("shops/"+processed.Hash+".jpg", "image/jpeg", processed.Thumbnails["large"])
+		if err != nil {
+			log.Println("error uploading shop banner:", err.Error())
+			return nil, errors.New("failed to upload banner")
+		}
+		profile.BannerURL = url
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if profile.Slug == "" {
+			name := profile.DisplayName
+			if name == "" {
+				name = seller.FirstName + " " + seller.LastName
+			}
+			slug, err := uniqueShopSlug(tx, Slugify(name), sellerID)
+			if err != nil {
+				return err
+			}
+			profile.Slug = slug
+		}
+		if isNew {
+			return tx.Create(&profile).Error
+		}
+		return tx.Save(&profile).Error
+	})
+	if err != nil {
+		log.Println("error saving shop profile:", err.Error())
+		return nil, errors.New("failed to update shop profile")
+	}
+	return &profile, nil
+}
+
+/*
+follows a seller's shop for the authenticated user
+@params seller_ref, the seller's id or shop slug
+*/
+func FollowShop(c *fiber.Ctx, ref string) error {
+	userID, _ := GetAuthUserID(c)
+	seller, err := findSeller(ref)
+	if err != nil {
+		return err
+	}
+	if seller.ID == userID {
+		return errors.New("you cannot follow your own shop")
+	}
+	follower := ShopFollower{SellerID: seller.ID, UserID: userID}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&follower).Error; err != nil {
+		log.Println("error following shop:", err.Error())
+		return errors.New("failed to follow shop")
+	}
+	return nil
+}
+
+/*
+stops the authenticated user following a seller's shop
+@params seller_ref, the seller's id or shop slug
+*/
+func UnfollowShop(c *fiber.Ctx, ref string) error {
+	userID, _ := GetAuthUserID(c)
+	seller, err := findSeller(ref)
+	if err != nil {
+		return err
+	}
+	if err := db.Where("seller_id = ? AND user_id = ?", seller.ID, userID).Delete(&ShopFollower{}).Error; err != nil {
+		log.Println("error unfollowing shop:", err.Error())
+		return errors.New("failed to unfollow shop")
+	}
+	return nil
+}
@@ -0,0 +1,428 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/dancankarani/palace/database"
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	twoFactorIssuer   = "Palace"
+	recoveryCodeCount = 10
+)
+
+// roles an admin can require two-factor authentication for
+var twoFactorRoles = []string{"admin", "seller"}
+
+var (
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already on")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not on")
+	ErrTwoFactorNotStarted  = errors.New("start two-factor setup first")
+	ErrTwoFactorRequired    = errors.New("two-factor authentication is required for your account")
+	ErrInvalidTwoFactorCode = errors.New("the code is invalid")
+)
+
+// TwoFactorSetup is what an authenticator app needs to start producing codes
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"` // otpauth:// URI to show as a QR code
+}
+
+type twoFactorCodeRequest struct {
+	Code     string `json:"code"` // from the authenticator app, or a recovery code
+	Password string `json:"password"`
+}
+
+// TwoFactorRequired reports whether an admin requires two-factor authentication for a role
+func TwoFactorRequired(role string) bool {
+	var policy TwoFactorPolicy
+	if err := db.First(&policy, "role = ?", role).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.Println("error finding two-factor policy:", err.Error())
+		}
+		return false
+	}
+	return policy.Required
+}
+
+func authUser(c *fiber.Ctx) (*User, error) {
+	userID, err := GetAuthUserID(c)
+	if err != nil {
+		return nil, err
+	}
+	var user User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, errors.New("user not found")
+	}
+	return &user, nil
+}
+
+/*
+starts two-factor setup with a new authenticator secret. Nothing changes for sign-in until
+the first code from the app is confirmed with EnableTwoFactor.
+*/
+func BeginTwoFactorSetup(c *fiber.Ctx) (*TwoFactorSetup, error) {
+	user, err := authUser(c)
+	if err != nil {
+		return nil, err
+	}
+	if user.TwoFactorEnabledAt != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+	secret, err := utilities.GenerateTOTPSecret()
+	if err != nil {
+		log.Println("error generating totp secret:", err.Error())
+		return nil, errors.New("failed to start two-factor setup")
+	}
+	if err := db.Model(user).Update("totp_secret", secret).Error; err != nil {
+		log.Println("error saving totp secret:", err.Error())
+		return nil, errors.New("failed to start two-factor setup")
+	}
+	account := user.Email
+	if account == "" {
+		account = user.PhoneNumber
+	}
+	return &TwoFactorSetup{Secret: secret, URI: utilities.TOTPProvisioningURI(twoFactorIssuer, account, secret)}, nil
+}
+
+/*
+turns on two-factor authentication once a code from the authenticator app checks out,
+returning the recovery codes. They are only ever shown here.
+*/
+func EnableTwoFactor(c *fiber.Ctx) ([]string, error) {
+	user, err := authUser(c)
+	if err != nil {
+		return nil, err
+	}
+	body := twoFactorCodeRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		return nil, errors.New("error parsing request data")
+	}
+	if user.TwoFactorEnabledAt != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotStarted
+	}
+	step, ok := utilities.ValidateTOTP(user.TOTPSecret, body.Code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	var codes []string
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).
+			Where("id = ? AND two_factor_enabled_at IS NULL", user.ID).
+			Updates(map[string]interface{}{"two_factor_enabled_at": time.Now(), "totp_last_step": step})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrTwoFactorEnabled
+		}
+		codes, err = newRecoveryCodes(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		return notifyUser(tx, Notification{
+			UserID:    user.ID,
+			Kind:      "two_factor_enabled",
+			Title:     "Two-factor authentication turned on",
+			Body:      "Signing in to your account now needs a code from your authenticator app. Keep your recovery codes somewhere safe.",
+			SendEmail: true,
+		})
+	})
+	if err != nil {
+		return nil, twoFactorError("enabling two-factor authentication", err)
+	}
+	return codes, nil
+}
+
+/*
+turns off two-factor authentication. Needs the password and a current code, and is refused
+while two-factor authentication is required for the user's role.
+*/
+func DisableTwoFactor(c *fiber.Ctx) error {
+	user, err := authUser(c)
+	if err != nil {
+		return err
+	}
+	body := twoFactorCodeRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		return errors.New("error parsing request data")
+	}
+	if user.TwoFactorEnabledAt == nil {
+		return ErrTwoFactorNotEnabled
+	}
+	if TwoFactorRequired(user.UserRole) {
+		return ErrTwoFactorRequired
+	}
+
+	err = confirmTwoFactorChange(user, body, func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", user.ID).
+			Updates(map[string]interface{}{"two_factor_enabled_at": nil, "totp_secret": "", "totp_last_step": 0}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return notifyUser(tx, Notification{
+			UserID:    user.ID,
+			Kind:      "two_factor_disabled",
+			Title:     "Two-factor authentication turned off",
+			Body:      "Signing in to your account only needs your password now. If this was not you, reset your password.",
+			SendEmail: true,
+		})
+	})
+	if err != nil {
+		return twoFactorError("disabling two-factor authentication", err)
+	}
+	return nil
+}
+
+/*
+replaces the user's recovery codes after checking the password and a code from the
+authenticator app
+*/
+func RegenerateRecoveryCodes(c *fiber.Ctx) ([]string, error) {
+	user, err := authUser(c)
+	if err != nil {
+		return nil, err
+	}
+	body := twoFactorCodeRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		return nil, errors.New("error parsing request data")
+	}
+	if user.TwoFactorEnabledAt == nil {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	var codes []string
+	err = confirmTwoFactorChange(user, body, func(tx *gorm.DB) error {
+		codes, err = newRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, twoFactorError("regenerating recovery codes", err)
+	}
+	return codes, nil
+}
+
+/*
+checks the second step of a two-factor login. Failed codes are throttled per user the
+same way as failed passwords.
+@params user_id
+@params code
+*/
+func VerifySecondFactor(userID uuid.UUID, code string) (*User, error) {
+	ctx := context.Background()
+	rdb := database.RedisClient()
+	throttle := twoFactorThrottle(userID)
+	if wait := throttle.blockedFor(ctx, rdb); wait > 0 {
+		return nil, &LoginBlockedError{RetryAfter: wait}
+	}
+
+	var user User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if user.TwoFactorEnabledAt == nil {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		return checkSecondFactor(tx, &user, code)
+	})
+	if errors.Is(err, ErrInvalidTwoFactorCode) {
+		throttle.fail(ctx, rdb)
+		return nil, err
+	}
+	if err != nil {
+		return nil, twoFactorError("verifying two-factor code", err)
+	}
+	throttle.reset(ctx, rdb)
+	return &user, nil
+}
+
+// twoFactorThrottle counts failed codes for a user, shared by sign-in and changes to two-factor settings
+func twoFactorThrottle(userID uuid.UUID) loginThrottle {
+	return loginThrottle{key: "2fa:" + userID.String(), free: accountFreeLoginAttempts, lockout: accountLockoutAttempts}
+}
+
+/*
+checks the password and a code before a change to the user's two-factor settings, then
+makes the change in the same transaction the code is spent in. Wrong passwords and codes
+are throttled like the second step of a login, so these can't be used to guess either.
+@params user
+@params body
+@params change
+*/
+func confirmTwoFactorChange(user *User, body twoFactorCodeRequest, change func(tx *gorm.DB) error) error {
+	ctx := context.Background()
+	rdb := database.RedisClient()
+	throttle := twoFactorThrottle(user.ID)
+	if wait := throttle.blockedFor(ctx, rdb); wait > 0 {
+		return &LoginBlockedError{RetryAfter: wait}
+	}
+	if err := utilities.CompareHashAndPassowrd(user.Password, body.Password); err != nil {
+		throttle.fail(ctx, rdb)
+		return ErrInvalidCredentials
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := checkSecondFactor(tx, user, body.Code); err != nil {
+			return err
+		}
+		return change(tx)
+	})
+	if errors.Is(err, ErrInvalidTwoFactorCode) {
+		throttle.fail(ctx, rdb)
+		return err
+	}
+	if err == nil {
+		throttle.reset(ctx, rdb)
+	}
+	return err
+}
+
+/*
+accepts a code from the authenticator app that hasn't been used yet, or an unused
+recovery code, spending it either way
+@params user
+@params code
+*/
+func checkSecondFactor(tx *gorm.DB, user *User, code string) error {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrInvalidTwoFactorCode
+	}
+	if step, ok := utilities.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		result := tx.Model(&User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	var rows []RecoveryCode
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND used_at IS NULL", user.ID).
+		Find(&rows).Error
+	if err != nil {
+		return err
+	}
+	stored := make([]utilities.StoredRecoveryCode, len(rows))
+	for i, row := range rows {
+		stored[i] = utilities.StoredRecoveryCode{Hash: row.CodeHash}
+	}
+	i, ok := utilities.SpendRecoveryCode(stored, code)
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+	result := tx.Model(&RecoveryCode{}).
+		Where("id = ? AND used_at IS NULL", rows[i].ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// newRecoveryCodes replaces a user's recovery codes and returns the new ones in plain text
+func newRecoveryCodes(tx *gorm.DB, userID uuid.UUID) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]RecoveryCode, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		token, err := utilities.RandomToken(5)
+		if err != nil {
+			return nil, err
+		}
+		code := token[:5] + "-" + token[5:]
+		codes = append(codes, code)
+		rows = append(rows, RecoveryCode{
+			BaseModel: BaseModel{ID: uuid.New()},
+			UserID:    userID,
+			CodeHash:  utilities.RecoveryCodeHash(code),
+		})
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// GetTwoFactorPolicies lists whether two-factor authentication is required for each role
+func GetTwoFactorPolicies() ([]TwoFactorPolicy, error) {
+	var saved []TwoFactorPolicy
+	if err := db.Find(&saved).Error; err != nil {
+		log.Println("error getting two-factor policies:", err.Error())
+		return nil, errors.New("failed to get two-factor policies")
+	}
+	policies := make([]TwoFactorPolicy, 0, len(twoFactorRoles))
+	for _, role := range twoFactorRoles {
+		policy := TwoFactorPolicy{Role: role}
+		for _, p := range saved {
+			if p.Role == role {
+				policy = p
+			}
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
+
+/*
+requires, or stops requiring, two-factor authentication for the admin or seller role.
+Accounts without it are sent to set it up the next time they sign in.
+*/
+func SetTwoFactorPolicy(c *fiber.Ctx) (*TwoFactorPolicy, error) {
+	adminID, _ := GetAuthUserID(c)
+	policy := TwoFactorPolicy{}
+	if err := c.BodyParser(&policy); err != nil {
+		return nil, errors.New("error parsing request data")
+	}
+	valid := false
+	for _, role := range twoFactorRoles {
+		valid = valid || policy.Role == role
+	}
+	if !valid {
+		return nil, errors.New("role must be one of " + strings.Join(twoFactorRoles, ", "))
+	}
+	policy.UpdatedBy = &adminID
+	if err := db.Save(&policy).Error; err != nil {
+		log.Println("error saving two-factor policy:", err.Error())
+		return nil, errors.New("failed to save two-factor policy")
+	}
+	return &policy, nil
+}
+
+func twoFactorError(action string, err error) error {
+	var blocked *LoginBlockedError
+	switch {
+	case errors.Is(err, ErrInvalidTwoFactorCode), errors.Is(err, ErrTwoFactorEnabled),
+		errors.Is(err, ErrInvalidCredentials), errors.As(err, &blocked):
+		return err
+	}
+	log.Println("error "+action+":", err.Error())
+	return errors.New("failed to update two-factor authentication")
+}
@@ -2,7 +2,10 @@ package model
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/dancankarani/palace/utilities"
 	"github.com/gofiber/fiber/v2"
@@ -18,6 +21,10 @@ type ResponseUser struct{
 	PhoneNumber string 	`json:"phone_number"`
 	Email string 		`json:"email"`
 	UserRole string		`json:"user_role"`
+	SellerVerified bool	`json:"seller_verified"`
+	EmailVerifiedAt *time.Time	`json:"email_verified_at"`
+	PhoneVerifiedAt *time.Time	`json:"phone_verified_at"`
+	TwoFactorEnabledAt *time.Time	`json:"two_factor_enabled_at"`
 	ProfilePhotoPath string	`json:"profile_photo_path"`
 }
 
@@ -43,61 +50,89 @@ func GetAllUsersDetails(c *fiber.Ctx)(*[]ResponseUser,error){
 	return &response,nil
 }
 
-// UpdateUser updates the user by ID and logs the changes.
+// profileUpdate is what a user may change on their own account; fields left out are unchanged
+type profileUpdate struct {
+	FirstName   *string `json:"first_name" form:"first_name"`
+	LastName    *string `json:"last_name" form:"last_name"`
+	Email       *string `json:"email" form:"email"`
+	PhoneNumber *string `json:"phone_number" form:"phone_number"`
+	Address     *string `json:"address" form:"address"`
+	City        *string `json:"city" form:"city"`
+	PostalCode  *string `json:"postal_code" form:"postal_code"`
+	Location    *string `json:"location" form:"location"`
+}
+
+// UpdateUser updates the profile of the authenticated user
 func UpdateUser(c *fiber.Ctx) (*ResponseUser, error) {
-    // Get the authenticated user ID
-    id, err := GetAuthUserID(c)
-    if err != nil {
-        return nil, errors.New("failed to get user's ID: " + err.Error())
-    }
+	// Get the authenticated user ID
+	id, err := GetAuthUserID(c)
+	if err != nil {
+		return nil, errors.New("failed to get user's ID: " + err.Error())
+	}
 
-    // Parse the request body into a User struct
-    var body User
-    if err := c.BodyParser(&body); err != nil {
-        return nil, errors.New("failed to parse: " + err.Error())
-    }
+	body := profileUpdate{}
+	if err := c.BodyParser(&body); err != nil {
+		return nil, errors.New("failed to parse: " + err.Error())
+	}
 
-	//validate phone number
-	if body.PhoneNumber !=""{
-		_,err :=utilities.ValidatePhoneNumber(body.PhoneNumber,"KE")
-		if err != nil{
-			return nil, err
+	// Fetch the current user record to get old values
+	oldValues := new(User)
+	if err := db.First(&oldValues, "id = ?", id).Error; err != nil {
+		return nil, errors.New("failed to fetch current user: " + err.Error())
+	}
+
+	updates := map[string]interface{}{}
+	for _, f := range []struct {
+		column string
+		value  *string
+		max    int
+	}{
+		{"first_name", body.FirstName, 255},
+		{"last_name", body.LastName, 255},
+		{"address", body.Address, 255},
+		{"city", body.City, 100},
+		{"postal_code", body.PostalCode, 20},
+		{"location", body.Location, 100},
+	} {
+		if f.value == nil {
+			continue
+		}
+		value := strings.TrimSpace(*f.value)
+		if len(value) > f.max {
+			return nil, fmt.Errorf("%s must be at most %d characters", f.column, f.max)
 		}
-		
+		updates[f.column] = value
+	}
+	if body.FirstName != nil && updates["first_name"] == "" {
+		return nil, errors.New("first_name can't be empty")
 	}
 
-	//validate email
-	if body.Email !=""{
-		_, err := utilities.ValidateEmail(body.Email)
-		if err != nil{
+	// A changed email address or phone number has to be verified again
+	if body.Email != nil && *body.Email != oldValues.Email {
+		if _, err := utilities.ValidateEmail(*body.Email); err != nil {
 			return nil, err
 		}
+		updates["email"] = *body.Email
+		updates["email_verified_at"] = nil
 	}
-
-	//hash password
-	if body.Password != ""{
-		hashed_password, err:= utilities.HashPassword(body.Password)
-		if err != nil{
-			return nil,err
+	if body.PhoneNumber != nil && *body.PhoneNumber != oldValues.PhoneNumber {
+		if _, err := utilities.ValidatePhoneNumber(*body.PhoneNumber, "KE"); err != nil {
+			return nil, err
 		}
-		body.Password= hashed_password
+		updates["phone_number"] = *body.PhoneNumber
+		updates["phone_verified_at"] = nil
 	}
-    // Fetch the current user record to get old values
-    oldValues := new(User)
-    if err := db.First(&oldValues, "id = ?", id).Error; err != nil {
-        return nil, errors.New("failed to fetch current user: " + err.Error())
-    }
-	response := new(ResponseUser)
-
-    // Update the user record
-    if err := db.Model(&oldValues).Updates(&body).Scan(response).Error; err != nil {
-        return nil, errors.New("error in updating the user: " + err.Error())
-    }
 
-
-    // Audit logs
-
-    return response, nil
+	response := new(ResponseUser)
+	if len(updates) > 0 {
+		if err := db.Model(&oldValues).Updates(updates).Error; err != nil {
+			return nil, errors.New("error in updating the user: " + err.Error())
+		}
+	}
+	if err := db.Model(&User{}).Where("id = ?", id).Scan(response).Error; err != nil {
+		return nil, errors.New("error in updating the user: " + err.Error())
+	}
+	return response, nil
 }
 func MapUserToResponse(user User) ResponseUser {
     return ResponseUser{
@@ -0,0 +1,455 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// VariantAttributes holds extra attributes of a variant, stored as JSON
+type VariantAttributes map[string]string
+
+func (a VariantAttributes) Value() (driver.Value, error) {
+	if a == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(a)
+	return string(data), err
+}
+
+func (a *VariantAttributes) Scan(value interface{}) error {
+	return scanJSON(value, a)
+}
+
+// StringList is a list of strings stored as JSON
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	if l == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(l)
+	return string(data), err
+}
+
+func (l *StringList) Scan(value interface{}) error {
+	return scanJSON(value, l)
+}
+
+func scanJSON(value interface{}, dest interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		if len(v) == 0 {
+			return nil
+		}
+		return json.Unmarshal(v, dest)
+	case string:
+		if v == "" {
+			return nil
+		}
+		return json.Unmarshal([]byte(v), dest)
+	}
+	return fmt.Errorf("cannot scan %T as JSON", value)
+}
+
+var (
+	ErrVariantRequired   = errors.New("choose a size or colour for this product")
+	ErrVariantNotFound   = errors.New("variant not found")
+	ErrNotProductOwner   = errors.New("only the seller of this product can change it")
+	ErrInsufficientStock = errors.New("not enough stock")
+)
+
+// UnitPrice is the variant's price override, or the product price when it has none
+func (v ProductVariant) UnitPrice(product Product) Money {
+	if v.PriceOverride.IsPositive() {
+		return v.PriceOverride
+	}
+	return product.Price
+}
+
+// attribute returns the value of a named attribute, looking at the dedicated columns first
+func (v ProductVariant) attribute(name string) string {
+	switch name {
+	case "size":
+		return v.Size
+	case "colour", "color":
+		return v.Colour
+	case "gender":
+		return v.Gender
+	}
+	return v.Attributes[name]
+}
+
+/*
+checks a variant against the attribute definitions of its product's category
+@params product
+@params variant
+*/
+func validateVariant(product Product, variant ProductVariant) error {
+	if variant.Stock < 0 {
+		return errors.New("stock cannot be negative")
+	}
+	if variant.PriceOverride.IsNegative() {
+		return errors.New("price override cannot be negative")
+	}
+
+	var definitions []CategoryAttribute
+	if product.CategoryID != nil {
+		if err := db.Where("category_id = ?", product.CategoryID).Find(&definitions).Error; err != nil {
+			log.Println("error fetching category attributes:", err.Error())
+			return errors.New("failed to validate variant")
+		}
+	}
+	category := product.Category
+	defined := map[string]bool{}
+	for _, definition := range definitions {
+		defined[definition.Name] = true
+		value := variant.attribute(definition.Name)
+		if value == "" {
+			if definition.Required {
+				return fmt.Errorf("%s is required for %s", definition.Name, category)
+			}
+			continue
+		}
+		if len(definition.Options) == 0 {
+			continue
+		}
+		allowed := false
+		for _, option := range definition.Options {
+			if strings.EqualFold(option, value) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%s must be one of %s", definition.Name, strings.Join(definition.Options, ", "))
+		}
+	}
+	if len(definitions) > 0 {
+		for name := range variant.Attributes {
+			if !defined[name] {
+				return fmt.Errorf("%s is not an attribute of %s", name, category)
+			}
+		}
+	}
+	return nil
+}
+
+// generateSKU builds a readable SKU from the product and variant attributes
+func generateSKU(product Product, variant ProductVariant) string {
+	parts := []string{strings.ToUpper(product.ID.String()[:8])}
+	for _, value := range []string{variant.Colour, variant.Size} {
+		if value != "" {
+			parts = append(parts, strings.ToUpper(strings.ReplaceAll(value, " ", "")))
+		}
+	}
+	parts = append(parts, strings.ToUpper(variant.ID.String()[:4]))
+	return strings.Join(parts, "-")
+}
+
+/*
+sets a product's stock to the total stock of its variants
+@params product_id
+*/
+func syncProductStock(tx *gorm.DB, productID uuid.UUID) error {
+	var count int64
+	if err := tx.Model(&ProductVariant{}).Where("product_id = ?", productID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+	return tx.Model(&Product{}).Where("id = ?", productID).
+		Update("stock", tx.Model(&ProductVariant{}).Select("COALESCE(SUM(stock), 0)").Where("product_id = ?", productID)).Error
+}
+
+// ownedProduct loads a product the authenticated seller (or an admin) may change
+func ownedProduct(c *fiber.Ctx, productID uuid.UUID) (*Product, error) {
+	var product Product
+	if err := db.First(&product, "id = ?", productID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("product not found")
+		}
+		log.Println("error finding product:", err.Error())
+		return nil, errors.New("failed to find product")
+	}
+	userID, _ := GetAuthUserID(c)
+	if product.SellerID != userID && GetAuthUser(c) != "admin" {
+		return nil, ErrNotProductOwner
+	}
+	return &product, nil
+}
+
+// variantRequest is what a seller sends for a variant. Its stock is only taken when the
+// variant is added, after that it changes through stock adjustments.
+type variantRequest struct {
+	SKU           string            `json:"sku"`
+	Size          string            `json:"size"`
+	Colour        string            `json:"colour"`
+	Gender        string            `json:"gender"`
+	PriceOverride Money             `json:"price_override"`
+	Stock         int               `json:"stock"`
+	Attributes    VariantAttributes `json:"attributes"`
+}
+
+// details returns the fields of the request a seller may change on an existing variant
+func (r variantRequest) details() ProductVariant {
+	return ProductVariant{
+		SKU:           strings.ToUpper(strings.TrimSpace(r.SKU)),
+		Size:          r.Size,
+		Colour:        r.Colour,
+		Gender:        r.Gender,
+		PriceOverride: r.PriceOverride,
+		Attributes:    r.Attributes,
+	}
+}
+
+/*
+gets the variants of a product
+@params product_id
+*/
+func GetProductVariants(productID uuid.UUID) (*[]ProductVariant, error) {
+	variants := []ProductVariant{}
+	if err := db.Where("product_id = ?", productID).Order("gender, colour, size").Find(&variants).Error; err != nil {
+		log.Println("error fetching product variants:", err.Error())
+		return nil, errors.New("failed to fetch product variants")
+	}
+	return &variants, nil
+}
+
+/*
+adds a size/colour variant to a product
+@params product_id
+*/
+func AddProductVariant(c *fiber.Ctx, productID uuid.UUID) (*ProductVariant, error) {
+	product, err := ownedProduct(c, productID)
+	if err != nil {
+		return nil, err
+	}
+	body := variantRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing variant request:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	variant := body.details()
+	variant.BaseModel = BaseModel{ID: uuid.New()}
+	variant.ProductID = product.ID
+	variant.Stock = body.Stock
+	if err := validateVariant(*product, variant); err != nil {
+		return nil, err
+	}
+	if variant.SKU == "" {
+		variant.SKU = generateSKU(*product, variant)
+	}
+
+	// the opening stock of the variant is logged as a restock
+	stock := variant.Stock
+	variant.Stock = 0
+	userID, _ := GetAuthUserID(c)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// once a product has variants its own stock is replaced by theirs
+		var variants int64
+		if err := tx.Model(&ProductVariant{}).Where("product_id = ?", product.ID).Count(&variants).Error; err != nil {
+			return err
+		}
+		if variants == 0 {
+			movement := InventoryMovement{ProductID: product.ID, Reason: InventoryAdjustment, Note: "stock moved to variants", ActorID: &userID}
+			if err := setStock(tx, &movement, 0); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&variant).Error; err != nil {
+			return err
+		}
+		if err := syncProductStock(tx, product.ID); err != nil {
+			return err
+		}
+		return changeStock(tx, &InventoryMovement{ProductID: product.ID, VariantID: &variant.ID, Change: stock, Reason: InventoryRestock, Note: "opening stock", ActorID: &userID})
+	})
+	variant.Stock = stock
+	if err != nil {
+		log.Println("error adding product variant:", err.Error())
+		return nil, errors.New("failed to add variant, the SKU may already be in use")
+	}
+	return &variant, nil
+}
+
+/*
+updates the details of a product variant. Its stock is changed with a stock adjustment.
+@params variant_id
+*/
+func UpdateProductVariant(c *fiber.Ctx, variantID uuid.UUID) (*ProductVariant, error) {
+	var variant ProductVariant
+	if err := db.First(&variant, "id = ?", variantID).Error; err != nil {
+		return nil, ErrVariantNotFound
+	}
+	product, err := ownedProduct(c, variant.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	body := variantRequest{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing variant update:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	update := body.details()
+	update.BaseModel, update.ProductID, update.Stock = variant.BaseModel, product.ID, variant.Stock
+	if update.SKU == "" {
+		update.SKU = generateSKU(*product, update)
+	}
+	if err := validateVariant(*product, update); err != nil {
+		return nil, err
+	}
+
+	err = db.Model(&ProductVariant{}).Where("id = ?", variant.ID).Updates(map[string]interface{}{
+		"sku":                     update.SKU,
+		"size":                    update.Size,
+		"colour":                  update.Colour,
+		"gender":                  update.Gender,
+		"price_override_minor":    update.PriceOverride.Minor,
+		"price_override_currency": update.PriceOverride.CurrencyCode(),
+		"attributes":              update.Attributes,
+	}).Error
+	if err != nil {
+		log.Println("error updating product variant:", err.Error())
+		return nil, errors.New("failed to update variant, the SKU may already be in use")
+	}
+	return &update, nil
+}
+
+/*
+deletes a product variant
+@params variant_id
+*/
+func DeleteProductVariant(c *fiber.Ctx, variantID uuid.UUID) error {
+	var variant ProductVariant
+	if err := db.First(&variant, "id = ?", variantID).Error; err != nil {
+		return ErrVariantNotFound
+	}
+	if _, err := ownedProduct(c, variant.ProductID); err != nil {
+		return err
+	}
+	userID, _ := GetAuthUserID(c)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// log the stock that goes with the variant
+		movement := InventoryMovement{ProductID: variant.ProductID, VariantID: &variant.ID, Change: -variant.Stock, Reason: InventoryAdjustment, Note: "variant deleted", ActorID: &userID}
+		if err := changeStock(tx, &movement); err != nil {
+			return err
+		}
+		if err := tx.Delete(&variant).Error; err != nil {
+			return err
+		}
+		return syncProductStock(tx, variant.ProductID)
+	})
+	if err != nil {
+		log.Println("error deleting product variant:", err.Error())
+		return errors.New("failed to delete variant")
+	}
+	return nil
+}
+
+/*
+resolves the variant an order or cart line refers to and its unit price. A product
+with variants requires one; a product without variants is sold from its own stock.
+@params product
+@params variant_id
+*/
+func resolveVariant(tx *gorm.DB, product Product, variantID *uuid.UUID) (*ProductVariant, Money, error) {
+	if variantID == nil || *variantID == uuid.Nil {
+		var count int64
+		if err := tx.Model(&ProductVariant{}).Where("product_id = ?", product.ID).Count(&count).Error; err != nil {
+			return nil, Money{}, err
+		}
+		if count > 0 {
+			return nil, Money{}, fmt.Errorf("%s: %w", product.Name, ErrVariantRequired)
+		}
+		return nil, product.Price, nil
+	}
+
+	var variant ProductVariant
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&variant, "id = ? AND product_id = ?", *variantID, product.ID).Error
+	if err != nil {
+		return nil, Money{}, fmt.Errorf("%s: %w", product.Name, ErrVariantNotFound)
+	}
+	return &variant, variant.UnitPrice(product), nil
+}
+
+/*
+gets the attribute definitions of a category given by id, slug or name
+@params category
+*/
+func GetCategoryAttributes(ref string) (*[]CategoryAttribute, error) {
+	category, err := FindCategory(CategoryProduct, ref)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []CategoryAttribute{}
+	if err := db.Where("category_id = ?", category.ID).Order("name").Find(&attributes).Error; err != nil {
+		log.Println("error fetching category attributes:", err.Error())
+		return nil, errors.New("failed to fetch category attributes")
+	}
+	return &attributes, nil
+}
+
+/*
+adds or replaces an attribute definition for a category, given by category_id or
+by its name or slug
+*/
+func SaveCategoryAttribute(c *fiber.Ctx) (*CategoryAttribute, error) {
+	body := CategoryAttribute{}
+	if err := c.BodyParser(&body); err != nil {
+		log.Println("error parsing category attribute:", err.Error())
+		return nil, errors.New("error parsing request data")
+	}
+	body.Name = strings.ToLower(strings.TrimSpace(body.Name))
+	if body.Name == "color" {
+		body.Name = "colour"
+	}
+	categoryID, categoryName, err := resolveCategory(CategoryProduct, body.CategoryID, body.Category)
+	if err != nil {
+		return nil, err
+	}
+	if categoryID == nil || body.Name == "" {
+		return nil, errors.New("category and name are required")
+	}
+
+	attribute := CategoryAttribute{}
+	err = db.Where("category_id = ? AND name = ?", categoryID, body.Name).First(&attribute).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		attribute = CategoryAttribute{BaseModel: BaseModel{ID: uuid.New()}, CategoryID: categoryID, Name: body.Name}
+	} else if err != nil {
+		log.Println("error finding category attribute:", err.Error())
+		return nil, errors.New("failed to save category attribute")
+	}
+	attribute.Category = categoryName
+	attribute.Required = body.Required
+	attribute.Options = body.Options
+	if err := db.Save(&attribute).Error; err != nil {
+		log.Println("error saving category attribute:", err.Error())
+		return nil, errors.New("failed to save category attribute")
+	}
+	return &attribute, nil
+}
+
+/*
+deletes a category attribute definition
+@params attribute_id
+*/
+func DeleteCategoryAttribute(attributeID uuid.UUID) error {
+	if err := db.Unscoped().Delete(&CategoryAttribute{}, "id = ?", attributeID).Error; err != nil {
+		log.Println("error deleting category attribute:", err.Error())
+		return errors.New("failed to delete category attribute")
+	}
+	return nil
+}
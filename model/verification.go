@@ -0,0 +1,267 @@
+package model
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dancankarani/palace/utilities"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	emailLinkLifetime     = 24 * time.Hour
+	phoneCodeLifetime     = 10 * time.Minute
+	verificationResendGap = time.Minute // between two codes on the same channel
+	maxCodesPerHour       = 5
+	maxCodeAttempts       = 5
+)
+
+var (
+	ErrUnverifiedAccount = errors.New("verify your email address and phone number first")
+	ErrTooManyCodes      = errors.New("too many codes requested, try again later")
+	ErrInvalidCode       = errors.New("the code is invalid or has expired")
+	ErrAlreadyVerified   = errors.New("already verified")
+)
+
+// AppURL is the public address of the API used in links sent to users, read from APP_URL
+func AppURL() string {
+	if url := os.Getenv("APP_URL"); url != "" {
+		return strings.TrimSuffix(url, "/")
+	}
+	return "http://localhost:8000"
+}
+
+/*
+stores the hash of a new code for a user, replacing any code still open on the
+channel. At most one code a minute and five an hour are handed out per channel.
+@params user_id
+@params channel
+@params target
+@params code
+@params lifetime
+*/
+func issueVerificationCode(userID uuid.UUID, channel VerificationChannel, target, code string, lifetime time.Duration) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var recent []VerificationCode
+		err := tx.Where("user_id = ? AND channel = ? AND created_at > ?", userID, channel, time.Now().Add(-time.Hour)).
+			Order("created_at DESC").Find(&recent).Error
+		if err != nil {
+			return err
+		}
+		if len(recent) >= maxCodesPerHour || (len(recent) > 0 && time.Since(recent[0].CreatedAt) < verificationResendGap) {
+			return ErrTooManyCodes
+		}
+		now := time.Now()
+		err = tx.Model(&VerificationCode{}).Where("user_id = ? AND channel = ? AND used_at IS NULL", userID, channel).
+			Update("used_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&VerificationCode{
+			BaseModel: BaseModel{ID: uuid.New()},
+			UserID:    userID,
+			Channel:   channel,
+			Target:    target,
+			CodeHash:  utilities.ContentHash([]byte(code)),
+			ExpiresAt: now.Add(lifetime),
+		}).Error
+	})
+}
+
+/*
+emails the user a link that verifies their email address
+@params user
+*/
+func SendEmailVerification(user User) error {
+	if user.EmailVerifiedAt != nil {
+		return ErrAlreadyVerified
+	}
+	token, err := utilities.RandomToken(32)
+	if err != nil {
+		log.Println("error generating email token:", err.Error())
+		return errors.New("failed to send verification email")
+	}
+	if err := issueVerificationCode(user.ID, VerifyEmail, user.Email, token, emailLinkLifetime); err != nil {
+		return verificationError("issuing email code", err)
+	}
+	link := AppURL() + "/api/v1/user/verify-email?token=" + token
+	body := fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening this link:\n%s\n\nThe link expires in 24 hours.", user.FirstName, link)
+	if err := utilities.SendEmail(user.Email, "Verify your email address", body); err != nil {
+		log.Println("error sending verification email:", err.Error())
+		return errors.New("failed to send verification email")
+	}
+	return nil
+}
+
+/*
+texts the user a code that verifies their phone number
+@params user
+*/
+func SendPhoneVerification(user User) error {
+	if user.PhoneVerifiedAt != nil {
+		return ErrAlreadyVerified
+	}
+	if user.PhoneNumber == "" {
+		return errors.New("add a phone number to your account first")
+	}
+	code, err := utilities.GenerateOTP(6)
+	if err != nil {
+		log.Println("error generating phone code:", err.Error())
+		return errors.New("failed to send verification code")
+	}
+	if err := issueVerificationCode(user.ID, VerifyPhone, user.PhoneNumber, code, phoneCodeLifetime); err != nil {
+		return verificationError("issuing phone code", err)
+	}
+	message := "Your Palace verification code is " + code + ". It expires in 10 minutes."
+	if err := utilities.NewSMSSender().Send(user.PhoneNumber, message); err != nil {
+		log.Println("error texting verification code:", err.Error())
+		return errors.New("failed to send verification code")
+	}
+	return nil
+}
+
+/*
+sends the email link and phone code to a new account. Failures are logged, the user
+can ask for them again.
+@params user_id
+*/
+func SendAccountVerifications(userID uuid.UUID) {
+	var user User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		log.Println("error finding user to verify:", err.Error())
+		return
+	}
+	if err := SendEmailVerification(user); err != nil {
+		log.Println("error sending email verification to", user.ID, ":", err.Error())
+	}
+	if err := SendPhoneVerification(user); err != nil {
+		log.Println("error sending phone verification to", user.ID, ":", err.Error())
+	}
+}
+
+// ResendEmailVerification emails the authenticated user a new verification link
+func ResendEmailVerification(c *fiber.Ctx) error {
+	userID, _ := GetAuthUserID(c)
+	var user User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		return errors.New("user not found")
+	}
+	return SendEmailVerification(user)
+}
+
+// ResendPhoneVerification texts the authenticated user a new verification code
+func ResendPhoneVerification(c *fiber.Ctx) error {
+	userID, _ := GetAuthUserID(c)
+	var user User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		return errors.New("user not found")
+	}
+	return SendPhoneVerification(user)
+}
+
+/*
+verifies the email address a link was sent to. The link only works once and only
+while the account still has that address.
+@params token
+*/
+func VerifyEmailToken(token string) error {
+	var code VerificationCode
+	err := db.Where("code_hash = ? AND channel = ? AND used_at IS NULL AND expires_at > ?",
+		utilities.ContentHash([]byte(token)), VerifyEmail, time.Now()).First(&code).Error
+	if err != nil {
+		return ErrInvalidCode
+	}
+	return useVerificationCode(code, "email", "email_verified_at")
+}
+
+/*
+verifies the authenticated user's phone number with the code texted to it
+*/
+func VerifyPhoneCode(c *fiber.Ctx) error {
+	userID, _ := GetAuthUserID(c)
+	body := struct {
+		Code string `json:"code"`
+	}{}
+	if err := c.BodyParser(&body); err != nil {
+		return errors.New("error parsing request data")
+	}
+	var code VerificationCode
+	err := db.Where("user_id = ? AND channel = ? AND used_at IS NULL AND expires_at > ?", userID, VerifyPhone, time.Now()).
+		Order("created_at DESC").First(&code).Error
+	if err != nil {
+		return ErrInvalidCode
+	}
+	// every guess uses up an attempt before it is checked, so guesses sent in parallel can't get past the limit
+	result := db.Model(&VerificationCode{}).
+		Where("id = ? AND attempts < ?", code.ID, maxCodeAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return verificationError("verifying code", result.Error)
+	}
+	if result.RowsAffected != 1 {
+		return ErrInvalidCode
+	}
+	hash := utilities.ContentHash([]byte(strings.TrimSpace(body.Code)))
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(code.CodeHash)) != 1 {
+		return ErrInvalidCode
+	}
+	return useVerificationCode(code, "phone_number", "phone_verified_at")
+}
+
+// useVerificationCode spends a code and marks the user's address verified if it still matches
+func useVerificationCode(code VerificationCode, targetColumn, verifiedColumn string) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Model(&VerificationCode{}).Where("id = ? AND used_at IS NULL", code.ID).Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidCode
+		}
+		result = tx.Model(&User{}).Where("id = ? AND "+targetColumn+" = ?", code.UserID, code.Target).Update(verifiedColumn, now)
+		if result.Error != nil {
+			return result.Error
+		}
+		// the address was changed after the code was sent
+		if result.RowsAffected == 0 {
+			return ErrInvalidCode
+		}
+		return nil
+	})
+	if err != nil {
+		return verificationError("verifying code", err)
+	}
+	return nil
+}
+
+/*
+fails with ErrUnverifiedAccount unless the user has verified their email address and
+phone number. Checkout and selling require a verified account.
+@params user_id
+*/
+func RequireVerified(userID uuid.UUID) error {
+	var user User
+	if err := db.Select("id, email_verified_at, phone_verified_at").First(&user, "id = ?", userID).Error; err != nil {
+		return errors.New("user not found")
+	}
+	if user.EmailVerifiedAt == nil || user.PhoneVerifiedAt == nil {
+		return ErrUnverifiedAccount
+	}
+	return nil
+}
+
+func verificationError(action string, err error) error {
+	if errors.Is(err, ErrTooManyCodes) || errors.Is(err, ErrInvalidCode) {
+		return err
+	}
+	log.Println("error "+action+":", err.Error())
+	return errors.New("failed to verify account")
+}
@@ -0,0 +1,296 @@
+/*
+Package money holds exact amounts of money in the minor unit of a currency. Arithmetic
+between amounts in different currencies returns ErrCurrencyMismatch.
+*/
+package money
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+Money is an exact amount in the minor unit (cents) of an ISO 4217 currency.
+It is stored as two columns, <prefix>minor and <prefix>currency, by embedding it:
+
+	Price Money `gorm:"embedded;embeddedPrefix:price_"`
+*/
+type Money struct {
+	Minor    int64  `gorm:"column:minor;not null;default:0"`
+	Currency string `gorm:"column:currency;size:3;not null;default:'KES'"`
+}
+
+// minorUnits is the number of decimal places of every supported currency
+const minorUnits = 2
+
+var (
+	ErrCurrencyMismatch    = errors.New("money: currency mismatch")
+	ErrUnsupportedCurrency = errors.New("money: unsupported currency")
+)
+
+// DefaultCurrency is the currency used when an amount does not name one, read from CURRENCY
+func DefaultCurrency() string {
+	if currency := strings.ToUpper(strings.TrimSpace(os.Getenv("CURRENCY"))); len(currency) == 3 {
+		return currency
+	}
+	return "KES"
+}
+
+/*
+Supported reports whether amounts may be given in a currency: the default currency or one
+listed in SUPPORTED_CURRENCIES such as "KES,UGX,TZS". Each has two decimal places.
+@params currency code
+*/
+func Supported(currency string) bool {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == DefaultCurrency() {
+		return true
+	}
+	for _, code := range strings.Split(os.Getenv("SUPPORTED_CURRENCIES"), ",") {
+		if strings.ToUpper(strings.TrimSpace(code)) == currency {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+NormalizeCurrency upper-cases a currency code, returning ErrUnsupportedCurrency when it is
+not supported
+@params currency code
+*/
+func NormalizeCurrency(currency string) (string, error) {
+	code := strings.ToUpper(strings.TrimSpace(currency))
+	if len(code) != 3 || !Supported(code) {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedCurrency, currency)
+	}
+	return code, nil
+}
+
+// New returns an amount in minor units of the default currency
+func New(minor int64) Money {
+	return Money{Minor: minor, Currency: DefaultCurrency()}
+}
+
+/*
+Parse reads a decimal amount such as "1250", "1250.5" or "1,250.50" exactly,
+without going through a float
+@params amount
+*/
+func Parse(amount string) (Money, error) {
+	s := strings.ReplaceAll(strings.TrimSpace(amount), ",", "")
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	whole, fraction, _ := strings.Cut(s, ".")
+	if whole == "" && fraction == "" {
+		return Money{}, fmt.Errorf("invalid amount %q", amount)
+	}
+	if len(fraction) > minorUnits {
+		return Money{}, fmt.Errorf("invalid amount %q: at most %d decimal places allowed", amount, minorUnits)
+	}
+	fraction += strings.Repeat("0", minorUnits-len(fraction))
+	if whole == "" {
+		whole = "0"
+	}
+	units, err := strconv.ParseUint(whole, 10, 63)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q", amount)
+	}
+	cents, err := strconv.ParseUint(fraction, 10, 63)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q", amount)
+	}
+	if units > math.MaxInt64/100 {
+		return Money{}, fmt.Errorf("amount %q is too large", amount)
+	}
+	minor := int64(units)*100 + int64(cents)
+	if negative {
+		minor = -minor
+	}
+	return New(minor), nil
+}
+
+/*
+FromFloat converts a float received from an external API, such as an M-Pesa callback,
+rounding to the nearest minor unit. Never use floats for arithmetic.
+@params amount
+*/
+func FromFloat(amount float64) Money {
+	return New(int64(math.Round(amount * 100)))
+}
+
+// CurrencyCode returns the currency of the amount, the default currency when it names none
+func (m Money) CurrencyCode() string {
+	if m.Currency == "" {
+		return DefaultCurrency()
+	}
+	return m.Currency
+}
+
+func (m Money) match(o Money) error {
+	if m.CurrencyCode() != o.CurrencyCode() {
+		return fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.CurrencyCode(), o.CurrencyCode())
+	}
+	return nil
+}
+
+// Add returns m + o, or ErrCurrencyMismatch when they are in different currencies
+func (m Money) Add(o Money) (Money, error) {
+	if err := m.match(o); err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: m.Minor + o.Minor, Currency: m.CurrencyCode()}, nil
+}
+
+// Sub returns m - o, or ErrCurrencyMismatch when they are in different currencies
+func (m Money) Sub(o Money) (Money, error) {
+	if err := m.match(o); err != nil {
+		return Money{}, err
+	}
+	return Money{Minor: m.Minor - o.Minor, Currency: m.CurrencyCode()}, nil
+}
+
+/*
+Sum adds up amounts in one currency. The sum of no amounts is zero in the default currency.
+@params amounts
+*/
+func Sum(amounts ...Money) (Money, error) {
+	total := New(0)
+	if len(amounts) > 0 {
+		total.Currency = amounts[0].CurrencyCode()
+	}
+	for _, amount := range amounts {
+		var err error
+		if total, err = total.Add(amount); err != nil {
+			return Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// Mul returns m multiplied by a whole quantity
+func (m Money) Mul(quantity int64) Money {
+	return Money{Minor: m.Minor * quantity, Currency: m.CurrencyCode()}
+}
+
+// Neg returns -m
+func (m Money) Neg() Money {
+	return Money{Minor: -m.Minor, Currency: m.CurrencyCode()}
+}
+
+// Percent returns basisPoints/10000 of m, rounded half away from zero
+func (m Money) Percent(basisPoints int64) Money {
+	product := m.Minor * basisPoints
+	half := int64(5000)
+	if product < 0 {
+		half = -half
+	}
+	return Money{Minor: (product + half) / 10000, Currency: m.CurrencyCode()}
+}
+
+// Cmp compares two amounts in the same currency, returning -1, 0 or 1
+func (m Money) Cmp(o Money) (int, error) {
+	if err := m.match(o); err != nil {
+		return 0, err
+	}
+	switch {
+	case m.Minor < o.Minor:
+		return -1, nil
+	case m.Minor > o.Minor:
+		return 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (m Money) IsZero() bool     { return m.Minor == 0 }
+func (m Money) IsPositive() bool { return m.Minor > 0 }
+func (m Money) IsNegative() bool { return m.Minor < 0 }
+
+// WholeUnits returns the amount in major units rounded up, e.g. for M-Pesa which only accepts whole shillings
+func (m Money) WholeUnits() int64 {
+	units := m.Minor / 100
+	if m.Minor%100 > 0 {
+		units++
+	}
+	return units
+}
+
+// Decimal formats the amount as a plain decimal string such as "1250.50"
+func (m Money) Decimal() string {
+	minor := m.Minor
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, minor/100, minor%100)
+}
+
+// String formats the amount with its currency such as "KES 1250.50"
+func (m Money) String() string {
+	return m.CurrencyCode() + " " + m.Decimal()
+}
+
+type moneyJSON struct {
+	Amount   string `json:"amount"`
+	Minor    int64  `json:"minor"`
+	Currency string `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(moneyJSON{Amount: m.Decimal(), Minor: m.Minor, Currency: m.CurrencyCode()})
+}
+
+// UnmarshalJSON accepts a number (1250.5), a decimal string ("1250.50") or an object
+// with either "minor" or "amount" and an optional "currency"
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	if len(data) > 0 && data[0] == '{' {
+		var raw struct {
+			Amount   json.RawMessage `json:"amount"`
+			Minor    *int64          `json:"minor"`
+			Currency string          `json:"currency"`
+		}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+		parsed := New(0)
+		if raw.Minor != nil {
+			parsed.Minor = *raw.Minor
+		} else if len(raw.Amount) > 0 {
+			if err := parsed.UnmarshalJSON(raw.Amount); err != nil {
+				return err
+			}
+		}
+		if raw.Currency != "" {
+			currency, err := NormalizeCurrency(raw.Currency)
+			if err != nil {
+				return err
+			}
+			parsed.Currency = currency
+		}
+		*m = parsed
+		return nil
+	}
+	return m.UnmarshalText(bytes.Trim(data, `"`))
+}
+
+// UnmarshalText lets form and query parsers decode decimal amounts into Money
+func (m *Money) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
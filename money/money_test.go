@@ -0,0 +1,160 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"testing/quick"
+)
+
+// amounts are kept to int32 so sums of a few of them can't overflow
+func amount(minor int32) Money { return New(int64(minor)) }
+
+func TestAddIsCommutativeAndAssociative(t *testing.T) {
+	property := func(a, b, c int32) bool {
+		ab, _ := amount(a).Add(amount(b))
+		ba, _ := amount(b).Add(amount(a))
+		abc1, _ := ab.Add(amount(c))
+		bc, _ := amount(b).Add(amount(c))
+		abc2, _ := amount(a).Add(bc)
+		return ab == ba && abc1 == abc2
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSubUndoesAdd(t *testing.T) {
+	property := func(a, b int32) bool {
+		sum, err := amount(a).Add(amount(b))
+		if err != nil {
+			return false
+		}
+		back, err := sum.Sub(amount(b))
+		return err == nil && back == amount(a)
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCmpAgreesWithSub(t *testing.T) {
+	property := func(a, b int32) bool {
+		cmp, err := amount(a).Cmp(amount(b))
+		if err != nil {
+			return false
+		}
+		diff, _ := amount(a).Sub(amount(b))
+		switch cmp {
+		case -1:
+			return diff.IsNegative()
+		case 1:
+			return diff.IsPositive()
+		default:
+			return diff.IsZero()
+		}
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMismatchedCurrenciesReturnAnError(t *testing.T) {
+	kes := Money{Minor: 100, Currency: "KES"}
+	usd := Money{Minor: 100, Currency: "USD"}
+	if _, err := kes.Add(usd); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Add: got %v, want ErrCurrencyMismatch", err)
+	}
+	if _, err := kes.Sub(usd); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Sub: got %v, want ErrCurrencyMismatch", err)
+	}
+	if _, err := kes.Cmp(usd); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Cmp: got %v, want ErrCurrencyMismatch", err)
+	}
+	if _, err := Sum(kes, kes, usd); !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("Sum: got %v, want ErrCurrencyMismatch", err)
+	}
+}
+
+func TestDecimalParsesBack(t *testing.T) {
+	property := func(minor int64) bool {
+		if minor == -minor && minor != 0 { // math.MinInt64 has no positive counterpart
+			return true
+		}
+		parsed, err := Parse(New(minor).Decimal())
+		return err == nil && parsed == New(minor)
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	property := func(minor int64) bool {
+		data, err := json.Marshal(New(minor))
+		if err != nil {
+			return false
+		}
+		var decoded Money
+		return json.Unmarshal(data, &decoded) == nil && decoded == New(minor)
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUnsupportedCurrencyIsRejected(t *testing.T) {
+	t.Setenv("CURRENCY", "KES")
+	t.Setenv("SUPPORTED_CURRENCIES", "UGX, tzs")
+
+	var m Money
+	if err := json.Unmarshal([]byte(`{"amount":"10.00","currency":"XYZ"}`), &m); !errors.Is(err, ErrUnsupportedCurrency) {
+		t.Errorf("XYZ: got %v, want ErrUnsupportedCurrency", err)
+	}
+	if err := json.Unmarshal([]byte(`{"amount":"10.00","currency":"tzs"}`), &m); err != nil || m.Currency != "TZS" || m.Minor != 1000 {
+		t.Errorf("tzs: got %+v, %v", m, err)
+	}
+	if _, err := NormalizeCurrency("KES"); err != nil {
+		t.Errorf("the default currency is always supported: %v", err)
+	}
+}
+
+// an order total is the sum of its lines, the platform's commission and the seller's share
+// add back up to it, and the whole shillings sent to M-Pesa cover it by less than a shilling
+func TestOrderTotalsReconcile(t *testing.T) {
+	type line struct {
+		UnitPrice uint16
+		Quantity  uint8
+	}
+	property := func(lines []line, basisPoints uint16) bool {
+		bp := int64(basisPoints % 10000)
+		var amounts []Money
+		var expected int64
+		for _, l := range lines {
+			total := New(int64(l.UnitPrice)).Mul(int64(l.Quantity))
+			amounts = append(amounts, total)
+			expected += int64(l.UnitPrice) * int64(l.Quantity)
+
+			commission := total.Percent(bp)
+			net, err := total.Sub(commission)
+			if err != nil {
+				return false
+			}
+			if back, _ := net.Add(commission); back != total {
+				return false
+			}
+			if commission.IsNegative() || commission.Minor > total.Minor {
+				return false
+			}
+		}
+		sum, err := Sum(amounts...)
+		if err != nil || sum.Minor != expected {
+			return false
+		}
+		whole := sum.WholeUnits() * 100
+		return whole >= sum.Minor && whole-sum.Minor < 100
+	}
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
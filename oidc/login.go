@@ -0,0 +1,123 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StateLifetime is how long a user has to come back from the provider
+const StateLifetime = 10 * time.Minute
+
+var (
+	ErrStateInvalid    = errors.New("the sign-in has expired, try again")
+	ErrEmailUnverified = errors.New("your email address is not verified with the sign-in provider")
+)
+
+/*
+StateStore keeps a login between sending the user to the provider and their return.
+Take has to read and delete the value in one step, returning ErrStateInvalid when there is
+none, so a state can only be spent once.
+*/
+type StateStore interface {
+	Save(ctx context.Context, state string, value []byte, lifetime time.Duration) error
+	Take(ctx context.Context, state string) ([]byte, error)
+}
+
+// loginState is what is kept for the callback
+type loginState struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+/*
+Begin starts a login, returning the address to send the user to and the state, which has to
+be tied to the user's browser with StateBinding. The PKCE verifier and nonce wait in the
+store for Finish.
+@params store
+*/
+func (p *Provider) Begin(ctx context.Context, store StateStore) (authURL, state string, err error) {
+	state, err = NewState()
+	if err != nil {
+		return "", "", fmt.Errorf("generating state: %w", err)
+	}
+	nonce, err := NewState()
+	if err != nil {
+		return "", "", fmt.Errorf("generating nonce: %w", err)
+	}
+	verifier, challenge, err := NewPKCE()
+	if err != nil {
+		return "", "", fmt.Errorf("generating pkce verifier: %w", err)
+	}
+	authURL, err = p.AuthCodeURL(ctx, state, nonce, challenge)
+	if err != nil {
+		return "", "", err
+	}
+	data, _ := json.Marshal(loginState{Provider: p.Name, Verifier: verifier, Nonce: nonce})
+	if err := store.Save(ctx, state, data, StateLifetime); err != nil {
+		return "", "", fmt.Errorf("saving state: %w", err)
+	}
+	return authURL, state, nil
+}
+
+/*
+StateBinding is kept in a cookie in the browser that started a login, so the callback can
+check with StateBound that it comes back to the same browser. Without it, someone could
+start a login to their own account and have someone else open its callback, signing them in
+as the attacker.
+@params state
+*/
+func StateBinding(state string) string {
+	sum := sha256.Sum256([]byte(state))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// StateBound reports whether the state a callback brought back belongs to the browser's binding
+func StateBound(binding, state string) bool {
+	if binding == "" || state == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(binding), []byte(StateBinding(state))) == 1
+}
+
+/*
+Finish completes a login once the provider sends the user back, spending the state and
+returning the verified ID token
+@params store
+@params state
+@params code
+*/
+func (p *Provider) Finish(ctx context.Context, store StateStore, state, code string) (*IDToken, error) {
+	if state == "" || code == "" {
+		return nil, ErrStateInvalid
+	}
+	data, err := store.Take(ctx, state)
+	if err != nil {
+		return nil, err
+	}
+	var saved loginState
+	if json.Unmarshal(data, &saved) != nil || saved.Provider != p.Name {
+		return nil, ErrStateInvalid
+	}
+	return p.Exchange(ctx, code, saved.Verifier, saved.Nonce)
+}
+
+/*
+LinkableEmail is the address an existing account may be found by for this identity. Only
+an address the provider has verified is, as anyone can put someone else's address on an
+account with a provider that doesn't check it.
+*/
+func (t *IDToken) LinkableEmail() (string, error) {
+	email := strings.TrimSpace(t.Email)
+	if email == "" || !t.EmailVerified {
+		return "", ErrEmailUnverified
+	}
+	return email, nil
+}
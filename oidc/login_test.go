@@ -0,0 +1,268 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dancankarani/palace/oidc/oidctest"
+	"github.com/dgrijalva/jwt-go"
+)
+
+// memoryStore is a StateStore for tests
+type memoryStore struct {
+	mu     sync.Mutex
+	states map[string][]byte
+}
+
+func (s *memoryStore) Save(ctx context.Context, state string, value []byte, lifetime time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.states == nil {
+		s.states = map[string][]byte{}
+	}
+	s.states[state] = value
+	return nil
+}
+
+func (s *memoryStore) Take(ctx context.Context, state string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.states[state]
+	if !ok {
+		return nil, ErrStateInvalid
+	}
+	delete(s.states, state)
+	return value, nil
+}
+
+// newTestProvider starts the mock provider and returns a Provider configured for it
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Server) {
+	t.Helper()
+	mock, err := oidctest.New("palace", "secret", jwt.MapClaims{
+		"sub":            "user-1",
+		"email":          "jane@example.com",
+		"email_verified": true,
+		"given_name":     "Jane",
+		"family_name":    "Doe",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(mock)
+	t.Cleanup(server.Close)
+	mock.Issuer = server.URL
+	return &Provider{
+		Name:         "mock",
+		Issuer:       server.URL,
+		ClientID:     "palace",
+		ClientSecret: "secret",
+		RedirectURL:  "https://palace.test/api/v1/user/oidc/mock/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+		HTTPClient:   server.Client(),
+	}, mock
+}
+
+// signIn opens the authorization address like a browser and returns what the provider sends back
+func signIn(t *testing.T, authURL string) (code, state string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize returned %s", resp.Status)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+// login runs the whole flow with the claims the mock puts in the ID token
+func login(t *testing.T, provider *Provider, mock *oidctest.Server, claims jwt.MapClaims) (*IDToken, error) {
+	t.Helper()
+	for k, v := range claims {
+		mock.Claims[k] = v
+	}
+	ctx := context.Background()
+	store := &memoryStore{}
+	authURL, _, err := provider.Begin(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := signIn(t, authURL)
+	return provider.Finish(ctx, store, state, code)
+}
+
+func TestLoginSucceeds(t *testing.T) {
+	provider, mock := newTestProvider(t)
+	token, err := login(t, provider, mock, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Subject != "user-1" || token.Email != "jane@example.com" || !token.EmailVerified || token.GivenName != "Jane" {
+		t.Errorf("unexpected token %+v", token)
+	}
+	if token.Issuer != provider.Issuer {
+		t.Errorf("issuer %q, want %q", token.Issuer, provider.Issuer)
+	}
+}
+
+func TestStateCannotBeReplayed(t *testing.T) {
+	provider, _ := newTestProvider(t)
+	ctx := context.Background()
+	store := &memoryStore{}
+	authURL, _, err := provider.Begin(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := signIn(t, authURL)
+	if _, err := provider.Finish(ctx, store, state, code); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Finish(ctx, store, state, code); !errors.Is(err, ErrStateInvalid) {
+		t.Errorf("replayed state: got %v, want ErrStateInvalid", err)
+	}
+	if _, err := provider.Finish(ctx, store, "made-up", code); !errors.Is(err, ErrStateInvalid) {
+		t.Errorf("unknown state: got %v, want ErrStateInvalid", err)
+	}
+}
+
+func TestStateIsForOneProvider(t *testing.T) {
+	provider, _ := newTestProvider(t)
+	ctx := context.Background()
+	store := &memoryStore{}
+	authURL, _, err := provider.Begin(ctx, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state := signIn(t, authURL)
+	other := &Provider{
+		Name:         "other",
+		Issuer:       provider.Issuer,
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  provider.RedirectURL,
+		HTTPClient:   provider.HTTPClient,
+	}
+	if _, err := other.Finish(ctx, store, state, code); !errors.Is(err, ErrStateInvalid) {
+		t.Errorf("got %v, want ErrStateInvalid", err)
+	}
+}
+
+func TestWrongPKCEVerifierIsRefused(t *testing.T) {
+	provider, _ := newTestProvider(t)
+	ctx := context.Background()
+	_, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong, _, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := signIn(t, authURL)
+	if _, err := provider.Exchange(ctx, code, wrong, "nonce"); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("got %v, want an invalid_grant error", err)
+	}
+}
+
+func TestTokensNotMeantForUsAreRefused(t *testing.T) {
+	cases := map[string]jwt.MapClaims{
+		"wrong nonce":           {"nonce": "someone-elses-nonce"},
+		"no nonce":              {"nonce": ""},
+		"wrong audience":        {"aud": "another-client"},
+		"several audiences":     {"aud": []string{"another-client", "palace"}, "azp": "another-client"},
+		"wrong issuer":          {"iss": "https://issuer.example"},
+		"expired":               {"exp": time.Now().Add(-time.Minute).Unix()},
+		"no subject":            {"sub": ""},
+		"issued for the future": {"iat": time.Now().Add(time.Hour).Unix()},
+		"not valid until later": {"nbf": time.Now().Add(time.Hour).Unix()},
+	}
+	for name, claims := range cases {
+		t.Run(name, func(t *testing.T) {
+			provider, mock := newTestProvider(t)
+			if token, err := login(t, provider, mock, claims); err == nil {
+				t.Errorf("accepted %+v", token)
+			}
+		})
+	}
+}
+
+func TestOnlyVerifiedEmailsAreLinkable(t *testing.T) {
+	cases := []struct {
+		name     string
+		claims   jwt.MapClaims
+		linkable bool
+	}{
+		{"verified", jwt.MapClaims{"email_verified": true}, true},
+		{"verified as a string", jwt.MapClaims{"email_verified": "true"}, true},
+		{"unverified", jwt.MapClaims{"email_verified": false}, false},
+		{"unverified as a string", jwt.MapClaims{"email_verified": "false"}, false},
+		{"not said", jwt.MapClaims{"email_verified": nil}, false},
+		{"no address", jwt.MapClaims{"email": ""}, false},
+	}
+	provider, mock := newTestProvider(t)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock.Claims["email"], mock.Claims["email_verified"] = "jane@example.com", true
+			token, err := login(t, provider, mock, tc.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			email, err := token.LinkableEmail()
+			if tc.linkable && (err != nil || email != "jane@example.com") {
+				t.Errorf("got %q, %v, want jane@example.com", email, err)
+			}
+			if !tc.linkable && !errors.Is(err, ErrEmailUnverified) {
+				t.Errorf("got %q, %v, want ErrEmailUnverified", email, err)
+			}
+		})
+	}
+}
+
+func TestStateIsBoundToTheBrowser(t *testing.T) {
+	provider, _ := newTestProvider(t)
+	store := &memoryStore{}
+	authURL, state, err := provider.Begin(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, returned := signIn(t, authURL)
+	if returned != state {
+		t.Fatalf("provider sent back state %q, want %q", returned, state)
+	}
+	binding := StateBinding(state)
+	if !StateBound(binding, returned) {
+		t.Error("the browser that started the login was refused")
+	}
+	// someone else's browser has the binding of its own login, or none
+	_, other, err := provider.Begin(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, b := range map[string]string{"another login": StateBinding(other), "no cookie": "", "the raw state": returned} {
+		if StateBound(b, returned) {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if StateBound(binding, "") {
+		t.Error("accepted an empty state")
+	}
+}
@@ -0,0 +1,183 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// keys are fetched again for an unknown key id, but not more often than this
+const keyRefreshInterval = time.Minute
+
+// IDToken holds the verified claims of an ID token that the login uses
+type IDToken struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+	Name          string
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+/*
+Verify checks the signature of an ID token against the provider's published keys, and its
+issuer, audience, expiry and nonce
+@params raw id token
+@params nonce sent with the authorization request
+*/
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (*IDToken, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		if token.Method.Alg() != jwt.SigningMethodRS256.Alg() {
+			return nil, fmt.Errorf("unexpected signing algorithm %s", token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	str := func(name string) string {
+		value, _ := claims[name].(string)
+		return value
+	}
+	// Google also issues tokens with the issuer written without the scheme
+	if iss := str("iss"); iss != p.Issuer && "https://"+iss != p.Issuer {
+		return nil, fmt.Errorf("id token issued by %q, expected %q", iss, p.Issuer)
+	}
+	if !p.audienceAllowed(claims) {
+		return nil, errors.New("id token is not for this client")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("id token has no expiry")
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(str("nonce")), []byte(nonce)) != 1 {
+		return nil, errors.New("id token nonce does not match")
+	}
+	if str("sub") == "" {
+		return nil, errors.New("id token has no subject")
+	}
+
+	// email_verified is a boolean, though some providers send it as a string
+	verified := false
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		verified = v
+	case string:
+		verified = v == "true"
+	}
+	return &IDToken{
+		Issuer:        str("iss"),
+		Subject:       str("sub"),
+		Email:         str("email"),
+		EmailVerified: verified,
+		GivenName:     str("given_name"),
+		FamilyName:    str("family_name"),
+		Name:          str("name"),
+	}, nil
+}
+
+// audienceAllowed checks the token is meant for this client, and was requested by it
+// when there are several audiences
+func (p *Provider) audienceAllowed(claims jwt.MapClaims) bool {
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	found := false
+	for _, aud := range audiences {
+		found = found || aud == p.ClientID
+	}
+	if !found {
+		return false
+	}
+	if len(audiences) > 1 {
+		azp, _ := claims["azp"].(string)
+		return azp == p.ClientID
+	}
+	return true
+}
+
+// key returns the provider's signing key with the given id, fetching the key set if needed
+func (p *Provider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysAt) < keyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.doJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("fetching signing keys: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		key, err := rsaKey(k)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	p.keys, p.keysAt = keys, time.Now()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func rsaKey(k jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() < 3 {
+		return nil, errors.New("invalid key exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
@@ -0,0 +1,30 @@
+package admin
+
+import (
+	"github.com/dancankarani/palace/controllers/category"
+	"github.com/dancankarani/palace/controllers/ledger"
+	"github.com/dancankarani/palace/controllers/product"
+	"github.com/dancankarani/palace/controllers/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetAdminRoutes(app *fiber.App) {
+	auth := app.Group("/api/v1/admin")
+	//protected routes
+	adminGroup := auth.Group("/", user.JWTMiddleware)
+	adminGroup.Get("/ledger/trial-balance", ledger.GetTrialBalanceHandler)
+	adminGroup.Post("/payments/:id/refund", ledger.RefundPaymentHandler)
+	adminGroup.Get("/refunds/pending", ledger.GetPendingRefundsHandler)
+	adminGroup.Post("/payments/:id/refund/settle", ledger.SettleRefundHandler)
+	adminGroup.Post("/category-attributes", products.SaveCategoryAttributeHandler)
+	adminGroup.Delete("/category-attributes/:id", products.DeleteCategoryAttributeHandler)
+	adminGroup.Post("/categories", category.CreateCategoryHandler)
+	adminGroup.Patch("/categories/:id", category.UpdateCategoryHandler)
+	adminGroup.Delete("/categories/:id", category.DeleteCategoryHandler)
+	adminGroup.Get("/seller-applications", user.GetSellerApplicationsHandler)
+	adminGroup.Get("/seller-applications/:id/id-document", user.GetSellerApplicationDocumentHandler)
+	adminGroup.Patch("/seller-applications/:id/approve", user.ApproveSellerApplicationHandler)
+	adminGroup.Patch("/seller-applications/:id/reject", user.RejectSellerApplicationHandler)
+	adminGroup.Get("/security/two-factor", user.GetTwoFactorPoliciesHandler)
+	adminGroup.Put("/security/two-factor", user.SetTwoFactorPolicyHandler)
+}
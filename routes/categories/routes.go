@@ -0,0 +1,12 @@
+package categories
+
+import (
+	"github.com/dancankarani/palace/controllers/category"
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetCategoryRoutes(app *fiber.App) {
+	auth := app.Group("/api/v1/categories")
+	auth.Get("/", category.GetCategoryTreeHandler)
+	auth.Get("/:ref", category.GetCategoryHandler)
+}
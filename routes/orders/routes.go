@@ -12,5 +12,9 @@ func SetOrdersRoutes(app *fiber.App){
 	auth.Get("/",model.GetOrders)
 	productGroup := auth.Group("/",user.JWTMiddleware)
 	productGroup.Post("/",order.MakeOrderHandler)
+	productGroup.Get("/:id/receipt.pdf",order.GetReceiptHandler)
+	productGroup.Post("/:id/cancel",order.CancelOrderHandler)
+	productGroup.Post("/:id/ship",order.ShipOrderHandler)
+	productGroup.Post("/:id/confirm-delivery",order.ConfirmDeliveryHandler)
 	
 }
\ No newline at end of file
@@ -2,11 +2,25 @@ package payments
 
 import (
 	"github.com/dancankarani/palace/controllers/payment"
+	"github.com/dancankarani/palace/controllers/user"
 	"github.com/gofiber/fiber/v2"
 )
 
 func SetPaymentsRoutes(app *fiber.App) {
 	auth := app.Group("/api/v1/")
-	auth.Post("/payments", payment.InitiateSTKPush)
-	auth.Post("/callback",payment.HandleCallback)
-}
\ No newline at end of file
+	auth.Post("/payments", user.JWTMiddleware, payment.InitiateSTKPush)
+	//stk push results called by safaricom, which end in the secret callback token
+	auth.Post("/callback/:token", payment.CallbackAuth, payment.HandleCallback)
+
+	//paybill/till webhooks called by safaricom, which end in the secret callback token
+	c2b := app.Group("/api/v1/c2b")
+	c2b.Post("/validation/:token", payment.CallbackAuth, payment.C2BValidation)
+	c2b.Post("/confirmation/:token", payment.CallbackAuth, payment.C2BConfirmation)
+	c2b.Post("/register", user.JWTMiddleware, payment.RegisterC2BURLs)
+
+	//seller payout results called by safaricom, which end in the secret callback token
+	b2c := app.Group("/api/v1/b2c")
+	b2c.Post("/result/:token", payment.CallbackAuth, payment.B2CResult)
+	b2c.Post("/timeout/:token", payment.CallbackAuth, payment.B2CTimeout)
+	b2c.Post("/status/:token", payment.CallbackAuth, payment.B2CStatusResult)
+}
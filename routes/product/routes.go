@@ -9,15 +9,42 @@ import (
 
 func SetProductsRoutes(app *fiber.App) {
 	auth := app.Group("/api/v1/products")
+	auth.Get("/",products.QueryProductsHandler)
 	auth.Get("/all",products.GetAllProductsHandler)
 	auth.Get("/ratings",model.GetRatings)
+	auth.Get("/ratings/seller/:id",model.GetSellerRatingHandler)
 	auth.Get("/price",products.GetProductsByPriceHandler)
 	auth.Get("/category",products.GetProductsByCategory)
+	auth.Get("/gender",products.GetProductsByGenderHandler)
+	auth.Get("/attributes",products.GetCategoryAttributesHandler)
+	auth.Get("/:id/variants",products.GetProductVariantsHandler)
+	auth.Get("/:id/images",products.GetProductImagesHandler)
+	auth.Get("/:id/reviews",products.GetProductReviewsHandler)
 	//protected routes
 	productGroup := auth.Group("/",user.JWTMiddleware)
-	productGroup.Get("/",products.GetSellersProductHandler)
 	productGroup.Post("/",products.AddProductHandler)
+	productGroup.Post("/import",products.ImportProductsHandler)
+	productGroup.Get("/import/:id",products.GetImportJobHandler)
+	productGroup.Get("/export",products.ExportProductsHandler)
 	productGroup.Post("/ratings/:id",model.CreateRatings)
+	productGroup.Patch("/ratings/:id",model.UpdateRatings)
+	productGroup.Delete("/ratings/:id",model.DeleteRatings)
 	productGroup.Patch("/:id",products.UpdateProductHandler)
+	productGroup.Patch("/:id/status",products.SetProductStatusHandler)
+	productGroup.Post("/:id/restore",products.RestoreProductHandler)
+	productGroup.Post("/:id/stock",products.AdjustStockHandler)
+	productGroup.Post("/reviews",products.CreateProductReviewHandler)
+	productGroup.Patch("/reviews/:id",products.UpdateProductReviewHandler)
+	productGroup.Delete("/reviews/:id",products.DeleteProductReviewHandler)
+	productGroup.Put("/reviews/:id/reply",products.ReplyToProductReviewHandler)
+	productGroup.Post("/reviews/:id/helpful",products.VoteReviewHelpfulHandler)
+	productGroup.Delete("/reviews/:id/helpful",products.RemoveReviewVoteHandler)
 	productGroup.Delete("/:id",products.DeleteProductHandler)
+	productGroup.Post("/:id/variants",products.AddProductVariantHandler)
+	productGroup.Patch("/variants/:id",products.UpdateProductVariantHandler)
+	productGroup.Delete("/variants/:id",products.DeleteProductVariantHandler)
+	productGroup.Post("/:id/images",products.AddProductImagesHandler)
+	productGroup.Put("/:id/images/order",products.ReorderProductImagesHandler)
+	productGroup.Patch("/images/:id/primary",products.SetPrimaryProductImageHandler)
+	productGroup.Delete("/images/:id",products.DeleteProductImageHandler)
 }
\ No newline at end of file
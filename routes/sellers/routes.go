@@ -0,0 +1,30 @@
+package sellers
+
+import (
+	"github.com/dancankarani/palace/controllers/product"
+	"github.com/dancankarani/palace/controllers/seller"
+	"github.com/dancankarani/palace/controllers/user"
+	"github.com/gofiber/fiber/v2"
+)
+
+func SetSellerRoutes(app *fiber.App) {
+	auth := app.Group("/api/v1/seller")
+	//protected routes
+	sellerGroup := auth.Group("/", user.JWTMiddleware)
+	sellerGroup.Get("/balance", seller.GetBalanceHandler)
+	sellerGroup.Get("/products", products.GetSellersProductHandler)
+	sellerGroup.Get("/products/archived", products.GetArchivedProductsHandler)
+	sellerGroup.Get("/inventory", seller.GetInventoryHandler)
+	sellerGroup.Put("/shop", seller.UpdateShopProfileHandler)
+}
+
+// SetStorefrontRoutes serves the public seller pages. It has to be registered before
+// SetSellerRoutes, whose middleware also matches paths starting with /api/v1/sellers.
+func SetStorefrontRoutes(app *fiber.App) {
+	storefront := app.Group("/api/v1/sellers")
+	storefront.Get("/:id", seller.GetStorefrontHandler)
+	//protected routes
+	followGroup := storefront.Group("/", user.JWTMiddleware)
+	followGroup.Post("/:id/follow", seller.FollowShopHandler)
+	followGroup.Delete("/:id/follow", seller.UnfollowShopHandler)
+}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"github.com/dancankarani/palace/controllers/booking"
+	services "github.com/dancankarani/palace/controllers/service"
 	"github.com/dancankarani/palace/controllers/user"
 	"github.com/dancankarani/palace/model"
 	"github.com/gofiber/fiber/v2"
@@ -9,12 +11,34 @@ import (
 
 func SetServicesRoutes(app *fiber.App) {
 	auth := app.Group("/api/v1/services")
-	auth.Get("/all",model.GetAllServicesHandler)
+	auth.Get("/all",services.GetAllServicesHandler)
+	auth.Get("/search",services.SearchServicesHandler)
+	auth.Get("/:id/slots",booking.GetServiceSlotsHandler)
+	auth.Get("/sellers/:id/availability",booking.GetAvailabilityHandler)
+	auth.Get("/:id/packages",services.GetServicePackagesHandler)
 	
 	//protected routes
 	productGroup := auth.Group("/",user.JWTMiddleware)
 	productGroup.Post("/",model.CreateService)
 	productGroup.Get("/",model.GetService)
+	productGroup.Put("/availability",booking.SetAvailabilityHandler)
+	productGroup.Post("/time-off",booking.AddTimeOffHandler)
+	productGroup.Delete("/time-off/:id",booking.DeleteTimeOffHandler)
+	productGroup.Get("/bookings",booking.GetBookingsHandler)
+	productGroup.Post("/:id/bookings",booking.CreateBookingHandler)
+	productGroup.Patch("/bookings/:id/confirm",booking.ConfirmBookingHandler)
+	productGroup.Patch("/bookings/:id/cancel",booking.CancelBookingHandler)
+	productGroup.Patch("/bookings/:id/complete",booking.CompleteBookingHandler)
+	productGroup.Put("/:id/packages/:tier",services.SaveServicePackageHandler)
+	productGroup.Delete("/:id/packages/:tier",services.DeleteServicePackageHandler)
+	productGroup.Post("/packages/:id/order",services.OrderServicePackageHandler)
+	productGroup.Patch("/orders/:id/deliver",services.DeliverServiceOrderHandler)
+	productGroup.Post("/:id/quotes",services.CreateQuoteRequestHandler)
+	productGroup.Get("/quotes",services.GetQuoteRequestsHandler)
+	productGroup.Patch("/quotes/:id/close",services.CloseQuoteRequestHandler)
+	productGroup.Post("/quotes/:id/offers",services.SendCustomOfferHandler)
+	productGroup.Patch("/offers/:id/accept",services.AcceptCustomOfferHandler)
+	productGroup.Patch("/offers/:id/reject",services.RejectCustomOfferHandler)
 	productGroup.Patch("/:id",model.UpdateServiceHandler)
 	productGroup.Delete("/:id",model.DeleteServiceHandler)
-}
\ No newline at end of file
+}
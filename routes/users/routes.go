@@ -9,13 +9,32 @@ func SetUserRoutes(app *fiber.App) {
 	auth := app.Group("/api/v1/user")
 	auth.Post("/",user.CreateUserAccount)
 	auth.Post("/login",user.Login)
+	auth.Post("/login/2fa",user.LoginTwoFactor)
+	auth.Get("/oidc/:provider/login",user.OIDCLoginHandler)
+	auth.Get("/oidc/:provider/callback",user.OIDCCallbackHandler)
+	auth.Post("/oidc/link",user.OIDCLinkHandler)
 	auth.Get("/all",user.GetAllUsersHandler)
+	auth.Get("/verify-email",user.VerifyEmailHandler)
+	auth.Post("/forgot-password",user.ForgotPassword)
+	auth.Post("/reset-password",user.ResetPassword)
 	//protected routes
 	userGroup := auth.Group("/",user.JWTMiddleware)
 	
 	userGroup.Get("/",user.GetOneUserHandler)
 	userGroup.Put("/",user.UpdateUserHandler)
-	userGroup.Post("/forgot-password",user.ForgotPassword)
-	userGroup.Post("/reset-password",user.ResetPassword)
 	userGroup.Post("/logout",user.Logout)
+	userGroup.Get("/notifications",user.GetNotificationsHandler)
+	userGroup.Patch("/notifications/:id/read",user.MarkNotificationReadHandler)
+	userGroup.Post("/verify-email/resend",user.ResendEmailVerificationHandler)
+	userGroup.Post("/verify-phone/send",user.SendPhoneVerificationHandler)
+	userGroup.Post("/verify-phone",user.VerifyPhoneHandler)
+	userGroup.Post("/2fa/setup",user.BeginTwoFactorSetupHandler)
+	userGroup.Post("/2fa/enable",user.EnableTwoFactorHandler)
+	userGroup.Post("/2fa/disable",user.DisableTwoFactorHandler)
+	userGroup.Post("/2fa/recovery-codes",user.RegenerateRecoveryCodesHandler)
+	userGroup.Get("/seller-application",user.GetSellerApplicationHandler)
+	userGroup.Put("/seller-application",user.SaveSellerApplicationHandler)
+	userGroup.Post("/seller-application/payout-phone/code",user.SendPayoutPhoneCodeHandler)
+	userGroup.Post("/seller-application/payout-phone/verify",user.VerifyPayoutPhoneHandler)
+	userGroup.Post("/seller-application/submit",user.SubmitSellerApplicationHandler)
 }
\ No newline at end of file
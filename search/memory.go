@@ -0,0 +1,177 @@
+package search
+
+import (
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// field weights: a match in the name counts more than one in the description
+const (
+	nameWeight        = 3.0
+	categoryWeight    = 2.0
+	descriptionWeight = 1.0
+)
+
+// how much a term counts when it only matches through a synonym, a prefix or a typo
+const (
+	exactBoost   = 1.0
+	synonymBoost = 0.8
+	prefixBoost  = 0.6
+	fuzzyBoost   = 0.5
+)
+
+/*
+MemorySearcher is an embedded inverted index. It supports synonyms, prefix
+matching of the last query word and typo tolerance, and is rebuilt from the
+database when the server starts.
+*/
+type MemorySearcher struct {
+	mu       sync.RWMutex
+	docs     map[uuid.UUID]Document
+	postings map[string]map[uuid.UUID]float64 // term -> document -> weighted term frequency
+	terms    map[uuid.UUID][]string           // document -> its distinct terms, for removal
+}
+
+func NewMemorySearcher() *MemorySearcher {
+	return &MemorySearcher{
+		docs:     map[uuid.UUID]Document{},
+		postings: map[string]map[uuid.UUID]float64{},
+		terms:    map[uuid.UUID][]string{},
+	}
+}
+
+func (s *MemorySearcher) Index(doc Document) error {
+	weights := map[string]float64{}
+	for _, field := range []struct {
+		text   string
+		weight float64
+	}{
+		{doc.Name, nameWeight},
+		{doc.Category, categoryWeight},
+		{doc.Description, descriptionWeight},
+	} {
+		for _, term := range Analyze(field.text) {
+			weights[term] += field.weight
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(doc.ID)
+	s.docs[doc.ID] = doc
+	for term, weight := range weights {
+		if s.postings[term] == nil {
+			s.postings[term] = map[uuid.UUID]float64{}
+		}
+		s.postings[term][doc.ID] = weight
+		s.terms[doc.ID] = append(s.terms[doc.ID], term)
+	}
+	return nil
+}
+
+func (s *MemorySearcher) Delete(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(id)
+	return nil
+}
+
+// remove drops a document from the index; the caller holds the write lock
+func (s *MemorySearcher) remove(id uuid.UUID) {
+	for _, term := range s.terms[id] {
+		delete(s.postings[term], id)
+		if len(s.postings[term]) == 0 {
+			delete(s.postings, term)
+		}
+	}
+	delete(s.terms, id)
+	delete(s.docs, id)
+}
+
+/*
+every query word must match, either exactly, through a synonym, as a prefix
+(last word only, so results update while typing) or within a couple of typos
+*/
+func (s *MemorySearcher) Search(query string, limit int) ([]Hit, error) {
+	words := Analyze(query)
+	if len(words) == 0 {
+		return []Hit{}, nil
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	total := float64(len(s.docs))
+	var scores map[uuid.UUID]float64
+	matched := map[string]bool{}
+	for i, word := range words {
+		expansions := s.expand(word, i == len(words)-1)
+		wordScores := map[uuid.UUID]float64{}
+		for term, boost := range expansions {
+			postings := s.postings[term]
+			idf := math.Log(1 + total/float64(len(postings)))
+			for id, weight := range postings {
+				// a document matching a word several ways keeps its best match
+				if score := boost * weight * idf; score > wordScores[id] {
+					wordScores[id] = score
+				}
+			}
+			matched[term] = true
+		}
+
+		if scores == nil {
+			scores = wordScores
+			continue
+		}
+		for id, score := range scores {
+			if extra, ok := wordScores[id]; ok {
+				scores[id] = score + extra
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	hits := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{ID: id, Score: score})
+	}
+	sortHits(hits)
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	for i := range hits {
+		hits[i].Highlights = highlightDocument(s.docs[hits[i].ID], matched)
+	}
+	return hits, nil
+}
+
+// expand returns the indexed terms a query word matches and how much each one counts
+func (s *MemorySearcher) expand(word string, prefix bool) map[string]float64 {
+	expansions := map[string]float64{}
+	add := func(term string, boost float64) {
+		if _, ok := s.postings[term]; ok && boost > expansions[term] {
+			expansions[term] = boost
+		}
+	}
+
+	add(word, exactBoost)
+	for _, synonym := range Synonyms(word) {
+		add(synonym, synonymBoost)
+	}
+
+	edits := maxEdits(word)
+	for term := range s.postings {
+		switch {
+		case term == word:
+		case prefix && len(word) >= 2 && strings.HasPrefix(term, word):
+			add(term, prefixBoost)
+		case edits > 0 && withinEdits(word, term, edits):
+			add(term, fuzzyBoost)
+		}
+	}
+	return expansions
+}
@@ -0,0 +1,158 @@
+package search
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+func newCatalog(t *testing.T, docs ...Document) *MemorySearcher {
+	t.Helper()
+	s := NewMemorySearcher()
+	for _, doc := range docs {
+		if err := s.Index(doc); err != nil {
+			t.Fatalf("indexing %q: %v", doc.Name, err)
+		}
+	}
+	return s
+}
+
+func TestMemorySearcherMatches(t *testing.T) {
+	sneakers := Document{ID: uuid.New(), Name: "Running Sneakers", Description: "Light shoes for the track", Category: "Footwear"}
+	trousers := Document{ID: uuid.New(), Name: "Chino Trousers", Description: "Slim fit cotton", Category: "Men"}
+	hoodie := Document{ID: uuid.New(), Name: "Fleece Hoodie", Description: "Warm and soft", Category: "Tops"}
+	dress := Document{ID: uuid.New(), Name: "Evening Dress", Description: "Silk dress with a long skirt", Category: "Women"}
+	s := newCatalog(t, sneakers, trousers, hoodie, dress)
+
+	cases := []struct {
+		name  string
+		query string
+		want  []uuid.UUID
+	}{
+		{"exact word", "hoodie", []uuid.UUID{hoodie.ID}},
+		{"plural is stemmed", "dresses", []uuid.UUID{dress.ID}},
+		{"synonym", "pants", []uuid.UUID{trousers.ID}},
+		{"synonym of another field", "trainer", []uuid.UUID{sneakers.ID}},
+		{"synonym group in the category", "shoe", []uuid.UUID{sneakers.ID}},
+		{"one typo", "hoddie", []uuid.UUID{hoodie.ID}},
+		{"swapped letters", "dersses", []uuid.UUID{dress.ID}},
+		{"two typos in a long word", "snaekerz", []uuid.UUID{sneakers.ID}},
+		{"short words need to be exact", "mon", nil},
+		{"last word is a prefix", "chino tro", []uuid.UUID{trousers.ID}},
+		{"earlier words are not prefixes", "tro chino", nil},
+		{"every word must match", "fleece trousers", nil},
+		{"too many typos", "hdooei", nil},
+		{"empty query", "  ", nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			hits, err := s.Search(tc.query, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(hits) != len(tc.want) {
+				t.Fatalf("Search(%q) returned %d hits, want %d", tc.query, len(hits), len(tc.want))
+			}
+			for i, id := range tc.want {
+				if hits[i].ID != id {
+					t.Errorf("Search(%q) hit %d = %s, want %s", tc.query, i, hits[i].ID, id)
+				}
+			}
+		})
+	}
+}
+
+func TestMemorySearcherRanking(t *testing.T) {
+	inName := Document{ID: uuid.New(), Name: "Denim Jacket", Description: "Blue wash"}
+	inDescription := Document{ID: uuid.New(), Name: "Work Shirt", Description: "Pairs well with a jacket"}
+	unrelated := Document{ID: uuid.New(), Name: "Wool Scarf", Description: "Long and warm"}
+	s := newCatalog(t, inDescription, unrelated, inName)
+
+	hits, err := s.Search("jacket", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uuid.UUID{inName.ID, inDescription.ID}
+	if len(hits) != len(want) {
+		t.Fatalf("got %d hits, want %d", len(hits), len(want))
+	}
+	for i, id := range want {
+		if hits[i].ID != id {
+			t.Errorf("hit %d = %s, want %s", i, hits[i].ID, id)
+		}
+	}
+
+	hits, _ = s.Search("jacket", 1)
+	if len(hits) != 1 || hits[0].ID != inName.ID {
+		t.Errorf("limit 1 returned %v, want only the name match", hits)
+	}
+}
+
+func TestMemorySearcherReindexAndDelete(t *testing.T) {
+	doc := Document{ID: uuid.New(), Name: "Canvas Bag"}
+	s := newCatalog(t, doc)
+
+	doc.Name = "Leather Purse"
+	if err := s.Index(doc); err != nil {
+		t.Fatal(err)
+	}
+	if hits, _ := s.Search("canvas", 10); len(hits) != 0 {
+		t.Errorf("old name still matches after reindexing: %v", hits)
+	}
+	if hits, _ := s.Search("leather", 10); len(hits) != 1 {
+		t.Errorf("new name does not match after reindexing: %v", hits)
+	}
+
+	if err := s.Delete(doc.ID); err != nil {
+		t.Fatal(err)
+	}
+	if hits, _ := s.Search("leather", 10); len(hits) != 0 {
+		t.Errorf("deleted document still matches: %v", hits)
+	}
+	if err := s.Delete(uuid.New()); err != nil {
+		t.Errorf("deleting an unknown id: %v", err)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	matched := map[string]bool{"tshirt": true, "café": true, "jean": true}
+	cases := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"marks the original word", "Blue Jeans", "Blue <mark>Jeans</mark>"},
+		{"hyphenated word", "Plain T-Shirt", "Plain <mark>T-Shirt</mark>"},
+		{"escapes the text", "<b>Jeans</b> & co", "&lt;b&gt;<mark>Jeans</mark>&lt;/b&gt; &amp; co"},
+		{"multibyte text around a match", "Ünïcode café jeans", "Ünïcode <mark>café</mark> <mark>jeans</mark>"},
+		{"no match", "Wool Scarf", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Highlight(tc.text, matched, false); got != tc.want {
+				t.Errorf("Highlight(%q) = %q, want %q", tc.text, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSnippetKeepsRunesWhole(t *testing.T) {
+	matched := map[string]bool{"jean": true}
+	for _, filler := range []string{"é", "日本", "ab€", "x"} {
+		for shift := 0; shift < 4; shift++ {
+			text := strings.Repeat("a", shift) + strings.Repeat(filler, 200) + " jeans " + strings.Repeat(filler, 200)
+			got := Highlight(text, matched, true)
+			if !utf8.ValidString(got) {
+				t.Errorf("snippet of %q text shifted by %d is not valid UTF-8: %q", filler, shift, got)
+			}
+			if !strings.Contains(got, "<mark>jeans</mark>") {
+				t.Errorf("snippet of %q text shifted by %d lost the match: %q", filler, shift, got)
+			}
+			if !strings.HasPrefix(got, "...") || !strings.HasSuffix(got, "...") {
+				t.Errorf("snippet of %q text shifted by %d is not marked as cut: %q", filler, shift, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package search
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// FullTextIndex is the name of the FULLTEXT index the MySQL backend searches
+const FullTextIndex = "idx_products_fulltext"
+
+// activeStatus is the status of products that are listed and searchable
+const activeStatus = "active"
+
+/*
+MySQLSearcher answers queries with a FULLTEXT index on the products table, so
+the database keeps it in sync and Index and Delete have nothing to do.
+Only active products are searched, like the embedded backend indexes. Each
+query word is expanded with its synonyms and matched as a prefix; typo
+tolerance is only available in the embedded backend.
+*/
+type MySQLSearcher struct {
+	db *gorm.DB
+}
+
+func NewMySQLSearcher(db *gorm.DB) *MySQLSearcher {
+	return &MySQLSearcher{db: db}
+}
+
+func (s *MySQLSearcher) Index(doc Document) error {
+	return nil
+}
+
+func (s *MySQLSearcher) Delete(id uuid.UUID) error {
+	return nil
+}
+
+func (s *MySQLSearcher) Search(query string, limit int) ([]Hit, error) {
+	words := Analyze(query)
+	if len(words) == 0 {
+		return []Hit{}, nil
+	}
+
+	// +(word* synonym*) for every word: all words are required, any of their forms may match
+	var boolean strings.Builder
+	matched := map[string]bool{}
+	for _, word := range words {
+		boolean.WriteString("+(")
+		for i, term := range append([]string{word}, Synonyms(word)...) {
+			if i > 0 {
+				boolean.WriteString(" ")
+			}
+			boolean.WriteString(term + "*")
+			matched[term] = true
+		}
+		boolean.WriteString(") ")
+	}
+
+	var rows []struct {
+		Document
+		Score float64
+	}
+	match := "MATCH(name, description, category) AGAINST (? IN BOOLEAN MODE)"
+	tx := s.db.Table("products").
+		Select("id, name, description, category, "+match+" AS score", boolean.String()).
+		Where("deleted_at IS NULL").
+		Where("status = ?", activeStatus).
+		Where(match, boolean.String()).
+		Order("score DESC").
+		Order("id")
+	if limit > 0 {
+		tx = tx.Limit(limit)
+	}
+	if err := tx.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	hits := make([]Hit, len(rows))
+	for i, row := range rows {
+		hits[i] = Hit{ID: row.ID, Score: row.Score, Highlights: highlightDocument(row.Document, prefixMatches(row.Document, matched))}
+	}
+	return hits, nil
+}
+
+// prefixMatches returns the terms of a document that start with one of the matched query terms
+func prefixMatches(doc Document, matched map[string]bool) map[string]bool {
+	terms := map[string]bool{}
+	for _, term := range Analyze(doc.Name + " " + doc.Description + " " + doc.Category) {
+		for m := range matched {
+			if strings.HasPrefix(term, m) {
+				terms[term] = true
+			}
+		}
+	}
+	return terms
+}
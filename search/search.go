@@ -0,0 +1,279 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Document is the searchable text of a product
+type Document struct {
+	ID          uuid.UUID
+	Name        string
+	Description string
+	Category    string
+}
+
+// Hit is a matching document with its relevance score and highlighted fields.
+// Highlighted text is HTML escaped and wraps matched words in <mark></mark>.
+type Hit struct {
+	ID         uuid.UUID         `json:"id"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// Searcher indexes documents and answers ranked full-text queries
+type Searcher interface {
+	// Index adds or replaces a document
+	Index(doc Document) error
+	// Delete removes a document; deleting an unknown id is not an error
+	Delete(id uuid.UUID) error
+	// Search returns up to limit hits ordered by descending relevance
+	Search(query string, limit int) ([]Hit, error)
+}
+
+// synonym groups for clothing terms, written in their analysed (stemmed) form
+var synonymGroups = [][]string{
+	{"trouser", "pant", "slack"},
+	{"tshirt", "tee", "top"},
+	{"sneaker", "trainer", "kick"},
+	{"hoodie", "sweatshirt"},
+	{"sweater", "jumper", "pullover"},
+	{"jacket", "coat", "blazer"},
+	{"dress", "gown", "frock"},
+	{"shoe", "footwear"},
+	{"jean", "denim"},
+	{"cap", "hat"},
+	{"bag", "handbag", "purse"},
+	{"kid", "child", "children"},
+	{"women", "woman", "ladie", "female"},
+	{"men", "man", "gent", "male"},
+}
+
+var synonyms = buildSynonyms()
+
+func buildSynonyms() map[string][]string {
+	m := map[string][]string{}
+	for _, group := range synonymGroups {
+		for _, term := range group {
+			for _, other := range group {
+				if other != term {
+					m[term] = append(m[term], other)
+				}
+			}
+		}
+	}
+	return m
+}
+
+// Synonyms returns the other terms that mean the same as an analysed term
+func Synonyms(term string) []string {
+	return synonyms[term]
+}
+
+// token is a word of the original text and where it starts and ends
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lowercase, stemmed terms. Hyphens inside words are
+// dropped so "T-Shirt" and "tshirt" analyse to the same term.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	var word strings.Builder
+	flush := func(end int) {
+		if word.Len() > 0 {
+			tokens = append(tokens, token{term: stem(word.String()), start: start, end: end})
+		}
+		word.Reset()
+		start = -1
+	}
+	for offset, r := range text {
+		_, size := utf8.DecodeRuneInString(text[offset:])
+		next, _ := utf8.DecodeRuneInString(text[offset+size:])
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if start < 0 {
+				start = offset
+			}
+			word.WriteRune(unicode.ToLower(r))
+		case r == '-' && start >= 0 && (unicode.IsLetter(next) || unicode.IsDigit(next)):
+			// keep hyphenated words together
+		default:
+			flush(offset)
+		}
+	}
+	flush(len(text))
+	return tokens
+}
+
+// Analyze returns the terms of text as they are indexed
+func Analyze(text string) []string {
+	tokens := tokenize(text)
+	terms := make([]string, len(tokens))
+	for i, t := range tokens {
+		terms[i] = t.term
+	}
+	return terms
+}
+
+// stem strips common English plural endings
+func stem(word string) string {
+	switch {
+	case len(word) > 4 && (strings.HasSuffix(word, "ches") || strings.HasSuffix(word, "shes") ||
+		strings.HasSuffix(word, "sses") || strings.HasSuffix(word, "xes")):
+		return word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss"):
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// maxEdits is how many typos a query term of this length may contain
+func maxEdits(term string) int {
+	switch n := len([]rune(term)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// withinEdits reports whether a and b are at most max edits apart, counting
+// insertions, deletions, substitutions and swaps of neighbouring letters
+func withinEdits(a, b string, max int) bool {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return false
+	}
+	before := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = minInt(curr[j], before[j-2]+1)
+			}
+			if curr[j] < rowMin {
+				rowMin = curr[j]
+			}
+		}
+		if rowMin > max {
+			return false
+		}
+		before, prev, curr = prev, curr, before
+	}
+	return prev[len(rb)] <= max
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+const snippetLength = 160
+
+/*
+Highlight wraps every word of text whose term is in matched with <mark></mark>. Long text is
+cut down to a snippet around the first match. The text itself is HTML escaped so only the
+marks are markup.
+*/
+func Highlight(text string, matched map[string]bool, snippet bool) string {
+	tokens := tokenize(text)
+	first := -1
+	for _, t := range tokens {
+		if matched[t.term] {
+			first = t.start
+			break
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	from, to := 0, len(text)
+	if snippet && len(text) > snippetLength {
+		from = first - snippetLength/4
+		if from < 0 {
+			from = 0
+		}
+		to = from + snippetLength
+		if to > len(text) {
+			to = len(text)
+		}
+		// never cut a character in two
+		for from > 0 && !utf8.RuneStart(text[from]) {
+			from--
+		}
+		for to < len(text) && !utf8.RuneStart(text[to]) {
+			to++
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("...")
+	}
+	pos := from
+	for _, t := range tokens {
+		if t.start < from || t.end > to || !matched[t.term] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:t.start]))
+		b.WriteString("<mark>" + html.EscapeString(text[t.start:t.end]) + "</mark>")
+		pos = t.end
+	}
+	b.WriteString(html.EscapeString(text[pos:to]))
+	if to < len(text) {
+		b.WriteString("...")
+	}
+	return b.String()
+}
+
+// highlightDocument builds the highlights of a hit
+func highlightDocument(doc Document, matched map[string]bool) map[string]string {
+	highlights := map[string]string{}
+	if h := Highlight(doc.Name, matched, false); h != "" {
+		highlights["name"] = h
+	}
+	if h := Highlight(doc.Description, matched, true); h != "" {
+		highlights["description"] = h
+	}
+	if h := Highlight(doc.Category, matched, false); h != "" {
+		highlights["category"] = h
+	}
+	return highlights
+}
+
+// sortHits orders hits by descending score with the id as a stable tie-breaker
+func sortHits(hits []Hit) {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID.String() < hits[j].ID.String()
+	})
+}
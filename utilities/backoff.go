@@ -0,0 +1,22 @@
+package utilities
+
+import "time"
+
+/*
+Backoff is how long to wait after a number of failed attempts: nothing for the free ones,
+then a second doubled with every further failure, and lockoutFor once there were lockout
+failures. The wait never exceeds lockoutFor.
+*/
+func Backoff(failures, free, lockout int, lockoutFor time.Duration) time.Duration {
+	if failures >= lockout {
+		return lockoutFor
+	}
+	if failures < free {
+		return 0
+	}
+	delay := time.Second << uint(failures-free)
+	if delay > lockoutFor || delay <= 0 {
+		delay = lockoutFor
+	}
+	return delay
+}
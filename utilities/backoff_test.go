@@ -0,0 +1,35 @@
+package utilities
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	const lockoutFor = 15 * time.Minute
+	cases := []struct {
+		name     string
+		failures int
+		free     int
+		lockout  int
+		want     time.Duration
+	}{
+		{"no failures", 0, 3, 10, 0},
+		{"last free failure", 2, 3, 10, 0},
+		{"first failure past the free ones", 3, 3, 10, time.Second},
+		{"doubles", 4, 3, 10, 2 * time.Second},
+		{"doubles again", 5, 3, 10, 4 * time.Second},
+		{"just before the lockout", 9, 3, 10, 64 * time.Second},
+		{"at the lockout", 10, 3, 10, lockoutFor},
+		{"past the lockout", 25, 3, 10, lockoutFor},
+		{"capped below the lockout", 49, 10, 50, lockoutFor},
+		{"cap where doubling passes it", 13, 3, 50, lockoutFor},
+		{"last doubling under the cap", 12, 3, 50, 512 * time.Second},
+		{"shift past the duration range", 80, 3, 100, lockoutFor},
+	}
+	for _, tc := range cases {
+		if got := Backoff(tc.failures, tc.free, tc.lockout, lockoutFor); got != tc.want {
+			t.Errorf("%s: Backoff(%d, %d, %d) = %s, want %s", tc.name, tc.failures, tc.free, tc.lockout, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package utilities
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+)
+
+// BlobStore stores uploaded files under a key and serves them from a public URL
+type BlobStore interface {
+	// Put stores data under key, replacing any existing blob, and returns its URL
+	Put(key, contentType string, data []byte) (string, error)
+	// Delete removes the blob under key; deleting a missing blob is not an error
+	Delete(key string) error
+}
+
+// PrivateBlobStore stores files that are never served publicly, only read back through the API
+type PrivateBlobStore interface {
+	BlobStore
+	// Get returns the blob under key and its content type, or ErrBlobNotFound
+	Get(key string) ([]byte, string, error)
+}
+
+var ErrBlobNotFound = errors.New("file not found")
+
+/*
+returns the configured blob store. BLOB_STORE=local keeps files on disk under
+LOCAL_STORAGE_DIR; otherwise files go to the Azure container from ACCOUNT_NAME,
+ACCOUNT_KEY and CONTAINER_NAME.
+*/
+func NewBlobStore() BlobStore {
+	if os.Getenv("BLOB_STORE") == "local" {
+		return NewLocalBlobStore(LocalStorageDir(), LocalStorageURL())
+	}
+	return &AzureBlobStore{
+		AccountName:   os.Getenv("ACCOUNT_NAME"),
+		AccountKey:    os.Getenv("ACCOUNT_KEY"),
+		ContainerName: os.Getenv("CONTAINER_NAME"),
+	}
+}
+
+/*
+returns the store for private files such as identity documents. BLOB_STORE=local keeps them
+on disk under PRIVATE_STORAGE_DIR, which is never served; otherwise they go to the Azure
+container PRIVATE_CONTAINER_NAME, which must not allow public access.
+*/
+func NewPrivateBlobStore() PrivateBlobStore {
+	if os.Getenv("BLOB_STORE") == "local" {
+		return NewLocalBlobStore(PrivateStorageDir(), "")
+	}
+	container := os.Getenv("PRIVATE_CONTAINER_NAME")
+	if container == "" {
+		container = "private"
+	}
+	return &AzureBlobStore{
+		AccountName:   os.Getenv("ACCOUNT_NAME"),
+		AccountKey:    os.Getenv("ACCOUNT_KEY"),
+		ContainerName: container,
+		Private:       true,
+	}
+}
+
+// PrivateStorageDir is the directory local private blobs are written to, read from PRIVATE_STORAGE_DIR
+func PrivateStorageDir() string {
+	if dir := os.Getenv("PRIVATE_STORAGE_DIR"); dir != "" {
+		return dir
+	}
+	return "./private"
+}
+
+// LocalStorageDir is the directory local blobs are written to, read from LOCAL_STORAGE_DIR
+func LocalStorageDir() string {
+	if dir := os.Getenv("LOCAL_STORAGE_DIR"); dir != "" {
+		return dir
+	}
+	return "./uploads"
+}
+
+// LocalStorageURL is the URL path local blobs are served from, read from LOCAL_STORAGE_URL
+func LocalStorageURL() string {
+	if base := os.Getenv("LOCAL_STORAGE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return "/uploads"
+}
+
+// ContentHash returns the hex SHA-256 of data, used to name blobs by their content
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+type AzureBlobStore struct {
+	AccountName   string
+	AccountKey    string
+	ContainerName string
+	Private       bool // blobs must not be cached by browsers or proxies
+}
+
+func (s *AzureBlobStore) blobURL(key string) (azblob.BlockBlobURL, error) {
+	cred, err := azblob.NewSharedKeyCredential(s.AccountName, s.AccountKey)
+	if err != nil {
+		return azblob.BlockBlobURL{}, errors.New("failed to create credentials")
+	}
+	serviceURL, _ := url.Parse(fmt.Sprintf("https://%s.blob.core.windows.net", s.AccountName))
+	pipeline := azblob.NewPipeline(cred, azblob.PipelineOptions{})
+	containerURL := azblob.NewServiceURL(*serviceURL, pipeline).NewContainerURL(s.ContainerName)
+	return containerURL.NewBlockBlobURL(key), nil
+}
+
+func (s *AzureBlobStore) Put(key, contentType string, data []byte) (string, error) {
+	blobURL, err := s.blobURL(key)
+	if err != nil {
+		return "", err
+	}
+	// keys are content hashes, so a blob never changes once written
+	cacheControl := "public, max-age=31536000, immutable"
+	if s.Private {
+		cacheControl = "private, no-store"
+	}
+	_, err = azblob.UploadBufferToBlockBlob(context.Background(), data, blobURL, azblob.UploadToBlockBlobOptions{
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{
+			ContentType:  contentType,
+			CacheControl: cacheControl,
+		},
+	})
+	if err != nil {
+		log.Println("error uploading file:", err.Error())
+		return "", errors.New("failed to upload file")
+	}
+	return blobURL.String(), nil
+}
+
+func (s *AzureBlobStore) Get(key string) ([]byte, string, error) {
+	blobURL, err := s.blobURL(key)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := blobURL.Download(context.Background(), 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		var storageErr azblob.StorageError
+		if errors.As(err, &storageErr) && storageErr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			return nil, "", ErrBlobNotFound
+		}
+		log.Println("error downloading file:", err.Error())
+		return nil, "", errors.New("failed to download file")
+	}
+	body := resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3})
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		log.Println("error downloading file:", err.Error())
+		return nil, "", errors.New("failed to download file")
+	}
+	return data, resp.ContentType(), nil
+}
+
+func (s *AzureBlobStore) Delete(key string) error {
+	blobURL, err := s.blobURL(key)
+	if err != nil {
+		return err
+	}
+	_, err = blobURL.Delete(context.Background(), azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	if err != nil {
+		var storageErr azblob.StorageError
+		if errors.As(err, &storageErr) && storageErr.ServiceCode() == azblob.ServiceCodeBlobNotFound {
+			return nil
+		}
+		log.Println("error deleting file:", err.Error())
+		return errors.New("failed to delete file")
+	}
+	return nil
+}
+
+// LocalBlobStore keeps blobs on the local filesystem, for development and single-server deployments
+type LocalBlobStore struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewLocalBlobStore(dir, baseURL string) *LocalBlobStore {
+	return &LocalBlobStore{Dir: dir, BaseURL: baseURL}
+}
+
+// path maps a key to a file inside Dir, refusing keys that would escape it
+func (s *LocalBlobStore) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" {
+		return "", errors.New("invalid blob key")
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(clean)), nil
+}
+
+func (s *LocalBlobStore) Put(key, contentType string, data []byte) (string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		log.Println("error creating upload directory:", err.Error())
+		return "", errors.New("failed to upload file")
+	}
+	// write to a temporary file and rename so readers never see a partial file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		log.Println("error writing file:", err.Error())
+		return "", errors.New("failed to upload file")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		log.Println("error writing file:", err.Error())
+		return "", errors.New("failed to upload file")
+	}
+	return s.BaseURL + "/" + strings.TrimPrefix(filepath.ToSlash(filepath.Clean("/"+key)), "/"), nil
+}
+
+func (s *LocalBlobStore) Get(key string) ([]byte, string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, "", ErrBlobNotFound
+	}
+	if err != nil {
+		log.Println("error reading file:", err.Error())
+		return nil, "", errors.New("failed to read file")
+	}
+	return data, http.DetectContentType(data), nil
+}
+
+func (s *LocalBlobStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Println("error deleting file:", err.Error())
+		return errors.New("failed to delete file")
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package utilities
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+const defaultMaxImageSize = 5 << 20 // 5 MB
+
+// allowed upload types and the extension their originals are stored with.
+// WebP uploads are refused because the standard library cannot decode them.
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// ThumbnailSizes are the widths, in pixels, thumbnails are generated at
+var ThumbnailSizes = map[string]int{
+	"small":  160,
+	"medium": 480,
+	"large":  1024,
+}
+
+const (
+	thumbnailQuality = 82
+	maxImagePixels   = 40000000
+)
+
+var (
+	ErrImageTooLarge   = errors.New("image is too large")
+	ErrUnsupportedType = errors.New("unsupported image type, upload a JPEG, PNG or GIF")
+)
+
+// MaxImageSize is the largest accepted upload in bytes, read from MAX_IMAGE_SIZE
+func MaxImageSize() int64 {
+	if size, err := strconv.ParseInt(os.Getenv("MAX_IMAGE_SIZE"), 10, 64); err == nil && size > 0 {
+		return size
+	}
+	return defaultMaxImageSize
+}
+
+// ProcessedImage is a validated upload with its JPEG thumbnails
+type ProcessedImage struct {
+	Hash        string // SHA-256 of the original bytes
+	ContentType string
+	Extension   string
+	Original    []byte
+	Width       int
+	Height      int
+	Thumbnails  map[string][]byte // by size name, always JPEG
+}
+
+/*
+checks an upload's size, type and dimensions without decoding the whole image
+@params data
+*/
+func ValidateImage(data []byte) error {
+	if int64(len(data)) > MaxImageSize() {
+		return fmt.Errorf("%w, the limit is %d MB", ErrImageTooLarge, MaxImageSize()>>20)
+	}
+	if _, ok := imageTypes[http.DetectContentType(data)]; !ok {
+		return ErrUnsupportedType
+	}
+	// check the dimensions before decoding so a small file cannot expand into a huge bitmap
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return errors.New("the image could not be read")
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return fmt.Errorf("%w, the limit is %d megapixels", ErrImageTooLarge, maxImagePixels/1000000)
+	}
+	return nil
+}
+
+/*
+validates an uploaded image by its content rather than its filename and
+re-encodes it as JPEG thumbnails at every size in ThumbnailSizes
+@params data
+*/
+func ProcessImage(data []byte) (*ProcessedImage, error) {
+	if err := ValidateImage(data); err != nil {
+		return nil, err
+	}
+	contentType := http.DetectContentType(data)
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("the image could not be read")
+	}
+
+	bounds := img.Bounds()
+	processed := &ProcessedImage{
+		Hash:        ContentHash(data),
+		ContentType: contentType,
+		Extension:   imageTypes[contentType],
+		Original:    data,
+		Width:       bounds.Dx(),
+		Height:      bounds.Dy(),
+		Thumbnails:  map[string][]byte{},
+	}
+	flat := flatten(img)
+	for name, width := range ThumbnailSizes {
+		var out bytes.Buffer
+		if err := jpeg.Encode(&out, resize(flat, width), &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+			return nil, errors.New("failed to create thumbnail")
+		}
+		processed.Thumbnails[name] = out.Bytes()
+	}
+	return processed, nil
+}
+
+// flatten draws an image onto white, since JPEG has no alpha
+func flatten(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	flat := image.NewRGBA(bounds)
+	draw.Draw(flat, bounds, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, bounds, src, bounds.Min, draw.Over)
+	return flat
+}
+
+/*
+scales a flattened image down to the given width keeping its aspect ratio, averaging
+the source pixels under each target pixel. Images narrower than width keep their size.
+*/
+func resize(flat *image.RGBA, width int) image.Image {
+	bounds := flat.Bounds()
+	if bounds.Dx() < width {
+		width = bounds.Dx()
+	}
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := bounds.Min.Y + (y+1)*bounds.Dy()/height
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := bounds.Min.X + (x+1)*bounds.Dx()/width
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, n uint32
+			for sy := y0; sy < y1; sy++ {
+				offset := flat.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(flat.Pix[offset])
+					g += uint32(flat.Pix[offset+1])
+					b += uint32(flat.Pix[offset+2])
+					offset += 4
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(b / n), 255})
+		}
+	}
+	return dst
+}
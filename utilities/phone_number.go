@@ -25,4 +25,18 @@ func ValidatePhoneNumber(phone_no,countrycode string) (string, error){
 		return "", errors.New(err_string)
 	}
 	return strings.Split(valid_no, "+")[1],nil
-}
\ No newline at end of file
+}
+/*
+lists the ways a valid phone number may have been saved: in E.164 with and without the plus,
+and in the national form with a leading zero, such as 0712345678
+@params phone_no
+@params countrycode
+*/
+func PhoneNumberForms(phone_no, countrycode string) []string {
+	num, err := libphonenumber.Parse(phone_no, countrycode)
+	if err != nil || !libphonenumber.IsValidNumber(num) {
+		return nil
+	}
+	e164 := libphonenumber.Format(num, libphonenumber.E164)
+	return []string{e164, strings.TrimPrefix(e164, "+"), "0" + libphonenumber.GetNationalSignificantNumber(num)}
+}
@@ -0,0 +1,29 @@
+package utilities
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+)
+
+// GenerateOTP returns a random numeric one-time code with the given number of digits
+func GenerateOTP(digits int) (string, error) {
+	code := make([]byte, digits)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code), nil
+}
+
+// RandomToken returns a random hex token made of n random bytes, for links sent to users
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
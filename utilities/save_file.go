@@ -1,52 +1,59 @@
 package utilities
 
 import (
-	"context"
 	"errors"
-	"fmt"
+	"io"
 	"log"
-	"net/url"
-	"os"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
 
-	"github.com/Azure/azure-storage-blob-go/azblob"
 	"github.com/gofiber/fiber/v2"
 )
 
+/*
+uploads the file in a form field to the blob store and returns its URL. Files are
+stored under the hash of their content, so uploads with the same name no longer
+overwrite each other and identical uploads are stored once.
+@params field_name
+*/
 func SaveFile(c *fiber.Ctx, fieldName string) (string, error) {
 	file, err := c.FormFile(fieldName)
 	if err != nil {
 		log.Println(err.Error())
 		return "", err
 	}
-
-	src, err := file.Open()
+	data, err := ReadFormFile(file, MaxImageSize())
 	if err != nil {
 		return "", err
 	}
-	defer src.Close()
 
-	accountName := os.Getenv("ACCOUNT_NAME")
-	accountKey := os.Getenv("ACCOUNT_KEY")
-	containerName := os.Getenv("CONTAINER_NAME")
+	extension := strings.ToLower(filepath.Ext(file.Filename))
+	if len(extension) > 10 || strings.ContainsAny(extension, "/\\") {
+		extension = ""
+	}
+	return NewBlobStore().Put("uploads/"+ContentHash(data)+extension, http.DetectContentType(data), data)
+}
 
-	cred, err := azblob.NewSharedKeyCredential(accountName, accountKey)
+// ReadFormFile reads an uploaded file, refusing files larger than limit bytes
+func ReadFormFile(file *multipart.FileHeader, limit int64) ([]byte, error) {
+	if file.Size > limit {
+		return nil, ErrImageTooLarge
+	}
+	src, err := file.Open()
 	if err != nil {
-		return "", errors.New("failed to create credentials")
+		return nil, err
 	}
+	defer src.Close()
 
-	serviceURL := fmt.Sprintf("https://%s.blob.core.windows.net", accountName)
-	url, _ := url.Parse(serviceURL)
-	pipeline := azblob.NewPipeline(cred, azblob.PipelineOptions{})
-	serviceURLObj := azblob.NewServiceURL(*url, pipeline)
-
-	containerURL := serviceURLObj.NewContainerURL(containerName)
-	blobURL := containerURL.NewBlockBlobURL(file.Filename)
-
-	_, err = azblob.UploadStreamToBlockBlob(context.Background(), src, blobURL, azblob.UploadStreamToBlockBlobOptions{})
+	data, err := io.ReadAll(io.LimitReader(src, limit+1))
 	if err != nil {
-		log.Println("error uploading file:",err.Error())
-		return "", errors.New("failed to upload file")
+		log.Println("error reading uploaded file:", err.Error())
+		return nil, errors.New("failed to read uploaded file")
 	}
-
-	return blobURL.String(), nil
-}
\ No newline at end of file
+	if int64(len(data)) > limit {
+		return nil, ErrImageTooLarge
+	}
+	return data, nil
+}
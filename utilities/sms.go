@@ -0,0 +1,59 @@
+package utilities
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// SMSSender delivers text messages to phone numbers
+type SMSSender interface {
+	Send(phoneNumber, message string) error
+}
+
+/*
+returns the SMS sender chosen by SMS_SENDER. Until an SMS gateway is set up messages
+are written to the server log (console, the default) or appended to SMS_LOG_FILE (file).
+*/
+func NewSMSSender() SMSSender {
+	if os.Getenv("SMS_SENDER") == "file" {
+		path := os.Getenv("SMS_LOG_FILE")
+		if path == "" {
+			path = "./sms.log"
+		}
+		return FileSMSSender{Path: path}
+	}
+	return ConsoleSMSSender{}
+}
+
+// ConsoleSMSSender writes messages to the server log, for development
+type ConsoleSMSSender struct{}
+
+func (ConsoleSMSSender) Send(phoneNumber, message string) error {
+	if phoneNumber == "" {
+		return errors.New("phone number is required")
+	}
+	log.Printf("sms to %s: %s", phoneNumber, message)
+	return nil
+}
+
+// FileSMSSender appends messages to a file, for development and tests
+type FileSMSSender struct {
+	Path string
+}
+
+func (s FileSMSSender) Send(phoneNumber, message string) error {
+	if phoneNumber == "" {
+		return errors.New("phone number is required")
+	}
+	file, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		log.Println("error opening sms log:", err.Error())
+		return errors.New("failed to send sms")
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "%s\t%s\t%s\n", time.Now().Format(time.RFC3339), phoneNumber, message)
+	return err
+}
@@ -0,0 +1,101 @@
+package utilities
+
+import (
+	"testing"
+	"time"
+)
+
+// the SHA1 seed from RFC 6238 appendix B, base32 encoded
+var rfcSecret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+// RFC 6238 appendix B lists 8-digit codes; a 6-digit code is the same value mod 10^6
+func TestTOTPRFC6238Vectors(t *testing.T) {
+	cases := []struct {
+		unix int64
+		rfc  string
+		want string
+	}{
+		{59, "94287082", "287082"},
+		{1111111109, "07081804", "081804"},
+		{1111111111, "14050471", "050471"},
+		{1234567890, "89005924", "005924"},
+		{2000000000, "69279037", "279037"},
+		{20000000000, "65353130", "353130"},
+	}
+	key := []byte("12345678901234567890")
+	for _, tc := range cases {
+		if got := totpCode(key, tc.unix/totpPeriod); got != tc.want {
+			t.Errorf("code at T=%d = %s, want %s (RFC %s)", tc.unix, got, tc.want, tc.rfc)
+		}
+		step, ok := ValidateTOTP(rfcSecret, tc.want, time.Unix(tc.unix, 0))
+		if !ok || step != tc.unix/totpPeriod {
+			t.Errorf("ValidateTOTP at T=%d = %d, %v, want step %d", tc.unix, step, ok, tc.unix/totpPeriod)
+		}
+	}
+}
+
+func TestTOTPClockSkewWindow(t *testing.T) {
+	key := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	cases := []struct {
+		offset int64 // steps the code was generated away from now
+		ok     bool
+	}{
+		{-2, false},
+		{-1, true},
+		{0, true},
+		{1, true},
+		{2, false},
+	}
+	for _, tc := range cases {
+		code := totpCode(key, current+tc.offset)
+		step, ok := ValidateTOTP(rfcSecret, code, now)
+		if ok != tc.ok {
+			t.Errorf("code from %d steps away: accepted = %v, want %v", tc.offset, ok, tc.ok)
+		}
+		if ok && step != current+tc.offset {
+			t.Errorf("code from %d steps away: step = %d, want %d", tc.offset, step, current+tc.offset)
+		}
+	}
+}
+
+func TestTOTPRejectsMalformedInput(t *testing.T) {
+	now := time.Unix(59, 0)
+	for _, tc := range []struct{ secret, code string }{
+		{rfcSecret, ""},
+		{rfcSecret, "28708"},
+		{rfcSecret, "94287082"},
+		{"not base32!", "287082"},
+	} {
+		if _, ok := ValidateTOTP(tc.secret, tc.code, now); ok {
+			t.Errorf("ValidateTOTP(%q, %q) accepted", tc.secret, tc.code)
+		}
+	}
+	if _, ok := ValidateTOTP(rfcSecret, " 287 082 ", now); !ok {
+		t.Error("a code typed with spaces was rejected")
+	}
+}
+
+func TestRecoveryCodeWorksOnce(t *testing.T) {
+	codes := []StoredRecoveryCode{
+		{Hash: RecoveryCodeHash("a1b2c-d3e4f")},
+		{Hash: RecoveryCodeHash("01234-56789")},
+	}
+
+	if i, ok := SpendRecoveryCode(codes, "A1B2CD3E4F"); !ok || i != 0 {
+		t.Fatalf("first use = %d, %v, want 0, true", i, ok)
+	}
+	if _, ok := SpendRecoveryCode(codes, "a1b2c-d3e4f"); ok {
+		t.Error("a spent recovery code was accepted again")
+	}
+	if _, ok := SpendRecoveryCode(codes, "99999-99999"); ok {
+		t.Error("an unknown recovery code was accepted")
+	}
+	if i, ok := SpendRecoveryCode(codes, " 01234-56789 "); !ok || i != 1 {
+		t.Errorf("the other code = %d, %v, want 1, true", i, ok)
+	}
+	if _, ok := SpendRecoveryCode(codes, "01234-56789"); ok {
+		t.Error("the other code was accepted twice")
+	}
+}